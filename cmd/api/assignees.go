@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+// assignTaskHandler assigns a member of the task's workspace to the task,
+// making them one of its watchers. Assigning someone who is already
+// assigned changes nothing.
+func (app *application) assignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		UserID string `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	assigneeID, err := primitive.ObjectIDFromHex(input.UserID)
+	if err != nil {
+		v.AddError("user_id", "must be a valid id")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	project, err := app.models.Projects.Get(r.Context(), task.ProjectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	member, err := app.models.Workspaces.IsMember(r.Context(), project.WorkspaceID, assigneeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !member {
+		v.AddError("user_id", "must be a member of the task's workspace")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if task.IsAssignee(assigneeID) {
+		err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	task.AssigneeIDs = append(task.AssigneeIDs, assigneeID)
+	if data.ValidateTask(v, task); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tasks.Update(r.Context(), task)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Watchers.Add(r.Context(), task.ID, assigneeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The new assignee hears that they were assigned rather than that the
+	// task was updated.
+	event, ok := app.taskEvent(r.Context(), task, events.TaskUpdated)
+	if ok {
+		app.publish(event)
+	}
+	user := app.contextGetUser(r)
+	if assigneeID != user.ID {
+		app.notify(user, task, data.NotificationTaskAssigned, []primitive.ObjectID{assigneeID})
+	}
+	app.notifyWatchers(r.Context(), task, data.NotificationTaskUpdated, assigneeID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unassignTaskHandler takes a user off the task. They carry on watching it
+// until they choose to stop.
+func (app *application) unassignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	assigneeID, err := app.readIDParam(r, "user_id")
+	if err != nil || !task.IsAssignee(assigneeID) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var assigneeIDs []primitive.ObjectID
+	for _, id := range task.AssigneeIDs {
+		if id != assigneeID {
+			assigneeIDs = append(assigneeIDs, id)
+		}
+	}
+	task.AssigneeIDs = assigneeIDs
+
+	err = app.models.Tasks.Update(r.Context(), task)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publishTask(r.Context(), task, events.TaskUpdated)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	ids, err := app.models.Watchers.ForTask(r.Context(), task.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if ids == nil {
+		ids = []primitive.ObjectID{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watcher_ids": ids}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// watchTaskHandler and unwatchTaskHandler start and stop the user watching
+// the task. Either may be repeated.
+func (app *application) watchTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Watchers.Add(r.Context(), task.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you are now watching this task"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unwatchTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Watchers.Remove(r.Context(), task.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you are no longer watching this task"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// taskParam fetches the task named by the id URL parameter, replying with a
+// 404 unless it belongs to one of the user's workspaces. It reports false
+// when a response has already been sent.
+func (app *application) taskParam(w http.ResponseWriter, r *http.Request) (*data.Task, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	task, err := app.memberTask(r.Context(), app.contextGetUser(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return task, true
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/blob"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+const (
+	// The largest file which can be attached to a task, and the largest
+	// upload request, which leaves room for the rest of the form.
+	maxAttachmentSize    = 25 << 20
+	maxAttachmentRequest = maxAttachmentSize + 1<<20
+
+	// Uploads are held in memory up to this size and spill to temporary
+	// files beyond it.
+	attachmentMemory = 1 << 20
+
+	// attachmentReadWait bounds reading an upload, in place of the server's
+	// usual read timeout.
+	attachmentReadWait = 5 * time.Minute
+
+	// How long a download link works for.
+	attachmentURLTTL = 15 * time.Minute
+)
+
+func (app *application) listAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	attachments, err := app.models.Attachments.ForTask(r.Context(), task.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, attachment := range attachments {
+		app.signAttachment(attachment)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attachments": attachments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAttachmentHandler attaches the file uploaded in the file field of a
+// multipart form to the task. Its type is sniffed from its contents rather
+// than taken from the form, and it is refused if it would take the
+// workspace over its storage quota.
+func (app *application) createAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(attachmentReadWait))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentRequest)
+	err = r.ParseMultipartForm(attachmentMemory)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxAttachmentRequest))
+		default:
+			app.badRequestResponse(w, r, errors.New("body must be a multipart form with the attachment in a file field"))
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("body must be a multipart form with the attachment in a file field"))
+		return
+	}
+	defer file.Close()
+
+	project, err := app.models.Projects.Get(r.Context(), task.ProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	attachment := &data.Attachment{
+		TaskID:      task.ID,
+		ProjectID:   task.ProjectID,
+		WorkspaceID: project.WorkspaceID,
+		UploaderID:  app.contextGetUser(r).ID,
+		Filename:    header.Filename,
+		Size:        header.Size,
+		StorageKey:  task.ID.Hex() + "/" + primitive.NewObjectID().Hex(),
+	}
+
+	v := validator.New()
+	if data.ValidateAttachment(v, attachment, maxAttachmentSize); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	usage, err := app.models.Attachments.Usage(r.Context(), project.WorkspaceID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if v.Check(usage+attachment.Size <= app.config.attachments.quota, "file", "would take the workspace over its storage quota"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	attachment.ContentType = http.DetectContentType(sniff[:n])
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	checksum := sha256.New()
+	err = app.blobs.Put(r.Context(), attachment.StorageKey, io.TeeReader(file, checksum), attachment.Size, attachment.ContentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	attachment.Checksum = hex.EncodeToString(checksum.Sum(nil))
+
+	err = app.models.Attachments.Insert(r.Context(), attachment)
+	if err != nil {
+		app.deleteBlob(attachment)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.signAttachment(attachment)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/tasks/%s/attachments/%s", task.ID.Hex(), attachment.ID.Hex()))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"attachment": attachment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	_, attachment, ok := app.attachmentParam(w, r)
+	if !ok {
+		return
+	}
+	app.signAttachment(attachment)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"attachment": attachment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAttachmentHandler removes an attachment. Only whoever uploaded it
+// can.
+func (app *application) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	_, attachment, ok := app.attachmentParam(w, r)
+	if !ok {
+		return
+	}
+	if attachment.UploaderID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.models.Attachments.Delete(r.Context(), attachment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteBlob(attachment)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "attachment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadAttachmentHandler serves an attachment's contents to anyone with
+// a download link which hasn't expired. There is no session: the signature
+// in the link is the only credential, so that links can be handed to
+// browsers and download managers. Contents are always served as a download
+// and never sniffed again by the browser, so an uploaded page can't run in
+// the API's origin.
+func (app *application) downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	expires, err := strconv.ParseInt(qs.Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(qs.Get("signature")), []byte(app.attachmentSignature(id, expires))) {
+		app.errorResponse(w, r, http.StatusForbidden, "this download link is invalid")
+		return
+	}
+	if time.Now().Unix() > expires {
+		app.errorResponse(w, r, http.StatusForbidden, "this download link has expired")
+		return
+	}
+
+	attachment, err := app.models.Attachments.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	contents, err := app.blobs.Get(r.Context(), attachment.StorageKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer contents.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(max(expires-time.Now().Unix(), 0), 10))
+
+	_, err = io.Copy(w, contents)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// signAttachment sets the attachment's download link, which works for
+// attachmentURLTTL.
+func (app *application) signAttachment(attachment *data.Attachment) {
+	expires := time.Now().Add(attachmentURLTTL).Unix()
+	attachment.DownloadURL = fmt.Sprintf("%s/v1/attachments/%s/download?expires=%d&signature=%s",
+		app.config.baseURL, attachment.ID.Hex(), expires, app.attachmentSignature(attachment.ID, expires))
+}
+
+func (app *application) attachmentSignature(id primitive.ObjectID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.attachments.signingKey))
+	fmt.Fprintf(mac, "%s:%d", id.Hex(), expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deleteAttachments removes the attachments, contents and all, of a task or
+// a project which has been deleted. It runs in the background, and anything
+// it can't remove is only logged.
+func (app *application) deleteAttachments(find func(ctx context.Context) ([]*data.Attachment, error)) {
+	app.background(func() {
+		ctx := context.Background()
+
+		attachments, err := find(ctx)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"component": "attachments"})
+			return
+		}
+
+		for _, attachment := range attachments {
+			err := app.models.Attachments.Delete(ctx, attachment.ID)
+			if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, map[string]string{"attachment_id": attachment.ID.Hex()})
+				continue
+			}
+			app.deleteBlob(attachment)
+		}
+	})
+}
+
+// deleteBlob removes an attachment's contents, only logging a failure since
+// nothing refers to them any more.
+func (app *application) deleteBlob(attachment *data.Attachment) {
+	err := app.blobs.Delete(context.Background(), attachment.StorageKey)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"attachment_id": attachment.ID.Hex()})
+	}
+}
+
+// attachmentParam fetches the attachment named by the attachment_id URL
+// parameter along with its task, replying with a 404 unless the task
+// belongs to one of the user's workspaces and the attachment to the task.
+// It reports false when a response has already been sent.
+func (app *application) attachmentParam(w http.ResponseWriter, r *http.Request) (*data.Task, *data.Attachment, bool) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	id, err := app.readIDParam(r, "attachment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	attachment, err := app.models.Attachments.Get(r.Context(), id)
+	if err == nil && attachment.TaskID != task.ID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+	return task, attachment, true
+}
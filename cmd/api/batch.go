@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const (
+	maxBatchOperations = 500
+
+	// A batch costs one rate limiter token for every batchOperationsPerToken
+	// operations it carries, rather than one for the whole request. The same
+	// goes for calendar and file imports. A cost larger than the client's
+	// burst isn't refused; the part over it is paid off afterwards, as
+	// chargeRateLimit describes.
+	batchOperationsPerToken = 10
+)
+
+// Results for the operations of an atomic batch which was rolled back
+// because of another operation.
+const (
+	batchStatusRolledBack = "rolled_back"
+	batchStatusSkipped    = "skipped"
+)
+
+// batchStatusError is the result of an operation in a batch which isn't
+// atomic that failed because of a problem on the server. The operations
+// either side of it still run, and it may be retried on its own.
+const batchStatusError = "error"
+
+var errBatchAborted = errors.New("batch aborted")
+
+// batchOperation is a single task operation in a batch. Version, when
+// given, must match the task's current version for an update, move or
+// delete to go ahead; unlike /v1/sync there is no merging.
+type batchOperation struct {
+	Op        string          `json:"op"`
+	ID        string          `json:"id"`
+	Version   *int32          `json:"version"`
+	ProjectID string          `json:"project_id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// batchTasksHandler applies up to maxBatchOperations task operations in
+// order, reporting a result for each in the same form as /v1/sync. By
+// default every operation succeeds or fails on its own, even if the server
+// fails to carry one out. With atomic set
+// they run in a single transaction, and the first operation which fails
+// rolls back the lot.
+func (app *application) batchTasksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     bool             `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	if input.Atomic {
+		v.Check(app.models.SupportsTransactions(r.Context()), "atomic", "is not supported by this server's database")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cost := (len(input.Operations) + batchOperationsPerToken - 1) / batchOperationsPerToken
+	if !app.chargeRateLimit(w, r, cost-1) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !input.Atomic {
+		results := make([]syncResult, len(input.Operations))
+		for i, op := range input.Operations {
+			result, eventType, err := applyBatchOperation(r.Context(), app.models, user, op)
+			if err != nil {
+				// Answering with a bare 500 would hide which of the
+				// operations so far were applied.
+				app.logError(r, err)
+				result = syncResult{
+					Status: batchStatusError,
+					Errors: map[string]string{"error": "the server encountered a problem and could not process this operation"},
+				}
+			}
+			if eventType != "" {
+				app.publishTask(r.Context(), result.Task, eventType)
+			}
+
+			result.Index = i
+			results[i] = result
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var (
+		results    []syncResult
+		eventTypes []string
+	)
+
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		// The function may be retried, so start afresh each time.
+		results = make([]syncResult, len(input.Operations))
+		eventTypes = make([]string, len(input.Operations))
+
+		for i, op := range input.Operations {
+			result, eventType, err := applyBatchOperation(ctx, tx, user, op)
+			if err != nil {
+				return err
+			}
+
+			result.Index = i
+			results[i] = result
+			eventTypes[i] = eventType
+
+			if result.Status != syncStatusApplied {
+				for j := range results {
+					switch {
+					case j < i:
+						results[j] = syncResult{Index: j, Status: batchStatusRolledBack}
+					case j > i:
+						results[j] = syncResult{Index: j, Status: batchStatusSkipped}
+					}
+				}
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errBatchAborted):
+			env := envelope{"error": "the batch was rolled back because an operation failed", "results": results}
+			err = app.writeJSON(w, http.StatusUnprocessableEntity, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only announce the changes once they have been committed.
+	for i, result := range results {
+		if eventTypes[i] != "" {
+			app.publishTask(r.Context(), result.Task, eventTypes[i])
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyBatchOperation runs one operation against m, returning its result
+// and, when it changed a task, the type of event to publish for the task
+// in result.Task. A conflict doesn't include the current task, since the
+// failed write may have left a transaction unusable for reading it.
+func applyBatchOperation(ctx context.Context, m data.Models, user *data.User, op batchOperation) (syncResult, string, error) {
+	var input taskMutationData
+	if op.Op == "create" || op.Op == "update" {
+		if err := decodeMutationData(op.Data, &input); err != nil {
+			return invalidSyncResult("data", err.Error()), "", nil
+		}
+	}
+
+	if op.Op == "create" {
+		task := &data.Task{CreatedBy: user.ID, Status: data.TaskStatusTodo}
+		if op.ID != "" {
+			id, err := primitive.ObjectIDFromHex(op.ID)
+			if err != nil {
+				return invalidSyncResult("id", "must be a valid id"), "", nil
+			}
+			task.ID = id
+		}
+
+		if result, ok := setTaskMutationData(task, input); !ok {
+			return result, "", nil
+		}
+		result, ok, err := checkTaskWith(ctx, m, user, task)
+		if !ok || err != nil {
+			return result, "", err
+		}
+
+		err = m.Tasks.Insert(ctx, task)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateID):
+				return syncResult{Status: syncStatusConflict}, "", nil
+			default:
+				return syncResult{}, "", err
+			}
+		}
+		return syncResult{Status: syncStatusApplied, Task: task}, events.TaskCreated, nil
+	}
+
+	if op.Op != "update" && op.Op != "move" && op.Op != "delete" {
+		return invalidSyncResult("op", "must be one of create, update, move or delete"), "", nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return invalidSyncResult("id", "must be a valid id"), "", nil
+	}
+
+	task, err := findMemberTask(ctx, m, user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return syncResult{Status: syncStatusNotFound}, "", nil
+		default:
+			return syncResult{}, "", err
+		}
+	}
+	if op.Version != nil && *op.Version != task.Version {
+		return syncResult{Status: syncStatusConflict, Task: task}, "", nil
+	}
+
+	switch op.Op {
+	case "delete":
+		err = m.Tasks.Delete(ctx, task)
+		if err != nil {
+			return batchWriteResult(err)
+		}
+		return syncResult{Status: syncStatusApplied, Task: task}, events.TaskDeleted, nil
+
+	case "move":
+		projectID, err := primitive.ObjectIDFromHex(op.ProjectID)
+		if err != nil {
+			return invalidSyncResult("project_id", "must be a valid id"), "", nil
+		}
+		task.ProjectID = projectID
+
+	default:
+		if result, ok := setTaskMutationData(task, input); !ok {
+			return result, "", nil
+		}
+	}
+
+	result, ok, err := checkTaskWith(ctx, m, user, task)
+	if !ok || err != nil {
+		return result, "", err
+	}
+
+	err = m.Tasks.Update(ctx, task)
+	if err != nil {
+		return batchWriteResult(err)
+	}
+	return syncResult{Status: syncStatusApplied, Task: task}, events.TaskUpdated, nil
+}
+
+func batchWriteResult(err error) (syncResult, string, error) {
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		return syncResult{Status: syncStatusConflict}, "", nil
+	default:
+		return syncResult{}, "", err
+	}
+}
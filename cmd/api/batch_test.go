@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+)
+
+// failingTaskStore fails to insert tasks with the given title.
+type failingTaskStore struct {
+	data.TaskStore
+	title string
+}
+
+func (s failingTaskStore) Insert(ctx context.Context, task *data.Task) error {
+	if task.Title == s.title {
+		return errors.New("insert failed")
+	}
+	return s.TaskStore.Insert(ctx, task)
+}
+
+// TestBatchServerError checks that a batch which isn't atomic reports a
+// server error as the result of the operation which hit it and carries on.
+func TestBatchServerError(t *testing.T) {
+	app := newTestApplication(t)
+	app.hub = events.NewHub(100)
+	app.models.Tasks = failingTaskStore{TaskStore: app.models.Tasks, title: "Broken"}
+	defer app.wg.Wait()
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "Batch", "batch@example.com")
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Batch"}
+	if err := app.models.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []string
+	for _, title := range []string{"First", "Broken", "Third"} {
+		ops = append(ops, `{"op": "create", "data": {"project_id": "`+project.ID.Hex()+`", "title": "`+title+`"}}`)
+	}
+	body := `{"operations": [` + strings.Join(ops, ", ") + `]}`
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tasks/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	app.batchTasksHandler(w, app.contextSetUser(r, user))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var response struct {
+		Results []syncResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{syncStatusApplied, batchStatusError, syncStatusApplied}
+	if len(response.Results) != len(want) {
+		t.Fatalf("got %d results; want %d", len(response.Results), len(want))
+	}
+	for i, result := range response.Results {
+		if result.Index != i || result.Status != want[i] {
+			t.Errorf("got result %d with status %q at %d; want %q", result.Index, result.Status, i, want[i])
+		}
+	}
+}
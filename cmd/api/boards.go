@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+var errMoveRejected = errors.New("move rejected")
+
+// listBoardsHandler returns the project's boards, oldest first, without
+// their tasks.
+func (app *application) listBoardsHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := app.readMemberProject(w, r)
+	if !ok {
+		return
+	}
+
+	boards, err := app.models.Boards.ForProject(r.Context(), project.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"boards": boards}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createBoardHandler adds a board to the project. Any member of its
+// workspace can. A board created without columns gets one for each status.
+func (app *application) createBoardHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := app.readMemberProject(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name    string             `json:"name"`
+		Columns []data.BoardColumn `json:"columns"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	board := &data.Board{
+		ProjectID: project.ID,
+		Name:      input.Name,
+		Columns:   input.Columns,
+	}
+	if board.Columns == nil {
+		board.Columns = data.DefaultBoardColumns()
+	}
+	for i := range board.Columns {
+		board.Columns[i].ID = primitive.NewObjectID()
+	}
+
+	v := validator.New()
+	if data.ValidateBoard(v, board); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Boards.Insert(r.Context(), board)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"board": board}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showBoardHandler returns the board along with a group of tasks for each
+// of its columns, keyed by column id, in rank order.
+func (app *application) showBoardHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := app.boardParam(w, r)
+	if !ok {
+		return
+	}
+
+	tasks, err := boardTasks(r.Context(), app.models, board.ProjectID, board.Statuses())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"board": board, "groups": data.GroupBoardTasks(board, tasks)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateBoardHandler renames the board or replaces its columns. Columns
+// sent with the id of one of the board's columns keep it, and columns sent
+// without an id are new.
+func (app *application) updateBoardHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := app.boardParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name    *string            `json:"name"`
+		Columns []data.BoardColumn `json:"columns"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Name != nil {
+		board.Name = *input.Name
+	}
+	if input.Columns != nil {
+		for i, column := range input.Columns {
+			if column.ID.IsZero() {
+				input.Columns[i].ID = primitive.NewObjectID()
+				continue
+			}
+			_, ok := board.Column(column.ID)
+			v.Check(ok, "columns", "must only contain the ids of the board's own columns")
+		}
+		board.Columns = input.Columns
+	}
+
+	if data.ValidateBoard(v, board); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Boards.Update(r.Context(), board)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"board": board}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteBoardHandler deletes the board. Its tasks, and their ranks, are
+// left as they were.
+func (app *application) deleteBoardHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := app.boardParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Boards.Delete(r.Context(), board.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "board successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moveBoardTaskHandler moves a task of the board's project into one of the
+// board's columns, just below after_id or at the top of the column when
+// after_id is left out. The task takes the column's status and a rank
+// between its new neighbours, and no other task is written. A column at its
+// WIP limit refuses tasks from elsewhere, which is checked in the same
+// transaction as the move where the database supports them.
+//
+// Watchers are only notified when the move changes the task's status;
+// reordering a column is announced to subscribers alone.
+func (app *application) moveBoardTaskHandler(w http.ResponseWriter, r *http.Request) {
+	board, ok := app.boardParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		TaskID   string  `json:"task_id"`
+		ColumnID string  `json:"column_id"`
+		AfterID  *string `json:"after_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	taskID, err := primitive.ObjectIDFromHex(input.TaskID)
+	v.Check(err == nil, "task_id", "must be a valid id")
+
+	columnID, err := primitive.ObjectIDFromHex(input.ColumnID)
+	column, ok := board.Column(columnID)
+	v.Check(err == nil && ok, "column_id", "must be one of the board's columns")
+
+	afterID := primitive.NilObjectID
+	if input.AfterID != nil {
+		afterID, err = primitive.ObjectIDFromHex(*input.AfterID)
+		v.Check(err == nil, "after_id", "must be a valid id")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	var (
+		task          *data.Task
+		reranked      []*data.Task
+		moved         bool
+		statusChanged bool
+	)
+
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		// The function may be retried, so start afresh each time.
+		v = validator.New()
+		reranked = nil
+		moved, statusChanged = false, false
+
+		var err error
+		task, err = findMemberTask(ctx, tx, user, taskID)
+		if err != nil {
+			return err
+		}
+		if task.ProjectID != board.ProjectID {
+			v.AddError("task_id", "must be a task in the board's project")
+			return errMoveRejected
+		}
+
+		tasks, err := boardTasks(ctx, tx, board.ProjectID, []string{column.Status})
+		if err != nil {
+			return err
+		}
+		data.SortTasksByRank(tasks)
+
+		var others []*data.Task
+		for _, other := range tasks {
+			if other.ID != task.ID {
+				others = append(others, other)
+			}
+		}
+
+		if task.Status != column.Status && column.WIPLimit > 0 && len(others) >= column.WIPLimit {
+			v.AddError("column_id", fmt.Sprintf("has reached its WIP limit of %d", column.WIPLimit))
+			return errMoveRejected
+		}
+
+		// Find the task's new place among the others.
+		next := 0
+		if !afterID.IsZero() {
+			next = -1
+			for i, other := range others {
+				if other.ID == afterID {
+					next = i + 1
+				}
+			}
+			if next < 0 {
+				v.AddError("after_id", "must be another task in the column")
+				return errMoveRejected
+			}
+		}
+
+		before, after := neighbourRanks(others, next)
+		if task.Status == column.Status && task.Rank > before && (after == "" || task.Rank < after) {
+			return nil
+		}
+
+		rank := data.RankBetween(before, after)
+		if rank <= before || (after != "" && rank >= after) {
+			// There's no room between the neighbours, which share a rank
+			// after concurrent inserts or status changes made elsewhere,
+			// until the column is re-ranked.
+			for i, spread := range data.SpreadRanks(len(others)) {
+				if others[i].Rank == spread {
+					continue
+				}
+				others[i].Rank = spread
+				if err := tx.Tasks.Move(ctx, others[i]); err != nil {
+					return err
+				}
+				reranked = append(reranked, others[i])
+			}
+			rank = data.RankBetween(neighbourRanks(others, next))
+		}
+
+		statusChanged = task.Status != column.Status
+		task.Status = column.Status
+		task.Rank = rank
+		moved = true
+		return tx.Tasks.Move(ctx, task)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errMoveRejected):
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("task_id", "must be a task in the board's project")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, other := range reranked {
+		event, ok := app.taskEvent(r.Context(), other, events.TaskUpdated)
+		if ok {
+			app.publish(event)
+		}
+	}
+
+	switch {
+	case statusChanged:
+		app.publishTask(r.Context(), task, events.TaskUpdated)
+	case moved:
+		event, ok := app.taskEvent(r.Context(), task, events.TaskUpdated)
+		if ok {
+			app.publish(event)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// neighbourRanks returns the ranks either side of position i in tasks, with
+// an empty string standing for either end.
+func neighbourRanks(tasks []*data.Task, i int) (before, after string) {
+	if i > 0 {
+		before = tasks[i-1].Rank
+	}
+	if i < len(tasks) {
+		after = tasks[i].Rank
+	}
+	return before, after
+}
+
+// boardTasks returns the project's live tasks in any of the statuses, in no
+// particular order.
+func boardTasks(ctx context.Context, m data.Models, projectID primitive.ObjectID, statuses []string) ([]*data.Task, error) {
+	filters := data.Filters{
+		ProjectIDs: []primitive.ObjectID{projectID},
+		Statuses:   statuses,
+	}
+
+	tasks := []*data.Task{}
+	after := primitive.NilObjectID
+	for {
+		page, err := m.Tasks.List(ctx, filters, after, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, page...)
+
+		if len(page) < exportPageSize {
+			return tasks, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// boardParam fetches the board named by the id URL parameter, replying with
+// a 404 unless the user belongs to its project's workspace. It reports
+// false when a response has already been sent.
+func (app *application) boardParam(w http.ResponseWriter, r *http.Request) (*data.Board, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	board, err := app.models.Boards.Get(r.Context(), id)
+	if err == nil {
+		_, err = app.memberProject(r.Context(), app.contextGetUser(r), board.ProjectID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return board, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+)
+
+// TestMoveBoardTaskSharedRanks checks that a task can be moved between two
+// tasks which share a rank, by re-ranking the column.
+func TestMoveBoardTaskSharedRanks(t *testing.T) {
+	app := newTestApplication(t)
+	app.hub = events.NewHub(100)
+	defer app.wg.Wait()
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "Boards", "boards@example.com")
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Boards"}
+	if err := app.models.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	board := &data.Board{ProjectID: project.ID, Name: "Board", Columns: data.DefaultBoardColumns()}
+	if err := app.models.Boards.Insert(ctx, board); err != nil {
+		t.Fatal(err)
+	}
+
+	var tasks []*data.Task
+	for _, title := range []string{"First", "Second", "Third"} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: user.ID, Title: title, Status: data.TaskStatusTodo}
+		if err := app.models.Tasks.Insert(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	// Give the first two tasks the same rank, as concurrent inserts can.
+	tasks[1].Rank = tasks[0].Rank
+	if err := app.models.Tasks.Move(ctx, tasks[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"task_id": "` + tasks[2].ID.Hex() + `", "column_id": "` + board.Columns[0].ID.Hex() + `", "after_id": "` + tasks[0].ID.Hex() + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/boards/"+board.ID.Hex()+"/move", strings.NewReader(body))
+	r = withParams(r, "id", board.ID.Hex())
+	w := httptest.NewRecorder()
+	app.moveBoardTaskHandler(w, app.contextSetUser(r, user))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var got []*data.Task
+	for _, task := range tasks {
+		task, err := app.models.Tasks.Get(ctx, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, task)
+	}
+	data.SortTasksByRank(got)
+
+	want := []string{"First", "Third", "Second"}
+	for i, task := range got {
+		if task.Title != want[i] {
+			t.Errorf("got %s at %d; want %s", task.Title, i, want[i])
+		}
+		if i > 0 && task.Rank <= got[i-1].Rank {
+			t.Errorf("got %s ranked %q, no later than %q", task.Title, task.Rank, got[i-1].Rank)
+		}
+	}
+}
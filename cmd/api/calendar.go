@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/ical"
+	"tasksync/internal/validator"
+)
+
+const (
+	// Calendar apps poll a feed for as long as they're subscribed, so its
+	// token lasts until it's rotated rather than expiring like a session.
+	calendarTokenTTL = 5 * 365 * 24 * time.Hour
+
+	calendarProdID = "-//tasksync//tasks//EN"
+)
+
+// calendarStatuses and calendarPriorities map task statuses and priorities
+// to their iCalendar equivalents. iCalendar priorities run from 1, the
+// highest, to 9, with 0 meaning undefined.
+var (
+	calendarStatuses = map[string]string{
+		data.TaskStatusTodo:       "NEEDS-ACTION",
+		data.TaskStatusInProgress: "IN-PROCESS",
+		data.TaskStatusDone:       "COMPLETED",
+	}
+
+	calendarPriorities = map[int32]string{
+		data.TaskPriorityHigh:   "1",
+		data.TaskPriorityMedium: "5",
+		data.TaskPriorityLow:    "9",
+	}
+)
+
+// createCalendarTokenHandler issues the user a new secret URL for their
+// calendar feed, revoking any they had before. The URL is only shown here,
+// so a lost one has to be replaced.
+func (app *application) createCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeCalendar, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, calendarTokenTTL, data.ScopeCalendar)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"calendar_token": token, "url": calendarFeedURL(token)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCalendarTokenHandler turns the user's calendar feed off.
+func (app *application) deleteCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeCalendar, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "calendar feed successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// calendarFeedHandler serves the tasks with due dates from every project
+// the token's owner can see, as an iCalendar file which calendar apps can
+// subscribe to. Tasks are VEVENTs by default, since most calendars only
+// show events, or VTODOs when the type parameter is todos. There is no
+// session: the token in the URL is the only credential.
+// The tasks are read and written a page at a time, as an export is, so a
+// failing query part way through cuts the calendar short.
+func (app *application) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+	token = strings.TrimSuffix(token, ".ics")
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	kind := qs.Get("type")
+	if kind == "" {
+		kind = "events"
+	}
+	v.Check(validator.In(kind, "events", "todos"), "type", "must be events or todos")
+
+	if data.ValidateTokenPlaintext(v, token); v.Errors["token"] != "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Tokens.GetUserIDForToken(r.Context(), data.ScopeCalendar, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hasDue := true
+	filters := data.Filters{HasDue: &hasDue}
+	filters.ProjectIDs, err = app.models.Projects.IDsForWorkspaces(r.Context(), workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Read the first page before committing to a response, so that a
+	// failing query can still be reported properly.
+	tasks, err := app.models.Tasks.List(r.Context(), filters, primitive.NilObjectID, exportPageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", calendarProdID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	cal.AddText("X-WR-CALNAME", "Tasks")
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := ical.NewEncoder(w)
+	enc.Begin(cal)
+
+	projectNames := make(map[primitive.ObjectID]string)
+
+	for {
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteWait)); err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		for _, task := range tasks {
+			name, ok := projectNames[task.ProjectID]
+			if !ok {
+				project, err := app.models.Projects.Get(r.Context(), task.ProjectID)
+				if err != nil {
+					if !errors.Is(err, data.ErrCanceled) {
+						app.logError(r, err)
+					}
+					return
+				}
+				name = project.Name
+				projectNames[task.ProjectID] = name
+			}
+
+			enc.Encode(calendarComponent(task, name, kind == "todos"))
+		}
+		if err := enc.Flush(); err != nil {
+			return
+		}
+		if len(tasks) < exportPageSize {
+			break
+		}
+
+		tasks, err = app.models.Tasks.List(r.Context(), filters, tasks[len(tasks)-1].ID, exportPageSize)
+		if err != nil {
+			if !errors.Is(err, data.ErrCanceled) {
+				app.logError(r, err)
+			}
+			return
+		}
+	}
+
+	enc.End(cal)
+	if err := enc.Flush(); err == nil {
+		rc.Flush()
+	}
+}
+
+// calendarComponent describes a task as a VTODO, or as a VEVENT on the day
+// or at the time it's due, which only makes sense for tasks with a due
+// date. A due time of midnight UTC, which is how dates
+// without a time are stored, is given as an all-day date.
+func calendarComponent(task *data.Task, projectName string, todo bool) *ical.Component {
+	c := ical.NewComponent("VEVENT")
+	if todo {
+		c.Name = "VTODO"
+	}
+
+	c.Add("UID", task.ID.Hex()+"@tasksync")
+	c.AddDateTime("DTSTAMP", task.UpdatedAt)
+	c.AddDateTime("CREATED", task.CreatedAt)
+	c.AddDateTime("LAST-MODIFIED", task.UpdatedAt)
+	c.Add("SEQUENCE", strconv.Itoa(int(task.Version)))
+	c.AddText("SUMMARY", task.Title)
+	if task.Description != "" {
+		c.AddText("DESCRIPTION", task.Description)
+	}
+
+	due := "DTSTART"
+	if todo {
+		due = "DUE"
+	}
+	if task.DueAt != nil {
+		if dueAt := task.DueAt.UTC(); dueAt.Equal(dueAt.Truncate(24 * time.Hour)) {
+			c.AddDate(due, dueAt)
+		} else {
+			c.AddDateTime(due, dueAt)
+		}
+	}
+
+	if task.Recurrence != "" {
+		c.Add("RRULE", task.Recurrence)
+	}
+
+	// VEVENT has statuses of its own, none of which fit a task.
+	if todo {
+		c.Add("STATUS", calendarStatuses[task.Status])
+		if task.Status == data.TaskStatusDone {
+			c.AddDateTime("COMPLETED", task.UpdatedAt)
+		}
+	}
+
+	if priority, ok := calendarPriorities[task.Priority]; ok {
+		c.Add("PRIORITY", priority)
+	}
+	c.AddText("CATEGORIES", projectName)
+	return c
+}
+
+func calendarFeedURL(token *data.Token) string {
+	return "/v1/calendar/" + token.PlainToken + ".ics"
+}
+
+// importCalendarHandler creates tasks in a project from the VTODOs and
+// VEVENTs of an iCalendar file, uploaded as the file field of a multipart
+// form, which may also set dry_run. It works just as importTasksHandler
+// does for CSV files, with entries numbered from 1 in the order they
+// appear in the file.
+func (app *application) importCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := app.readMemberProject(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.readImportForm(w, r, "calendar") {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+	dryRun := readImportDryRun(r, v)
+
+	var entries []*ical.Component
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		v.AddError("file", "must be provided")
+	} else {
+		defer file.Close()
+		entries, err = readImportCalendar(file)
+		if err != nil {
+			v.AddError("file", err.Error())
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cost := (len(entries) + batchOperationsPerToken - 1) / batchOperationsPerToken
+	if !app.chargeRateLimit(w, r, cost-1) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	tasks := make([]*data.Task, 0, len(entries))
+	rowErrors := []importRowError{}
+	invalid := 0
+
+	for i, entry := range entries {
+		task, errs := parseCalendarEntry(entry, project, user)
+		if errs != nil {
+			invalid++
+			if len(rowErrors) < maxImportRowErrors {
+				rowErrors = append(rowErrors, importRowError{Row: i + 1, UID: entry.Text("UID"), Errors: errs})
+			}
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+
+	app.finishImport(w, r, tasks, len(entries), invalid, rowErrors, dryRun)
+}
+
+// readImportCalendar reads the VTODOs and VEVENTs from an uploaded
+// calendar, ignoring its other components such as time zones.
+func readImportCalendar(file io.Reader) ([]*ical.Component, error) {
+	cal, err := ical.Decode(file)
+	if err != nil {
+		var syntaxError *ical.SyntaxError
+		switch {
+		case errors.As(err, &syntaxError) && syntaxError.Line > 0:
+			return nil, fmt.Errorf("must be a valid iCalendar file (%s on line %d)", syntaxError.Msg, syntaxError.Line)
+		case errors.As(err, &syntaxError):
+			return nil, fmt.Errorf("must be a valid iCalendar file (%s)", syntaxError.Msg)
+		default:
+			return nil, err
+		}
+	}
+	if cal.Name != "VCALENDAR" {
+		return nil, errors.New("must be a VCALENDAR")
+	}
+
+	var entries []*ical.Component
+	for _, c := range cal.Components {
+		if c.Name != "VTODO" && c.Name != "VEVENT" {
+			continue
+		}
+		entries = append(entries, c)
+		if len(entries) > maxImportRows {
+			return nil, fmt.Errorf("must not contain more than %d tasks", maxImportRows)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("must contain at least one VTODO or VEVENT")
+	}
+	return entries, nil
+}
+
+// parseCalendarEntry reads a task from a VTODO or VEVENT, which is due at
+// its DUE time or, failing that, when it starts. If the entry can't be
+// made into a valid task it returns the problems with it instead.
+func parseCalendarEntry(c *ical.Component, project *data.Project, user *data.User) (*data.Task, map[string]string) {
+	task := &data.Task{
+		ProjectID:   project.ID,
+		CreatedBy:   user.ID,
+		Title:       strings.TrimSpace(c.Text("SUMMARY")),
+		Description: strings.TrimSpace(c.Text("DESCRIPTION")),
+		Status:      data.TaskStatusTodo,
+	}
+
+	v := validator.New()
+
+	due := c.Prop("DUE")
+	if due == nil {
+		due = c.Prop("DTSTART")
+	}
+	if due != nil {
+		dueAt, _, err := due.Time()
+		if err != nil {
+			v.AddError("due_at", fmt.Sprintf("must be a valid date or time (%s)", due.Name))
+		} else {
+			task.DueAt = &dueAt
+		}
+	}
+
+	if c.Name == "VTODO" {
+		switch c.Text("STATUS") {
+		case "IN-PROCESS":
+			task.Status = data.TaskStatusInProgress
+		case "COMPLETED", "CANCELLED":
+			task.Status = data.TaskStatusDone
+		}
+	}
+
+	if prop := c.Prop("PRIORITY"); prop != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(prop.Value))
+		switch {
+		case err != nil || n < 0 || n > 9:
+			v.AddError("priority", "must be an iCalendar priority between 0 and 9")
+		case n == 0:
+			task.Priority = data.TaskPriorityNone
+		case n <= 4:
+			task.Priority = data.TaskPriorityHigh
+		case n == 5:
+			task.Priority = data.TaskPriorityMedium
+		default:
+			task.Priority = data.TaskPriorityLow
+		}
+	}
+
+	if prop := c.Prop("RRULE"); prop != nil {
+		task.Recurrence = prop.Value
+	}
+
+	data.ValidateTask(v, task)
+	if !v.Valid() {
+		return nil, v.Errors
+	}
+	return task, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tasksync/internal/data"
+)
+
+// TestCalendarFeed checks that the feed lists only tasks with due dates and
+// is written out in full.
+func TestCalendarFeed(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "Feed", "feed@example.com")
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Feed"}
+	if err := app.models.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2024, time.June, 1, 9, 0, 0, 0, time.UTC)
+	for _, task := range []*data.Task{
+		{ProjectID: project.ID, CreatedBy: user.ID, Title: "Dated", Status: data.TaskStatusTodo, DueAt: &due},
+		{ProjectID: project.ID, CreatedBy: user.ID, Title: "Undated", Status: data.TaskStatusTodo},
+	} {
+		if err := app.models.Tasks.Insert(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeCalendar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.calendarFeedHandler(w, withParams(r, "token", token.PlainToken+".ics"))
+	}))
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", res.StatusCode, http.StatusOK, body)
+	}
+
+	feed := string(body)
+	if n := strings.Count(feed, "BEGIN:VEVENT"); n != 1 {
+		t.Errorf("got %d events; want 1", n)
+	}
+	if !strings.Contains(feed, "SUMMARY:Dated") || strings.Contains(feed, "Undated") {
+		t.Errorf("got a feed without only the dated task:\n%s", feed)
+	}
+	if !strings.HasPrefix(feed, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(feed, "END:VCALENDAR\r\n") {
+		t.Errorf("got an unfinished calendar:\n%s", feed)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/markdown"
+	"tasksync/internal/validator"
+)
+
+func (app *application) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	comments, err := app.models.Comments.ForTask(r.Context(), task.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCommentHandler adds a comment to the task and makes its author one
+// of the task's watchers. Users the comment mentions are told so, and the
+// task's other watchers that it was commented on.
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	comment := &data.Comment{
+		TaskID:    task.ID,
+		ProjectID: task.ProjectID,
+		AuthorID:  user.ID,
+		Body:      input.Body,
+	}
+
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.renderComment(r.Context(), task, comment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Comments.Insert(r.Context(), comment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Watchers.Add(r.Context(), task.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.notifyMentioned(user, task, comment.MentionIDs)
+	app.notifyWatchers(r.Context(), task, data.NotificationTaskCommented, comment.MentionIDs...)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/tasks/%s/comments/%s", task.ID.Hex(), comment.ID.Hex()))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := app.commentParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCommentHandler replaces the body of a comment, keeping the old one
+// in its history. Only the comment's author can edit it. Users who are
+// mentioned for the first time are told so, but nobody else hears about
+// the edit.
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	task, comment, ok := app.authoredComment(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body *string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Body != nil {
+		comment.Body = *input.Body
+	}
+
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mentioned := comment.MentionIDs
+	err = app.renderComment(r.Context(), task, comment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Comments.Update(r.Context(), comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var newlyMentioned []primitive.ObjectID
+	for _, id := range comment.MentionIDs {
+		if !containsID(mentioned, id) {
+			newlyMentioned = append(newlyMentioned, id)
+		}
+	}
+	app.notifyMentioned(app.contextGetUser(r), task, newlyMentioned)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := app.authoredComment(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Comments.Delete(r.Context(), comment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "comment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listCommentRevisionsHandler returns the comment's edit history: every
+// version of its body, oldest first.
+func (app *application) listCommentRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := app.commentParam(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.models.Comments.Revisions(r.Context(), comment.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// renderComment sets the comment's HTML from its body, along with the
+// members of the task's workspace the body mentions.
+func (app *application) renderComment(ctx context.Context, task *data.Task, comment *data.Comment) error {
+	html, err := markdown.Render(comment.Body)
+	if err != nil {
+		return err
+	}
+	comment.BodyHTML = html
+
+	comment.MentionIDs = nil
+	handles := markdown.Mentions(comment.Body)
+	if len(handles) == 0 {
+		return nil
+	}
+
+	members, err := app.workspaceMembers(ctx, task.ProjectID)
+	if err != nil {
+		return err
+	}
+	comment.MentionIDs = data.ResolveMentions(handles, members)
+	return nil
+}
+
+// workspaceMembers returns the members of the project's workspace. A
+// personal workspace which has never been stored has only its owner, who
+// shares its id.
+func (app *application) workspaceMembers(ctx context.Context, projectID primitive.ObjectID) ([]*data.User, error) {
+	project, err := app.models.Projects.Get(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberIDs := []primitive.ObjectID{project.WorkspaceID}
+	workspace, err := app.models.Workspaces.Get(ctx, project.WorkspaceID)
+	switch {
+	case err == nil:
+		memberIDs = workspace.MemberIDs
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	var members []*data.User
+	for _, id := range memberIDs {
+		user, err := app.models.Users.GetByID(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		members = append(members, user)
+	}
+	return members, nil
+}
+
+// notifyMentioned tells the users a comment mentions about it, other than
+// its author.
+func (app *application) notifyMentioned(author *data.User, task *data.Task, mentionIDs []primitive.ObjectID) {
+	var recipients []primitive.ObjectID
+	for _, id := range mentionIDs {
+		if id != author.ID {
+			recipients = append(recipients, id)
+		}
+	}
+	app.notify(author, task, data.NotificationMentioned, recipients)
+}
+
+// commentParam fetches the comment named by the comment_id URL parameter
+// along with its task, replying with a 404 unless the task belongs to one
+// of the user's workspaces and the comment to the task. It reports false
+// when a response has already been sent.
+func (app *application) commentParam(w http.ResponseWriter, r *http.Request) (*data.Task, *data.Comment, bool) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	id, err := app.readIDParam(r, "comment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	comment, err := app.models.Comments.Get(r.Context(), id)
+	if err == nil && comment.TaskID != task.ID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+	return task, comment, true
+}
+
+// authoredComment is like commentParam but also requires the user to have
+// written the comment. Other members of the workspace get a 403.
+func (app *application) authoredComment(w http.ResponseWriter, r *http.Request) (*data.Task, *data.Comment, bool) {
+	task, comment, ok := app.commentParam(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if comment.AuthorID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return nil, nil, false
+	}
+	return task, comment, true
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"net/http"
 
+	"golang.org/x/time/rate"
 	"tasksync/internal/data"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	rateLimiterContextKey = contextKey("rateLimiter")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +27,15 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetRateLimiter(r *http.Request, limiter *rate.Limiter) *http.Request {
+	ctx := context.WithValue(r.Context(), rateLimiterContextKey, limiter)
+	return r.WithContext(ctx)
+}
+
+// contextGetRateLimiter returns the client's rate limiter, or nil when rate
+// limiting is disabled.
+func (app *application) contextGetRateLimiter(r *http.Request) *rate.Limiter {
+	limiter, _ := r.Context().Value(rateLimiterContextKey).(*rate.Limiter)
+	return limiter
+}
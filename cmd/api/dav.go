@@ -0,0 +1,992 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/ical"
+	"tasksync/internal/validator"
+)
+
+// The CalDAV server is a subset of RFC 4791, enough for calendar and task
+// apps to sync tasks both ways. Each project the user can see is a calendar
+// of VTODOs under /dav/calendars/, laid out as:
+//
+//	/dav/                         the root, pointing clients at the principal
+//	/dav/principal/               the user, with their calendar home
+//	/dav/calendars/               the calendar home, listing the projects
+//	/dav/calendars/:project/      a project's tasks
+//	/dav/calendars/:project/:name a task, as an iCalendar file
+//
+// Tasks created through the API are named after their IDs, as <id>.ics,
+// while those created by a client keep the name and UID it gave them.
+const (
+	davRootPath      = "/dav/"
+	davPrincipalPath = "/dav/principal/"
+	davCalendarsPath = "/dav/calendars/"
+
+	maxDAVBodySize = 1_048_576
+
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+
+	davTimeLayout = "20060102T150405Z"
+)
+
+type davResourceKind int
+
+const (
+	davRoot davResourceKind = iota
+	davPrincipal
+	davHome
+	davCalendar
+	davObject
+)
+
+// davResource is the resource a request's path names. For an object, task
+// is nil when there is no task by that name yet, and object is the record
+// of the name a client gave the task, if it has one.
+type davResource struct {
+	kind    davResourceKind
+	project *data.Project
+	name    string
+	task    *data.Task
+	object  *data.CalendarObject
+}
+
+// authenticateDAV identifies the user making a CalDAV request. Calendar apps
+// mostly only speak basic auth, so as well as a bearer API key or
+// authentication token it accepts the user's email address along with
+// either their password or an API key. Unlike the JSON API every request
+// must be authenticated, and a failure is answered with a basic auth
+// challenge.
+func (app *application) authenticateDAV(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		user, err := app.davUser(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				w.Header().Set("WWW-Authenticate", `Basic realm="tasksync", charset="UTF-8"`)
+				app.invalidCredentialsResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// davUser returns the user whose credentials are in the request, or
+// ErrRecordNotFound if they are missing or wrong.
+func (app *application) davUser(r *http.Request) (*data.User, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		user, err := app.userForAPIKey(r.Context(), token)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return app.userForToken(r.Context(), token)
+		}
+		return user, err
+	}
+
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), email)
+	if err != nil {
+		return nil, err
+	}
+
+	keyUser, err := app.userForAPIKey(r.Context(), password)
+	switch {
+	case err == nil && keyUser.ID == user.ID:
+		return user, nil
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	match, err := user.PasswordMatches(password)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, data.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// userForAPIKey looks up the user holding an API key, returning
+// ErrRecordNotFound if the key is malformed, expired or unknown.
+func (app *application) userForAPIKey(ctx context.Context, key string) (*data.User, error) {
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, key); !v.Valid() {
+		return nil, data.ErrRecordNotFound
+	}
+
+	userID, err := app.models.Tokens.GetUserIDForToken(ctx, data.ScopeAPIKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return app.models.Users.GetByID(ctx, userID)
+}
+
+// davHandler serves every CalDAV request, working out which resource the
+// path names before handing it on according to the method.
+func (app *application) davHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	res, err := app.davResolve(r.Context(), app.contextGetUser(r), r.URL.EscapedPath())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		app.davPropfind(w, r, res)
+	case "REPORT":
+		app.davReport(w, r, res)
+	case http.MethodGet, http.MethodHead:
+		app.davGet(w, r, res)
+	case http.MethodPut:
+		app.davPut(w, r, res)
+	case http.MethodDelete:
+		app.davDelete(w, r, res)
+	default:
+		app.methodNotAllowedResponse(w, r)
+	}
+}
+
+// davResolve finds the resource at an escaped path, returning ErrRecordNotFound if
+// there can't be one. A path naming a task which doesn't exist, in a
+// project the user can see, resolves to an object without a task, as that
+// is where a new task would be PUT.
+func (app *application) davResolve(ctx context.Context, user *data.User, path string) (*davResource, error) {
+	rest, ok := strings.CutPrefix(path, davRootPath)
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	var segments []string
+	if rest = strings.Trim(rest, "/"); rest != "" {
+		segments = strings.Split(rest, "/")
+	}
+
+	switch {
+	case len(segments) == 0:
+		return &davResource{kind: davRoot}, nil
+	case len(segments) == 1 && segments[0] == "principal":
+		return &davResource{kind: davPrincipal}, nil
+	case segments[0] != "calendars" || len(segments) > 3:
+		return nil, data.ErrRecordNotFound
+	case len(segments) == 1:
+		return &davResource{kind: davHome}, nil
+	}
+
+	projectID, err := primitive.ObjectIDFromHex(segments[1])
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+	project, err := app.memberProject(ctx, user, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 2 {
+		return &davResource{kind: davCalendar, project: project}, nil
+	}
+
+	name, err := url.PathUnescape(segments[2])
+	if err != nil || name == "" {
+		return nil, data.ErrRecordNotFound
+	}
+	res := &davResource{kind: davObject, project: project, name: name}
+
+	var taskID primitive.ObjectID
+	res.object, err = app.models.Calendars.GetByName(ctx, project.ID, name)
+	switch {
+	case err == nil:
+		taskID = res.object.TaskID
+	case errors.Is(err, data.ErrRecordNotFound):
+		taskID, _ = primitive.ObjectIDFromHex(strings.TrimSuffix(name, ".ics"))
+	default:
+		return nil, err
+	}
+
+	if !taskID.IsZero() {
+		res.task, err = app.models.Tasks.Get(ctx, taskID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	// A task moved to another project is no longer in this calendar.
+	if res.task != nil && res.task.ProjectID != project.ID {
+		res.task = nil
+	}
+	return res, nil
+}
+
+// davPropfind reports the properties of a resource and, unless the Depth
+// header is 0, of its members too. An infinite depth is treated as 1.
+func (app *application) davPropfind(w http.ResponseWriter, r *http.Request, res *davResource) {
+	root, err := app.readDAVBody(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var requested []xml.Name
+	if root != nil {
+		if root.name != (xml.Name{Space: nsDAV, Local: "propfind"}) {
+			app.badRequestResponse(w, r, errors.New("body must be a DAV:propfind element"))
+			return
+		}
+		if prop := root.child(nsDAV, "prop"); prop != nil {
+			requested = prop.childNames()
+		}
+	}
+
+	if res.kind == davObject && res.task == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	responses := []davResponse{app.davProps(user, res).response(requested)}
+
+	if r.Header.Get("Depth") != "0" {
+		var members []*davResource
+		switch res.kind {
+		case davRoot:
+			members = []*davResource{{kind: davPrincipal}, {kind: davHome}}
+		case davHome:
+			members, err = app.davCalendars(r.Context(), user)
+		case davCalendar:
+			members, err = app.davObjects(r.Context(), res.project, nil)
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, member := range members {
+			responses = append(responses, app.davProps(user, member).response(requested))
+		}
+	}
+
+	app.writeMultistatus(w, r, responses)
+}
+
+// davReport answers the calendar-query and calendar-multiget reports on a
+// calendar. Queries can filter on VTODO and a time range, which is matched
+// against a task's due time; a task without one matches every range.
+func (app *application) davReport(w http.ResponseWriter, r *http.Request, res *davResource) {
+	root, err := app.readDAVBody(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if root == nil {
+		app.badRequestResponse(w, r, errors.New("body must not be empty"))
+		return
+	}
+	if res.kind != davCalendar {
+		app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+		return
+	}
+
+	var requested []xml.Name
+	if prop := root.child(nsDAV, "prop"); prop != nil {
+		requested = prop.childNames()
+	}
+
+	user := app.contextGetUser(r)
+	var responses []davResponse
+
+	switch root.name {
+	case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+		match, err := davQueryFilter(root.child(nsCalDAV, "filter"))
+		if err != nil {
+			app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-filter"})
+			return
+		}
+
+		objects, err := app.davObjects(r.Context(), res.project, match)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, object := range objects {
+			responses = append(responses, app.davProps(user, object).response(requested))
+		}
+
+	case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+		for _, href := range root.children {
+			if href.name != (xml.Name{Space: nsDAV, Local: "href"}) {
+				continue
+			}
+
+			path := strings.TrimSpace(href.text)
+			if u, err := url.Parse(path); err == nil {
+				path = u.EscapedPath()
+			}
+
+			object, err := app.davResolve(r.Context(), user, path)
+			switch {
+			case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+				app.serverErrorResponse(w, r, err)
+				return
+			case err != nil || object.kind != davObject || object.project.ID != res.project.ID || object.task == nil:
+				responses = append(responses, davResponse{href: path, status: http.StatusNotFound})
+			default:
+				responses = append(responses, app.davProps(user, object).response(requested))
+			}
+		}
+
+	default:
+		app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+		return
+	}
+
+	app.writeMultistatus(w, r, responses)
+}
+
+// davQueryFilter reads the filter of a calendar-query, which must select
+// VCALENDAR components and may narrow them to VTODOs, optionally within a
+// time range. Filters on anything else match no tasks.
+func davQueryFilter(filter *davNode) (func(*data.Task) bool, error) {
+	matchAll := func(*data.Task) bool { return true }
+	matchNone := func(*data.Task) bool { return false }
+
+	if filter == nil {
+		return matchAll, nil
+	}
+
+	calendar := filter.child(nsCalDAV, "comp-filter")
+	if calendar == nil || calendar.attr("name") != "VCALENDAR" {
+		return nil, errors.New("filter must select VCALENDAR")
+	}
+
+	component := calendar.child(nsCalDAV, "comp-filter")
+	switch {
+	case component == nil:
+		return matchAll, nil
+	case component.attr("name") != "VTODO":
+		return matchNone, nil
+	}
+
+	timeRange := component.child(nsCalDAV, "time-range")
+	if timeRange == nil {
+		return matchAll, nil
+	}
+
+	var start, end *time.Time
+	for attr, dst := range map[string]**time.Time{"start": &start, "end": &end} {
+		if s := timeRange.attr(attr); s != "" {
+			t, err := time.Parse(davTimeLayout, s)
+			if err != nil {
+				return nil, err
+			}
+			*dst = &t
+		}
+	}
+
+	return func(task *data.Task) bool {
+		if task.DueAt == nil {
+			return true
+		}
+		return (start == nil || !task.DueAt.Before(*start)) && (end == nil || task.DueAt.Before(*end))
+	}, nil
+}
+
+// davCalendars returns a calendar for each project the user can see.
+func (app *application) davCalendars(ctx context.Context, user *data.User) ([]*davResource, error) {
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	projectIDs, err := app.models.Projects.IDsForWorkspaces(ctx, workspaceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := make([]*davResource, 0, len(projectIDs))
+	for _, id := range projectIDs {
+		project, err := app.models.Projects.Get(ctx, id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		calendars = append(calendars, &davResource{kind: davCalendar, project: project})
+	}
+	return calendars, nil
+}
+
+// davObjects returns an object for each of the project's tasks, or for those
+// which match when match isn't nil.
+func (app *application) davObjects(ctx context.Context, project *data.Project, match func(*data.Task) bool) ([]*davResource, error) {
+	named, err := app.models.Calendars.ForProject(ctx, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[primitive.ObjectID]*data.CalendarObject, len(named))
+	for _, object := range named {
+		objects[object.TaskID] = object
+	}
+
+	filters := data.Filters{ProjectIDs: []primitive.ObjectID{project.ID}}
+	after := primitive.NilObjectID
+
+	var resources []*davResource
+	for {
+		tasks, err := app.models.Tasks.List(ctx, filters, after, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			if match != nil && !match(task) {
+				continue
+			}
+
+			res := &davResource{kind: davObject, project: project, task: task, name: task.ID.Hex() + ".ics"}
+			if object, ok := objects[task.ID]; ok {
+				res.name, res.object = object.Name, object
+			}
+			resources = append(resources, res)
+		}
+
+		if len(tasks) < exportPageSize {
+			return resources, nil
+		}
+		after = tasks[len(tasks)-1].ID
+	}
+}
+
+// davGet serves a task as an iCalendar file holding a single VTODO.
+func (app *application) davGet(w http.ResponseWriter, r *http.Request, res *davResource) {
+	if res.kind != davObject {
+		app.methodNotAllowedResponse(w, r)
+		return
+	}
+	if res.task == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	body, err := davCalendarData(res)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("ETag", davETag(res.task))
+	w.Header().Set("Last-Modified", res.task.UpdatedAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, body)
+}
+
+// davPut creates or replaces a task from an iCalendar file holding a single
+// VTODO. The If-Match and If-None-Match headers are honoured, so that a
+// client's stale copy can't overwrite someone else's change. Properties
+// which tasks have no place for are dropped.
+func (app *application) davPut(w http.ResponseWriter, r *http.Request, res *davResource) {
+	if res.kind != davObject {
+		app.methodNotAllowedResponse(w, r)
+		return
+	}
+	if !davPreconditions(r, res.task) {
+		app.davPreconditionFailedResponse(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDAVBodySize)
+	cal, err := ical.Decode(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxDAVBodySize))
+		default:
+			app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-data"})
+		}
+		return
+	}
+
+	var todos []*ical.Component
+	for _, c := range cal.Components {
+		switch c.Name {
+		case "VTODO":
+			todos = append(todos, c)
+		case "VEVENT", "VJOURNAL", "VFREEBUSY":
+			app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "supported-calendar-component"})
+			return
+		}
+	}
+	if cal.Name != "VCALENDAR" || len(todos) != 1 {
+		app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-object-resource"})
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	parsed, errs := parseCalendarEntry(todos[0], res.project, user)
+	if errs != nil {
+		app.davErrorResponse(w, r, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-object-resource"})
+		return
+	}
+
+	if res.task != nil {
+		task := res.task
+		task.Title = parsed.Title
+		task.Description = parsed.Description
+		task.Status = parsed.Status
+		task.Priority = parsed.Priority
+		task.DueAt = parsed.DueAt
+		task.Recurrence = parsed.Recurrence
+
+		err := app.models.Tasks.Update(r.Context(), task)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.davPreconditionFailedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		app.publishTask(r.Context(), task, events.TaskUpdated)
+
+		w.Header().Set("ETag", davETag(task))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	task := parsed
+	task.ID = primitive.NewObjectID()
+
+	uid := todos[0].Text("UID")
+	if uid == "" {
+		uid = task.ID.Hex() + "@tasksync"
+	}
+	object := &data.CalendarObject{ProjectID: res.project.ID, Name: res.name, TaskID: task.ID, UID: uid}
+
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		// The name may be left over from a task which has since been
+		// deleted or moved elsewhere.
+		if res.object != nil {
+			err := tx.Calendars.Delete(ctx, res.project.ID, res.name)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.Calendars.Insert(ctx, object)
+		if err != nil {
+			return err
+		}
+		return tx.Tasks.Insert(ctx, task)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			app.davPreconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publishTask(r.Context(), task, events.TaskCreated)
+
+	w.Header().Set("ETag", davETag(task))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davDelete deletes a task, honouring If-Match.
+func (app *application) davDelete(w http.ResponseWriter, r *http.Request, res *davResource) {
+	if res.kind != davObject {
+		app.methodNotAllowedResponse(w, r)
+		return
+	}
+	if res.task == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if !davPreconditions(r, res.task) {
+		app.davPreconditionFailedResponse(w, r)
+		return
+	}
+
+	err := app.models.Tasks.Delete(r.Context(), res.task)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.davPreconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publishTask(r.Context(), res.task, events.TaskDeleted)
+
+	if res.object != nil {
+		err := app.models.Calendars.Delete(r.Context(), res.project.ID, res.name)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davPreconditions reports whether the request's If-Match and If-None-Match
+// headers allow it to go ahead against the task, which is nil when there is
+// none.
+func davPreconditions(r *http.Request, task *data.Task) bool {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if task == nil || (match != "*" && !davETagListed(match, davETag(task))) {
+			return false
+		}
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && task != nil {
+		if noneMatch == "*" || davETagListed(noneMatch, davETag(task)) {
+			return false
+		}
+	}
+	return true
+}
+
+func davETagListed(list, etag string) bool {
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(s), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// davETag identifies a version of a task. It includes the task's ID as
+// well, so that a task deleted and recreated under the same name doesn't
+// repeat an old ETag.
+func davETag(task *data.Task) string {
+	return fmt.Sprintf(`"%s-%d"`, task.ID.Hex(), task.Version)
+}
+
+// davCalendarData encodes an object's task as a VTODO, under the UID the
+// client created it with if it came from one.
+func davCalendarData(res *davResource) (string, error) {
+	todo := calendarComponent(res.task, res.project.Name, true)
+	if res.object != nil {
+		todo.Prop("UID").Value = res.object.UID
+	}
+
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", calendarProdID)
+	cal.Components = []*ical.Component{todo}
+
+	var b strings.Builder
+	err := ical.Encode(&b, cal)
+	return b.String(), err
+}
+
+func davHref(res *davResource) string {
+	switch res.kind {
+	case davPrincipal:
+		return davPrincipalPath
+	case davHome:
+		return davCalendarsPath
+	case davCalendar:
+		return davCalendarsPath + res.project.ID.Hex() + "/"
+	case davObject:
+		return davCalendarsPath + res.project.ID.Hex() + "/" + url.PathEscape(res.name)
+	default:
+		return davRootPath
+	}
+}
+
+// davPropSet holds the properties of a resource, each as the XML of its
+// value. Properties which are costly to produce, like calendar-data, are
+// only given when asked for by name.
+type davPropSet struct {
+	href     string
+	props    map[xml.Name]string
+	onDemand map[xml.Name]func() (string, error)
+}
+
+func (app *application) davProps(user *data.User, res *davResource) *davPropSet {
+	ps := &davPropSet{href: davHref(res), props: make(map[xml.Name]string)}
+	dav := func(local, value string) { ps.props[xml.Name{Space: nsDAV, Local: local}] = value }
+	caldav := func(local, value string) { ps.props[xml.Name{Space: nsCalDAV, Local: local}] = value }
+
+	dav("current-user-principal", davHrefXML(davPrincipalPath))
+
+	switch res.kind {
+	case davRoot:
+		dav("resourcetype", "<d:collection/>")
+	case davPrincipal:
+		dav("resourcetype", "<d:principal/>")
+		dav("displayname", davEscape(user.Name))
+		dav("principal-URL", davHrefXML(davPrincipalPath))
+		caldav("calendar-home-set", davHrefXML(davCalendarsPath))
+		caldav("calendar-user-address-set", davHrefXML("mailto:"+user.Email))
+	case davHome:
+		dav("resourcetype", "<d:collection/>")
+		dav("displayname", "Calendars")
+	case davCalendar:
+		dav("resourcetype", "<d:collection/><c:calendar/>")
+		dav("displayname", davEscape(res.project.Name))
+		caldav("supported-calendar-component-set", `<c:comp name="VTODO"/>`)
+		dav("supported-report-set", "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>"+
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>")
+	case davObject:
+		dav("resourcetype", "")
+		dav("getetag", davEscape(davETag(res.task)))
+		dav("getcontenttype", "text/calendar; charset=utf-8; component=VTODO")
+		dav("getlastmodified", res.task.UpdatedAt.UTC().Format(http.TimeFormat))
+		ps.onDemand = map[xml.Name]func() (string, error){
+			{Space: nsCalDAV, Local: "calendar-data"}: func() (string, error) {
+				body, err := davCalendarData(res)
+				return davEscape(body), err
+			},
+		}
+	}
+	return ps
+}
+
+// response picks out the requested properties, or every property other than
+// the on-demand ones when requested is empty, as for an allprop request.
+func (ps *davPropSet) response(requested []xml.Name) davResponse {
+	resp := davResponse{href: ps.href, found: make(map[xml.Name]string)}
+
+	if len(requested) == 0 {
+		for name, value := range ps.props {
+			resp.found[name] = value
+		}
+		return resp
+	}
+
+	for _, name := range requested {
+		if value, ok := ps.props[name]; ok {
+			resp.found[name] = value
+			continue
+		}
+		if fn, ok := ps.onDemand[name]; ok {
+			value, err := fn()
+			if err == nil {
+				resp.found[name] = value
+				continue
+			}
+		}
+		resp.missing = append(resp.missing, name)
+	}
+	return resp
+}
+
+// davResponse is one response in a multistatus: either the properties found
+// and missing for a resource, or a status for the resource as a whole.
+type davResponse struct {
+	href    string
+	status  int
+	found   map[xml.Name]string
+	missing []xml.Name
+}
+
+func (app *application) writeMultistatus(w http.ResponseWriter, r *http.Request, responses []davResponse) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+
+	for _, resp := range responses {
+		b.WriteString("<d:response>" + davHrefXML(resp.href))
+
+		if resp.status != 0 {
+			b.WriteString(davStatusXML(resp.status) + "</d:response>")
+			continue
+		}
+
+		if len(resp.found) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for name, value := range resp.found {
+				b.WriteString(davElement(name, value))
+			}
+			b.WriteString("</d:prop>" + davStatusXML(http.StatusOK) + "</d:propstat>")
+		}
+		if len(resp.missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range resp.missing {
+				b.WriteString(davElement(name, ""))
+			}
+			b.WriteString("</d:prop>" + davStatusXML(http.StatusNotFound) + "</d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	b.WriteString("</d:multistatus>\n")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// davErrorResponse reports which CalDAV or WebDAV precondition a request
+// failed, as RFC 4791 asks.
+func (app *application) davErrorResponse(w http.ResponseWriter, r *http.Request, status int, condition xml.Name) {
+	body := xml.Header + `<d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">` + davElement(condition, "") + "</d:error>\n"
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+func (app *application) davPreconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since it was last read"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// davElement writes a property element around a value which is already
+// XML, using the prefixes declared on the document's root for the DAV and
+// CalDAV namespaces.
+func davElement(name xml.Name, value string) string {
+	var tag, attrs string
+	switch name.Space {
+	case nsDAV:
+		tag = "d:" + name.Local
+	case nsCalDAV:
+		tag = "c:" + name.Local
+	default:
+		tag = "x:" + name.Local
+		attrs = ` xmlns:x="` + davEscape(name.Space) + `"`
+	}
+
+	if value == "" {
+		return "<" + tag + attrs + "/>"
+	}
+	return "<" + tag + attrs + ">" + value + "</" + tag + ">"
+}
+
+func davHrefXML(href string) string {
+	return "<d:href>" + davEscape(href) + "</d:href>"
+}
+
+func davStatusXML(status int) string {
+	return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", status, http.StatusText(status))
+}
+
+func davEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// davNode is an element of a request body, which is small enough to be
+// read whole.
+type davNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*davNode
+	text     string
+}
+
+func (n *davNode) child(space, local string) *davNode {
+	for _, c := range n.children {
+		if c.name.Space == space && c.name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *davNode) childNames() []xml.Name {
+	names := make([]xml.Name, 0, len(n.children))
+	for _, c := range n.children {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func (n *davNode) attr(local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// readDAVBody reads the XML body of a request, returning a nil node if it
+// is empty.
+func (app *application) readDAVBody(w http.ResponseWriter, r *http.Request) (*davNode, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDAVBodySize)
+	dec := xml.NewDecoder(r.Body)
+
+	var (
+		root  *davNode
+		stack []*davNode
+	)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				return nil, fmt.Errorf("body must not be larger than %d bytes", maxDAVBodySize)
+			default:
+				return nil, fmt.Errorf("body contains badly-formed XML (%s)", err)
+			}
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			node := &davNode{name: tok.Name, attrs: tok.Attr}
+			if len(stack) == 0 {
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(tok)
+			}
+		}
+	}
+	return root, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+)
+
+const (
+	// Time allowed for a webhook endpoint to respond.
+	webhookTimeout = 10 * time.Second
+
+	// A delivery is given up on after webhookMaxAttempts attempts, spaced
+	// webhookRetryDelay apart and doubling each time.
+	webhookMaxAttempts = 6
+	webhookRetryDelay  = 10 * time.Second
+
+	// A webhook is disabled after this many failed attempts in a row,
+	// across all of its deliveries.
+	webhookDisableAfter = 15
+
+	// Deliveries attempted at once by each instance.
+	webhookConcurrency = 4
+
+	// How often to look for due deliveries when not woken by a new one.
+	webhookPollInterval = time.Second
+
+	// How long an instance has to finish an attempt after claiming a
+	// delivery before another may claim it.
+	webhookLease = time.Minute
+)
+
+// queueWebhookDeliveries stores a delivery of the event for every webhook
+// in its workspace which wants it, then wakes the worker. It runs in the
+// background so that writes aren't held up by it.
+func (app *application) queueWebhookDeliveries(event events.Event) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.db.queryTimeout)
+		defer cancel()
+
+		webhooks, err := app.models.Webhooks.ForEvent(ctx, event.WorkspaceID, event.Type)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"event": event.Type})
+			return
+		}
+		if len(webhooks) == 0 {
+			return
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"event": event.Type})
+			return
+		}
+
+		for _, webhook := range webhooks {
+			delivery := &data.WebhookDelivery{
+				WebhookID: webhook.ID,
+				EventID:   event.ID,
+				EventType: event.Type,
+				Payload:   payload,
+			}
+
+			err := app.models.Deliveries.Insert(ctx, delivery)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{
+					"event":      event.Type,
+					"webhook_id": webhook.ID.Hex(),
+				})
+			}
+		}
+		app.wakeWebhookWorker()
+	})
+}
+
+// wakeWebhookWorker asks the worker to look for due deliveries now rather
+// than at its next poll. It never blocks.
+func (app *application) wakeWebhookWorker() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookWorker attempts due deliveries until ctx is canceled. Deliveries
+// live in the database, so any instance may pick up a retry, and each
+// attempt is claimed first so that only one instance makes it.
+func (app *application) runWebhookWorker(ctx context.Context) {
+	slots := make(chan struct{}, webhookConcurrency)
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		app.dispatchWebhookDeliveries(ctx, slots)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-app.webhookWake:
+		}
+	}
+}
+
+func (app *application) dispatchWebhookDeliveries(ctx context.Context, slots chan struct{}) {
+	free := cap(slots) - len(slots)
+	if free == 0 {
+		return
+	}
+
+	deliveries, err := app.models.Deliveries.Due(ctx, time.Now().UTC(), free)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.PrintError(err, map[string]string{"component": "webhook worker"})
+		}
+		return
+	}
+
+	for _, delivery := range deliveries {
+		lease := time.Now().UTC().Add(webhookLease)
+		delivery.NextAttemptAt = &lease
+
+		err := app.models.Deliveries.Update(ctx, delivery)
+		if err != nil {
+			// Another instance claimed it first.
+			if errors.Is(err, data.ErrEditConflict) {
+				continue
+			}
+			if ctx.Err() == nil {
+				app.logger.PrintError(err, map[string]string{"component": "webhook worker"})
+			}
+			return
+		}
+
+		// Each attempt needs its own copy of the loop variable, which is
+		// shared between iterations before Go 1.22.
+		delivery := delivery
+		slots <- struct{}{}
+		app.background(func() {
+			defer func() { <-slots }()
+			app.attemptWebhookDelivery(ctx, delivery)
+		})
+	}
+}
+
+// attemptWebhookDelivery makes one attempt at a claimed delivery and
+// records it, scheduling the next attempt with exponential backoff if it
+// failed. If ctx is canceled part way through nothing is recorded, and the
+// delivery is retried once its claim runs out.
+func (app *application) attemptWebhookDelivery(ctx context.Context, delivery *data.WebhookDelivery) {
+	webhook, err := app.models.Webhooks.Get(ctx, delivery.WebhookID)
+	if err != nil {
+		// The webhook's deliveries are removed along with it.
+		if !errors.Is(err, data.ErrRecordNotFound) && ctx.Err() == nil {
+			app.logger.PrintError(err, map[string]string{"delivery_id": delivery.ID.Hex()})
+		}
+		return
+	}
+
+	if !webhook.Active {
+		delivery.Status = data.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		app.saveWebhookDelivery(ctx, delivery)
+		return
+	}
+
+	attempt := app.postWebhook(ctx, webhook, delivery)
+	if ctx.Err() != nil {
+		return
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	success := attempt.StatusCode >= 200 && attempt.StatusCode < 300
+	switch {
+	case success:
+		delivery.Status = data.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = nil
+	case len(delivery.Attempts) >= webhookMaxAttempts:
+		delivery.Status = data.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+	default:
+		next := attempt.At.Add(webhookRetryDelay << (len(delivery.Attempts) - 1))
+		delivery.NextAttemptAt = &next
+	}
+	app.saveWebhookDelivery(ctx, delivery)
+
+	disabled, err := app.models.Webhooks.RecordResult(ctx, webhook.ID, success, webhookDisableAfter)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"webhook_id": webhook.ID.Hex()})
+		return
+	}
+	if disabled {
+		app.logger.PrintInfo("webhook disabled after repeated failures", map[string]string{
+			"webhook_id": webhook.ID.Hex(),
+			"url":        webhook.URL,
+		})
+	}
+}
+
+func (app *application) saveWebhookDelivery(ctx context.Context, delivery *data.WebhookDelivery) {
+	err := app.models.Deliveries.Update(ctx, delivery)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"delivery_id": delivery.ID.Hex()})
+	}
+}
+
+// postWebhook sends the delivery's payload to the webhook. The
+// X-Tasksync-Signature header carries the time of sending and an
+// HMAC-SHA256 of "<time>.<body>" keyed with the webhook's secret, so that
+// receivers can check both where the request came from and that it isn't a
+// replay. Any response outside 2xx, redirects included, counts as a failure.
+func (app *application) postWebhook(ctx context.Context, webhook *data.Webhook, delivery *data.WebhookDelivery) (attempt data.WebhookAttempt) {
+	attempt.At = time.Now().UTC()
+	defer func() {
+		attempt.DurationMS = time.Since(attempt.At).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tasksync-webhooks/"+version)
+	req.Header.Set("X-Tasksync-Event", delivery.EventType)
+	req.Header.Set("X-Tasksync-Delivery", delivery.ID.Hex())
+	req.Header.Set("X-Tasksync-Signature", "t="+timestamp+",v1="+signWebhook(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+
+	// Read a little of the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	attempt.StatusCode = res.StatusCode
+	return attempt
+}
+
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tasksync/internal/data"
+	"tasksync/internal/jsonlog"
+)
+
+// newTestApplication returns an application backed by a fresh, migrated
+// SQLite database.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	migrator := data.SQLMigrator{DB: db, Dialect: data.DialectSQLite}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		logger:        jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:        data.NewSQLModels(db, data.DialectSQLite, 0),
+		webhookClient: newWebhookClient(),
+		webhookWake:   make(chan struct{}, 1),
+	}
+}
+
+func insertTestWebhook(t *testing.T, app *application, url string) *data.Webhook {
+	t.Helper()
+	ctx := context.Background()
+
+	user := &data.User{Name: "Hooks", Email: "hooks@example.com"}
+	if err := user.SetPassword("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace := &data.Workspace{Name: "Hooks", OwnerID: user.ID}
+	if err := app.models.Workspaces.Insert(ctx, workspace); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &data.Webhook{
+		WorkspaceID: workspace.ID,
+		CreatedBy:   user.ID,
+		URL:         url,
+		Events:      []string{"task.*"},
+		Secret:      "0123456789abcdef",
+	}
+	if err := app.models.Webhooks.Insert(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func insertTestDelivery(t *testing.T, app *application, webhook *data.Webhook, eventID int64) *data.WebhookDelivery {
+	t.Helper()
+
+	delivery := &data.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   eventID,
+		EventType: "task.created",
+		Payload:   []byte(`{"type":"task.created"}`),
+	}
+	if err := app.models.Deliveries.Insert(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+// dispatch runs a single pass of the webhook worker and waits for the
+// attempts it starts.
+func dispatch(app *application) {
+	app.dispatchWebhookDeliveries(context.Background(), make(chan struct{}, webhookConcurrency))
+	app.wg.Wait()
+}
+
+func getTestDelivery(t *testing.T, app *application, delivery *data.WebhookDelivery) *data.WebhookDelivery {
+	t.Helper()
+
+	got, err := app.models.Deliveries.Get(context.Background(), delivery.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	app := newTestApplication(t)
+
+	var requests []*http.Request
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	webhook := insertTestWebhook(t, app, srv.URL)
+	delivery := insertTestDelivery(t, app, webhook, 1)
+
+	dispatch(app)
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests; want 1", len(requests))
+	}
+	r := requests[0]
+
+	if r.Method != http.MethodPost {
+		t.Errorf("got method %s; want POST", r.Method)
+	}
+	if bodies[0] != string(delivery.Payload) {
+		t.Errorf("got body %q; want %q", bodies[0], delivery.Payload)
+	}
+
+	headers := map[string]string{
+		"Content-Type":        "application/json",
+		"User-Agent":          "tasksync-webhooks/" + version,
+		"X-Tasksync-Event":    "task.created",
+		"X-Tasksync-Delivery": delivery.ID.Hex(),
+	}
+	for name, want := range headers {
+		if got := r.Header.Get(name); got != want {
+			t.Errorf("got %s %q; want %q", name, got, want)
+		}
+	}
+
+	timestamp, signature, ok := strings.Cut(r.Header.Get("X-Tasksync-Signature"), ",v1=")
+	timestamp, found := strings.CutPrefix(timestamp, "t=")
+	if !ok || !found {
+		t.Fatalf("got malformed signature %q", r.Header.Get("X-Tasksync-Signature"))
+	}
+	if want := signWebhook(webhook.Secret, timestamp, []byte(bodies[0])); signature != want {
+		t.Errorf("got signature %s; want %s", signature, want)
+	}
+
+	got := getTestDelivery(t, app, delivery)
+	if got.Status != data.WebhookDeliverySucceeded || got.NextAttemptAt != nil {
+		t.Errorf("got status %s, next attempt %v; want succeeded with none", got.Status, got.NextAttemptAt)
+	}
+	if len(got.Attempts) != 1 || got.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("got attempts %+v; want a single 204", got.Attempts)
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	app := newTestApplication(t)
+
+	status := http.StatusInternalServerError
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	webhook := insertTestWebhook(t, app, srv.URL)
+	delivery := insertTestDelivery(t, app, webhook, 1)
+
+	// retry makes the delivery due again rather than waiting out its backoff.
+	retry := func() {
+		got := getTestDelivery(t, app, delivery)
+		now := time.Now().UTC()
+		got.NextAttemptAt = &now
+		if err := app.models.Deliveries.Update(context.Background(), got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i < webhookMaxAttempts; i++ {
+		dispatch(app)
+
+		got := getTestDelivery(t, app, delivery)
+		if got.Status != data.WebhookDeliveryPending || len(got.Attempts) != i {
+			t.Fatalf("attempt %d: got status %s with %d attempts", i, got.Status, len(got.Attempts))
+		}
+
+		last := got.Attempts[i-1]
+		if last.StatusCode != status {
+			t.Errorf("attempt %d: got status code %d; want %d", i, last.StatusCode, status)
+		}
+		wantDelay := webhookRetryDelay << (i - 1)
+		if got.NextAttemptAt == nil || !got.NextAttemptAt.Equal(last.At.Add(wantDelay)) {
+			t.Errorf("attempt %d: got next attempt %v; want %s after %v", i, got.NextAttemptAt, wantDelay, last.At)
+		}
+
+		// Nothing is attempted again before the backoff is up.
+		dispatch(app)
+		if requests != i {
+			t.Fatalf("attempt %d: got %d requests during backoff", i, requests)
+		}
+
+		retry()
+	}
+
+	dispatch(app)
+
+	got := getTestDelivery(t, app, delivery)
+	if got.Status != data.WebhookDeliveryFailed || got.NextAttemptAt != nil || len(got.Attempts) != webhookMaxAttempts {
+		t.Errorf("got status %s, next attempt %v with %d attempts; want failed after %d", got.Status, got.NextAttemptAt, len(got.Attempts), webhookMaxAttempts)
+	}
+
+	hook, err := app.models.Webhooks.Get(context.Background(), webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.FailureCount != webhookMaxAttempts || !hook.Active {
+		t.Errorf("got failure count %d, active %t; want %d and still active", hook.FailureCount, hook.Active, webhookMaxAttempts)
+	}
+
+	// A later success resets the webhook's failures.
+	status = http.StatusOK
+	delivery = insertTestDelivery(t, app, webhook, 2)
+	dispatch(app)
+
+	if got := getTestDelivery(t, app, delivery); got.Status != data.WebhookDeliverySucceeded {
+		t.Errorf("got status %s; want succeeded", got.Status)
+	}
+	hook, err = app.models.Webhooks.Get(context.Background(), webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.FailureCount != 0 {
+		t.Errorf("got failure count %d after a success; want 0", hook.FailureCount)
+	}
+}
+
+// TestWebhookDeliveriesClaimedOnce runs two workers against the same
+// database, each claiming a batch of deliveries at once, and checks that
+// every delivery is posted exactly once.
+func TestWebhookDeliveriesClaimedOnce(t *testing.T) {
+	app := newTestApplication(t)
+	other := &application{
+		logger:        app.logger,
+		models:        app.models,
+		webhookClient: app.webhookClient,
+		webhookWake:   make(chan struct{}, 1),
+	}
+
+	var mu sync.Mutex
+	posts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts[r.Header.Get("X-Tasksync-Delivery")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	webhook := insertTestWebhook(t, app, srv.URL)
+
+	var deliveries []*data.WebhookDelivery
+	for i := 0; i < 3*webhookConcurrency; i++ {
+		deliveries = append(deliveries, insertTestDelivery(t, app, webhook, int64(i+1)))
+	}
+
+	for i := 0; i < len(deliveries); i++ {
+		var wg sync.WaitGroup
+		for _, worker := range []*application{app, other} {
+			wg.Add(1)
+			go func(worker *application) {
+				defer wg.Done()
+				dispatch(worker)
+			}(worker)
+		}
+		wg.Wait()
+
+		mu.Lock()
+		done := len(posts) == len(deliveries)
+		mu.Unlock()
+		if done {
+			break
+		}
+	}
+
+	for _, delivery := range deliveries {
+		if n := posts[delivery.ID.Hex()]; n != 1 {
+			t.Errorf("delivery %s was posted %d times; want 1", delivery.ID.Hex(), n)
+		}
+		if got := getTestDelivery(t, app, delivery); got.Status != data.WebhookDeliverySucceeded || len(got.Attempts) != 1 {
+			t.Errorf("delivery %s: got status %s with %d attempts; want succeeded with 1", delivery.ID.Hex(), got.Status, len(got.Attempts))
+		}
+	}
+	if len(posts) != len(deliveries) {
+		t.Errorf("got posts for %d deliveries; want %d", len(posts), len(deliveries))
+	}
+}
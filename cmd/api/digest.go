@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+const (
+	// How often to look for users whose digest is due. Digests go out on
+	// the hour, so they are at most this late.
+	digestPollInterval = time.Minute
+
+	// Digests sent at once by each poll, and the most notifications and
+	// tasks listed in each.
+	digestBatchSize = 20
+	digestMaxItems  = 20
+
+	// Only the newest digest's unsubscribe link works, so it lasts a good
+	// while after the next digest would have replaced it.
+	unsubscribeTokenTTL = 30 * 24 * time.Hour
+)
+
+// digestTask is a task as a digest lists it, with its due date already
+// written out in the user's time zone.
+type digestTask struct {
+	Title string
+	Due   string
+}
+
+// runDigestWorker sends digests as they fall due until ctx is canceled. Each
+// is claimed before it is sent, so that only one instance sends it, and a
+// digest which fails to send isn't retried.
+func (app *application) runDigestWorker(ctx context.Context) {
+	ticker := time.NewTicker(digestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		app.dispatchDigests(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) dispatchDigests(ctx context.Context) {
+	now := time.Now().UTC()
+
+	due, err := app.models.Preferences.DueDigests(ctx, now, digestBatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.logger.PrintError(err, map[string]string{"component": "digest worker"})
+		}
+		return
+	}
+
+	for _, prefs := range due {
+		lastDigestAt := prefs.LastDigestAt
+		prefs.ScheduleDigest(now)
+
+		err := app.models.Preferences.ClaimDigest(ctx, prefs, now)
+		if err != nil {
+			// Another instance claimed it first.
+			if errors.Is(err, data.ErrEditConflict) {
+				continue
+			}
+			if ctx.Err() == nil {
+				app.logger.PrintError(err, map[string]string{"component": "digest worker"})
+			}
+			return
+		}
+
+		prefs.LastDigestAt = lastDigestAt
+		err = app.sendDigest(ctx, prefs, now)
+		if err != nil && ctx.Err() == nil {
+			app.logger.PrintError(err, map[string]string{"component": "digest worker", "user_id": prefs.UserID.Hex()})
+		}
+	}
+}
+
+// sendDigest emails the user the notifications they have received since
+// their last digest and haven't read yet, along with their unfinished tasks
+// which are overdue or due today in their time zone. Nothing is sent if
+// there's nothing to tell them.
+func (app *application) sendDigest(ctx context.Context, prefs *data.NotificationPreferences, now time.Time) error {
+	user, err := app.models.Users.GetByID(ctx, prefs.UserID)
+	if err != nil {
+		return err
+	}
+
+	unread, err := app.models.Notifications.ForUser(ctx, user.ID, primitive.NilObjectID, true, digestMaxItems)
+	if err != nil {
+		return err
+	}
+	unreadCount, err := app.models.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	actorNames := map[primitive.ObjectID]string{primitive.NilObjectID: "Someone"}
+	var notifications []string
+	for _, n := range unread {
+		if prefs.LastDigestAt != nil && !n.CreatedAt.After(*prefs.LastDigestAt) {
+			continue
+		}
+		if _, ok := actorNames[n.ActorID]; !ok {
+			actorNames[n.ActorID] = "Someone"
+			actor, err := app.models.Users.GetByID(ctx, n.ActorID)
+			switch {
+			case err == nil:
+				actorNames[n.ActorID] = actor.Name
+			case !errors.Is(err, data.ErrRecordNotFound):
+				return err
+			}
+		}
+		notifications = append(notifications, fmt.Sprintf("%s %s %q", actorNames[n.ActorID], notificationActions[n.Type], n.TaskTitle))
+	}
+
+	loc := prefs.Location()
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	tomorrow := today.AddDate(0, 0, 1)
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	filters := data.Filters{
+		AssigneeIDs:     []primitive.ObjectID{user.ID},
+		ExcludeStatuses: []string{data.TaskStatusDone},
+	}
+	filters.ProjectIDs, err = app.models.Projects.IDsForWorkspaces(ctx, workspaceIDs)
+	if err != nil {
+		return err
+	}
+
+	// Overdue and due today are listed separately, each soonest first, so
+	// that a long tail of overdue tasks can't crowd out today's.
+	filters.DueBefore = &today
+	tasks, err := app.models.Tasks.ListByDue(ctx, filters, digestMaxItems)
+	if err != nil {
+		return err
+	}
+	var overdue []digestTask
+	for _, task := range tasks {
+		overdue = append(overdue, digestTask{Title: task.Title, Due: task.DueAt.In(loc).Format("Mon 2 Jan")})
+	}
+
+	filters.DueAfter, filters.DueBefore = &today, &tomorrow
+	tasks, err = app.models.Tasks.ListByDue(ctx, filters, digestMaxItems)
+	if err != nil {
+		return err
+	}
+	var dueToday []digestTask
+	for _, task := range tasks {
+		dueToday = append(dueToday, digestTask{Title: task.Title, Due: task.DueAt.In(loc).Format("15:04")})
+	}
+
+	if len(notifications) == 0 && len(overdue) == 0 && len(dueToday) == 0 {
+		return nil
+	}
+
+	// A new digest replaces the last one's link.
+	err = app.models.Tokens.DeleteAllForUser(ctx, data.ScopeUnsubscribe, user.ID)
+	if err != nil {
+		return err
+	}
+	token, err := app.models.Tokens.New(ctx, user.ID, unsubscribeTokenTTL, data.ScopeUnsubscribe)
+	if err != nil {
+		return err
+	}
+
+	digest := map[string]interface{}{
+		"name":           user.Name,
+		"date":           local.Format("Monday 2 January"),
+		"notifications":  notifications,
+		"unreadCount":    unreadCount,
+		"overdue":        overdue,
+		"dueToday":       dueToday,
+		"unsubscribeURL": app.config.baseURL + "/v1/notifications/unsubscribe/" + token.PlainToken,
+	}
+
+	return app.mailer.Send(user.Email, "digest.tmpl.html", digest)
+}
+
+// unsubscribePage asks the user to confirm, so that mail scanners and link
+// previews which follow the digest's link don't unsubscribe them.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!doctype html>
+<html>
+<head>
+    <meta name="viewport" content="width=device-width"/>
+    <meta http-equiv="Content-Type" content="text/html; charset=UTF-8"/>
+    <title>Unsubscribe from TaskSync digests</title>
+</head>
+
+<body>
+    <p>Stop receiving your TaskSync digest emails?</p>
+    <form method="post" action="{{.}}">
+        <button type="submit">Unsubscribe</button>
+    </form>
+</body>
+</html>
+`))
+
+// showUnsubscribeHandler shows the page the link at the foot of every digest
+// opens, which asks the user to confirm before unsubscribeDigestHandler turns
+// the digest off. Showing it changes nothing.
+func (app *application) showUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.unsubscribeUserID(w, r); !ok {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err := unsubscribePage.Execute(buf, r.URL.Path)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// unsubscribeDigestHandler turns off the digest of the user whose token is
+// in the URL, either from the confirmation page or straight from a mail
+// client's one-click unsubscribe (RFC 8058). There is no session: the token
+// is the only credential, so that it works from the email. Posting it again
+// changes nothing.
+func (app *application) unsubscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.unsubscribeUserID(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := app.models.Preferences.Get(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if prefs.Digest {
+		prefs.Digest = false
+		prefs.ScheduleDigest(time.Now())
+
+		err = app.models.Preferences.Put(r.Context(), prefs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you will no longer receive digest emails"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unsubscribeUserID returns the id of the user whose unsubscribe token is in
+// the URL. If the token isn't valid it responds with a 404 and returns false.
+func (app *application) unsubscribeUserID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return primitive.NilObjectID, false
+	}
+
+	userID, err := app.models.Tokens.GetUserIDForToken(r.Context(), data.ScopeUnsubscribe, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tasksync/internal/data"
+)
+
+// TestUnsubscribeDigest checks that following a digest's unsubscribe link
+// only asks for confirmation, and that posting to it turns the digest off.
+func TestUnsubscribeDigest(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "Digest", "digest@example.com")
+	prefs := data.NewNotificationPreferences(user.ID)
+	prefs.Digest = true
+	prefs.ScheduleDigest(time.Now())
+	if err := app.models.Preferences.Put(ctx, prefs); err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.models.Tokens.New(ctx, user.ID, unsubscribeTokenTTL, data.ScopeUnsubscribe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		token  string
+		status int
+		digest bool
+	}{
+		{http.MethodGet, strings.Repeat("A", len(token.PlainToken)), http.StatusNotFound, true},
+		{http.MethodGet, token.PlainToken, http.StatusOK, true},
+		{http.MethodPost, token.PlainToken, http.StatusOK, false},
+		{http.MethodPost, token.PlainToken, http.StatusOK, false},
+	}
+
+	for i, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/v1/notifications/unsubscribe/"+tt.token, strings.NewReader("List-Unsubscribe=One-Click"))
+		r = withParams(r, "token", tt.token)
+		w := httptest.NewRecorder()
+		if tt.method == http.MethodGet {
+			app.showUnsubscribeHandler(w, r)
+		} else {
+			app.unsubscribeDigestHandler(w, r)
+		}
+		if w.Code != tt.status {
+			t.Errorf("request %d: got status %d; want %d: %s", i, w.Code, tt.status, w.Body)
+		}
+
+		prefs, err := app.models.Preferences.Get(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefs.Digest != tt.digest {
+			t.Errorf("request %d: got digest %t; want %t", i, prefs.Digest, tt.digest)
+		}
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"tasksync/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+
+	// A query canceled because the client went away or the server is
+	// shutting down is expected, so it doesn't warrant an error and trace.
+	if errors.Is(err, data.ErrCanceled) {
+		app.logger.PrintInfo(err.Error(), properties)
+		return
+	}
+
+	app.logger.PrintError(err, properties)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
@@ -70,3 +82,13 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	message := "your user account must be activated to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
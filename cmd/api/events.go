@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+)
+
+// publishProject announces a project change made through this instance to
+// everyone subscribed to its workspace and to its webhooks. Deleting a
+// project also deletes its tasks, but only the project.deleted event is sent
+// for them. Their attachments are removed here.
+func (app *application) publishProject(project *data.Project, eventType string) {
+	app.publish(projectEvent(project, eventType))
+
+	if eventType == events.ProjectDeleted {
+		app.deleteAttachments(func(ctx context.Context) ([]*data.Attachment, error) {
+			return app.models.Attachments.ForProject(ctx, project.ID)
+		})
+	}
+}
+
+// publishTask is publishProject for tasks, which also notifies the task's
+// watchers of updates and deletions and removes a deleted task's
+// attachments. Failing to find the task's workspace is only logged, since
+// the change itself has already been saved and will reach clients through
+// /v1/sync regardless.
+func (app *application) publishTask(ctx context.Context, task *data.Task, eventType string) {
+	event, ok := app.taskEvent(ctx, task, eventType)
+	if ok {
+		app.publish(event)
+	}
+
+	switch eventType {
+	case events.TaskUpdated:
+		app.notifyWatchers(ctx, task, data.NotificationTaskUpdated)
+	case events.TaskDeleted:
+		app.notifyWatchers(ctx, task, data.NotificationTaskDeleted)
+		app.deleteAttachments(func(ctx context.Context) ([]*data.Attachment, error) {
+			return app.models.Attachments.ForTask(ctx, task.ID)
+		})
+	}
+}
+
+// publish hands an event to the local subscribers and queues it for the
+// workspace's webhooks. Only the instance which made a change publishes it
+// this way, so that each webhook gets one delivery however many instances
+// see the change.
+func (app *application) publish(event events.Event) {
+	app.hub.Publish(event)
+	app.queueWebhookDeliveries(event)
+}
+
+func projectEvent(project *data.Project, eventType string) events.Event {
+	return events.Event{
+		ID:          project.Seq,
+		Type:        eventType,
+		WorkspaceID: project.WorkspaceID,
+		Time:        project.UpdatedAt,
+		Data:        project,
+	}
+}
+
+func (app *application) taskEvent(ctx context.Context, task *data.Task, eventType string) (events.Event, bool) {
+	project, err := app.models.Projects.Get(ctx, task.ProjectID)
+	if err != nil {
+		// Tasks deleted along with their project are covered by the
+		// project.deleted event.
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.PrintError(err, map[string]string{
+				"event":   eventType,
+				"task_id": task.ID.Hex(),
+			})
+		}
+		return events.Event{}, false
+	}
+
+	return events.Event{
+		ID:          task.Seq,
+		Type:        eventType,
+		WorkspaceID: project.WorkspaceID,
+		Time:        task.UpdatedAt,
+		Data:        task,
+	}, true
+}
+
+// runChangeFeed republishes the writes made through every instance into the
+// hub until ctx is canceled, restarting the feed after a growing delay if it
+// fails. Writes made through this instance have already been published, and
+// the hub drops them when they come round again.
+func (app *application) runChangeFeed(ctx context.Context) {
+	delay := time.Second
+
+	for {
+		started := time.Now()
+		err := app.feed.Run(ctx, func(change data.Change) {
+			app.publishChange(ctx, change)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > time.Minute {
+			delay = time.Second
+		}
+		app.logger.PrintError(err, map[string]string{
+			"component": "change feed",
+			"retry_in":  delay.String(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, time.Minute)
+	}
+}
+
+// publishChange passes a change from the feed on to the local subscribers.
+// Its webhooks were seen to by the instance which made it.
+func (app *application) publishChange(ctx context.Context, change data.Change) {
+	switch {
+	case change.Project != nil:
+		project := change.Project
+		eventType := events.ProjectUpdated
+		switch {
+		case project.Deleted:
+			eventType = events.ProjectDeleted
+		case project.Version == 1:
+			eventType = events.ProjectCreated
+		}
+		app.hub.Publish(projectEvent(project, eventType))
+
+	case change.Task != nil:
+		task := change.Task
+		eventType := events.TaskUpdated
+		switch {
+		case task.Deleted:
+			eventType = events.TaskDeleted
+		case task.Version == 1:
+			eventType = events.TaskCreated
+		}
+		if event, ok := app.taskEvent(ctx, task, eventType); ok {
+			app.hub.Publish(event)
+		}
+	}
+}
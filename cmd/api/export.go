@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+const (
+	exportPageSize = 500
+
+	// exportWriteWait bounds writing each page of an export, in place of the
+	// server's WriteTimeout, which a large export could easily outlast.
+	exportWriteWait = 30 * time.Second
+)
+
+var exportColumns = []string{"id", "project_id", "title", "description", "status", "priority", "due_at", "recurrence", "created_at", "updated_at"}
+
+// exportTasksHandler streams every task matching the filters in the query
+// string, in the format given by the format parameter: csv, json (the
+// default) or ndjson. The tasks are read a page at a time, so an export of
+// any size is never held in memory. Should a query fail part way through,
+// the response is cut short, leaving a truncated document behind.
+func (app *application) exportTasksHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	format := qs.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	v.Check(validator.In(format, "csv", "json", "ndjson"), "format", "must be one of csv, json or ndjson")
+
+	filters, err := app.readTaskFilters(r, qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read the first page before committing to a response, so that a
+	// failing query can still be reported properly.
+	tasks, err := app.models.Tasks.List(r.Context(), filters, primitive.NilObjectID, exportPageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var (
+		tw          taskWriter
+		contentType string
+	)
+	switch format {
+	case "csv":
+		tw, contentType = newCSVTaskWriter(w), "text/csv; charset=utf-8"
+	case "ndjson":
+		tw, contentType = &ndjsonTaskWriter{enc: json.NewEncoder(w)}, "application/x-ndjson"
+	default:
+		tw, contentType = &jsonTaskWriter{w: w}, "application/json"
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		if err := rc.SetWriteDeadline(time.Now().Add(exportWriteWait)); err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		for _, task := range tasks {
+			if err := tw.Write(task); err != nil {
+				return
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return
+		}
+		if len(tasks) < exportPageSize {
+			break
+		}
+
+		tasks, err = app.models.Tasks.List(r.Context(), filters, tasks[len(tasks)-1].ID, exportPageSize)
+		if err != nil {
+			if !errors.Is(err, data.ErrCanceled) {
+				app.logError(r, err)
+			}
+			return
+		}
+	}
+
+	if err := tw.Close(); err == nil {
+		rc.Flush()
+	}
+}
+
+// readTaskFilters reads the task filters shared by the endpoints which list
+// tasks from the query string: project_id, along with status, priority,
+// assignee and label, which take comma-separated values, and due_after and
+// due_before. An assignee of me stands for the user.
+// Problems with the parameters are recorded in v. The filters are always
+// limited to the projects the user can see.
+func (app *application) readTaskFilters(r *http.Request, qs url.Values, v *validator.Validator) (data.Filters, error) {
+	var filters data.Filters
+
+	filters.Statuses = app.readCSV(qs, "status", nil)
+	for _, status := range filters.Statuses {
+		v.Check(validator.In(status, data.TaskStatuses...), "status", "must only contain todo, in_progress or done")
+	}
+
+	for _, s := range app.readCSV(qs, "priority", nil) {
+		priority, ok := data.ParseTaskPriority(s)
+		v.Check(ok && priority >= data.TaskPriorityNone && priority <= data.TaskPriorityHigh, "priority", "must only contain 0-3 or none, low, medium or high")
+		filters.Priorities = append(filters.Priorities, priority)
+	}
+
+	for key, dst := range map[string]**time.Time{"due_after": &filters.DueAfter, "due_before": &filters.DueBefore} {
+		if s := qs.Get(key); s != "" {
+			t, err := parseTaskTime(s)
+			if err != nil {
+				v.AddError(key, "must be an RFC 3339 time or a date")
+				continue
+			}
+			*dst = &t
+		}
+	}
+
+	user := app.contextGetUser(r)
+
+	for _, s := range app.readCSV(qs, "assignee", nil) {
+		id := user.ID
+		if s != "me" {
+			var err error
+			id, err = primitive.ObjectIDFromHex(s)
+			if err != nil {
+				v.AddError("assignee", "must only contain me or user ids")
+				break
+			}
+		}
+		filters.AssigneeIDs = append(filters.AssigneeIDs, id)
+	}
+
+	for _, s := range app.readCSV(qs, "label", nil) {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("label", "must only contain label ids")
+			break
+		}
+		filters.LabelIDs = append(filters.LabelIDs, id)
+	}
+
+	if s := qs.Get("project_id"); s != "" {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("project_id", "must be a valid id")
+			return filters, nil
+		}
+
+		_, err = app.memberProject(r.Context(), user, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("project_id", "must be an existing project")
+				return filters, nil
+			default:
+				return filters, err
+			}
+		}
+		filters.ProjectIDs = []primitive.ObjectID{id}
+		return filters, nil
+	}
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+	if err != nil {
+		return filters, err
+	}
+	filters.ProjectIDs, err = app.models.Projects.IDsForWorkspaces(r.Context(), workspaceIDs)
+	return filters, err
+}
+
+// parseTaskTime reads a time given either in RFC 3339 form or as a plain
+// date, as spreadsheets tend to write them, which is taken as midnight UTC.
+func parseTaskTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	return t.UTC(), err
+}
+
+// taskWriter encodes a stream of tasks in one of the export formats. Flush
+// sends anything buffered on to the client, and Close finishes the output.
+type taskWriter interface {
+	Write(task *data.Task) error
+	Flush() error
+	Close() error
+}
+
+type csvTaskWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVTaskWriter(w io.Writer) *csvTaskWriter {
+	return &csvTaskWriter{w: csv.NewWriter(w)}
+}
+
+func (tw *csvTaskWriter) Write(task *data.Task) error {
+	if !tw.header {
+		tw.header = true
+		if err := tw.w.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+
+	var dueAt string
+	if task.DueAt != nil {
+		dueAt = task.DueAt.UTC().Format(time.RFC3339)
+	}
+
+	return tw.w.Write([]string{
+		task.ID.Hex(),
+		task.ProjectID.Hex(),
+		task.Title,
+		task.Description,
+		task.Status,
+		strconv.Itoa(int(task.Priority)),
+		dueAt,
+		task.Recurrence,
+		task.CreatedAt.UTC().Format(time.RFC3339),
+		task.UpdatedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (tw *csvTaskWriter) Flush() error {
+	tw.w.Flush()
+	return tw.w.Error()
+}
+
+// Close writes the header even when there were no tasks, so that an empty
+// export is still a well-formed file.
+func (tw *csvTaskWriter) Close() error {
+	if !tw.header {
+		tw.header = true
+		if err := tw.w.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// jsonTaskWriter writes the tasks as a single {"tasks": [...]} document,
+// in the same envelope as the other endpoints.
+type jsonTaskWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (tw *jsonTaskWriter) Write(task *data.Task) error {
+	js, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	prefix := ","
+	if !tw.started {
+		tw.started = true
+		prefix = `{"tasks":[`
+	}
+	_, err = io.WriteString(tw.w, prefix+string(js))
+	return err
+}
+
+func (tw *jsonTaskWriter) Flush() error {
+	return nil
+}
+
+func (tw *jsonTaskWriter) Close() error {
+	suffix := "]}\n"
+	if !tw.started {
+		suffix = `{"tasks":[]}` + "\n"
+	}
+	_, err := io.WriteString(tw.w, suffix)
+	return err
+}
+
+type ndjsonTaskWriter struct {
+	enc *json.Encoder
+}
+
+func (tw *ndjsonTaskWriter) Write(task *data.Task) error {
+	return tw.enc.Encode(task)
+}
+
+func (tw *ndjsonTaskWriter) Flush() error {
+	return nil
+}
+
+func (tw *ndjsonTaskWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const (
+	maxImportSize = 10 << 20
+	maxImportRows = 10_000
+
+	// Imports of more rows than importInlineRows run in the background,
+	// saving their progress every importProgressInterval rows.
+	importInlineRows       = 200
+	importProgressInterval = 100
+
+	// maxImportRowErrors caps the row errors reported for a file, which
+	// could otherwise run to one for every row.
+	maxImportRowErrors = 100
+
+	// importReadWait bounds reading an upload, in place of the server's
+	// ReadTimeout, which is too short for a large file on a slow link.
+	importReadWait = time.Minute
+)
+
+// importFields are the task fields which can be read from a CSV column.
+// They share their names with the exported columns, so an export can be
+// imported again without a mapping.
+var importFields = []string{"project_id", "title", "description", "status", "priority", "due_at", "recurrence"}
+
+// importRowError reports the problems with one entry of an import: a row
+// of a CSV file, or a component of a calendar, which is also identified by
+// its UID when it has one.
+type importRowError struct {
+	Row    int               `json:"row"`
+	UID    string            `json:"uid,omitempty"`
+	Errors map[string]string `json:"errors"`
+}
+
+// importTasksHandler creates tasks from the rows of a CSV file, uploaded as
+// the file field of a multipart form. The form may also hold:
+//
+//   - mapping, a JSON object naming the column to read each task field
+//     from, such as {"title": "Summary"}. Without it, columns named after
+//     the fields are used.
+//   - project_id, the project for rows which don't name their own.
+//   - dry_run, which when true only checks the file.
+//
+// Every row is checked before anything is created, and if any of them are
+// invalid nothing is imported; the errors are reported by row, counting
+// the header as row 1 just as a spreadsheet would. Small files are imported
+// straight away, while larger ones are handed to a background job whose
+// progress can be followed at /v1/tasks/import/:id.
+func (app *application) importTasksHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.readImportForm(w, r, "CSV") {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	var mapping map[string]string
+	if s := r.FormValue("mapping"); s != "" {
+		err := json.Unmarshal([]byte(s), &mapping)
+		v.Check(err == nil, "mapping", "must be a JSON object of task fields and column names")
+	}
+
+	dryRun := readImportDryRun(r, v)
+
+	var (
+		projectID primitive.ObjectID
+		err       error
+	)
+	if s := r.FormValue("project_id"); s != "" {
+		projectID, err = primitive.ObjectIDFromHex(s)
+		v.Check(err == nil, "project_id", "must be a valid id")
+	}
+
+	var records [][]string
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		v.AddError("file", "must be provided")
+	} else {
+		defer file.Close()
+		records, err = readImportCSV(file)
+		if err != nil {
+			v.AddError("file", err.Error())
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	columns := importColumns(v, records[0], mapping)
+	_, mapsProject := columns["project_id"]
+	v.Check(!projectID.IsZero() || mapsProject, "project_id", "must be provided unless the file has a project_id column")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	records = records[1:]
+
+	cost := (len(records) + batchOperationsPerToken - 1) / batchOperationsPerToken
+	if !app.chargeRateLimit(w, r, cost-1) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Whether each project named in the file is one the user can see.
+	projects := make(map[primitive.ObjectID]bool)
+
+	if !projectID.IsZero() {
+		ok, err := app.visibleImportProject(r.Context(), user, projectID, projects)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			v.AddError("project_id", "must be an existing project")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	tasks := make([]*data.Task, 0, len(records))
+	rowErrors := []importRowError{}
+	invalid := 0
+
+	for i, record := range records {
+		task, errs, err := app.parseImportRow(r.Context(), user, record, columns, projectID, projects)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if errs != nil {
+			invalid++
+			if len(rowErrors) < maxImportRowErrors {
+				rowErrors = append(rowErrors, importRowError{Row: i + 2, Errors: errs})
+			}
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+
+	app.finishImport(w, r, tasks, len(records), invalid, rowErrors, dryRun)
+}
+
+// readImportForm reads an uploaded multipart form of up to maxImportSize
+// bytes, allowing longer than usual to receive it. If the form can't be
+// read it sends an error response, naming the kind of file expected, and
+// returns false.
+func (app *application) readImportForm(w http.ResponseWriter, r *http.Request, kind string) bool {
+	err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(importReadWait))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err = r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxImportSize))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form with the %s in a file field", kind))
+		}
+		return false
+	}
+	return true
+}
+
+func readImportDryRun(r *http.Request, v *validator.Validator) bool {
+	s := r.FormValue("dry_run")
+	if s == "" {
+		return false
+	}
+	dryRun, err := strconv.ParseBool(s)
+	v.Check(err == nil, "dry_run", "must be true or false")
+	return dryRun
+}
+
+// finishImport completes an import once every entry in the file has been
+// read: rows in all, of which invalid couldn't be made into tasks. A dry
+// run only reports what was found, as does a file with invalid entries,
+// since nothing is imported unless everything can be. Otherwise small
+// imports are done straight away and larger ones handed to a background
+// job.
+//
+// A small import is done in a transaction, so a failure part-way imports
+// nothing. Without transactions, on a standalone MongoDB server say, the
+// tasks created before the failure are kept, and the error response says
+// how many there were. A background job likewise keeps what it created
+// before failing.
+func (app *application) finishImport(w http.ResponseWriter, r *http.Request, tasks []*data.Task, rows, invalid int, rowErrors []importRowError, dryRun bool) {
+	report := envelope{
+		"rows":         rows,
+		"invalid_rows": invalid,
+		"errors":       rowErrors,
+	}
+
+	var err error
+	switch {
+	case dryRun:
+		report["dry_run"] = true
+		err = app.writeJSON(w, http.StatusOK, report, nil)
+
+	case invalid > 0:
+		report["error"] = "the file has invalid rows, so nothing was imported"
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, report, nil)
+
+	case len(tasks) <= importInlineRows:
+		var inserted int
+
+		atomic := app.models.SupportsTransactions(r.Context())
+		err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+			// The function may be retried, so start afresh each time.
+			inserted = 0
+			for _, task := range tasks {
+				err := tx.Tasks.Insert(ctx, task)
+				if err != nil {
+					return err
+				}
+				inserted++
+			}
+			return nil
+		})
+		if err == nil || !atomic {
+			for _, task := range tasks[:inserted] {
+				app.publishTask(r.Context(), task, events.TaskCreated)
+			}
+		}
+		if err != nil {
+			switch {
+			case !atomic && inserted > 0:
+				app.logError(r, err)
+				message := fmt.Sprintf("the server encountered a problem after importing %d of %d tasks, which have been kept", inserted, len(tasks))
+				app.errorResponse(w, r, http.StatusInternalServerError, message)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{"imported": len(tasks), "tasks": tasks}, nil)
+
+	default:
+		job := &data.ImportJob{UserID: app.contextGetUser(r).ID, Total: len(tasks)}
+		err = app.models.Imports.Insert(r.Context(), job)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// The job carries on changing in the background, so it gets its own
+		// copy.
+		running := *job
+		app.background(func() {
+			app.runImportJob(&running, tasks)
+		})
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("/v1/tasks/import/%s", job.ID.Hex()))
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"job": job}, headers)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showImportJobHandler reports the progress of one of the user's background
+// imports.
+func (app *application) showImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Imports.Get(r.Context(), id)
+	if err == nil && job.UserID != app.contextGetUser(r).ID {
+		err = data.ErrRecordNotFound
+	}
+
+	// A job abandoned part-way, by a restart say, would otherwise be shown
+	// as unfinished until it expired. Should it have saved its progress in
+	// the meantime it isn't abandoned after all, so is looked up again.
+	if err == nil && job.Stale(time.Now()) {
+		job.Status = data.ImportJobFailed
+		job.Error = fmt.Sprintf("the import was interrupted after at least %d of %d tasks", job.Processed, job.Total)
+		err = app.models.Imports.Update(r.Context(), job)
+		if errors.Is(err, data.ErrEditConflict) {
+			job, err = app.models.Imports.Get(r.Context(), id)
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runImportJob creates the tasks of a background import. It stops at the
+// first task which can't be saved, marking the job failed; the tasks saved
+// before then are kept. It also stops if the job has been given up on as
+// abandoned, which only happens if it fails to save its progress for long.
+func (app *application) runImportJob(job *data.ImportJob, tasks []*data.Task) {
+	ctx := context.Background()
+	properties := map[string]string{"import_job_id": job.ID.Hex()}
+
+	job.Status = data.ImportJobRunning
+	err := app.models.Imports.Update(ctx, job)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+
+	for i, task := range tasks {
+		err := app.models.Tasks.Insert(ctx, task)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+			job.Status = data.ImportJobFailed
+			job.Error = fmt.Sprintf("the import stopped after %d of %d tasks because of a server error", i, len(tasks))
+			break
+		}
+		app.publishTask(ctx, task, events.TaskCreated)
+
+		job.Processed = i + 1
+		if job.Processed%importProgressInterval == 0 && job.Processed < job.Total {
+			err := app.models.Imports.Update(ctx, job)
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return
+			case err != nil:
+				app.logger.PrintError(err, properties)
+			}
+		}
+	}
+
+	if job.Status == data.ImportJobRunning {
+		job.Status = data.ImportJobSucceeded
+	}
+	err = app.models.Imports.Update(ctx, job)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+// readImportCSV reads every record of an uploaded file, header included.
+// Rows may have fewer cells than the header, the missing ones being empty.
+func readImportCSV(file io.Reader) ([][]string, error) {
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+
+	var records [][]string
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("must be a valid CSV file (%s)", err)
+		}
+
+		records = append(records, record)
+		if len(records) > maxImportRows+1 {
+			return nil, fmt.Errorf("must not contain more than %d rows", maxImportRows)
+		}
+	}
+
+	switch {
+	case len(records) == 0:
+		return nil, errors.New("must contain a header row")
+	case len(records) == 1:
+		return nil, errors.New("must contain at least one row after the header")
+	}
+
+	// Spreadsheet programs like to start their CSV files with a byte order
+	// mark.
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	return records, nil
+}
+
+// importColumns works out which column of the header each task field is
+// read from, recording any problem with the mapping in v.
+func importColumns(v *validator.Validator, header []string, mapping map[string]string) map[string]int {
+	columns := make(map[string]int)
+
+	if mapping == nil {
+		for i, name := range header {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, exists := columns[name]; !exists && validator.In(name, importFields...) {
+				columns[name] = i
+			}
+		}
+		_, ok := columns["title"]
+		v.Check(ok, "mapping.title", "must be provided, as the file has no title column")
+		return columns
+	}
+
+	for field, name := range mapping {
+		if !validator.In(field, importFields...) {
+			v.AddError("mapping", "must only map project_id, title, description, status, priority, due_at or recurrence")
+			continue
+		}
+
+		found := false
+		for i := range header {
+			if strings.TrimSpace(header[i]) == strings.TrimSpace(name) {
+				columns[field], found = i, true
+				break
+			}
+		}
+		v.Check(found, "mapping."+field, "must name a column in the file")
+	}
+	if _, ok := mapping["title"]; !ok {
+		v.AddError("mapping.title", "must be provided")
+	}
+	return columns
+}
+
+// parseImportRow reads a task from a row of an import. If the row isn't
+// valid it returns the problems with it instead. Projects caches whether
+// the user can see each project the rows name.
+func (app *application) parseImportRow(ctx context.Context, user *data.User, record []string, columns map[string]int, projectID primitive.ObjectID, projects map[primitive.ObjectID]bool) (*data.Task, map[string]string, error) {
+	cell := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	task := &data.Task{
+		ProjectID:   projectID,
+		CreatedBy:   user.ID,
+		Title:       cell("title"),
+		Description: cell("description"),
+		Status:      data.TaskStatusTodo,
+		Recurrence:  strings.TrimPrefix(cell("recurrence"), "RRULE:"),
+	}
+
+	v := validator.New()
+
+	if s := cell("project_id"); s != "" {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("project_id", "must be a valid id")
+		}
+		task.ProjectID = id
+	}
+
+	// Statuses are matched loosely, so that "In progress" will do.
+	if s := cell("status"); s != "" {
+		task.Status = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(s))
+	}
+
+	if s := cell("priority"); s != "" {
+		priority, ok := data.ParseTaskPriority(s)
+		v.Check(ok, "priority", "must be 0-3 or one of none, low, medium or high")
+		task.Priority = priority
+	}
+
+	if s := cell("due_at"); s != "" {
+		dueAt, err := parseTaskTime(s)
+		if err != nil {
+			v.AddError("due_at", "must be an RFC 3339 time or a date")
+		} else {
+			task.DueAt = &dueAt
+		}
+	}
+
+	data.ValidateTask(v, task)
+
+	if _, exists := v.Errors["project_id"]; !exists {
+		ok, err := app.visibleImportProject(ctx, user, task.ProjectID, projects)
+		if err != nil {
+			return nil, nil, err
+		}
+		v.Check(ok, "project_id", "must be an existing project")
+	}
+
+	if !v.Valid() {
+		return nil, v.Errors, nil
+	}
+	return task, nil, nil
+}
+
+func (app *application) visibleImportProject(ctx context.Context, user *data.User, id primitive.ObjectID, projects map[primitive.ObjectID]bool) (bool, error) {
+	if ok, cached := projects[id]; cached {
+		return ok, nil
+	}
+
+	_, err := app.memberProject(ctx, user, id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return false, err
+	}
+
+	projects[id] = err == nil
+	return err == nil, nil
+}
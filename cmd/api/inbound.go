@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+func (app *application) listInboundHooksHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := app.readMemberProject(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := app.models.InboundHooks.ForProject(r.Context(), project.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inbound_hooks": hooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInboundHookHandler adds an inbound hook to a project the user can
+// see. The response carries the hook's secret URL, which can't be fetched
+// again later; a lost URL is replaced by rotating the token.
+func (app *application) createInboundHookHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := app.readMemberProject(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name     string            `json:"name"`
+		Template data.TaskTemplate `json:"template"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.InboundHook{
+		ProjectID: project.ID,
+		CreatedBy: app.contextGetUser(r).ID,
+		Name:      input.Name,
+		Template:  input.Template,
+	}
+
+	v := validator.New()
+	if data.ValidateInboundHook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.InboundHooks.Insert(r.Context(), hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"inbound_hook": hook, "url": inboundHookURL(hook)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateInboundHookHandler changes the hook's name or template, and gives
+// it a new token when rotate_token is set, which stops the old URL working
+// straight away.
+func (app *application) updateInboundHookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.memberInboundHook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string            `json:"name"`
+		Template    *data.TaskTemplate `json:"template"`
+		RotateToken bool               `json:"rotate_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		hook.Name = *input.Name
+	}
+	if input.Template != nil {
+		hook.Template = *input.Template
+	}
+
+	v := validator.New()
+	if data.ValidateInboundHook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.RotateToken {
+		err = hook.SetToken()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.InboundHooks.Update(r.Context(), hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"inbound_hook": hook}
+	if input.RotateToken {
+		env["url"] = inboundHookURL(hook)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteInboundHookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.memberInboundHook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.InboundHooks.Delete(r.Context(), hook.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "inbound hook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// receiveInboundHookHandler creates a task from a JSON payload POSTed to a
+// hook's secret URL, with no user session; the task is attributed to
+// whoever created the hook. The idempotency key comes from the
+// Idempotency-Key header or, failing that, the hook's template. A payload
+// whose key has been seen before returns the task it created with a 200
+// rather than opening another.
+func (app *application) receiveInboundHookHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+	if data.ValidateInboundToken(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hook, err := app.models.InboundHooks.GetForToken(r.Context(), token)
+	if err == nil {
+		// Projects are only ever deleted as tombstones, so check that the
+		// hook's project is still live.
+		_, err = app.models.Projects.Get(r.Context(), hook.ProjectID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var payload interface{}
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	task := &data.Task{
+		ID:        primitive.NewObjectID(),
+		ProjectID: hook.ProjectID,
+		CreatedBy: hook.CreatedBy,
+		Status:    data.TaskStatusTodo,
+	}
+
+	key := hook.Template.Apply(v, task, payload)
+	if header := r.Header.Get("Idempotency-Key"); header != "" {
+		key = header
+		v.Check(len(key) <= 200, "idempotency_key", "must not be more than 200 bytes long")
+	}
+
+	if data.ValidateTask(v, task); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		if key != "" {
+			err := tx.InboundHooks.ClaimKey(ctx, hook.ID, key, task.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Tasks.Insert(ctx, task)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateKey):
+			app.duplicateInboundResponse(w, r, hook, key)
+		default:
+			// Without transactions the key may have been claimed for the
+			// task all the same.
+			if key != "" {
+				err := app.models.InboundHooks.ReleaseKey(context.WithoutCancel(r.Context()), hook.ID, key, task.ID)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.publishTask(r.Context(), task, events.TaskCreated)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// duplicateInboundResponse replies to a repeated payload with the task the
+// first one created, or a null task if that has since been deleted.
+func (app *application) duplicateInboundResponse(w http.ResponseWriter, r *http.Request, hook *data.InboundHook, key string) {
+	var task *data.Task
+
+	taskID, err := app.models.InboundHooks.TaskForKey(r.Context(), hook.ID, key)
+	if err == nil {
+		task, err = app.models.Tasks.Get(r.Context(), taskID)
+	}
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task, "duplicate": true}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMemberProject fetches the project named by the id URL parameter,
+// replying with a 404 unless the user belongs to its workspace. It reports
+// false when a response has already been sent.
+func (app *application) readMemberProject(w http.ResponseWriter, r *http.Request) (*data.Project, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	project, err := app.memberProject(r.Context(), app.contextGetUser(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return project, true
+}
+
+func (app *application) memberInboundHook(w http.ResponseWriter, r *http.Request) (*data.InboundHook, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	hook, err := app.models.InboundHooks.Get(r.Context(), id)
+	if err == nil {
+		_, err = app.memberProject(r.Context(), app.contextGetUser(r), hook.ProjectID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return hook, true
+}
+
+func inboundHookURL(hook *data.InboundHook) string {
+	return "/v1/inbound/" + hook.PlainToken
+}
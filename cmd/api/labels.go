@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+// listLabelsHandler returns the workspace's labels in order of name, each
+// with the number of tasks carrying it.
+func (app *application) listLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := app.memberWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	labels, err := app.models.Labels.ForWorkspace(r.Context(), workspace.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Labels.CountTasks(r.Context(), labels)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"labels": labels}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createLabelHandler adds a label to the workspace. Any member can.
+func (app *application) createLabelHandler(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := app.memberWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name  string  `json:"name"`
+		Color *string `json:"color"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	label := &data.Label{
+		WorkspaceID: workspace.ID,
+		Name:        input.Name,
+		Color:       data.DefaultLabelColor,
+	}
+	if input.Color != nil {
+		label.Color = *input.Color
+	}
+
+	others, err := app.models.Labels.ForWorkspace(r.Context(), workspace.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateLabel(v, label, others); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Labels.Insert(r.Context(), label)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			v.AddError("name", "is already used by another label in this workspace")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"label": label}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := app.labelParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Labels.CountTasks(r.Context(), []*data.Label{label})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"label": label}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLabelHandler renames or recolours a label. Tasks refer to labels
+// by id, so a rename reaches every task carrying the label at once.
+func (app *application) updateLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := app.labelParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name  *string `json:"name"`
+		Color *string `json:"color"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		label.Name = *input.Name
+	}
+	if input.Color != nil {
+		label.Color = *input.Color
+	}
+
+	others, err := app.models.Labels.ForWorkspace(r.Context(), label.WorkspaceID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateLabel(v, label, others); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Labels.Update(r.Context(), label)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateName):
+			v.AddError("name", "is already used by another label in this workspace")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Labels.CountTasks(r.Context(), []*data.Label{label})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"label": label}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteLabelHandler takes the label off every task carrying it and then
+// deletes it, all in one transaction where the database supports them; see
+// replaceLabel for where it doesn't.
+func (app *application) deleteLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := app.labelParam(w, r)
+	if !ok {
+		return
+	}
+
+	tasks, ok := app.replaceLabel(w, r, label, primitive.NilObjectID)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "label successfully deleted", "tasks_updated": len(tasks)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeLabelHandler merges the label into another of the workspace's
+// labels: every task carrying it carries the other label instead, and the
+// label is deleted. As with deleteLabelHandler, this happens in one
+// transaction where the database supports them.
+func (app *application) mergeLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label, ok := app.labelParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		LabelID string `json:"label_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	intoID, err := primitive.ObjectIDFromHex(input.LabelID)
+	if err != nil {
+		v.AddError("label_id", "must be a valid id")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	into, err := app.models.Labels.Get(r.Context(), intoID)
+	if err == nil && into.WorkspaceID != label.WorkspaceID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("label_id", "must be another label in the same workspace")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if v.Check(into.ID != label.ID, "label_id", "must be another label in the same workspace"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tasks, ok := app.replaceLabel(w, r, label, into.ID)
+	if !ok {
+		return
+	}
+
+	err = app.models.Labels.CountTasks(r.Context(), []*data.Label{into})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"label": into, "tasks_updated": len(tasks)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceLabel swaps the label for another on all of its tasks, or just
+// takes it off them when into is the zero id, and deletes it, announcing
+// each changed task. The tasks' watchers aren't notified, since nothing
+// about the work has changed. It reports false when a response has already
+// been sent.
+//
+// Without transactions, on a standalone MongoDB server say, this isn't
+// atomic: the tasks are changed one at a time, and a failure part-way
+// leaves those changed so far as they are, with the label still in place.
+// Those tasks are announced all the same, and the error response says the
+// request can be repeated to finish the job.
+func (app *application) replaceLabel(w http.ResponseWriter, r *http.Request, label *data.Label, into primitive.ObjectID) ([]*data.Task, bool) {
+	var tasks []*data.Task
+
+	atomic := app.models.SupportsTransactions(r.Context())
+	err := app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		var err error
+		tasks, err = tx.Tasks.ReplaceLabel(ctx, label.ID, into)
+		if err != nil {
+			return err
+		}
+		return tx.Labels.Delete(ctx, label.ID)
+	})
+	if err == nil || !atomic {
+		for _, task := range tasks {
+			event, ok := app.taskEvent(r.Context(), task, events.TaskUpdated)
+			if ok {
+				app.publish(event)
+			}
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case !atomic && len(tasks) > 0:
+			app.logError(r, err)
+			message := fmt.Sprintf("the server encountered a problem after updating %d of the label's tasks; repeat the request to finish", len(tasks))
+			app.errorResponse(w, r, http.StatusInternalServerError, message)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return tasks, true
+}
+
+// labelTaskHandler puts one of the workspace's labels on the task. Adding a
+// label the task already carries changes nothing.
+func (app *application) labelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		LabelID string `json:"label_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	labelID, err := primitive.ObjectIDFromHex(input.LabelID)
+	if err != nil {
+		v.AddError("label_id", "must be a valid id")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	project, err := app.models.Projects.Get(r.Context(), task.ProjectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	label, err := app.models.Labels.Get(r.Context(), labelID)
+	if err == nil && label.WorkspaceID != project.WorkspaceID {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("label_id", "must be a label in the task's workspace")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if task.HasLabel(label.ID) {
+		err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	task.LabelIDs = append(task.LabelIDs, label.ID)
+	if data.ValidateTask(v, task); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tasks.Update(r.Context(), task)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publishTask(r.Context(), task, events.TaskUpdated)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unlabelTaskHandler takes a label off the task.
+func (app *application) unlabelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := app.taskParam(w, r)
+	if !ok {
+		return
+	}
+
+	labelID, err := app.readIDParam(r, "label_id")
+	if err != nil || !task.HasLabel(labelID) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var labelIDs []primitive.ObjectID
+	for _, id := range task.LabelIDs {
+		if id != labelID {
+			labelIDs = append(labelIDs, id)
+		}
+	}
+	task.LabelIDs = labelIDs
+
+	err = app.models.Tasks.Update(r.Context(), task)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.publishTask(r.Context(), task, events.TaskUpdated)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"task": task}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// labelParam fetches the label named by the id URL parameter, replying with
+// a 404 unless the user belongs to its workspace. It reports false when a
+// response has already been sent.
+func (app *application) labelParam(w http.ResponseWriter, r *http.Request) (*data.Label, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	label, err := app.models.Labels.Get(r.Context(), id)
+	if err == nil {
+		var member bool
+		member, err = app.models.Workspaces.IsMember(r.Context(), label.WorkspaceID, app.contextGetUser(r).ID)
+		if err == nil && !member {
+			err = data.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return label, true
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCreateLabelPersonalWorkspace checks that labels can be added to a
+// personal workspace before it has been listed, and so stored.
+func TestCreateLabelPersonalWorkspace(t *testing.T) {
+	app := newTestApplication(t)
+	user := insertTestUser(t, app, "Labels", "labels@example.com")
+
+	for i, want := range []int{http.StatusCreated, http.StatusUnprocessableEntity} {
+		r := httptest.NewRequest(http.MethodPost, "/v1/workspaces/"+user.ID.Hex()+"/labels", strings.NewReader(`{"name": "Urgent"}`))
+		r = withParams(r, "id", user.ID.Hex())
+		w := httptest.NewRecorder()
+		app.createLabelHandler(w, app.contextSetUser(r, user))
+		if w.Code != want {
+			t.Errorf("request %d: got status %d; want %d: %s", i, w.Code, want, w.Body)
+		}
+	}
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -11,23 +17,31 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/blob"
 	"tasksync/internal/data"
+	"tasksync/internal/events"
 	"tasksync/internal/jsonlog"
 	"tasksync/internal/mailer"
+	"tasksync/internal/validator"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port    int
+	env     string
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  int
+		queryTimeout time.Duration
+		autoMigrate  bool
 	}
 
 	limiter struct {
@@ -45,6 +59,21 @@ type config struct {
     cors struct {
         trustedOrigins []string
     }
+	sync struct {
+		conflictPolicy string
+	}
+	events struct {
+		logSize      int
+		feedName     string
+		pollInterval time.Duration
+	}
+	attachments struct {
+		store      string
+		dir        string
+		quota      int64
+		signingKey string
+		s3         blob.S3Config
+	}
 }
 
 type application struct {
@@ -52,7 +81,13 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
+	hub    *events.Hub
+	feed   data.ChangeFeed
+	blobs  blob.BlobStore
 	wg     sync.WaitGroup
+
+	webhookClient *http.Client
+	webhookWake   chan struct{}
 }
 
 func main() {
@@ -66,9 +101,18 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:4000", "Public URL of the API, used for links in emails")
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&cfg.sync.conflictPolicy, "sync-conflict-policy", data.MergePolicyManual, "Default policy for conflicting offline task edits (last_writer_wins|server_wins|manual)")
+	flag.IntVar(&cfg.events.logSize, "events-log-size", 1000, "Number of recent change events kept for resuming event streams")
+	flag.StringVar(&cfg.events.feedName, "events-feed-name", hostname(), "Name under which this instance saves its change feed position")
+	flag.DurationVar(&cfg.events.pollInterval, "events-poll-interval", time.Second, "How often to poll for changes made by other instances when change streams are unavailable")
+	flag.BoolVar(&cfg.db.autoMigrate, "db-auto-migrate", true, "Apply pending database migrations on startup")
+	flag.StringVar(&cfg.attachments.store, "attachments-store", "local", "Where attachment contents are kept (local|s3)")
+	flag.StringVar(&cfg.attachments.dir, "attachments-dir", "./uploads", "Directory for attachment contents when kept locally")
+	flag.Int64Var(&cfg.attachments.quota, "attachments-quota", 1<<30, "Total size of the attachments allowed in each workspace, in bytes")
     flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
         cfg.cors.trustedOrigins = strings.Fields(val)
         return nil
@@ -76,6 +120,15 @@ func main() {
 
 	flag.Parse()
 
+	cfg.baseURL = strings.TrimSuffix(cfg.baseURL, "/")
+
+	if !validator.In(cfg.sync.conflictPolicy, data.MergePolicies...) {
+		log.Fatalf("invalid -sync-conflict-policy %q", cfg.sync.conflictPolicy)
+	}
+	if !validator.In(cfg.attachments.store, "local", "s3") {
+		log.Fatalf("invalid -attachments-store %q", cfg.attachments.store)
+	}
+
 	cfg.db.dsn = os.Getenv("DB_DSN")
 	if cfg.db.dsn == "" {
 		log.Fatal("DB_DSN must be set in .env or as an environment variable")
@@ -87,6 +140,13 @@ func main() {
 		cfg.db.maxOpenConns = maxOpenConns
 	}
 
+	maxIdleConns, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS"))
+	if err != nil || maxIdleConns <= 0 {
+		cfg.db.maxIdleConns = 10
+	} else {
+		cfg.db.maxIdleConns = maxIdleConns
+	}
+
 	// For maxIdleTime
 	maxIdleTime, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_TIME"))
 	if err != nil || maxIdleTime <= 0 {
@@ -95,17 +155,38 @@ func main() {
 		cfg.db.maxIdleTime = maxIdleTime * 60 * 1000
 	}
 
+	cfg.db.queryTimeout, err = time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT"))
+	if err != nil || cfg.db.queryTimeout <= 0 {
+		cfg.db.queryTimeout = data.DefaultQueryTimeout
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
-		log.Println(err)
+		logger.PrintFatal(err, nil)
 	}
 	defer func() {
-		if err := db.Disconnect(context.Background()); err != nil {
+		if err := db.close(); err != nil {
 			log.Println(err)
 		}
 	}()
 	logger.PrintInfo("Database connection pool established", nil)
 
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(db.migrator, flag.Args()[1:], os.Stdout)
+		if err != nil {
+			logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	if cfg.db.autoMigrate {
+		err = db.migrator.Up(context.Background())
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("Database migrations applied", nil)
+	}
+
 	cfg.smtp.host = os.Getenv("SMTP_HOST")
 	if cfg.smtp.host == "" {
 		cfg.smtp.host = "smtp.mailtrap.io" // default value
@@ -131,11 +212,44 @@ func main() {
 		cfg.smtp.sender = "Greenlight <no-reply@greenlight.alexedwards.net>" // default value
 	}
 
+	// Download links are signed with this key, so every instance needs the
+	// same one. Without it links only work on the instance which made them,
+	// and only until it restarts.
+	cfg.attachments.signingKey = os.Getenv("ATTACHMENTS_SIGNING_KEY")
+	if cfg.attachments.signingKey == "" {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.attachments.signingKey = hex.EncodeToString(key)
+		logger.PrintInfo("ATTACHMENTS_SIGNING_KEY is not set; using a random key", nil)
+	}
+
+	cfg.attachments.s3 = blob.S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db.Database("tasksync")),
+		models: db.models,
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		hub:    events.NewHub(cfg.events.logSize),
+		feed:   db.feed,
+		blobs:  blobs,
+
+		webhookClient: newWebhookClient(),
+		webhookWake:   make(chan struct{}, 1),
 	}
 
 	err = app.serve()
@@ -144,7 +258,83 @@ func main() {
 	}
 }
 
-func openDB(cfg config) (*mongo.Client, error) {
+// openBlobStore returns the store for attachment contents which the config
+// asks for.
+func openBlobStore(cfg config) (blob.BlobStore, error) {
+	if cfg.attachments.store == "s3" {
+		if cfg.attachments.s3.Endpoint == "" || cfg.attachments.s3.Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET must be set to keep attachments in S3")
+		}
+		return blob.NewS3(cfg.attachments.s3), nil
+	}
+	return blob.NewLocal(cfg.attachments.dir)
+}
+
+type database struct {
+	models   data.Models
+	migrator data.Migrator
+	feed     data.ChangeFeed
+	close    func() error
+}
+
+// openDB picks a storage backend from the scheme of the DSN and returns the
+// models and migrator for it, along with a function which closes the
+// underlying pool.
+func openDB(cfg config) (*database, error) {
+	scheme, _, _ := strings.Cut(cfg.db.dsn, ":")
+
+	switch scheme {
+	case "mongodb", "mongodb+srv":
+		client, err := openMongoDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		db := client.Database("tasksync")
+		return &database{
+			models:   data.NewModels(db, cfg.db.queryTimeout),
+			migrator: data.MongoMigrator{DB: db},
+			feed: data.MongoChangeFeed{
+				DB:           db,
+				Name:         cfg.events.feedName,
+				PollInterval: cfg.events.pollInterval,
+				Timeout:      cfg.db.queryTimeout,
+			},
+			close: func() error {
+				return client.Disconnect(context.Background())
+			},
+		}, nil
+
+	case "sqlite", "postgres", "postgresql":
+		db, dialect, err := openSQLDB(cfg, scheme)
+		if err != nil {
+			return nil, err
+		}
+		return &database{
+			models:   data.NewSQLModels(db, dialect, cfg.db.queryTimeout),
+			migrator: data.SQLMigrator{DB: db, Dialect: dialect},
+			feed: data.SQLChangeFeed{
+				DB:           db,
+				Dialect:      dialect,
+				PollInterval: cfg.events.pollInterval,
+				Timeout:      cfg.db.queryTimeout,
+			},
+			close:    db.Close,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported DB_DSN scheme %q", scheme)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "api"
+	}
+	return name
+}
+
+func openMongoDB(cfg config) (*mongo.Client, error) {
 	clientOptions := options.Client().ApplyURI(cfg.db.dsn)
 	clientOptions.SetMaxPoolSize(uint64(cfg.db.maxOpenConns))
 	clientOptions.SetMaxConnIdleTime(time.Duration(cfg.db.maxIdleTime) * time.Millisecond)
@@ -164,3 +354,49 @@ func openDB(cfg config) (*mongo.Client, error) {
 
 	return client, nil
 }
+
+func openSQLDB(cfg config, scheme string) (*sql.DB, data.Dialect, error) {
+	var (
+		db      *sql.DB
+		dialect data.Dialect
+		err     error
+	)
+
+	switch scheme {
+	case "sqlite":
+		// Accept both sqlite:///path/to/file.db and sqlite:file.db.
+		path := strings.TrimPrefix(strings.TrimPrefix(cfg.db.dsn, "sqlite:"), "//")
+		if !strings.Contains(path, "?") {
+			path += "?_foreign_keys=on&_busy_timeout=5000"
+		}
+
+		dialect = data.DialectSQLite
+		db, err = sql.Open("sqlite3", "file:"+path)
+		if err != nil {
+			return nil, dialect, err
+		}
+		// SQLite only supports a single writer, so serialise access rather
+		// than surfacing "database is locked" errors to clients.
+		db.SetMaxOpenConns(1)
+	default:
+		dialect = data.DialectPostgres
+		db, err = sql.Open("postgres", cfg.db.dsn)
+		if err != nil {
+			return nil, dialect, err
+		}
+		db.SetMaxOpenConns(cfg.db.maxOpenConns)
+		db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	}
+	db.SetConnMaxIdleTime(time.Duration(cfg.db.maxIdleTime) * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, dialect, err
+	}
+
+	return db, dialect, nil
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -68,25 +73,57 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 			clients[ip].lastSeen = time.Now()
 
-			if !clients[ip].limiter.Allow() {
+			limiter := clients[ip].limiter
+			if !limiter.Allow() {
 				mu.Unlock()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
 			mu.Unlock()
+
+			r = app.contextSetRateLimiter(r, limiter)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// chargeRateLimit takes n more tokens from the client's rate limiter on top
+// of the one every request costs, for handlers doing the work of several
+// requests. As much of the charge as the client's bucket can hold must be
+// there to take now, and the request is refused with a 429 otherwise. The
+// rest is reserved, which leaves the bucket in debt, so the client's
+// following requests are refused until it has refilled by the whole
+// charge. It reports false if a response has been sent.
+func (app *application) chargeRateLimit(w http.ResponseWriter, r *http.Request, n int) bool {
+	limiter := app.contextGetRateLimiter(r)
+	if limiter == nil || n <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	burst := limiter.Burst()
+
+	upfront := min(n, burst-1)
+	if upfront > 0 && !limiter.AllowN(now, upfront) {
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	// A single reservation can't be larger than the bucket.
+	for rest := n - max(upfront, 0); rest > 0 && burst > 0; rest -= burst {
+		limiter.ReserveN(now, min(rest, burst))
+	}
+	return true
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
 
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
-			r = app.contextSetUser(r, nil)
+			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -95,23 +132,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		token := headerParts[1]
-		v := validator.New()
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
-		userID, err := app.models.Tokens.GetUserIDForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
-			default:
-				app.serverErrorResponse(w, r, err)
-			}
-			return
-		}
-		user, err := app.models.Users.GetByID(userID)
+		user, err := app.userForToken(r.Context(), headerParts[1])
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -126,8 +147,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// userForToken looks up the user holding an authentication token, returning
+// ErrRecordNotFound if the token is malformed, expired or unknown.
+func (app *application) userForToken(ctx context.Context, token string) (*data.User, error) {
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, data.ErrRecordNotFound
+	}
+
+	userID, err := app.models.Tokens.GetUserIDForToken(ctx, data.ScopeAuthentication, token)
+	if err != nil {
+		return nil, err
+	}
+	return app.models.Users.GetByID(ctx, userID)
+}
+
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 		if !user.Activated {
 			app.inactiveAccountResponse(w, r)
@@ -136,6 +172,8 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 		next.ServeHTTP(w, r)
 	})
+
+	return app.requireAuthenticatedUser(fn)
 }
 
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -167,3 +205,289 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// idempotencyExempt are the POST routes the idempotency middleware passes
+// straight through. Most of their responses carry bearer tokens or secrets,
+// which must not be kept at rest in the clear, and inbound hooks handle the
+// Idempotency-Key header themselves. A segment starting with a colon
+// matches any one segment, as for the router.
+var idempotencyExempt = []string{
+	"/v1/tokens/authentication",
+	"/v1/tokens/api-keys",
+	"/v1/calendar/token",
+	"/v1/webhooks",
+	"/v1/projects/:id/inbound-hooks",
+	"/v1/inbound/:token",
+}
+
+// idempotencyBodyLimits are the routes accepting larger bodies than the
+// 1MB readJSON allows, with their own limits. The middleware buffers no
+// more than the route would read, and only lets activated users, whom
+// these routes are for, send more than 1MB. Bodies beyond idempotencyMemory
+// are buffered in a temporary file rather than in memory.
+var idempotencyBodyLimits = map[string]int64{
+	"/v1/tasks/import":          maxImportSize,
+	"/v1/projects/:id/ics":      maxImportSize,
+	"/v1/tasks/:id/attachments": maxAttachmentRequest,
+}
+
+const (
+	idempotencyDefaultMaxBody = 1_048_576
+	idempotencyMemory         = 1_048_576
+)
+
+// matchRoute reports whether path matches the route pattern.
+func matchRoute(pattern, path string) bool {
+	want, got := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if want[i] != got[i] && !(strings.HasPrefix(want[i], ":") && got[i] != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyMaxBody returns how much of the request's body the
+// idempotency middleware may buffer.
+func idempotencyMaxBody(r *http.Request, user *data.User) int64 {
+	if user.IsAnonymous() || !user.Activated {
+		return idempotencyDefaultMaxBody
+	}
+	for pattern, limit := range idempotencyBodyLimits {
+		if matchRoute(pattern, r.URL.Path) {
+			return limit
+		}
+	}
+	return idempotencyDefaultMaxBody
+}
+
+// idempotency makes POST requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key runs as normal and its response is
+// stored for data.IdempotencyKeyTTL; retries get that response back with an
+// Idempotent-Replayed header instead of running again. Keys are scoped to
+// the authenticated user, or to the client's IP address for anonymous
+// requests, and reusing one for a different request is refused. Responses
+// with a 5xx status aren't stored, so those requests can be retried for
+// real, and nor are any for the idempotencyExempt routes.
+func (app *application) idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, pattern := range idempotencyExempt {
+			if matchRoute(pattern, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.RequestURI())
+
+		maxBody := idempotencyMaxBody(r, app.contextGetUser(r))
+		body, err := bufferBody(w, r, maxBody, fingerprint)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBody))
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+
+		record := &data.IdempotencyRecord{
+			Scope:       idempotencyScope(r, app.contextGetUser(r)),
+			Key:         key,
+			Fingerprint: fingerprint.Sum(nil),
+		}
+
+		err = app.models.Idempotency.Claim(r.Context(), record)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateKey):
+				app.replayIdempotentResponse(w, r, record)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// The record must be settled even if the client has gone away or the
+		// handler panics, or the key would stay locked.
+		ctx := context.WithoutCancel(r.Context())
+		rec := newResponseRecorder(w)
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			err := app.models.Idempotency.Release(ctx, record)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= 500 {
+			return
+		}
+
+		record.Status = max(rec.status, http.StatusOK)
+		record.Header = rec.addedHeader()
+		record.Body = rec.body.Bytes()
+
+		err = app.models.Idempotency.Complete(ctx, record)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// bufferBody reads the whole of the request's body, up to maxBody bytes,
+// writing it to fingerprint as it goes, and returns a copy for the handler
+// to read in its place. Bodies larger than idempotencyMemory are copied to a
+// temporary file which is removed straight away and vanishes once closed,
+// and reading them is allowed as long as an attachment upload, since the
+// handler won't get the chance to extend the deadline itself before the body
+// has been read.
+func bufferBody(w http.ResponseWriter, r *http.Request, maxBody int64, fingerprint io.Writer) (io.ReadCloser, error) {
+	body := io.TeeReader(http.MaxBytesReader(w, r.Body, maxBody), fingerprint)
+
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, body, idempotencyMemory+1)
+	if errors.Is(err, io.EOF) {
+		return io.NopCloser(&buf), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = http.NewResponseController(w).SetReadDeadline(time.Now().Add(attachmentReadWait))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+
+	_, err = io.Copy(f, io.MultiReader(&buf, body))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// replayIdempotentResponse answers a request whose key is already claimed,
+// either with the stored response or, if the key is still held by a running
+// request or was used for a different one, with an error.
+func (app *application) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, record *data.IdempotencyRecord) {
+	stored, err := app.models.Idempotency.Get(r.Context(), record.Scope, record.Key)
+	if err != nil {
+		switch {
+		// The claim expired in between; asking the client to retry is
+		// simpler than claiming it again here.
+		case errors.Is(err, data.ErrRecordNotFound):
+			w.Header().Set("Retry-After", "1")
+			app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case !bytes.Equal(stored.Fingerprint, record.Fingerprint):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "this Idempotency-Key has already been used for a different request")
+	case !stored.Completed():
+		w.Header().Set("Retry-After", "1")
+		app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+	default:
+		for name, values := range stored.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
+
+func idempotencyScope(r *http.Request, user *data.User) string {
+	if !user.IsAnonymous() {
+		return "user:" + user.ID.Hex()
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of it, along with the headers the handler added.
+type responseRecorder struct {
+	http.ResponseWriter
+	before http.Header
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, before: w.Header().Clone()}
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// addedHeader returns the headers set by the handler rather than by the
+// middleware around it, which sets its own again on a replay.
+func (rec *responseRecorder) addedHeader() http.Header {
+	added := http.Header{}
+	for name, values := range rec.header {
+		if strings.Join(rec.before[name], "\n") != strings.Join(values, "\n") {
+			added[name] = values
+		}
+	}
+	return added
+}
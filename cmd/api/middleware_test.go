@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/time/rate"
+	"tasksync/internal/data"
+	"tasksync/internal/jsonlog"
+)
+
+func TestChargeRateLimit(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+
+	tests := []struct {
+		name   string
+		taken  int
+		charge int
+		ok     bool
+		tokens float64
+	}{
+		{"within burst", 1, 2, true, 1},
+		{"whole bucket", 1, 3, true, 0},
+		{"over burst", 1, 10, true, -7},
+		{"over burst with bucket part used", 2, 10, false, 2},
+		{"nothing to charge", 4, 0, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A rate low enough that the bucket doesn't visibly refill
+			// during the test.
+			limiter := rate.NewLimiter(rate.Every(time.Hour), 4)
+			limiter.AllowN(time.Now(), tt.taken)
+
+			r := app.contextSetRateLimiter(httptest.NewRequest(http.MethodPost, "/v1/tasks/batch", nil), limiter)
+			w := httptest.NewRecorder()
+
+			ok := app.chargeRateLimit(w, r, tt.charge)
+			if ok != tt.ok {
+				t.Fatalf("got ok %t; want %t", ok, tt.ok)
+			}
+			if !ok && w.Code != http.StatusTooManyRequests {
+				t.Errorf("got status %d; want %d", w.Code, http.StatusTooManyRequests)
+			}
+
+			tokens := limiter.Tokens()
+			if tokens < tt.tokens-0.01 || tokens > tt.tokens+0.01 {
+				t.Errorf("got %.2f tokens left; want %.0f", tokens, tt.tokens)
+			}
+		})
+	}
+
+	// A client left in debt is refused until the bucket has refilled by
+	// the whole charge.
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 4)
+	limiter.Allow()
+	r := app.contextSetRateLimiter(httptest.NewRequest(http.MethodPost, "/v1/tasks/batch", nil), limiter)
+	if !app.chargeRateLimit(httptest.NewRecorder(), r, 10) {
+		t.Fatal("over burst: got refused")
+	}
+	if limiter.AllowN(time.Now().Add(7*time.Hour), 1) {
+		t.Error("got a token before the debt was repaid")
+	}
+	if !limiter.AllowN(time.Now().Add(8*time.Hour+time.Minute), 1) {
+		t.Error("got no token once the debt was repaid")
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	app := newTestApplication(t)
+	user := &data.User{ID: primitive.NewObjectID(), Activated: true}
+
+	calls := 0
+	handler := app.idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			t.Error(err)
+		}
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	// A real server, since bodies over 1MB need their read deadline
+	// extending.
+	var current *data.User
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, app.contextSetUser(r, current))
+	}))
+	defer srv.Close()
+
+	post := func(user *data.User, path, key string, size int) {
+		current = user
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(make([]byte, size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	tests := []struct {
+		name  string
+		user  *data.User
+		path  string
+		size  int
+		calls int
+	}{
+		{"replayed", user, "/v1/tasks", 10, 1},
+		{"token route", data.AnonymousUser, "/v1/tokens/authentication", 10, 2},
+		{"secret route", user, "/v1/projects/abc/inbound-hooks", 10, 2},
+		{"inbound hook", data.AnonymousUser, "/v1/inbound/abc", 10, 2},
+		{"over the default limit", user, "/v1/tasks/batch", 2 << 20, 0},
+		{"under the route's limit", user, "/v1/tasks/abc/attachments", 2 << 20, 1},
+		{"anonymous over the default limit", data.AnonymousUser, "/v1/tasks/abc/attachments", 2 << 20, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			for i := 0; i < 2; i++ {
+				post(tt.user, tt.path, tt.name, tt.size)
+			}
+			if calls != tt.calls {
+				t.Errorf("got %d calls to the handler; want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+// listNotificationsHandler returns a page of the user's notifications,
+// newest first, along with how many they have unread. The cursor is the id
+// of the oldest notification in the page, to pass as before for the next.
+func (app *application) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Check(limit >= 1 && limit <= 100, "limit", "must be between 1 and 100")
+
+	var before primitive.ObjectID
+	if s := qs.Get("before"); s != "" {
+		var err error
+		before, err = primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("before", "must be a valid id")
+		}
+	}
+
+	unreadOnly := false
+	if s := qs.Get("unread"); s != "" {
+		var err error
+		unreadOnly, err = strconv.ParseBool(s)
+		if err != nil {
+			v.AddError("unread", "must be true or false")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	notifications, err := app.models.Notifications.ForUser(r.Context(), user.ID, before, unreadOnly, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	unread, err := app.models.Notifications.UnreadCount(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cursor := qs.Get("before")
+	if len(notifications) > 0 {
+		cursor = notifications[len(notifications)-1].ID.Hex()
+	}
+
+	env := envelope{
+		"notifications": notifications,
+		"unread_count":  unread,
+		"cursor":        cursor,
+		"has_more":      len(notifications) == limit,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationHandler marks one of the user's notifications as read,
+// or as unread again.
+func (app *application) updateNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Read *bool `json:"read"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Read != nil, "read", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	notification, err := app.models.Notifications.SetRead(r.Context(), app.contextGetUser(r).ID, id, *input.Read)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"notification": notification}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readAllNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := app.models.Notifications.MarkAllRead(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"marked_read": n}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showNotificationPreferencesHandler returns the channel every type of
+// notification goes to for the user, the defaults included.
+func (app *application) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	prefs, err := app.models.Preferences.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": preferencesView(prefs)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationPreferencesHandler changes the user's preferences.
+// Channels, when given, replace the user's channels altogether, and types
+// left out go back to the default of both. Turning the digest on, moving it
+// or changing how often it comes schedules the next one.
+func (app *application) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	prefs, err := app.models.Preferences.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Channels        map[string]string `json:"channels"`
+		Digest          *bool             `json:"digest"`
+		DigestFrequency *string           `json:"digest_frequency"`
+		DigestWeekday   *string           `json:"digest_weekday"`
+		Timezone        *string           `json:"timezone"`
+		DigestHour      *int              `json:"digest_hour"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Channels != nil {
+		prefs.Channels = input.Channels
+	}
+	if input.Digest != nil {
+		prefs.Digest = *input.Digest
+	}
+	if input.DigestFrequency != nil {
+		prefs.DigestFrequency = *input.DigestFrequency
+	}
+	if input.DigestWeekday != nil {
+		prefs.DigestWeekday = *input.DigestWeekday
+	}
+	if input.Timezone != nil {
+		prefs.Timezone = *input.Timezone
+	}
+	if input.DigestHour != nil {
+		prefs.DigestHour = *input.DigestHour
+	}
+
+	v := validator.New()
+	if data.ValidateNotificationPreferences(v, prefs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Digest != nil || input.DigestFrequency != nil || input.DigestWeekday != nil || input.Timezone != nil || input.DigestHour != nil {
+		prefs.ScheduleDigest(time.Now())
+	}
+
+	err = app.models.Preferences.Put(r.Context(), prefs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preferences": preferencesView(prefs)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// preferencesView fills in the channel for every type of notification.
+func preferencesView(prefs *data.NotificationPreferences) envelope {
+	channels := make(map[string]string, len(data.NotificationTypes))
+	for _, kind := range data.NotificationTypes {
+		channels[kind] = prefs.Channel(kind)
+	}
+
+	view := envelope{
+		"channels":         channels,
+		"digest":           prefs.Digest,
+		"digest_frequency": prefs.DigestFrequency,
+		"digest_weekday":   prefs.DigestWeekday,
+		"timezone":         prefs.Timezone,
+		"digest_hour":      prefs.DigestHour,
+		"updated_at":       prefs.UpdatedAt,
+	}
+	if prefs.NextDigestAt != nil {
+		view["next_digest_at"] = prefs.NextDigestAt
+	}
+	return view
+}
+
+// notifyWatchers tells the task's watchers what happened to it, leaving out
+// whoever did it and anyone in skip, who have been told some other way.
+// Changes made by webhooks and other anonymous callers have no actor.
+// Watchers who aren't members of the task's workspace, having left it or
+// the task having moved to another, stop watching the task instead.
+func (app *application) notifyWatchers(ctx context.Context, task *data.Task, kind string, skip ...primitive.ObjectID) {
+	logError := func(err error) {
+		app.logger.PrintError(err, map[string]string{
+			"notification": kind,
+			"task_id":      task.ID.Hex(),
+		})
+	}
+
+	watchers, err := app.models.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		logError(err)
+		return
+	}
+	if len(watchers) == 0 {
+		return
+	}
+
+	project, err := app.models.Projects.Get(ctx, task.ProjectID)
+	if err != nil {
+		// Tasks deleted along with their project are covered by the
+		// project.deleted event.
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			logError(err)
+		}
+		return
+	}
+
+	actor := contextActor(ctx)
+	if actor != nil {
+		skip = append(skip, actor.ID)
+	}
+
+	var recipients []primitive.ObjectID
+	for _, id := range watchers {
+		member, err := app.models.Workspaces.IsMember(ctx, project.WorkspaceID, id)
+		if err != nil {
+			logError(err)
+			return
+		}
+		if !member {
+			err = app.models.Watchers.Remove(ctx, task.ID, id)
+			if err != nil {
+				logError(err)
+			}
+			continue
+		}
+		if !containsID(skip, id) {
+			recipients = append(recipients, id)
+		}
+	}
+	app.notify(actor, task, kind, recipients)
+}
+
+// notify saves a notification for each recipient and emails it to them in
+// the background, so that a slow mail server doesn't hold up the request.
+// Each recipient's preferences decide which of the two they get, and those
+// no longer in the task's workspace get neither.
+func (app *application) notify(actor *data.User, task *data.Task, kind string, recipients []primitive.ObjectID) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx := context.Background()
+
+		actorName := "Someone"
+		var actorID primitive.ObjectID
+		if actor != nil {
+			actorName, actorID = actor.Name, actor.ID
+		}
+
+		project, err := app.models.Projects.Get(ctx, task.ProjectID)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, map[string]string{"notification": kind, "task_id": task.ID.Hex()})
+			}
+			return
+		}
+
+		for _, id := range recipients {
+			member, err := app.models.Workspaces.IsMember(ctx, project.WorkspaceID, id)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"notification": kind, "user_id": id.Hex()})
+				continue
+			}
+			if !member {
+				continue
+			}
+
+			prefs, err := app.models.Preferences.Get(ctx, id)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"notification": kind, "user_id": id.Hex()})
+				continue
+			}
+
+			if prefs.InApp(kind) {
+				notification := &data.Notification{
+					UserID:    id,
+					Type:      kind,
+					ActorID:   actorID,
+					TaskID:    task.ID,
+					ProjectID: task.ProjectID,
+					TaskTitle: task.Title,
+				}
+
+				err = app.models.Notifications.Insert(ctx, notification)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"notification": kind, "user_id": id.Hex()})
+					continue
+				}
+			}
+			if !prefs.Email(kind) {
+				continue
+			}
+
+			user, err := app.models.Users.GetByID(ctx, id)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"notification": kind, "user_id": id.Hex()})
+				continue
+			}
+
+			data := map[string]interface{}{
+				"actorName": actorName,
+				"action":    notificationActions[kind],
+				"taskTitle": task.Title,
+				"taskID":    task.ID.Hex(),
+			}
+
+			err = app.mailer.Send(user.Email, "task_notification.tmpl.html", data)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"notification": kind, "user_id": id.Hex()})
+			}
+		}
+	})
+}
+
+// notificationActions words each kind of notification for emails, to follow
+// the actor's name.
+var notificationActions = map[string]string{
+	data.NotificationTaskAssigned:  "assigned you to",
+	data.NotificationTaskUpdated:   "updated",
+	data.NotificationTaskDeleted:   "deleted",
+	data.NotificationTaskCommented: "commented on",
+	data.NotificationMentioned:     "mentioned you in a comment on",
+}
+
+// contextActor returns the user making the request ctx belongs to, or nil
+// when there isn't one or they are anonymous.
+func contextActor(ctx context.Context) *data.User {
+	user, ok := ctx.Value(userContextKey).(*data.User)
+	if !ok || user.IsAnonymous() {
+		return nil
+	}
+	return user
+}
@@ -16,7 +16,102 @@ func (app *application) routes() http.Handler {
     router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
     router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
     router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+    router.HandlerFunc(http.MethodPost, "/v1/tokens/api-keys", app.requireActivatedUser(app.createAPIKeyHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/tokens/api-keys", app.requireActivatedUser(app.deleteAPIKeysHandler))
 
-    return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+    router.HandlerFunc(http.MethodGet, "/v1/sync", app.requireActivatedUser(app.syncPullHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/sync", app.requireActivatedUser(app.syncPushHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/tasks/batch", app.requireActivatedUser(app.batchTasksHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/tasks/export", app.requireActivatedUser(app.exportTasksHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/tasks/import", app.requireActivatedUser(app.importTasksHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/tasks/import/:id", app.requireActivatedUser(app.showImportJobHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/search", app.requireActivatedUser(app.searchHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/views", app.requireActivatedUser(app.listViewsHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/views", app.requireActivatedUser(app.createViewHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/views/:id", app.requireActivatedUser(app.showViewHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/views/:id", app.requireActivatedUser(app.updateViewHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/views/:id", app.requireActivatedUser(app.deleteViewHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/views/:id/tasks", app.requireActivatedUser(app.viewTasksHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/calendar/token", app.requireActivatedUser(app.createCalendarTokenHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/calendar/token", app.requireActivatedUser(app.deleteCalendarTokenHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/calendar/:token", app.calendarFeedHandler)
+    router.HandlerFunc(http.MethodGet, "/v1/attachments/:id/download", app.downloadAttachmentHandler)
+    router.HandlerFunc(http.MethodGet, "/v1/ws", app.authenticateQueryToken(app.requireActivatedUser(app.wsHandler)))
+    router.HandlerFunc(http.MethodGet, "/v1/events", app.authenticateQueryToken(app.requireActivatedUser(app.eventsHandler)))
+
+    router.HandlerFunc(http.MethodGet, "/v1/workspaces", app.requireActivatedUser(app.listWorkspacesHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/workspaces", app.requireActivatedUser(app.createWorkspaceHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/workspaces/:id/members", app.requireActivatedUser(app.addWorkspaceMemberHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/workspaces/:id/members/:user_id", app.requireActivatedUser(app.removeWorkspaceMemberHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/workspaces/:id/labels", app.requireActivatedUser(app.listLabelsHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/workspaces/:id/labels", app.requireActivatedUser(app.createLabelHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/labels/:id", app.requireActivatedUser(app.showLabelHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/labels/:id", app.requireActivatedUser(app.updateLabelHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/labels/:id", app.requireActivatedUser(app.deleteLabelHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/labels/:id/merge", app.requireActivatedUser(app.mergeLabelHandler))
+
+    router.HandlerFunc(http.MethodGet, "/v1/notifications", app.requireActivatedUser(app.listNotificationsHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/notifications/:id", app.requireActivatedUser(app.updateNotificationHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/notifications/read-all", app.requireActivatedUser(app.readAllNotificationsHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/notifications/preferences", app.requireActivatedUser(app.showNotificationPreferencesHandler))
+    router.HandlerFunc(http.MethodPut, "/v1/notifications/preferences", app.requireActivatedUser(app.updateNotificationPreferencesHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/notifications/unsubscribe/:token", app.showUnsubscribeHandler)
+    router.HandlerFunc(http.MethodPost, "/v1/notifications/unsubscribe/:token", app.unsubscribeDigestHandler)
+
+    router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requireActivatedUser(app.listWebhooksHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requireActivatedUser(app.createWebhookHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requireActivatedUser(app.showWebhookHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requireActivatedUser(app.updateWebhookHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requireActivatedUser(app.deleteWebhookHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requireActivatedUser(app.listWebhookDeliveriesHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requireActivatedUser(app.redeliverWebhookHandler))
+
+    router.HandlerFunc(http.MethodGet, "/v1/projects/:id/inbound-hooks", app.requireActivatedUser(app.listInboundHooksHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/projects/:id/inbound-hooks", app.requireActivatedUser(app.createInboundHookHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/projects/:id/ics", app.requireActivatedUser(app.importCalendarHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/projects/:id/boards", app.requireActivatedUser(app.listBoardsHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/projects/:id/boards", app.requireActivatedUser(app.createBoardHandler))
+    router.HandlerFunc(http.MethodGet, "/v1/boards/:id", app.requireActivatedUser(app.showBoardHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/boards/:id", app.requireActivatedUser(app.updateBoardHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/boards/:id", app.requireActivatedUser(app.deleteBoardHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/boards/:id/move", app.requireActivatedUser(app.moveBoardTaskHandler))
+    router.HandlerFunc(http.MethodPatch, "/v1/inbound-hooks/:id", app.requireActivatedUser(app.updateInboundHookHandler))
+    router.HandlerFunc(http.MethodDelete, "/v1/inbound-hooks/:id", app.requireActivatedUser(app.deleteInboundHookHandler))
+    router.HandlerFunc(http.MethodPost, "/v1/inbound/:token", app.receiveInboundHookHandler)
+
+    // httprouter won't let /v1/tasks/:id sit beside /v1/tasks/batch and the
+    // like, so routes about a single task live on a router of their own,
+    // which is tried for anything the main router doesn't know.
+    tasks := httprouter.New()
+    tasks.NotFound = http.HandlerFunc(app.notFoundResponse)
+    tasks.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+    router.NotFound = tasks
+
+    tasks.HandlerFunc(http.MethodPost, "/v1/tasks/:id/assignees", app.requireActivatedUser(app.assignTaskHandler))
+    tasks.HandlerFunc(http.MethodDelete, "/v1/tasks/:id/assignees/:user_id", app.requireActivatedUser(app.unassignTaskHandler))
+    tasks.HandlerFunc(http.MethodPost, "/v1/tasks/:id/labels", app.requireActivatedUser(app.labelTaskHandler))
+    tasks.HandlerFunc(http.MethodDelete, "/v1/tasks/:id/labels/:label_id", app.requireActivatedUser(app.unlabelTaskHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/watchers", app.requireActivatedUser(app.listWatchersHandler))
+    tasks.HandlerFunc(http.MethodPost, "/v1/tasks/:id/watchers", app.requireActivatedUser(app.watchTaskHandler))
+    tasks.HandlerFunc(http.MethodDelete, "/v1/tasks/:id/watchers", app.requireActivatedUser(app.unwatchTaskHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/comments", app.requireActivatedUser(app.listCommentsHandler))
+    tasks.HandlerFunc(http.MethodPost, "/v1/tasks/:id/comments", app.requireActivatedUser(app.createCommentHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/comments/:comment_id", app.requireActivatedUser(app.showCommentHandler))
+    tasks.HandlerFunc(http.MethodPatch, "/v1/tasks/:id/comments/:comment_id", app.requireActivatedUser(app.updateCommentHandler))
+    tasks.HandlerFunc(http.MethodDelete, "/v1/tasks/:id/comments/:comment_id", app.requireActivatedUser(app.deleteCommentHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/comments/:comment_id/revisions", app.requireActivatedUser(app.listCommentRevisionsHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/attachments", app.requireActivatedUser(app.listAttachmentsHandler))
+    tasks.HandlerFunc(http.MethodPost, "/v1/tasks/:id/attachments", app.requireActivatedUser(app.createAttachmentHandler))
+    tasks.HandlerFunc(http.MethodGet, "/v1/tasks/:id/attachments/:attachment_id", app.requireActivatedUser(app.showAttachmentHandler))
+    tasks.HandlerFunc(http.MethodDelete, "/v1/tasks/:id/attachments/:attachment_id", app.requireActivatedUser(app.deleteAttachmentHandler))
+
+    // CalDAV clients authenticate differently, so the CalDAV server sits
+    // beside the JSON API rather than behind its authentication.
+    mux := http.NewServeMux()
+    mux.Handle("/dav/", app.authenticateDAV(http.HandlerFunc(app.davHandler)))
+    mux.Handle("/.well-known/caldav", http.RedirectHandler("/dav/", http.StatusMovedPermanently))
+    mux.Handle("/", app.authenticate(app.idempotency(router)))
+
+    return app.recoverPanic(app.enableCORS(app.rateLimit(mux)))
 }
 
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+// searchHandler finds the tasks, projects and comments the user can see
+// which match the q parameter, best match first. The query syntax is
+// described in the search package, with the qualifiers data.ParseSearchQuery
+// adds to it.
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	query := data.ParseSearchQuery(v, qs.Get("q"))
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Check(limit >= 1 && limit <= 100, "limit", "must be between 1 and 100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	projectIDs, err := app.models.Projects.IDsForWorkspaces(r.Context(), workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results, err := app.models.Search.Search(r.Context(), query, projectIDs, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,14 +13,43 @@ import (
 )
 
 func (app *application) serve() error {
+	// Every request context derives from baseCtx, so canceling it aborts
+	// any queries still running once the shutdown grace period is over.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
+	// Pick up writes made through other instances for as long as the
+	// server runs.
+	feedCtx, stopFeed := context.WithCancel(context.Background())
+	defer stopFeed()
+	app.background(func() {
+		app.runChangeFeed(feedCtx)
+	})
+
+	// Deliver webhooks until shutdown. Attempts still in flight then are
+	// abandoned and retried once their claim runs out.
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	app.background(func() {
+		app.runWebhookWorker(workerCtx)
+	})
+
+	// Send digests as they fall due, which is likewise stopped at shutdown.
+	app.background(func() {
+		app.runDigestWorker(workerCtx)
+	})
+
 	shutdownError := make(chan error)
 
 	go func() {
@@ -33,7 +63,15 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		// Shutdown doesn't track hijacked WebSocket connections, so end
+		// their subscriptions first. Their handlers then send a close frame
+		// and are waited for along with the background tasks below.
+		app.hub.Close()
+
 		err := srv.Shutdown(ctx)
+		cancelBase()
+		stopFeed()
+		stopWorker()
 		if err != nil {
 			shutdownError <- err
 		}
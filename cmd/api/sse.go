@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const (
+	// Time allowed to write a single event to the client. The stream as a
+	// whole is exempt from the server's WriteTimeout.
+	sseWriteWait = 10 * time.Second
+	// How often a comment line is sent to keep proxies from timing out an
+	// idle stream.
+	sseHeartbeatPeriod = 15 * time.Second
+	// How long clients should wait before reconnecting.
+	sseRetry = 5 * time.Second
+)
+
+// eventsHandler streams the same change events as wsHandler as Server-Sent
+// Events, for clients behind proxies which break WebSockets. A client which
+// reconnects with a Last-Event-ID header, or a last_event_id query string
+// parameter, is first sent the logged events it missed. If the log no
+// longer reaches back that far a reset event is sent instead, and the
+// client should catch up through /v1/sync from its own cursor.
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID int64
+	if lastEventID != "" {
+		v := validator.New()
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && id >= 0, "last_event_id", "must be a non-negative integer")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		lastID = id
+	}
+
+	workspaceIDs, ok := app.subscriptionWorkspaces(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		sub     *events.Subscription
+		backlog []events.Event
+		resumed = true
+		err     error
+	)
+	if lastEventID != "" {
+		sub, backlog, resumed, err = app.hub.Resume(user.ID, workspaceIDs, eventBufferSize, lastID)
+	} else {
+		sub, err = app.hub.Subscribe(user.ID, workspaceIDs, eventBufferSize)
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(sseRetry.Seconds())))
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// write sends whatever fn writes to the client straight away, bounding
+	// each write with its own deadline in place of the server's WriteTimeout.
+	write := func(fn func(io.Writer) error) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(sseWriteWait)); err != nil {
+			return false
+		}
+		if err := fn(w); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	ok = write(func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+		if err != nil || resumed {
+			return err
+		}
+		return writeSSE(w, "reset", "", map[string]int64{"last_event_id": lastID})
+	})
+	if !ok {
+		return
+	}
+
+	for _, event := range backlog {
+		if !write(func(w io.Writer) error { return writeEvent(w, event) }) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseHeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			if !write(func(w io.Writer) error { return writeEvent(w, event) }) {
+				return
+			}
+
+		case <-ticker.C:
+			ok := write(func(w io.Writer) error {
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err
+			})
+			if !ok {
+				return
+			}
+
+		// The client reconnects by itself once the stream ends, resuming
+		// from the last event it received.
+		case <-sub.Done():
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event events.Event) error {
+	return writeSSE(w, event.Type, strconv.FormatInt(event.ID, 10), event)
+}
+
+func writeSSE(w io.Writer, eventType, id string, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, js)
+	return err
+}
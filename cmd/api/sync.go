@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const maxSyncMutations = 500
+
+type syncChange struct {
+	Seq     int64              `json:"seq"`
+	Type    string             `json:"type"`
+	ID      primitive.ObjectID `json:"id"`
+	Deleted bool               `json:"deleted"`
+	Project *data.Project      `json:"project,omitempty"`
+	Task    *data.Task         `json:"task,omitempty"`
+}
+
+// syncMutation is a single offline edit. For task updates made against an
+// older version, Policy overrides the server's default merge policy and
+// ChangedAt is when the edit was made on the device, which last_writer_wins
+// compares against server edits; it defaults to the time of the push.
+type syncMutation struct {
+	Type        string          `json:"type"`
+	Op          string          `json:"op"`
+	ID          string          `json:"id"`
+	BaseVersion int32           `json:"base_version"`
+	Policy      string          `json:"policy"`
+	ChangedAt   *time.Time      `json:"changed_at"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// syncResult reports the outcome of one mutation. A conflict carries the
+// current server document, plus the conflicting fields when a merge was
+// attempted, so the client can resolve it and resubmit against the server
+// version. A merge lists the fields it settled along with the winner.
+type syncResult struct {
+	Index     int                  `json:"index"`
+	Status    string               `json:"status"`
+	Project   *data.Project        `json:"project,omitempty"`
+	Task      *data.Task           `json:"task,omitempty"`
+	Conflicts []data.FieldConflict `json:"conflicts,omitempty"`
+	Errors    map[string]string    `json:"errors,omitempty"`
+}
+
+const (
+	syncStatusApplied  = "applied"
+	syncStatusMerged   = "merged"
+	syncStatusConflict = "conflict"
+	syncStatusInvalid  = "invalid"
+	syncStatusNotFound = "not_found"
+)
+
+type projectMutationData struct {
+	WorkspaceID *string `json:"workspace_id"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type taskMutationData struct {
+	ProjectID   *string         `json:"project_id"`
+	Title       *string         `json:"title"`
+	Description *string         `json:"description"`
+	Status      *string         `json:"status"`
+	Priority    *int32          `json:"priority"`
+	DueAt       json.RawMessage `json:"due_at"`
+	Recurrence  *string         `json:"recurrence"`
+}
+
+// syncPullHandler returns every project and task change visible to the
+// user after the since cursor, oldest first. Deleted documents are returned
+// as tombstones carrying only their id. Changes above the change horizon
+// are held back, as a write with a lower sequence may still be committing
+// and the cursor would otherwise move past it.
+func (app *application) syncPullHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	since := int64(app.readInt(qs, "since", 0, v))
+	limit := app.readInt(qs, "limit", 500, v)
+
+	v.Check(since >= 0, "since", "must be zero or more")
+	v.Check(limit >= 1 && limit <= 1000, "limit", "must be between 1 and 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// The horizon must be read before the changes, so that everything at or
+	// below it has committed by the time they are.
+	horizon, err := app.models.Changes.Horizon(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	projects, err := app.models.Projects.ChangesSince(r.Context(), workspaceIDs, since, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	projectIDs, err := app.models.Projects.IDsForWorkspaces(r.Context(), workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tasks, err := app.models.Tasks.ChangesSince(r.Context(), projectIDs, since, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	changes := make([]syncChange, 0, len(projects)+len(tasks))
+	for _, p := range projects {
+		if p.Seq > horizon {
+			continue
+		}
+		change := syncChange{Seq: p.Seq, Type: "project", ID: p.ID, Deleted: p.Deleted}
+		if !p.Deleted {
+			change.Project = p
+		}
+		changes = append(changes, change)
+	}
+	for _, t := range tasks {
+		if t.Seq > horizon {
+			continue
+		}
+		change := syncChange{Seq: t.Seq, Type: "task", ID: t.ID, Deleted: t.Deleted}
+		if !t.Deleted {
+			change.Task = t
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Seq < changes[j].Seq
+	})
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+
+	cursor := since
+	if len(changes) > 0 {
+		cursor = changes[len(changes)-1].Seq
+	}
+
+	env := envelope{
+		"changes":  changes,
+		"cursor":   cursor,
+		"has_more": len(changes) == limit,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// syncPushHandler applies a batch of offline mutations in order. Each one
+// succeeds or fails independently and gets its own result, so a conflict on
+// one item doesn't hold back the rest of the batch.
+func (app *application) syncPushHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mutations []syncMutation `json:"mutations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Mutations) > 0, "mutations", "must contain at least one mutation")
+	v.Check(len(input.Mutations) <= maxSyncMutations, "mutations", fmt.Sprintf("must not contain more than %d mutations", maxSyncMutations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	results := make([]syncResult, len(input.Mutations))
+	for i, mutation := range input.Mutations {
+		var result syncResult
+
+		switch mutation.Type {
+		case "project":
+			result, err = app.applyProjectMutation(r.Context(), user, mutation)
+		case "task":
+			result, err = app.applyTaskMutation(r.Context(), user, mutation)
+		default:
+			result = invalidSyncResult("type", "must be one of project or task")
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		result.Index = i
+		results[i] = result
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) applyProjectMutation(ctx context.Context, user *data.User, mutation syncMutation) (syncResult, error) {
+	var input projectMutationData
+	if mutation.Op != "delete" {
+		if err := decodeMutationData(mutation.Data, &input); err != nil {
+			return invalidSyncResult("data", err.Error()), nil
+		}
+	}
+
+	if mutation.Op == "create" {
+		// New projects go in the user's personal workspace unless another
+		// one they belong to is named. A project can't change workspace.
+		project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID}
+		if mutation.ID != "" {
+			id, err := primitive.ObjectIDFromHex(mutation.ID)
+			if err != nil {
+				return invalidSyncResult("id", "must be a valid id"), nil
+			}
+			project.ID = id
+		}
+		if input.WorkspaceID != nil {
+			workspaceID, err := primitive.ObjectIDFromHex(*input.WorkspaceID)
+			if err != nil {
+				return invalidSyncResult("workspace_id", "must be a valid id"), nil
+			}
+			ok, err := app.models.Workspaces.IsMember(ctx, workspaceID, user.ID)
+			if err != nil {
+				return syncResult{}, err
+			}
+			if !ok {
+				return invalidSyncResult("workspace_id", "must be a workspace you belong to"), nil
+			}
+			project.WorkspaceID = workspaceID
+		}
+		applyProjectMutationData(project, input)
+
+		v := validator.New()
+		if data.ValidateProject(v, project); !v.Valid() {
+			return syncResult{Status: syncStatusInvalid, Errors: v.Errors}, nil
+		}
+
+		err := app.models.Projects.Insert(ctx, project)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateID):
+				return app.projectConflict(ctx, user, project.ID)
+			default:
+				return syncResult{}, err
+			}
+		}
+		app.publishProject(project, events.ProjectCreated)
+		return syncResult{Status: syncStatusApplied, Project: project}, nil
+	}
+
+	project, result, err := app.loadProjectForMutation(ctx, user, mutation)
+	if project == nil || err != nil {
+		return result, err
+	}
+
+	switch mutation.Op {
+	case "update":
+		applyProjectMutationData(project, input)
+
+		v := validator.New()
+		if data.ValidateProject(v, project); !v.Valid() {
+			return syncResult{Status: syncStatusInvalid, Errors: v.Errors}, nil
+		}
+		err = app.models.Projects.Update(ctx, project)
+
+	case "delete":
+		err = app.models.WithTransaction(ctx, func(ctx context.Context, tx data.Models) error {
+			err := tx.Projects.Delete(ctx, project)
+			if err != nil {
+				return err
+			}
+			return tx.Tasks.DeleteAllForProject(ctx, project.ID)
+		})
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return app.projectConflict(ctx, user, project.ID)
+		default:
+			return syncResult{}, err
+		}
+	}
+
+	if project.Deleted {
+		app.publishProject(project, events.ProjectDeleted)
+		return syncResult{Status: syncStatusApplied}, nil
+	}
+	app.publishProject(project, events.ProjectUpdated)
+	return syncResult{Status: syncStatusApplied, Project: project}, nil
+}
+
+func (app *application) applyTaskMutation(ctx context.Context, user *data.User, mutation syncMutation) (syncResult, error) {
+	var input taskMutationData
+	if mutation.Op != "delete" {
+		if err := decodeMutationData(mutation.Data, &input); err != nil {
+			return invalidSyncResult("data", err.Error()), nil
+		}
+	}
+
+	if mutation.Op == "create" {
+		task := &data.Task{CreatedBy: user.ID, Status: data.TaskStatusTodo}
+		if mutation.ID != "" {
+			id, err := primitive.ObjectIDFromHex(mutation.ID)
+			if err != nil {
+				return invalidSyncResult("id", "must be a valid id"), nil
+			}
+			task.ID = id
+		}
+
+		if result, ok := setTaskMutationData(task, input); !ok {
+			return result, nil
+		}
+		result, ok, err := app.checkTask(ctx, user, task)
+		if !ok || err != nil {
+			return result, err
+		}
+
+		err = app.models.Tasks.Insert(ctx, task)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateID):
+				return app.taskConflict(ctx, user, task.ID)
+			default:
+				return syncResult{}, err
+			}
+		}
+		app.publishTask(ctx, task, events.TaskCreated)
+		return syncResult{Status: syncStatusApplied, Task: task}, nil
+	}
+
+	task, result, err := app.loadTaskForMutation(ctx, user, mutation)
+	if task == nil || err != nil {
+		return result, err
+	}
+
+	switch mutation.Op {
+	case "update":
+		if task.Version != mutation.BaseVersion {
+			return app.mergeTaskMutation(ctx, user, task, mutation, input)
+		}
+
+		if result, ok := setTaskMutationData(task, input); !ok {
+			return result, nil
+		}
+		result, ok, err := app.checkTask(ctx, user, task)
+		if !ok || err != nil {
+			return result, err
+		}
+
+		err = app.models.Tasks.Update(ctx, task)
+		if err != nil {
+			return app.taskWriteResult(ctx, user, task, err)
+		}
+		app.publishTask(ctx, task, events.TaskUpdated)
+		return syncResult{Status: syncStatusApplied, Task: task}, nil
+
+	default:
+		if task.Version != mutation.BaseVersion {
+			return syncResult{Status: syncStatusConflict, Task: task}, nil
+		}
+
+		err = app.models.Tasks.Delete(ctx, task)
+		if err != nil {
+			return app.taskWriteResult(ctx, user, task, err)
+		}
+		app.publishTask(ctx, task, events.TaskDeleted)
+		return syncResult{Status: syncStatusApplied}, nil
+	}
+}
+
+// mergeTaskMutation handles an update made against an older version of the
+// task by replaying it on top of the base revision and three-way merging the
+// result with the current task. If the base has dropped out of the task's
+// history the mutation is reported as a plain conflict.
+func (app *application) mergeTaskMutation(ctx context.Context, user *data.User, task *data.Task, mutation syncMutation, input taskMutationData) (syncResult, error) {
+	policy := mutation.Policy
+	if policy == "" {
+		policy = app.config.sync.conflictPolicy
+	}
+
+	v := validator.New()
+	if data.ValidateMergePolicy(v, policy); !v.Valid() {
+		return syncResult{Status: syncStatusInvalid, Errors: v.Errors}, nil
+	}
+
+	base, err := app.models.Tasks.GetRevision(ctx, task.ID, mutation.BaseVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return syncResult{Status: syncStatusConflict, Task: task}, nil
+		default:
+			return syncResult{}, err
+		}
+	}
+
+	revisions, err := app.models.Tasks.RevisionsSince(ctx, task.ID, mutation.BaseVersion)
+	if err != nil {
+		return syncResult{}, err
+	}
+
+	client := *base
+	if result, ok := setTaskMutationData(&client, input); !ok {
+		return result, nil
+	}
+
+	changedAt := time.Now()
+	if mutation.ChangedAt != nil {
+		changedAt = *mutation.ChangedAt
+	}
+
+	merged, conflicts, ok := data.MergeTask(base, task, &client, changedAt, data.TaskFieldTimes(base, revisions), policy)
+	if !ok {
+		return syncResult{Status: syncStatusConflict, Task: task, Conflicts: conflicts}, nil
+	}
+
+	// Every conflict went the server's way, so there is nothing to save.
+	if data.TaskFieldsEqual(merged, task) {
+		return syncResult{Status: syncStatusMerged, Task: task, Conflicts: conflicts}, nil
+	}
+
+	result, ok, err := app.checkTask(ctx, user, merged)
+	if !ok || err != nil {
+		return result, err
+	}
+
+	err = app.models.Tasks.Update(ctx, merged)
+	if err != nil {
+		return app.taskWriteResult(ctx, user, merged, err)
+	}
+	app.publishTask(ctx, merged, events.TaskUpdated)
+
+	status := syncStatusApplied
+	if len(revisions) > 0 {
+		status = syncStatusMerged
+	}
+	return syncResult{Status: status, Task: merged, Conflicts: conflicts}, nil
+}
+
+func (app *application) taskWriteResult(ctx context.Context, user *data.User, task *data.Task, err error) (syncResult, error) {
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		return app.taskConflict(ctx, user, task.ID)
+	default:
+		return syncResult{}, err
+	}
+}
+
+// setTaskMutationData copies the supplied fields onto the task. It reports
+// false along with the result to return if a field can't be parsed.
+func setTaskMutationData(task *data.Task, input taskMutationData) (syncResult, bool) {
+	if input.ProjectID != nil {
+		projectID, err := primitive.ObjectIDFromHex(*input.ProjectID)
+		if err != nil {
+			return invalidSyncResult("project_id", "must be a valid id"), false
+		}
+		task.ProjectID = projectID
+	}
+	if input.Title != nil {
+		task.Title = *input.Title
+	}
+	if input.Description != nil {
+		task.Description = *input.Description
+	}
+	if input.Status != nil {
+		task.Status = *input.Status
+	}
+	if input.Priority != nil {
+		task.Priority = *input.Priority
+	}
+	if len(input.DueAt) > 0 {
+		if bytes.Equal(input.DueAt, []byte("null")) {
+			task.DueAt = nil
+		} else {
+			var dueAt time.Time
+			if err := json.Unmarshal(input.DueAt, &dueAt); err != nil {
+				return invalidSyncResult("due_at", "must be an RFC 3339 timestamp or null"), false
+			}
+			task.DueAt = &dueAt
+		}
+	}
+	if input.Recurrence != nil {
+		task.Recurrence = *input.Recurrence
+	}
+	return syncResult{}, true
+}
+
+// checkTask validates a task about to be saved. It reports false along with
+// the result to return when the task can't be saved.
+func (app *application) checkTask(ctx context.Context, user *data.User, task *data.Task) (syncResult, bool, error) {
+	return checkTaskWith(ctx, app.models, user, task)
+}
+
+// checkTaskWith is checkTask against the given models, such as those bound
+// to a transaction.
+func checkTaskWith(ctx context.Context, m data.Models, user *data.User, task *data.Task) (syncResult, bool, error) {
+	v := validator.New()
+	if data.ValidateTask(v, task); !v.Valid() {
+		return syncResult{Status: syncStatusInvalid, Errors: v.Errors}, false, nil
+	}
+
+	// The task may only live in a project the user can see.
+	project, err := findMemberProject(ctx, m, user, task.ProjectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return invalidSyncResult("project_id", "must be an existing project"), false, nil
+		default:
+			return syncResult{}, false, err
+		}
+	}
+
+	// Only members of the task's workspace can be assigned to it, so anyone
+	// else is unassigned, after moving the task to another workspace say.
+	var assigneeIDs []primitive.ObjectID
+	for _, id := range task.AssigneeIDs {
+		member, err := m.Workspaces.IsMember(ctx, project.WorkspaceID, id)
+		if err != nil {
+			return syncResult{}, false, err
+		}
+		if member {
+			assigneeIDs = append(assigneeIDs, id)
+		}
+	}
+	if len(assigneeIDs) < len(task.AssigneeIDs) {
+		task.AssigneeIDs = assigneeIDs
+	}
+
+	return syncResult{}, true, nil
+}
+
+func applyProjectMutationData(project *data.Project, input projectMutationData) {
+	if input.Name != nil {
+		project.Name = *input.Name
+	}
+	if input.Description != nil {
+		project.Description = *input.Description
+	}
+}
+
+// loadProjectForMutation fetches the project targeted by an update or
+// delete. When the mutation can't go ahead it returns a nil project and the
+// result to report instead.
+func (app *application) loadProjectForMutation(ctx context.Context, user *data.User, mutation syncMutation) (*data.Project, syncResult, error) {
+	if mutation.Op != "update" && mutation.Op != "delete" {
+		return nil, invalidSyncResult("op", "must be one of create, update or delete"), nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(mutation.ID)
+	if err != nil {
+		return nil, invalidSyncResult("id", "must be a valid id"), nil
+	}
+
+	project, err := app.memberProject(ctx, user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, syncResult{Status: syncStatusNotFound}, nil
+		default:
+			return nil, syncResult{}, err
+		}
+	}
+
+	if project.Version != mutation.BaseVersion {
+		return nil, syncResult{Status: syncStatusConflict, Project: project}, nil
+	}
+	return project, syncResult{}, nil
+}
+
+// loadTaskForMutation is the task counterpart of loadProjectForMutation,
+// except that it leaves the version check to the caller so that stale
+// updates can be merged.
+func (app *application) loadTaskForMutation(ctx context.Context, user *data.User, mutation syncMutation) (*data.Task, syncResult, error) {
+	if mutation.Op != "update" && mutation.Op != "delete" {
+		return nil, invalidSyncResult("op", "must be one of create, update or delete"), nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(mutation.ID)
+	if err != nil {
+		return nil, invalidSyncResult("id", "must be a valid id"), nil
+	}
+
+	task, err := app.memberTask(ctx, user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, syncResult{Status: syncStatusNotFound}, nil
+		default:
+			return nil, syncResult{}, err
+		}
+	}
+	return task, syncResult{}, nil
+}
+
+func (app *application) projectConflict(ctx context.Context, user *data.User, id primitive.ObjectID) (syncResult, error) {
+	project, err := app.memberProject(ctx, user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return syncResult{Status: syncStatusConflict}, nil
+		default:
+			return syncResult{}, err
+		}
+	}
+	return syncResult{Status: syncStatusConflict, Project: project}, nil
+}
+
+func (app *application) taskConflict(ctx context.Context, user *data.User, id primitive.ObjectID) (syncResult, error) {
+	task, err := app.memberTask(ctx, user, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return syncResult{Status: syncStatusConflict}, nil
+		default:
+			return syncResult{}, err
+		}
+	}
+	return syncResult{Status: syncStatusConflict, Task: task}, nil
+}
+
+// memberProject fetches a project, reporting ErrRecordNotFound if it is in a
+// workspace the user doesn't belong to so that its existence isn't leaked.
+func (app *application) memberProject(ctx context.Context, user *data.User, id primitive.ObjectID) (*data.Project, error) {
+	return findMemberProject(ctx, app.models, user, id)
+}
+
+func (app *application) memberTask(ctx context.Context, user *data.User, id primitive.ObjectID) (*data.Task, error) {
+	return findMemberTask(ctx, app.models, user, id)
+}
+
+// findMemberProject and findMemberTask are memberProject and memberTask
+// against the given models, such as those bound to a transaction.
+func findMemberProject(ctx context.Context, m data.Models, user *data.User, id primitive.ObjectID) (*data.Project, error) {
+	project, err := m.Projects.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := m.Workspaces.IsMember(ctx, project.WorkspaceID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	return project, nil
+}
+
+func findMemberTask(ctx context.Context, m data.Models, user *data.User, id primitive.ObjectID) (*data.Task, error) {
+	task, err := m.Tasks.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = findMemberProject(ctx, m, user, task.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func invalidSyncResult(key, message string) syncResult {
+	return syncResult{Status: syncStatusInvalid, Errors: map[string]string{key: message}}
+}
+
+func decodeMutationData(raw json.RawMessage, dst interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("must be provided")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
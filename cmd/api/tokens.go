@@ -28,7 +28,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -50,7 +50,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -61,3 +61,37 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// apiKeyTTL is how long an API key lasts. Keys are meant for clients which
+// store their credentials, such as calendar apps, so they outlive the
+// authentication tokens handed out at sign in by far.
+const apiKeyTTL = 365 * 24 * time.Hour
+
+// createAPIKeyHandler issues the user a new API key. A user can hold any
+// number of them; the key itself is only shown here.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := app.models.Tokens.New(r.Context(), app.contextGetUser(r).ID, apiKeyTTL, data.ScopeAPIKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeysHandler revokes every API key the user holds.
+func (app *application) deleteAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeAPIKey, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api keys successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -47,7 +48,17 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Users.Insert(user)
+
+	var token *data.Token
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		err := tx.Users.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(ctx, user.ID, 24*time.Hour, data.ScopeActivation)
+		return err
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -59,12 +70,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	app.background(func() {
 		data := map[string]interface{}{
 			"activationToken": token.PlainToken,
@@ -104,7 +109,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	userID, err := app.models.Tokens.GetUserIDForToken(data.ScopeActivation, input.TokenPlaintext)
+	userID, err := app.models.Tokens.GetUserIDForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -115,7 +120,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	user, err := app.models.Users.GetByID(userID)
+	user, err := app.models.Users.GetByID(r.Context(), userID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -127,23 +132,24 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	user.Activated = true
-	err = app.models.Users.Update(user)
+	err = app.models.WithTransaction(r.Context(), func(ctx context.Context, tx data.Models) error {
+		err := tx.Users.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
-		// case errors.Is(err, data.ErrEditConflict):
-		//     app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+// listViewsHandler returns the user's own saved views along with those
+// shared with the workspaces they belong to.
+func (app *application) listViewsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	views, err := app.models.Views.ForUser(r.Context(), user.ID, workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"views": views}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createViewHandler saves a view for the user alone, or shares it with a
+// workspace they belong to when one is named. Naming the personal
+// workspace is the same as naming none.
+func (app *application) createViewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WorkspaceID *string `json:"workspace_id"`
+		Name        string  `json:"name"`
+		Query       string  `json:"query"`
+		Sort        string  `json:"sort"`
+		GroupBy     string  `json:"group_by"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	view := &data.SavedView{
+		OwnerID: user.ID,
+		Name:    input.Name,
+		Query:   input.Query,
+		Sort:    input.Sort,
+		GroupBy: input.GroupBy,
+	}
+
+	v := validator.New()
+
+	if input.WorkspaceID != nil {
+		workspaceID, err := primitive.ObjectIDFromHex(*input.WorkspaceID)
+		if err != nil {
+			v.AddError("workspace_id", "must be a valid id")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if workspaceID != user.ID {
+			_, err = app.getMemberWorkspace(r.Context(), user, workspaceID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					v.AddError("workspace_id", "must be a workspace you belong to")
+					app.failedValidationResponse(w, r, v.Errors)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+			view.WorkspaceID = &workspaceID
+		}
+	}
+
+	if data.ValidateSavedView(v, view); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Views.Insert(r.Context(), view)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"view": view}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showViewHandler(w http.ResponseWriter, r *http.Request) {
+	view, ok := app.visibleView(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"view": view}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateViewHandler changes any of the view's name, query, sort and
+// grouping. Only the user who saved the view can change it.
+func (app *application) updateViewHandler(w http.ResponseWriter, r *http.Request) {
+	view, ok := app.ownedView(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name    *string `json:"name"`
+		Query   *string `json:"query"`
+		Sort    *string `json:"sort"`
+		GroupBy *string `json:"group_by"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		view.Name = *input.Name
+	}
+	if input.Query != nil {
+		view.Query = *input.Query
+	}
+	if input.Sort != nil {
+		view.Sort = *input.Sort
+	}
+	if input.GroupBy != nil {
+		view.GroupBy = *input.GroupBy
+	}
+
+	v := validator.New()
+	if data.ValidateSavedView(v, view); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Views.Update(r.Context(), view)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"view": view}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteViewHandler(w http.ResponseWriter, r *http.Request) {
+	view, ok := app.ownedView(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Views.Delete(r.Context(), view.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "view successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// viewTasksHandler runs the view's query, with dates in the user's time
+// zone, relative due dates counted from now and assignee:me standing for the
+// user, and returns a page of the tasks it finds in the view's order. A view
+// which groups its tasks returns groups instead of a flat list. Views shared
+// with a workspace only find that workspace's tasks.
+// Pages follow one another in the order the tasks were created, and each is
+// sorted and grouped by itself. The cursor is the id of the last task read
+// for the page, to pass as after for the next.
+func (app *application) viewTasksHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	limit := app.readInt(qs, "limit", 100, v)
+	v.Check(limit >= 1 && limit <= exportPageSize, "limit", "must be between 1 and 500")
+
+	var after primitive.ObjectID
+	if s := qs.Get("after"); s != "" {
+		var err error
+		after, err = primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("after", "must be a valid id")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	view, ok := app.visibleView(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	prefs, err := app.models.Preferences.Get(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filters, err := data.ParseTaskQuery(view.Query, user.ID, time.Now().In(prefs.Location()))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	workspaceIDs := []primitive.ObjectID{}
+	if view.WorkspaceID != nil {
+		workspaceIDs = append(workspaceIDs, *view.WorkspaceID)
+	} else {
+		workspaceIDs, err = app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	filters.ProjectIDs, err = app.models.Projects.IDsForWorkspaces(r.Context(), workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tasks, err := app.models.Tasks.List(r.Context(), filters, after, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cursor := qs.Get("after")
+	if len(tasks) > 0 {
+		cursor = tasks[len(tasks)-1].ID.Hex()
+	}
+
+	data.SortTasks(tasks, view.Sort)
+
+	env := envelope{"view": view, "cursor": cursor, "has_more": len(tasks) == limit}
+	if view.GroupBy != "" {
+		env["groups"] = data.GroupTasks(tasks, view.GroupBy)
+	} else {
+		env["tasks"] = tasks
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// visibleView fetches the view named by the id URL parameter, replying with
+// a 404 unless it is the user's own or shared with a workspace they belong
+// to. It reports false when a response has already been sent.
+func (app *application) visibleView(w http.ResponseWriter, r *http.Request) (*data.SavedView, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+
+	view, err := app.models.Views.Get(r.Context(), id)
+	if err == nil {
+		switch {
+		case view.WorkspaceID != nil:
+			var member bool
+			member, err = app.models.Workspaces.IsMember(r.Context(), *view.WorkspaceID, user.ID)
+			if err == nil && !member {
+				err = data.ErrRecordNotFound
+			}
+		case view.OwnerID != user.ID:
+			err = data.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return view, true
+}
+
+// ownedView is like visibleView but also requires the user to have saved
+// the view. Other members of a workspace get a 403 for its shared views.
+func (app *application) ownedView(w http.ResponseWriter, r *http.Request) (*data.SavedView, bool) {
+	view, ok := app.visibleView(w, r)
+	if !ok {
+		return nil, false
+	}
+	if view.OwnerID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+	return view, true
+}
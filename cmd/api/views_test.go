@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tasksync/internal/data"
+)
+
+// TestViewTasksPages checks that a view's tasks are listed a page at a time,
+// each page picking up where the cursor left off.
+func TestViewTasksPages(t *testing.T) {
+	app := newTestApplication(t)
+	ctx := context.Background()
+
+	user := insertTestUser(t, app, "Views", "views@example.com")
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Views"}
+	if err := app.models.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"C", "A", "B"} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: user.ID, Title: title, Status: data.TaskStatusTodo}
+		if err := app.models.Tasks.Insert(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	view := &data.SavedView{OwnerID: user.ID, Name: "Open", Query: "status:open", Sort: "title"}
+	if err := app.models.Views.Insert(ctx, view); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		titles  []string
+		hasMore bool
+	}{
+		{[]string{"A", "C"}, true},
+		{[]string{"B"}, false},
+	}
+
+	cursor := ""
+	for i, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/views/"+view.ID.Hex()+"/tasks?limit=2&after="+cursor, nil)
+		r = withParams(r, "id", view.ID.Hex())
+		w := httptest.NewRecorder()
+		app.viewTasksHandler(w, app.contextSetUser(r, user))
+		if w.Code != http.StatusOK {
+			t.Fatalf("page %d: got status %d; want %d: %s", i, w.Code, http.StatusOK, w.Body)
+		}
+
+		var response struct {
+			Tasks   []*data.Task `json:"tasks"`
+			Cursor  string       `json:"cursor"`
+			HasMore bool         `json:"has_more"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		for _, task := range response.Tasks {
+			titles = append(titles, task.Title)
+		}
+		if len(titles) != len(tt.titles) {
+			t.Fatalf("page %d: got %v; want %v", i, titles, tt.titles)
+		}
+		for j := range titles {
+			if titles[j] != tt.titles[j] {
+				t.Errorf("page %d: got %v; want %v", i, titles, tt.titles)
+				break
+			}
+		}
+		if response.HasMore != tt.hasMore {
+			t.Errorf("page %d: got has_more %t; want %t", i, response.HasMore, tt.hasMore)
+		}
+		cursor = response.Cursor
+	}
+}
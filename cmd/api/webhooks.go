@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+// listWebhooksHandler returns the webhooks of every workspace the user owns,
+// including their personal workspace.
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	workspaces, err := app.models.Workspaces.ForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var workspaceIDs []primitive.ObjectID
+	for _, workspace := range workspaces {
+		if workspace.OwnerID == user.ID {
+			workspaceIDs = append(workspaceIDs, workspace.ID)
+		}
+	}
+
+	webhooks, err := app.models.Webhooks.ForWorkspaces(r.Context(), workspaceIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createWebhookHandler registers a webhook on a workspace the user owns,
+// their personal workspace unless another is named. The signing secret is
+// generated when the client doesn't supply one, and this is the only
+// response which includes it.
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		WorkspaceID *string  `json:"workspace_id"`
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Secret      *string  `json:"secret"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	webhook := &data.Webhook{
+		WorkspaceID: user.ID,
+		CreatedBy:   user.ID,
+		URL:         input.URL,
+		Events:      input.Events,
+	}
+
+	v := validator.New()
+
+	if input.WorkspaceID != nil {
+		workspaceID, err := primitive.ObjectIDFromHex(*input.WorkspaceID)
+		if err != nil {
+			v.AddError("workspace_id", "must be a valid id")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		owner, err := app.ownsWorkspace(r.Context(), user, workspaceID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !owner {
+			v.AddError("workspace_id", "must be a workspace you own")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		webhook.WorkspaceID = workspaceID
+	}
+
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	} else {
+		webhook.Secret, err = data.GenerateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Personal workspaces are only stored once first listed, and the
+	// webhook must refer to a stored one.
+	if webhook.WorkspaceID == user.ID {
+		_, err = app.models.Workspaces.ForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhookHandler changes any of the webhook's URL, events, secret and
+// active flag. Setting active to true re-enables a webhook which was
+// disabled after repeated failures.
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Secret *string  `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(r.Context(), webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Webhooks.Delete(r.Context(), webhook.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookDeliveriesHandler returns the webhook's most recent deliveries,
+// newest first, with every attempt made for each.
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 20, v)
+	v.Check(limit >= 1 && limit <= 100, "limit", "must be between 1 and 100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, err := app.models.Deliveries.ForWebhook(r.Context(), webhook.ID, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhookHandler queues the payload of an earlier delivery again as
+// a new delivery with a fresh set of attempts. The original is left as it
+// was.
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := app.readIDParam(r, "delivery_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	original, err := app.models.Deliveries.Get(r.Context(), deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if original.WebhookID != webhook.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !webhook.Active {
+		v := validator.New()
+		v.AddError("webhook", "must be re-enabled before redelivering")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	delivery := &data.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   original.EventID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+	}
+
+	err = app.models.Deliveries.Insert(r.Context(), delivery)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.wakeWebhookWorker()
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ownedWebhook fetches the webhook named by the id URL parameter, replying
+// with a 404 unless the user owns its workspace. It reports false when a
+// response has already been sent.
+func (app *application) ownedWebhook(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err := app.models.Webhooks.Get(r.Context(), id)
+	if err == nil {
+		var owner bool
+		owner, err = app.ownsWorkspace(r.Context(), app.contextGetUser(r), webhook.WorkspaceID)
+		if err == nil && !owner {
+			err = data.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return webhook, true
+}
+
+// ownsWorkspace reports whether the user owns the workspace. Everyone owns
+// their personal workspace, whether or not it has been stored yet.
+func (app *application) ownsWorkspace(ctx context.Context, user *data.User, workspaceID primitive.ObjectID) (bool, error) {
+	if workspaceID == user.ID {
+		return true, nil
+	}
+
+	workspace, err := app.models.Workspaces.Get(ctx, workspaceID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return workspace.OwnerID == user.ID, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+func (app *application) listWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	workspaces, err := app.models.Workspaces.ForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"workspaces": workspaces}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	workspace := &data.Workspace{
+		Name:    input.Name,
+		OwnerID: user.ID,
+	}
+
+	v := validator.New()
+	if data.ValidateWorkspace(v, workspace); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Workspaces.Insert(r.Context(), workspace)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"workspace": workspace}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addWorkspaceMemberHandler lets the owner of a shared workspace add another
+// user by email. The new member sees the workspace's existing projects the
+// next time they pull /v1/sync from a zero cursor.
+func (app *application) addWorkspaceMemberHandler(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := app.ownedWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	member, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching user found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Workspaces.AddMember(r.Context(), workspace, member.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"workspace": workspace}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeWorkspaceMemberHandler lets the owner remove a member, or a member
+// leave, and ends any live event subscriptions the member had to it.
+func (app *application) removeWorkspaceMemberHandler(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := app.memberWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if user.ID != workspace.OwnerID && user.ID != userID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	if !workspace.HasMember(userID) {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if userID == workspace.OwnerID {
+		v := validator.New()
+		v.AddError("user_id", "the owner can't leave their workspace")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Workspaces.RemoveMember(r.Context(), workspace, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.hub.Unsubscribe(workspace.ID, userID)
+
+	err = app.removeMemberFromTasks(r.Context(), workspace, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"workspace": workspace}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMemberFromTasks unassigns a user who has left the workspace from
+// its tasks and stops them watching any.
+func (app *application) removeMemberFromTasks(ctx context.Context, workspace *data.Workspace, userID primitive.ObjectID) error {
+	projectIDs, err := app.models.Projects.IDsForWorkspaces(ctx, []primitive.ObjectID{workspace.ID})
+	if err != nil {
+		return err
+	}
+	if len(projectIDs) == 0 {
+		return nil
+	}
+
+	err = app.models.Watchers.RemoveForProjects(ctx, userID, projectIDs)
+	if err != nil {
+		return err
+	}
+
+	filters := data.Filters{
+		ProjectIDs:  projectIDs,
+		AssigneeIDs: []primitive.ObjectID{userID},
+	}
+
+	after := primitive.NilObjectID
+	for {
+		tasks, err := app.models.Tasks.List(ctx, filters, after, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			saved, err := app.unassignTask(ctx, task, userID)
+			if err != nil {
+				return err
+			}
+			if saved == nil {
+				continue
+			}
+			event, ok := app.taskEvent(ctx, saved, events.TaskUpdated)
+			if ok {
+				app.publish(event)
+			}
+		}
+
+		if len(tasks) < exportPageSize {
+			return nil
+		}
+		after = tasks[len(tasks)-1].ID
+	}
+}
+
+// unassignTask takes the user off the task, fetching it again to retry if
+// it has changed in the meantime. It returns the task as saved, or nil if
+// it has since been deleted or the user unassigned.
+func (app *application) unassignTask(ctx context.Context, task *data.Task, userID primitive.ObjectID) (*data.Task, error) {
+	for task.IsAssignee(userID) {
+		var assigneeIDs []primitive.ObjectID
+		for _, id := range task.AssigneeIDs {
+			if id != userID {
+				assigneeIDs = append(assigneeIDs, id)
+			}
+		}
+		task.AssigneeIDs = assigneeIDs
+
+		err := app.models.Tasks.Update(ctx, task)
+		if !errors.Is(err, data.ErrEditConflict) {
+			return task, err
+		}
+
+		task, err = app.models.Tasks.Get(ctx, task.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// memberWorkspace fetches the workspace named by the id URL parameter,
+// replying with a 404 unless the user belongs to it. It reports false when
+// a response has already been sent.
+func (app *application) memberWorkspace(w http.ResponseWriter, r *http.Request) (*data.Workspace, bool) {
+	id, err := app.readIDParam(r, "id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	workspace, err := app.getMemberWorkspace(r.Context(), app.contextGetUser(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return workspace, true
+}
+
+// ownedWorkspace is like memberWorkspace but also requires the user to own
+// the workspace, and refuses personal workspaces, which can't be shared.
+func (app *application) ownedWorkspace(w http.ResponseWriter, r *http.Request) (*data.Workspace, bool) {
+	workspace, ok := app.memberWorkspace(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if workspace.OwnerID != app.contextGetUser(r).ID || workspace.Personal {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+	return workspace, true
+}
+
+// getMemberWorkspace fetches the workspace, failing with ErrRecordNotFound
+// unless the user belongs to it. Personal workspaces are only stored once
+// first listed, so the user's own is stored if it hasn't been yet.
+func (app *application) getMemberWorkspace(ctx context.Context, user *data.User, id primitive.ObjectID) (*data.Workspace, error) {
+	workspace, err := app.models.Workspaces.Get(ctx, id)
+	if errors.Is(err, data.ErrRecordNotFound) && id == user.ID {
+		workspaces, err := app.models.Workspaces.ForUser(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, workspace := range workspaces {
+			if workspace.ID == id {
+				return workspace, nil
+			}
+		}
+		return nil, data.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !workspace.HasMember(user.ID) {
+		return nil, data.ErrRecordNotFound
+	}
+	return workspace, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+)
+
+func insertTestUser(t *testing.T, app *application, name, email string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: name, Email: email, Activated: true}
+	if err := user.SetPassword("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// withParams returns the request with the router's URL parameters set, for
+// calling a handler directly.
+func withParams(r *http.Request, params ...string) *http.Request {
+	var ps httprouter.Params
+	for i := 0; i+1 < len(params); i += 2 {
+		ps = append(ps, httprouter.Param{Key: params[i], Value: params[i+1]})
+	}
+	return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, ps))
+}
+
+// TestRemoveWorkspaceMember checks that a member who leaves a workspace is
+// unassigned from its tasks and stops watching them.
+func TestRemoveWorkspaceMember(t *testing.T) {
+	app := newTestApplication(t)
+	app.hub = events.NewHub(100)
+	defer app.wg.Wait()
+	ctx := context.Background()
+
+	owner := insertTestUser(t, app, "Owner", "owner@example.com")
+	member := insertTestUser(t, app, "Member", "member@example.com")
+
+	workspace := &data.Workspace{Name: "Team", OwnerID: owner.ID}
+	if err := app.models.Workspaces.Insert(ctx, workspace); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Workspaces.AddMember(ctx, workspace, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	project := &data.Project{WorkspaceID: workspace.ID, OwnerID: owner.ID, Name: "Team"}
+	if err := app.models.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &data.Task{
+		ProjectID:   project.ID,
+		CreatedBy:   owner.ID,
+		Title:       "Shared",
+		Status:      data.TaskStatusTodo,
+		AssigneeIDs: []primitive.ObjectID{owner.ID, member.ID},
+	}
+	if err := app.models.Tasks.Insert(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []primitive.ObjectID{owner.ID, member.ID} {
+		if err := app.models.Watchers.Add(ctx, task.ID, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/v1/workspaces/"+workspace.ID.Hex()+"/members/"+member.ID.Hex(), nil)
+	r = withParams(r, "id", workspace.ID.Hex(), "user_id", member.ID.Hex())
+	w := httptest.NewRecorder()
+	app.removeWorkspaceMemberHandler(w, app.contextSetUser(r, owner))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	task, err := app.models.Tasks.Get(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.AssigneeIDs) != 1 || task.AssigneeIDs[0] != owner.ID {
+		t.Errorf("got assignees %v; want only the owner", task.AssigneeIDs)
+	}
+
+	watchers, err := app.models.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watchers) != 1 || watchers[0] != owner.ID {
+		t.Errorf("got watchers %v; want only the owner", watchers)
+	}
+}
+
+// TestMovedTaskWatchers checks that moving a task to another workspace
+// unassigns those who aren't members of it and stops them watching.
+func TestMovedTaskWatchers(t *testing.T) {
+	app := newTestApplication(t)
+	app.hub = events.NewHub(100)
+	defer app.wg.Wait()
+	ctx := context.Background()
+
+	owner := insertTestUser(t, app, "Mover", "mover@example.com")
+	member := insertTestUser(t, app, "Left", "left@example.com")
+
+	workspace := &data.Workspace{Name: "Team", OwnerID: owner.ID}
+	if err := app.models.Workspaces.Insert(ctx, workspace); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Workspaces.AddMember(ctx, workspace, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	team := &data.Project{WorkspaceID: workspace.ID, OwnerID: owner.ID, Name: "Team"}
+	if err := app.models.Projects.Insert(ctx, team); err != nil {
+		t.Fatal(err)
+	}
+	personal := &data.Project{WorkspaceID: owner.ID, OwnerID: owner.ID, Name: "Personal"}
+	if err := app.models.Projects.Insert(ctx, personal); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &data.Task{
+		ProjectID:   team.ID,
+		CreatedBy:   owner.ID,
+		Title:       "Shared",
+		Status:      data.TaskStatusTodo,
+		AssigneeIDs: []primitive.ObjectID{member.ID},
+	}
+	if err := app.models.Tasks.Insert(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Watchers.Add(ctx, task.ID, member.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	task.ProjectID = personal.ID
+	result, ok, err := app.checkTask(ctx, owner, task)
+	if !ok || err != nil {
+		t.Fatalf("got %v, %v checking the task", result, err)
+	}
+	if err := app.models.Tasks.Update(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	app.publishTask(ctx, task, events.TaskUpdated)
+
+	if len(task.AssigneeIDs) != 0 {
+		t.Errorf("got assignees %v; want none", task.AssigneeIDs)
+	}
+	watchers, err := app.models.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watchers) != 0 {
+		t.Errorf("got watchers %v; want none", watchers)
+	}
+}
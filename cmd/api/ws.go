@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const (
+	// Time allowed to write a single message to the client.
+	wsWriteWait = 10 * time.Second
+	// Time allowed between pongs before the connection is considered dead.
+	wsPongWait = 60 * time.Second
+	// How often pings are sent, which must be less than wsPongWait.
+	wsPingPeriod = wsPongWait * 9 / 10
+	// Largest message accepted from the client. Clients only ever need to
+	// send control frames.
+	wsMaxMessageSize = 512
+	// Number of events buffered for a WebSocket or SSE stream before it is
+	// considered too slow and dropped.
+	eventBufferSize = 64
+)
+
+// authenticateQueryToken accepts the authentication token as a token query
+// string parameter when no Authorization header was sent, because browsers
+// can't set headers when opening a WebSocket or an EventSource.
+func (app *application) authenticateQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" || !app.contextGetUser(r).IsAnonymous() {
+			next(w, r)
+			return
+		}
+
+		user, err := app.userForToken(r.Context(), token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		next(w, app.contextSetUser(r, user))
+	}
+}
+
+// wsHandler streams project and task change events for the user's
+// workspaces, or just those named by workspace_id query parameters, over a
+// WebSocket. A client which can't keep up is disconnected with close code
+// 1013 and should catch up through /v1/sync using the last event id it saw
+// as the cursor before reconnecting.
+func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	workspaceIDs, ok := app.subscriptionWorkspaces(w, r)
+	if !ok {
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     app.checkWebSocketOrigin,
+	}
+
+	// Count the connection as background work so that a graceful shutdown
+	// waits for its close frame to be sent. This must happen before the
+	// upgrade, while srv.Shutdown still tracks the request.
+	app.wg.Add(1)
+	defer app.wg.Done()
+
+	// Upgrade replies to the client itself if the handshake fails.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub, err := app.hub.Subscribe(user.ID, workspaceIDs, eventBufferSize)
+	if err != nil {
+		writeWebSocketClose(conn, websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	defer sub.Close()
+
+	// The client is never expected to send anything but control frames, but
+	// reading is what processes pongs and notices the connection closing.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		conn.SetReadLimit(wsMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			if err != nil {
+				return
+			}
+
+		case <-sub.Done():
+			switch sub.Err() {
+			case events.ErrHubClosed:
+				writeWebSocketClose(conn, websocket.CloseGoingAway, "server shutting down")
+			case events.ErrSlowConsumer:
+				writeWebSocketClose(conn, websocket.CloseTryAgainLater, "too many pending events, resync and reconnect")
+			default:
+				writeWebSocketClose(conn, websocket.ClosePolicyViolation, "removed from workspace")
+			}
+			return
+
+		case <-closed:
+			return
+		}
+	}
+}
+
+// subscriptionWorkspaces returns the workspaces an event stream should
+// cover: those named by workspace_id query parameters, or otherwise all of
+// the user's workspaces. It reports false when a response has already been
+// sent.
+func (app *application) subscriptionWorkspaces(w http.ResponseWriter, r *http.Request) ([]primitive.ObjectID, bool) {
+	user := app.contextGetUser(r)
+
+	workspaceIDs, err := app.models.Workspaces.IDsForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	requested := r.URL.Query()["workspace_id"]
+	if len(requested) == 0 {
+		return workspaceIDs, true
+	}
+
+	v := validator.New()
+	ids := make([]primitive.ObjectID, 0, len(requested))
+	for _, s := range requested {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			v.AddError("workspace_id", "must be a valid id")
+			break
+		}
+		if !containsID(workspaceIDs, id) {
+			v.AddError("workspace_id", "must be a workspace you belong to")
+			break
+		}
+		ids = append(ids, id)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	return ids, true
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebSocketOrigin allows clients without an Origin header, pages
+// served from the API's own host and the trusted CORS origins.
+func (app *application) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && u.Host == r.Host {
+		return true
+	}
+
+	for _, trusted := range app.config.cors.trustedOrigins {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+func writeWebSocketClose(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+}
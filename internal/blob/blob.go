@@ -0,0 +1,22 @@
+// Package blob stores the contents of uploaded files, such as task
+// attachments, apart from the database which holds what is known about
+// them. Blobs are written once under a key chosen by the caller and never
+// changed.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob: not found")
+
+// BlobStore is somewhere blobs can be kept. Put stores size bytes read from
+// r under key, Get returns them, failing with ErrNotFound if there are none,
+// and Delete removes them, succeeding if they are already gone.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,162 @@
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tasksync/internal/blob"
+)
+
+func TestLocal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	key := "workspaces/1/tasks/2/notes.txt"
+
+	put := func(content string) {
+		t.Helper()
+		err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func() string {
+		t.Helper()
+		rc, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		body, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	put("first")
+	if got := get(); got != "first" {
+		t.Errorf("got %q; want first", got)
+	}
+
+	// Keys name files below the directory, slashes making subdirectories.
+	if _, err := os.Stat(filepath.Join(dir, "workspaces", "1", "tasks", "2", "notes.txt")); err != nil {
+		t.Error(err)
+	}
+
+	put("second")
+	if got := get(); got != "second" {
+		t.Errorf("got %q after replacing; want second", got)
+	}
+
+	// Nothing but the blob is left behind by writing it.
+	entries, err := os.ReadDir(filepath.Join(dir, "workspaces", "1", "tasks", "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files beside the blob; want none", len(entries)-1)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ctx, key)
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("got error %v getting a deleted blob; want %v", err, blob.ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("got error %v deleting a deleted blob; want none", err)
+	}
+}
+
+// failingReader fails part way through a blob.
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestLocalFailedPut(t *testing.T) {
+	dir := t.TempDir()
+	store, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(context.Background(), "partial.txt", io.MultiReader(strings.NewReader("part"), failingReader{}), 100, "text/plain")
+	if err == nil {
+		t.Fatal("got no error from a failed read")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d files left behind by a failed put; want none", len(entries))
+	}
+}
+
+func TestLocalInvalidKeys(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "blobs")
+	store, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file beside the store's directory, which no key may reach.
+	outside := filepath.Join(root, "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	keys := []string{
+		"",
+		".",
+		"a/..",
+		"..",
+		"../secret.txt",
+		"a/../../secret.txt",
+		"/etc/passwd",
+		filepath.Join(root, "secret.txt"),
+	}
+
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain")
+			if err == nil {
+				t.Error("put: got no error")
+			}
+
+			rc, err := store.Get(ctx, key)
+			if err == nil {
+				rc.Close()
+				t.Error("get: got no error")
+			}
+			if errors.Is(err, blob.ErrNotFound) {
+				t.Error("get: got ErrNotFound; want the key refused")
+			}
+
+			if err := store.Delete(ctx, key); err == nil {
+				t.Error("delete: got no error")
+			}
+		})
+	}
+
+	got, err := os.ReadFile(outside)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("got %q, %v for the file outside the store; want it untouched", got, err)
+	}
+}
@@ -0,0 +1,224 @@
+package blob_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tasksync/internal/blob"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion          = "eu-west-1"
+	testBucket          = "tasksync-attachments"
+)
+
+// fakeS3 stands in for an S3 bucket. It checks every request's Signature
+// Version 4 Authorization header against a canonical request rebuilt from
+// what it received, and records the canonical requests for the test to
+// compare against what S3 would expect.
+type fakeS3 struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	objects   map[string][]byte
+	types     map[string]string
+	canonical []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	canonical, err := verifySignature(r)
+	if err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.RequestURI, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	f.canonical = append(f.canonical, canonical)
+
+	// The escaped path as sent is the object's name.
+	path := r.RequestURI
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if int64(len(body)) != r.ContentLength {
+			f.t.Errorf("got %d bytes with a Content-Length of %d", len(body), r.ContentLength)
+		}
+		f.objects[path] = body
+		f.types[path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		body, ok := f.objects[path]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+// verifySignature checks the request's Authorization header as S3 would,
+// returning the canonical request it was checked against.
+func verifySignature(r *http.Request) (string, error) {
+	amzDate := r.Header.Get("X-Amz-Date")
+	now, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return "", errors.New("missing or malformed X-Amz-Date")
+	}
+	if d := time.Since(now); d < -time.Minute || d > time.Minute {
+		return "", errors.New("X-Amz-Date is not the current time")
+	}
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+		return "", errors.New("got X-Amz-Content-Sha256 " + got + "; want UNSIGNED-PAYLOAD")
+	}
+
+	date := amzDate[:8]
+	scope := date + "/" + testRegion + "/s3/aws4_request"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	path, query, _ := strings.Cut(r.RequestURI, "?")
+	canonical := strings.Join([]string{
+		r.Method,
+		path,
+		query,
+		"host:" + r.Host + "\n" +
+			"x-amz-content-sha256:" + r.Header.Get("X-Amz-Content-Sha256") + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + testSecretAccessKey)
+	for _, part := range []string{date, testRegion, "s3", "aws4_request"} {
+		key = sign(key, part)
+	}
+	signature := hex.EncodeToString(sign(key, stringToSign))
+
+	want := "AWS4-HMAC-SHA256 Credential=" + testAccessKeyID + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+	if got := r.Header.Get("Authorization"); got != want {
+		return "", errors.New("got Authorization " + got + "; want " + want)
+	}
+	return canonical, nil
+}
+
+func sign(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func TestS3(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	store := blob.NewS3(blob.S3Config{
+		Endpoint:        srv.URL + "/",
+		Region:          testRegion,
+		Bucket:          testBucket,
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretAccessKey,
+	})
+
+	ctx := context.Background()
+	key := "workspaces/1/tasks/2/a b+c.txt"
+	path := "/" + testBucket + "/workspaces/1/tasks/2/a%20b%2Bc.txt"
+	content := "hello, world"
+
+	// canonicalRequest is the canonical request S3 expects for an operation
+	// on the key, without the date, which is only known once it is sent.
+	canonicalRequest := func(method string) string {
+		return method + "\n" + path + "\n\nhost:" + host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:"
+	}
+	checkCanonical := func(method string) {
+		t.Helper()
+		got := fake.canonical[len(fake.canonical)-1]
+		want := canonicalRequest(method)
+		if !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "\n\nhost;x-amz-content-sha256;x-amz-date\nUNSIGNED-PAYLOAD") {
+			t.Errorf("got canonical request\n%s\nwant\n%s<date>\n\nhost;x-amz-content-sha256;x-amz-date\nUNSIGNED-PAYLOAD", got, want)
+		}
+	}
+
+	err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCanonical(http.MethodPut)
+	if got := string(fake.objects[path]); got != content {
+		t.Errorf("got object %q; want %q", got, content)
+	}
+	if got := fake.types[path]; got != "text/plain" {
+		t.Errorf("got Content-Type %q; want text/plain", got)
+	}
+
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != content {
+		t.Errorf("got %q; want %q", body, content)
+	}
+	checkCanonical(http.MethodGet)
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	checkCanonical(http.MethodDelete)
+	if _, ok := fake.objects[path]; ok {
+		t.Error("object still there after delete")
+	}
+
+	_, err = store.Get(ctx, key)
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("got error %v getting a deleted blob; want %v", err, blob.ErrNotFound)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("got error %v deleting a deleted blob; want none", err)
+	}
+}
+
+func TestS3RejectedRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	store := blob.NewS3(blob.S3Config{Endpoint: srv.URL, Bucket: testBucket, AccessKeyID: testAccessKeyID, SecretAccessKey: "wrong"})
+
+	err := store.Put(context.Background(), "key", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || errors.Is(err, blob.ErrNotFound) || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("got error %v; want one carrying the 403 and S3's message", err)
+	}
+}
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// Attachment is a file uploaded to a task. Its contents are kept in a blob
+// store under StorageKey; this is what is known about them. ContentType is
+// sniffed from the contents rather than taken from the uploader, and
+// Checksum is their SHA-256 in hex. ProjectID and WorkspaceID are the
+// task's, kept in step as the task moves, so that the attachments can be
+// removed with the project and the workspace's storage totted up against
+// its quota.
+type Attachment struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	TaskID      primitive.ObjectID `json:"task_id" bson:"task_id"`
+	ProjectID   primitive.ObjectID `json:"project_id" bson:"project_id"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	UploaderID  primitive.ObjectID `json:"uploader_id" bson:"uploader_id"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	Filename    string             `json:"filename" bson:"filename"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Size        int64              `json:"size" bson:"size"`
+	Checksum    string             `json:"checksum" bson:"checksum"`
+	StorageKey  string             `json:"-" bson:"storage_key"`
+	DownloadURL string             `json:"download_url,omitempty" bson:"-"`
+}
+
+func ValidateAttachment(v *validator.Validator, attachment *Attachment, maxSize int64) {
+	v.Check(attachment.Filename != "", "filename", "must be provided")
+	v.Check(len(attachment.Filename) <= 255, "filename", "must not be more than 255 bytes long")
+	v.Check(attachment.Size > 0, "file", "must not be empty")
+	v.Check(attachment.Size <= maxSize, "file", "must not be larger than the maximum attachment size")
+}
+
+type AttachmentModel struct {
+	DB       *mongo.Collection
+	Projects *mongo.Collection
+	Timeout  time.Duration
+}
+
+func (m AttachmentModel) Insert(ctx context.Context, attachment *Attachment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	attachment.ID = primitive.NewObjectID()
+	attachment.CreatedAt = time.Now().UTC()
+
+	_, err := m.DB.InsertOne(ctx, attachment)
+	return queryError(ctx, err)
+}
+
+func (m AttachmentModel) Get(ctx context.Context, id primitive.ObjectID) (*Attachment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var attachment Attachment
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&attachment)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &attachment, nil
+}
+
+// ForTask returns the task's attachments, oldest first.
+func (m AttachmentModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Attachment, error) {
+	return m.find(ctx, bson.M{"task_id": taskID})
+}
+
+// ForProject returns the attachments of all the project's tasks, so that
+// they can be removed along with it.
+func (m AttachmentModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Attachment, error) {
+	return m.find(ctx, bson.M{"project_id": projectID})
+}
+
+// moveTask points the task's attachments at the project it is now in and
+// that project's workspace, for the task model to call whenever it saves a
+// task.
+func (m AttachmentModel) moveTask(ctx context.Context, taskID, projectID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"task_id": taskID, "project_id": bson.M{"$ne": projectID}}
+	n, err := m.DB.CountDocuments(ctx, filter)
+	if err != nil || n == 0 {
+		return queryError(ctx, err)
+	}
+
+	var project Project
+	err = m.Projects.FindOne(ctx, bson.M{"_id": projectID}).Decode(&project)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	update := bson.M{"$set": bson.M{"project_id": projectID, "workspace_id": project.WorkspaceID}}
+	_, err = m.DB.UpdateMany(ctx, filter, update)
+	return queryError(ctx, err)
+}
+
+func (m AttachmentModel) find(ctx context.Context, filter bson.M) ([]*Attachment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	attachments := []*Attachment{}
+	err = cursor.All(ctx, &attachments)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return attachments, nil
+}
+
+// Usage returns the total size of the workspace's attachments.
+func (m AttachmentModel) Usage(ctx context.Context, workspaceID primitive.ObjectID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"workspace_id": workspaceID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}}},
+	}
+
+	cursor, err := m.DB.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	var totals []struct {
+		Size int64 `bson:"size"`
+	}
+	err = cursor.All(ctx, &totals)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Size, nil
+}
+
+func (m AttachmentModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLAttachmentModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const attachmentColumns = `id, task_id, project_id, workspace_id, uploader_id, created_at, filename, content_type, size, checksum, storage_key`
+
+func (m SQLAttachmentModel) Insert(ctx context.Context, attachment *Attachment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	attachment.ID = primitive.NewObjectID()
+	attachment.CreatedAt = time.Now().UTC()
+
+	query := `
+		INSERT INTO attachments (` + attachmentColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		attachment.ID.Hex(), attachment.TaskID.Hex(), attachment.ProjectID.Hex(), attachment.WorkspaceID.Hex(),
+		attachment.UploaderID.Hex(), attachment.CreatedAt, attachment.Filename, attachment.ContentType,
+		attachment.Size, attachment.Checksum, attachment.StorageKey,
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLAttachmentModel) Get(ctx context.Context, id primitive.ObjectID) (*Attachment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments
+		WHERE id = ?`
+
+	attachment, err := scanAttachment(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return attachment, nil
+}
+
+func (m SQLAttachmentModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Attachment, error) {
+	return m.find(ctx, "task_id", taskID)
+}
+
+func (m SQLAttachmentModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Attachment, error) {
+	return m.find(ctx, "project_id", projectID)
+}
+
+// find returns the attachments whose column matches id. column is always a
+// constant.
+func (m SQLAttachmentModel) find(ctx context.Context, column string, id primitive.ObjectID) ([]*Attachment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments
+		WHERE ` + column + ` = ?
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, queryError(ctx, rows.Err())
+}
+
+func (m SQLAttachmentModel) Usage(ctx context.Context, workspaceID primitive.ObjectID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT COALESCE(SUM(size), 0)
+		FROM attachments
+		WHERE workspace_id = ?`
+
+	var size int64
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), workspaceID.Hex()).Scan(&size)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return size, nil
+}
+
+func (m SQLAttachmentModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM attachments
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var attachment Attachment
+	var id, taskID, projectID, workspaceID, uploaderID string
+
+	err := row.Scan(
+		&id,
+		&taskID,
+		&projectID,
+		&workspaceID,
+		&uploaderID,
+		&attachment.CreatedAt,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.Checksum,
+		&attachment.StorageKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range []struct {
+		src string
+		dst *primitive.ObjectID
+	}{{id, &attachment.ID}, {taskID, &attachment.TaskID}, {projectID, &attachment.ProjectID}, {workspaceID, &attachment.WorkspaceID}, {uploaderID, &attachment.UploaderID}} {
+		*f.dst, err = primitive.ObjectIDFromHex(f.src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &attachment, nil
+}
@@ -0,0 +1,129 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/data"
+	"tasksync/internal/data/datatest"
+)
+
+// The Postgres and Mongo backends are only checked when these variables
+// name a server to run against. The Postgres database is emptied by
+// migrating all the way down first, so don't point it at one you want to
+// keep; the Mongo checks use a database of their own and drop it after.
+const (
+	postgresDSNEnv = "TASKSYNC_TEST_POSTGRES_DSN"
+	mongoURIEnv    = "TASKSYNC_TEST_MONGO_URI"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrateUpDownUp(t, data.SQLMigrator{DB: db, Dialect: data.DialectSQLite})
+
+	err = datatest.Run(context.Background(), data.NewSQLModels(db, data.DialectSQLite, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skip(postgresDSNEnv + " is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator := data.SQLMigrator{DB: db, Dialect: data.DialectPostgres}
+	migrateDown(t, migrator)
+	migrateUpDownUp(t, migrator)
+
+	err = datatest.Run(context.Background(), data.NewSQLModels(db, data.DialectPostgres, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMongoConformance(t *testing.T) {
+	uri := os.Getenv(mongoURIEnv)
+	if uri == "" {
+		t.Skip(mongoURIEnv + " is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database(fmt.Sprintf("tasksync_test_%d", time.Now().UnixNano()))
+	defer db.Drop(ctx)
+
+	migrateUpDownUp(t, data.MongoMigrator{DB: db})
+
+	err = datatest.Run(ctx, data.NewModels(db, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// migrateUpDownUp applies every migration, reverts them all and applies
+// them again, so that each down migration is checked too.
+func migrateUpDownUp(t *testing.T, migrator data.Migrator) {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	migrateDown(t, migrator)
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Fatalf("migration %d_%s is not applied", s.Version, s.Name)
+		}
+	}
+}
+
+func migrateDown(t *testing.T, migrator data.Migrator) {
+	t.Helper()
+
+	for {
+		err := migrator.Down(context.Background())
+		if errors.Is(err, data.ErrNoMigrations) {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
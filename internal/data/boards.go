@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// MaxBoardWIPLimit caps the WIP limit of a board column.
+const MaxBoardWIPLimit = 1000
+
+// Board is a kanban view of a project's tasks. Each column shows the tasks
+// in one status in rank order, and a status without a column is left off
+// the board. Boards share the ranks of the project's tasks, so reordering a
+// column on one board reorders it on every board.
+type Board struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	ProjectID primitive.ObjectID `json:"project_id" bson:"project_id"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	Name      string             `json:"name" bson:"name"`
+	Columns   []BoardColumn      `json:"columns" bson:"columns"`
+	Version   int32              `json:"version" bson:"version"`
+}
+
+// BoardColumn is one column of a board. A column with a WIPLimit other
+// than zero takes no more tasks once it holds that many, though the tasks
+// already in it can still be reordered.
+type BoardColumn struct {
+	ID       primitive.ObjectID `json:"id" bson:"id"`
+	Name     string             `json:"name" bson:"name"`
+	Status   string             `json:"status" bson:"status"`
+	WIPLimit int                `json:"wip_limit" bson:"wip_limit"`
+}
+
+// DefaultBoardColumns returns a column for each status in workflow order,
+// for a board created without any.
+func DefaultBoardColumns() []BoardColumn {
+	names := map[string]string{
+		TaskStatusTodo:       "To do",
+		TaskStatusInProgress: "In progress",
+		TaskStatusDone:       "Done",
+	}
+
+	columns := make([]BoardColumn, len(TaskStatuses))
+	for i, status := range TaskStatuses {
+		columns[i] = BoardColumn{ID: primitive.NewObjectID(), Name: names[status], Status: status}
+	}
+	return columns
+}
+
+func ValidateBoard(v *validator.Validator, board *Board) {
+	v.Check(board.Name != "", "name", "must be provided")
+	v.Check(len(board.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(board.Columns) > 0, "columns", "must contain at least one column")
+
+	var ids, statuses []string
+	for _, column := range board.Columns {
+		v.Check(column.Name != "", "columns", "must not contain a column without a name")
+		v.Check(len(column.Name) <= 100, "columns", "must not contain a column name more than 100 bytes long")
+		v.Check(validator.In(column.Status, TaskStatuses...), "columns", "must map each column to one of todo, in_progress or done")
+		v.Check(column.WIPLimit >= 0 && column.WIPLimit <= MaxBoardWIPLimit, "columns", "must have WIP limits between 0 and 1000")
+		ids = append(ids, column.ID.Hex())
+		statuses = append(statuses, column.Status)
+	}
+	v.Check(validator.Unique(ids), "columns", "must not contain duplicate column ids")
+	v.Check(validator.Unique(statuses), "columns", "must not map two columns to the same status")
+}
+
+// Column returns the board's column with the given id.
+func (b *Board) Column(id primitive.ObjectID) (*BoardColumn, bool) {
+	for i := range b.Columns {
+		if b.Columns[i].ID == id {
+			return &b.Columns[i], true
+		}
+	}
+	return nil, false
+}
+
+// Statuses returns the statuses which have a column on the board.
+func (b *Board) Statuses() []string {
+	statuses := make([]string, len(b.Columns))
+	for i, column := range b.Columns {
+		statuses[i] = column.Status
+	}
+	return statuses
+}
+
+// SortTasksByRank puts tasks in rank order. Tasks which share a rank, after
+// being moved to the same place at the same time, fall back to the order
+// they were created in.
+func SortTasksByRank(tasks []*Task) {
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	})
+}
+
+// GroupBoardTasks splits tasks into a group for each of the board's
+// columns, keyed by the column id, in column order and each in rank order.
+// Tasks in a status without a column are left out.
+func GroupBoardTasks(board *Board, tasks []*Task) []*TaskGroup {
+	groups := make([]*TaskGroup, len(board.Columns))
+	index := make(map[string]*TaskGroup, len(board.Columns))
+	for i, column := range board.Columns {
+		groups[i] = &TaskGroup{Key: column.ID.Hex(), Tasks: []*Task{}}
+		index[column.Status] = groups[i]
+	}
+
+	SortTasksByRank(tasks)
+	for _, task := range tasks {
+		if group, ok := index[task.Status]; ok {
+			group.Tasks = append(group.Tasks, task)
+		}
+	}
+	return groups
+}
+
+type BoardModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+func (m BoardModel) Insert(ctx context.Context, board *Board) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	board.ID = primitive.NewObjectID()
+	board.CreatedAt = time.Now().UTC()
+	board.UpdatedAt = board.CreatedAt
+	board.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, board)
+	return queryError(ctx, err)
+}
+
+func (m BoardModel) Get(ctx context.Context, id primitive.ObjectID) (*Board, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var board Board
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&board)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &board, nil
+}
+
+// ForProject returns the project's boards, oldest first.
+func (m BoardModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Board, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := m.DB.Find(ctx, bson.M{"project_id": projectID}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	boards := []*Board{}
+	err = cursor.All(ctx, &boards)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return boards, nil
+}
+
+// Update saves the board's name and columns if it is still at
+// board.Version, returning ErrEditConflict otherwise.
+func (m BoardModel) Update(ctx context.Context, board *Board) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	board.UpdatedAt = time.Now().UTC()
+
+	filter := bson.M{"_id": board.ID, "version": board.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"updated_at": board.UpdatedAt,
+			"name":       board.Name,
+			"columns":    board.Columns,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	board.Version++
+	return nil
+}
+
+func (m BoardModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
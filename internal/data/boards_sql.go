@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLBoardModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const boardColumns = `id, project_id, created_at, updated_at, name, columns, version`
+
+func (m SQLBoardModel) Insert(ctx context.Context, board *Board) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	board.ID = primitive.NewObjectID()
+	board.CreatedAt = time.Now().UTC()
+	board.UpdatedAt = board.CreatedAt
+	board.Version = 1
+
+	columns, err := json.Marshal(board.Columns)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO boards (` + boardColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		board.ID.Hex(), board.ProjectID.Hex(), board.CreatedAt, board.UpdatedAt,
+		board.Name, string(columns), board.Version,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLBoardModel) Get(ctx context.Context, id primitive.ObjectID) (*Board, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + boardColumns + `
+		FROM boards
+		WHERE id = ?`
+
+	board, err := scanBoard(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return board, nil
+}
+
+func (m SQLBoardModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Board, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + boardColumns + `
+		FROM boards
+		WHERE project_id = ?
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), projectID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	boards := []*Board{}
+	for rows.Next() {
+		board, err := scanBoard(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		boards = append(boards, board)
+	}
+	return boards, queryError(ctx, rows.Err())
+}
+
+func (m SQLBoardModel) Update(ctx context.Context, board *Board) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	board.UpdatedAt = time.Now().UTC()
+
+	columns, err := json.Marshal(board.Columns)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE boards
+		SET updated_at = ?, name = ?, columns = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{board.UpdatedAt, board.Name, string(columns), board.ID.Hex(), board.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	board.Version++
+	return nil
+}
+
+func (m SQLBoardModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM boards
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func scanBoard(row rowScanner) (*Board, error) {
+	var board Board
+	var id, projectID, columns string
+
+	err := row.Scan(
+		&id,
+		&projectID,
+		&board.CreatedAt,
+		&board.UpdatedAt,
+		&board.Name,
+		&columns,
+		&board.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(columns), &board.Columns)
+	if err != nil {
+		return nil, err
+	}
+	board.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	board.ProjectID, err = primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &board, nil
+}
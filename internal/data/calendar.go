@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrDuplicateName = errors.New("duplicate name")
+
+// CalendarObject records the name a CalDAV client gave a task it created,
+// so that the task can be found again at the URL the client PUT it to. The
+// task keeps the iCalendar UID it was created with too. Tasks created any
+// other way have no CalendarObject and are named after their IDs.
+type CalendarObject struct {
+	ProjectID primitive.ObjectID `bson:"project_id"`
+	Name      string             `bson:"name"`
+	TaskID    primitive.ObjectID `bson:"task_id"`
+	UID       string             `bson:"uid"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type CalendarObjectModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+// Insert stores a new object, returning ErrDuplicateName if the project
+// already has one with the same name.
+func (m CalendarObjectModel) Insert(ctx context.Context, object *CalendarObject) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	object.CreatedAt = time.Now().UTC()
+
+	_, err := m.DB.InsertOne(ctx, object)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m CalendarObjectModel) GetByName(ctx context.Context, projectID primitive.ObjectID, name string) (*CalendarObject, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var object CalendarObject
+	err := m.DB.FindOne(ctx, bson.M{"project_id": projectID, "name": name}).Decode(&object)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &object, nil
+}
+
+func (m CalendarObjectModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*CalendarObject, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	cursor, err := m.DB.Find(ctx, bson.M{"project_id": projectID})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	objects := []*CalendarObject{}
+	err = cursor.All(ctx, &objects)
+	return objects, queryError(ctx, err)
+}
+
+func (m CalendarObjectModel) Delete(ctx context.Context, projectID primitive.ObjectID, name string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.DeleteOne(ctx, bson.M{"project_id": projectID, "name": name})
+	return queryError(ctx, err)
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLCalendarObjectModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const calendarObjectColumns = `project_id, name, task_id, uid, created_at`
+
+func (m SQLCalendarObjectModel) Insert(ctx context.Context, object *CalendarObject) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	object.CreatedAt = time.Now().UTC()
+
+	query := `
+		INSERT INTO calendar_objects (` + calendarObjectColumns + `)
+		VALUES (?, ?, ?, ?, ?)`
+
+	args := []interface{}{object.ProjectID.Hex(), object.Name, object.TaskID.Hex(), object.UID, object.CreatedAt}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLCalendarObjectModel) GetByName(ctx context.Context, projectID primitive.ObjectID, name string) (*CalendarObject, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + calendarObjectColumns + `
+		FROM calendar_objects
+		WHERE project_id = ? AND name = ?`
+
+	object, err := scanCalendarObject(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), projectID.Hex(), name))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return object, nil
+}
+
+func (m SQLCalendarObjectModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*CalendarObject, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + calendarObjectColumns + `
+		FROM calendar_objects
+		WHERE project_id = ?`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), projectID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	objects := []*CalendarObject{}
+	for rows.Next() {
+		object, err := scanCalendarObject(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, queryError(ctx, rows.Err())
+}
+
+func (m SQLCalendarObjectModel) Delete(ctx context.Context, projectID primitive.ObjectID, name string) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM calendar_objects
+		WHERE project_id = ? AND name = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), projectID.Hex(), name)
+	return queryError(ctx, err)
+}
+
+func scanCalendarObject(row rowScanner) (*CalendarObject, error) {
+	var object CalendarObject
+	var projectID, taskID string
+
+	err := row.Scan(&projectID, &object.Name, &taskID, &object.UID, &object.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	object.ProjectID, err = primitive.ObjectIDFromHex(projectID)
+	if err != nil {
+		return nil, err
+	}
+	object.TaskID, err = primitive.ObjectIDFromHex(taskID)
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
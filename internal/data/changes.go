@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every write to a synced document stamps it with the next value of a
+// single server-wide change sequence. Clients use the highest sequence they
+// have seen as their sync cursor, which only ever moves forward.
+const changeSeqName = "changes"
+
+// Sequences are allocated before the write that uses them commits, so a
+// slow writer can commit below sequences other writers have already
+// committed. Each allocation is therefore claimed along with it and the
+// claim released once the write is done, and sync cursors are held below
+// the oldest claim still open. Claims older than changeClaimTimeout are
+// taken to belong to writers which died before releasing them.
+const changeClaimTimeout = time.Minute
+
+type ChangeModel struct {
+	Counters *mongo.Collection
+	Timeout  time.Duration
+}
+
+// Horizon returns the highest change sequence at or below which every write
+// has committed, which is as far as a sync cursor can safely move.
+func (m ChangeModel) Horizon(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var counter struct {
+		Value  int64 `bson:"value"`
+		Claims []struct {
+			Seq int64     `bson:"seq"`
+			At  time.Time `bson:"at"`
+		} `bson:"claims"`
+	}
+	err := m.Counters.FindOne(ctx, bson.M{"_id": changeSeqName}).Decode(&counter)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, queryError(ctx, err)
+	}
+
+	horizon := counter.Value
+	cutoff := time.Now().Add(-changeClaimTimeout)
+	for _, claim := range counter.Claims {
+		if claim.At.After(cutoff) && claim.Seq <= horizon {
+			horizon = claim.Seq - 1
+		}
+	}
+	return horizon, nil
+}
+
+// nextMongoSeq allocates and claims the next change sequence in a single
+// update of the counter, dropping any claims which have timed out.
+func nextMongoSeq(ctx context.Context, counters *mongo.Collection) (int64, error) {
+	now := time.Now().UTC()
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"value": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$value", 0}}, 1}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"claims": bson.M{"$concatArrays": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$claims", bson.A{}}},
+					"cond":  bson.M{"$gt": bson.A{"$$this.at", now.Add(-changeClaimTimeout)}},
+				}},
+				bson.A{bson.M{"seq": "$value", "at": now}},
+			}},
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := counters.FindOneAndUpdate(ctx, bson.M{"_id": changeSeqName}, update, opts).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Value, nil
+}
+
+// releaseMongoSeq releases the claim on seq once the write using it is
+// done, whether or not it succeeded. Errors are ignored, since a claim left
+// behind only holds sync cursors back until it times out.
+func releaseMongoSeq(ctx context.Context, counters *mongo.Collection, seq int64) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx), 0)
+	defer cancel()
+
+	counters.UpdateOne(ctx, bson.M{"_id": changeSeqName}, bson.M{"$pull": bson.M{"claims": bson.M{"seq": seq}}})
+}
+
+type SQLChangeModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLChangeModel) Horizon(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// The counter must be read first: any sequence at or below it was
+	// claimed before, so it is either still claimed or already committed.
+	query := `
+		SELECT value
+		FROM counters
+		WHERE name = ?`
+
+	var horizon int64
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), changeSeqName).Scan(&horizon)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	query = `
+		SELECT MIN(seq)
+		FROM change_claims
+		WHERE claimed_at > ?`
+
+	var oldest sql.NullInt64
+	err = m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), time.Now().UTC().Add(-changeClaimTimeout)).Scan(&oldest)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	if oldest.Valid && oldest.Int64 <= horizon {
+		horizon = oldest.Int64 - 1
+	}
+	return horizon, nil
+}
+
+// nextSQLSeq allocates and claims the next change sequence. Outside a
+// transaction both happen in one of their own, so that no reader can see
+// the allocation without the claim.
+func nextSQLSeq(ctx context.Context, db SQLQuerier, dialect Dialect) (int64, error) {
+	sqlDB, ok := db.(*sql.DB)
+	if !ok {
+		return claimSQLSeq(ctx, db, dialect)
+	}
+
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	seq, err := claimSQLSeq(ctx, tx, dialect)
+	if err != nil {
+		return 0, err
+	}
+	return seq, tx.Commit()
+}
+
+func claimSQLSeq(ctx context.Context, db SQLQuerier, dialect Dialect) (int64, error) {
+	query := `
+		UPDATE counters
+		SET value = value + 1
+		WHERE name = ?
+		RETURNING value`
+
+	var value int64
+	err := db.QueryRowContext(ctx, dialect.rebind(query), changeSeqName).Scan(&value)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO change_claims (seq, claimed_at)
+		VALUES (?, ?)`
+
+	_, err = db.ExecContext(ctx, dialect.rebind(query), value, time.Now().UTC())
+	return value, err
+}
+
+// releaseSQLSeq releases the claim on seq once the write using it is done,
+// along with any claims which have timed out. As for releaseMongoSeq,
+// errors are ignored.
+func releaseSQLSeq(ctx context.Context, db SQLQuerier, dialect Dialect, seq int64) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx), 0)
+	defer cancel()
+
+	query := `
+		DELETE FROM change_claims
+		WHERE seq = ? OR claimed_at <= ?`
+
+	db.ExecContext(ctx, dialect.rebind(query), seq, time.Now().UTC().Add(-changeClaimTimeout))
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// Comment is a Markdown comment on a task. BodyHTML is the body rendered and
+// sanitised when it was last saved, and MentionIDs are the users the body
+// mentions. ProjectID is the task's, kept in step as the task moves, so
+// that the comments can be searched within the projects a user can see.
+type Comment struct {
+	ID         primitive.ObjectID   `json:"id" bson:"_id"`
+	TaskID     primitive.ObjectID   `json:"task_id" bson:"task_id"`
+	ProjectID  primitive.ObjectID   `json:"project_id" bson:"project_id"`
+	AuthorID   primitive.ObjectID   `json:"author_id" bson:"author_id"`
+	CreatedAt  time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at" bson:"updated_at"`
+	Body       string               `json:"body" bson:"body"`
+	BodyHTML   string               `json:"body_html" bson:"body_html"`
+	MentionIDs []primitive.ObjectID `json:"mention_ids,omitempty" bson:"mention_ids,omitempty"`
+	Version    int32                `json:"version" bson:"version"`
+}
+
+// CommentRevision is the body of a comment as it was at one version. Every
+// version is kept, the first included, so a comment's revisions are its
+// whole edit history.
+type CommentRevision struct {
+	CommentID primitive.ObjectID `json:"-" bson:"comment_id"`
+	Version   int32              `json:"version" bson:"version"`
+	Body      string             `json:"body" bson:"body"`
+	EditedAt  time.Time          `json:"edited_at" bson:"edited_at"`
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(strings.TrimSpace(comment.Body) != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// ResolveMentions picks out the users whom the handles found in a comment
+// refer to. A handle can be a user's email address, the part of it before
+// the @, or their name with the spaces taken out, in any case. Handles
+// which match no user, or more than one, are ignored.
+func ResolveMentions(handles []string, users []*User) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+	for _, handle := range handles {
+		var match *User
+		ambiguous := false
+		for _, user := range users {
+			local, _, _ := strings.Cut(user.Email, "@")
+			if strings.EqualFold(handle, user.Email) || strings.EqualFold(handle, local) ||
+				strings.EqualFold(handle, strings.Join(strings.Fields(user.Name), "")) {
+				if match != nil && match.ID != user.ID {
+					ambiguous = true
+				}
+				match = user
+			}
+		}
+		if match != nil && !ambiguous && !seen[match.ID] {
+			seen[match.ID] = true
+			ids = append(ids, match.ID)
+		}
+	}
+	return ids
+}
+
+type CommentModel struct {
+	DB      *mongo.Collection
+	History *mongo.Collection
+	Timeout time.Duration
+}
+
+func (m CommentModel) Insert(ctx context.Context, comment *Comment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	comment.ID = primitive.NewObjectID()
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	comment.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, comment)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	return m.saveRevision(ctx, comment)
+}
+
+func (m CommentModel) Get(ctx context.Context, id primitive.ObjectID) (*Comment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var comment Comment
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &comment, nil
+}
+
+// ForTask returns the task's comments, oldest first.
+func (m CommentModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Comment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.DB.Find(ctx, bson.M{"task_id": taskID}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	comments := []*Comment{}
+	err = cursor.All(ctx, &comments)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return comments, nil
+}
+
+// Update saves the comment's body if it is still at comment.Version,
+// returning ErrEditConflict otherwise, and records the new body in its
+// history.
+func (m CommentModel) Update(ctx context.Context, comment *Comment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	updatedAt := time.Now().UTC()
+
+	filter := bson.M{"_id": comment.ID, "version": comment.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"updated_at":  updatedAt,
+			"body":        comment.Body,
+			"body_html":   comment.BodyHTML,
+			"mention_ids": comment.MentionIDs,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	comment.UpdatedAt = updatedAt
+	comment.Version++
+	return m.saveRevision(ctx, comment)
+}
+
+// Delete removes the comment along with its history.
+func (m CommentModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = m.History.DeleteMany(ctx, bson.M{"comment_id": id})
+	return queryError(ctx, err)
+}
+
+// Revisions returns every version of the comment's body, oldest first.
+func (m CommentModel) Revisions(ctx context.Context, id primitive.ObjectID) ([]*CommentRevision, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cursor, err := m.History.Find(ctx, bson.M{"comment_id": id}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	revs := []*CommentRevision{}
+	err = cursor.All(ctx, &revs)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return revs, nil
+}
+
+func (m CommentModel) saveRevision(ctx context.Context, comment *Comment) error {
+	rev := CommentRevision{CommentID: comment.ID, Version: comment.Version, Body: comment.Body, EditedAt: comment.UpdatedAt}
+
+	_, err := m.History.InsertOne(ctx, rev)
+	return queryError(ctx, err)
+}
+
+// moveTask points the task's comments at the project it is now in, for the
+// task model to call whenever it saves a task.
+func (m CommentModel) moveTask(ctx context.Context, taskID, projectID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"task_id": taskID, "project_id": bson.M{"$ne": projectID}}
+	_, err := m.DB.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"project_id": projectID}})
+	return queryError(ctx, err)
+}
+
+// deleteWhere removes the comments matching filter, and their history, for
+// the task model to call when it deletes their tasks.
+func (m CommentModel) deleteWhere(ctx context.Context, filter bson.M) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	ids, err := m.DB.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = m.History.DeleteMany(ctx, bson.M{"comment_id": bson.M{"$in": ids}})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	_, err = m.DB.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return queryError(ctx, err)
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLCommentModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const commentColumns = `id, task_id, project_id, author_id, created_at, updated_at, body, body_html, mention_ids, version`
+
+func (m SQLCommentModel) Insert(ctx context.Context, comment *Comment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	comment.ID = primitive.NewObjectID()
+	comment.CreatedAt = time.Now().UTC()
+	comment.UpdatedAt = comment.CreatedAt
+	comment.Version = 1
+
+	mentions, err := marshalIDs(comment.MentionIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO comments (` + commentColumns + `, search_words)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		comment.ID.Hex(), comment.TaskID.Hex(), comment.ProjectID.Hex(), comment.AuthorID.Hex(),
+		comment.CreatedAt, comment.UpdatedAt, comment.Body, comment.BodyHTML, mentions, comment.Version,
+		searchWords(comment.Body),
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	return m.saveRevision(ctx, comment)
+}
+
+func (m SQLCommentModel) Get(ctx context.Context, id primitive.ObjectID) (*Comment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments
+		WHERE id = ?`
+
+	comment, err := scanComment(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return comment, nil
+}
+
+func (m SQLCommentModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Comment, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments
+		WHERE task_id = ?
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), taskID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, queryError(ctx, rows.Err())
+}
+
+func (m SQLCommentModel) Update(ctx context.Context, comment *Comment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	mentions, err := marshalIDs(comment.MentionIDs)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+
+	query := `
+		UPDATE comments
+		SET updated_at = ?, body = ?, body_html = ?, mention_ids = ?, search_words = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{updatedAt, comment.Body, comment.BodyHTML, mentions, searchWords(comment.Body), comment.ID.Hex(), comment.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	comment.UpdatedAt = updatedAt
+	comment.Version++
+	return m.saveRevision(ctx, comment)
+}
+
+// Delete removes the comment. Its history goes with it.
+func (m SQLCommentModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM comments
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m SQLCommentModel) Revisions(ctx context.Context, id primitive.ObjectID) ([]*CommentRevision, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT version, body, edited_at
+		FROM comment_revisions
+		WHERE comment_id = ?
+		ORDER BY version`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	revs := []*CommentRevision{}
+	for rows.Next() {
+		rev := CommentRevision{CommentID: id}
+		err := rows.Scan(&rev.Version, &rev.Body, &rev.EditedAt)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		revs = append(revs, &rev)
+	}
+	return revs, queryError(ctx, rows.Err())
+}
+
+func (m SQLCommentModel) saveRevision(ctx context.Context, comment *Comment) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO comment_revisions (comment_id, version, body, edited_at)
+		VALUES (?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), comment.ID.Hex(), comment.Version, comment.Body, comment.UpdatedAt)
+	return queryError(ctx, err)
+}
+
+func scanComment(row rowScanner) (*Comment, error) {
+	var comment Comment
+	var id, taskID, projectID, authorID string
+	var mentions string
+
+	err := row.Scan(
+		&id,
+		&taskID,
+		&projectID,
+		&authorID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.Body,
+		&comment.BodyHTML,
+		&mentions,
+		&comment.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if mentions != "[]" {
+		if err := json.Unmarshal([]byte(mentions), &comment.MentionIDs); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range []struct {
+		src string
+		dst *primitive.ObjectID
+	}{{id, &comment.ID}, {taskID, &comment.TaskID}, {projectID, &comment.ProjectID}, {authorID, &comment.AuthorID}} {
+		*f.dst, err = primitive.ObjectIDFromHex(f.src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &comment, nil
+}
@@ -0,0 +1,2145 @@
+// Package datatest holds a conformance suite which every storage backend
+// behind data.Models must pass. Each backend supplies a fresh, empty set of
+// models and Run exercises them through the shared interfaces only.
+package datatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+type check struct {
+	name string
+	fn   func(ctx context.Context, m data.Models) error
+}
+
+var checks = []check{
+	{"users/insert and get", usersInsertAndGet},
+	{"users/duplicate email", usersDuplicateEmail},
+	{"users/not found", usersNotFound},
+	{"users/update", usersUpdate},
+	{"tokens/lookup", tokensLookup},
+	{"tokens/expired", tokensExpired},
+	{"tokens/delete all for user", tokensDeleteAllForUser},
+	{"workspaces/membership", workspacesMembership},
+	{"projects/lifecycle", projectsLifecycle},
+	{"tasks/lifecycle", tasksLifecycle},
+	{"tasks/changes since", tasksChangesSince},
+	{"changes/horizon", changesHorizon},
+	{"tasks/list", tasksList},
+	{"tasks/list by due", tasksListByDue},
+	{"tasks/query", tasksQuery},
+	{"webhooks/lifecycle", webhooksLifecycle},
+	{"webhooks/deliveries", webhooksDeliveries},
+	{"inbound hooks/lifecycle", inboundHooksLifecycle},
+	{"idempotency/claim", idempotencyClaim},
+	{"import jobs/lifecycle", importJobsLifecycle},
+	{"calendar objects/lifecycle", calendarObjectsLifecycle},
+	{"search/ranking", searchRanking},
+	{"saved views/lifecycle", savedViewsLifecycle},
+	{"watchers/lifecycle", watchersLifecycle},
+	{"comments/lifecycle", commentsLifecycle},
+	{"comments/task moves", commentsTaskMoves},
+	{"notifications/inbox", notificationsInbox},
+	{"notifications/digests", notificationsDigests},
+	{"attachments/lifecycle", attachmentsLifecycle},
+	{"attachments/task moves", attachmentsTaskMoves},
+	{"labels/lifecycle", labelsLifecycle},
+	{"boards/lifecycle", boardsLifecycle},
+	{"context/canceled", contextCanceled},
+}
+
+// Run executes every conformance check against the models in order and
+// returns an error describing the first one which fails.
+func Run(ctx context.Context, m data.Models) error {
+	for _, c := range checks {
+		if err := c.fn(ctx, m); err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+func newUser(email string) (*data.User, error) {
+	user := &data.User{Name: "Conformance", Email: email}
+	err := user.SetPassword("pa55word1234")
+	return user, err
+}
+
+func insertUser(ctx context.Context, m data.Models, email string) (*data.User, error) {
+	user, err := newUser(email)
+	if err != nil {
+		return nil, err
+	}
+	return user, m.Users.Insert(ctx, user)
+}
+
+func usersInsertAndGet(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "alice@example.com")
+	if err != nil {
+		return err
+	}
+	if user.ID.IsZero() {
+		return errors.New("insert did not assign an id")
+	}
+	if user.Version != 1 {
+		return fmt.Errorf("got version %d after insert; want 1", user.Version)
+	}
+
+	byID, err := m.Users.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	byEmail, err := m.Users.GetByEmail(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+
+	for _, got := range []*data.User{byID, byEmail} {
+		if got.ID != user.ID || got.Email != user.Email || got.Name != user.Name {
+			return fmt.Errorf("got %+v; want %+v", got, user)
+		}
+		match, err := got.PasswordMatches("pa55word1234")
+		if err != nil {
+			return err
+		}
+		if !match {
+			return errors.New("stored password hash does not match")
+		}
+	}
+	return nil
+}
+
+func usersDuplicateEmail(ctx context.Context, m data.Models) error {
+	_, err := insertUser(ctx, m, "bob@example.com")
+	if err != nil {
+		return err
+	}
+
+	_, err = insertUser(ctx, m, "bob@example.com")
+	if !errors.Is(err, data.ErrDuplicateEmail) {
+		return fmt.Errorf("got error %v; want %v", err, data.ErrDuplicateEmail)
+	}
+	return nil
+}
+
+func usersNotFound(ctx context.Context, m data.Models) error {
+	_, err := m.Users.GetByID(ctx, primitive.NewObjectID())
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("GetByID: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	_, err = m.Users.GetByEmail(ctx, "nobody@example.com")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("GetByEmail: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func usersUpdate(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "carol@example.com")
+	if err != nil {
+		return err
+	}
+
+	user.Name = "Carol"
+	user.Activated = true
+	if err := m.Users.Update(ctx, user); err != nil {
+		return err
+	}
+
+	got, err := m.Users.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != "Carol" || !got.Activated || got.Version != 2 {
+		return fmt.Errorf("got %+v after update", got)
+	}
+
+	missing, err := newUser("dave@example.com")
+	if err != nil {
+		return err
+	}
+	missing.ID = primitive.NewObjectID()
+	err = m.Users.Update(ctx, missing)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("updating missing user: got error %v; want %v", err, data.ErrEditConflict)
+	}
+	return nil
+}
+
+func tokensLookup(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "erin@example.com")
+	if err != nil {
+		return err
+	}
+
+	token, err := m.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	userID, err := m.Tokens.GetUserIDForToken(ctx, data.ScopeAuthentication, token.PlainToken)
+	if err != nil {
+		return err
+	}
+	if userID != user.ID {
+		return fmt.Errorf("got user id %s; want %s", userID.Hex(), user.ID.Hex())
+	}
+
+	_, err = m.Tokens.GetUserIDForToken(ctx, data.ScopeActivation, token.PlainToken)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("wrong scope: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func tokensExpired(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "frank@example.com")
+	if err != nil {
+		return err
+	}
+
+	token, err := m.Tokens.New(ctx, user.ID, -time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Tokens.GetUserIDForToken(ctx, data.ScopeAuthentication, token.PlainToken)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func tokensDeleteAllForUser(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "grace@example.com")
+	if err != nil {
+		return err
+	}
+
+	activation, err := m.Tokens.New(ctx, user.ID, time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+	authentication, err := m.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID); err != nil {
+		return err
+	}
+
+	_, err = m.Tokens.GetUserIDForToken(ctx, data.ScopeActivation, activation.PlainToken)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("deleted scope: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	_, err = m.Tokens.GetUserIDForToken(ctx, data.ScopeAuthentication, authentication.PlainToken)
+	if err != nil {
+		return fmt.Errorf("other scope: %w", err)
+	}
+	return nil
+}
+
+func attachmentsLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "zelda@example.com")
+	if err != nil {
+		return err
+	}
+	workspaceID := primitive.NewObjectID()
+
+	var tasks []*data.Task
+	for _, title := range []string{"Scanned", "Also scanned"} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: title}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	var attachments []*data.Attachment
+	for i, size := range []int64{100, 250, 4000} {
+		attachment := &data.Attachment{
+			TaskID:      tasks[i/2].ID,
+			ProjectID:   project.ID,
+			WorkspaceID: workspaceID,
+			UploaderID:  project.OwnerID,
+			Filename:    fmt.Sprintf("scan-%d.pdf", i),
+			ContentType: "application/pdf",
+			Size:        size,
+			Checksum:    "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			StorageKey:  fmt.Sprintf("%s/%d", tasks[i/2].ID.Hex(), i),
+		}
+		if err := m.Attachments.Insert(ctx, attachment); err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	got, err := m.Attachments.Get(ctx, attachments[2].ID)
+	if err != nil {
+		return err
+	}
+	if got.Filename != "scan-2.pdf" || got.Size != 4000 || got.StorageKey != attachments[2].StorageKey || got.TaskID != tasks[1].ID || got.WorkspaceID != workspaceID {
+		return fmt.Errorf("got %+v; want %+v", got, attachments[2])
+	}
+
+	list, err := m.Attachments.ForTask(ctx, tasks[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 2 || list[0].ID != attachments[0].ID || list[1].ID != attachments[1].ID {
+		return fmt.Errorf("got %d attachments on the first task; want 2 oldest first", len(list))
+	}
+
+	list, err = m.Attachments.ForProject(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 3 {
+		return fmt.Errorf("got %d attachments on the project; want 3", len(list))
+	}
+
+	usage, err := m.Attachments.Usage(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if usage != 4350 {
+		return fmt.Errorf("got usage %d; want 4350", usage)
+	}
+
+	if err := m.Attachments.Delete(ctx, attachments[2].ID); err != nil {
+		return err
+	}
+	if err := m.Attachments.Delete(ctx, attachments[2].ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v deleting a deleted attachment; want ErrRecordNotFound", err)
+	}
+	if _, err := m.Attachments.Get(ctx, attachments[2].ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v fetching a deleted attachment; want ErrRecordNotFound", err)
+	}
+
+	usage, err = m.Attachments.Usage(ctx, primitive.NewObjectID())
+	if err != nil {
+		return err
+	}
+	if usage != 0 {
+		return fmt.Errorf("got usage %d for an empty workspace; want 0", usage)
+	}
+	return nil
+}
+
+// attachmentsTaskMoves checks that attachments follow their task to another
+// project and workspace, for removing the project they left and for each
+// workspace's storage.
+func attachmentsTaskMoves(ctx context.Context, m data.Models) error {
+	from, err := insertProject(ctx, m, "xena@example.com")
+	if err != nil {
+		return err
+	}
+	to, err := insertProject(ctx, m, "xerxes@example.com")
+	if err != nil {
+		return err
+	}
+
+	task := &data.Task{ProjectID: from.ID, CreatedBy: from.OwnerID, Title: "Scanned"}
+	if err := m.Tasks.Insert(ctx, task); err != nil {
+		return err
+	}
+	attachment := &data.Attachment{
+		TaskID:      task.ID,
+		ProjectID:   from.ID,
+		WorkspaceID: from.WorkspaceID,
+		UploaderID:  from.OwnerID,
+		Filename:    "scan.pdf",
+		ContentType: "application/pdf",
+		Size:        500,
+		Checksum:    "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		StorageKey:  task.ID.Hex() + "/scan",
+	}
+	if err := m.Attachments.Insert(ctx, attachment); err != nil {
+		return err
+	}
+
+	task.ProjectID = to.ID
+	if err := m.Tasks.Update(ctx, task); err != nil {
+		return err
+	}
+
+	got, err := m.Attachments.Get(ctx, attachment.ID)
+	if err != nil {
+		return err
+	}
+	if got.ProjectID != to.ID || got.WorkspaceID != to.WorkspaceID {
+		return fmt.Errorf("got project %s and workspace %s after the move; want %s and %s",
+			got.ProjectID.Hex(), got.WorkspaceID.Hex(), to.ID.Hex(), to.WorkspaceID.Hex())
+	}
+
+	list, err := m.Attachments.ForProject(ctx, from.ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 0 {
+		return fmt.Errorf("got %d attachments on the project the task left; want none", len(list))
+	}
+
+	for _, workspace := range []struct {
+		id   primitive.ObjectID
+		want int64
+	}{{from.WorkspaceID, 0}, {to.WorkspaceID, 500}} {
+		usage, err := m.Attachments.Usage(ctx, workspace.id)
+		if err != nil {
+			return err
+		}
+		if usage != workspace.want {
+			return fmt.Errorf("got usage %d for workspace %s; want %d", usage, workspace.id.Hex(), workspace.want)
+		}
+	}
+	return nil
+}
+
+func labelsLifecycle(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "abigail@example.com")
+	if err != nil {
+		return err
+	}
+
+	workspace := &data.Workspace{Name: "Labelled", OwnerID: user.ID}
+	if err := m.Workspaces.Insert(ctx, workspace); err != nil {
+		return err
+	}
+	project := &data.Project{WorkspaceID: workspace.ID, OwnerID: user.ID, Name: "Labelled"}
+	if err := m.Projects.Insert(ctx, project); err != nil {
+		return err
+	}
+
+	var labels []*data.Label
+	for _, name := range []string{"Feature", "Bug", "Defect"} {
+		label := &data.Label{WorkspaceID: workspace.ID, Name: name, Color: data.DefaultLabelColor}
+		if err := m.Labels.Insert(ctx, label); err != nil {
+			return err
+		}
+		labels = append(labels, label)
+	}
+	feature, bug, defect := labels[0], labels[1], labels[2]
+
+	err = m.Labels.Insert(ctx, &data.Label{WorkspaceID: workspace.ID, Name: "BUG", Color: data.DefaultLabelColor})
+	if !errors.Is(err, data.ErrDuplicateName) {
+		return fmt.Errorf("duplicate name: got error %v; want %v", err, data.ErrDuplicateName)
+	}
+	if _, err := m.Workspaces.ForUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := m.Labels.Insert(ctx, &data.Label{WorkspaceID: user.ID, Name: "Bug", Color: data.DefaultLabelColor}); err != nil {
+		return fmt.Errorf("same name in another workspace: %w", err)
+	}
+
+	stale := *defect
+	defect.Name = "bug"
+	if err := m.Labels.Update(ctx, defect); !errors.Is(err, data.ErrDuplicateName) {
+		return fmt.Errorf("renaming onto another label: got error %v; want %v", err, data.ErrDuplicateName)
+	}
+	defect.Name, defect.Color = "Regression", "#ff0000"
+	if err := m.Labels.Update(ctx, defect); err != nil {
+		return err
+	}
+	if err := m.Labels.Update(ctx, &stale); !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("got %v updating a stale label; want ErrEditConflict", err)
+	}
+
+	list, err := m.Labels.ForWorkspace(ctx, workspace.ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 3 || list[0].ID != bug.ID || list[1].ID != feature.ID || list[2].Name != "Regression" || list[2].Color != "#ff0000" {
+		return fmt.Errorf("got %d labels; want Bug, Feature and Regression in order", len(list))
+	}
+
+	var tasks []*data.Task
+	for _, labelIDs := range [][]primitive.ObjectID{{bug.ID, defect.ID}, {defect.ID}, {feature.ID}, nil} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: user.ID, Title: "Labelled", Status: data.TaskStatusTodo, LabelIDs: labelIDs}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	got, err := m.Tasks.Get(ctx, tasks[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(got.LabelIDs) != 2 || got.LabelIDs[0] != bug.ID || got.LabelIDs[1] != defect.ID {
+		return fmt.Errorf("got labels %v; want %v", got.LabelIDs, tasks[0].LabelIDs)
+	}
+
+	filters := data.Filters{ProjectIDs: []primitive.ObjectID{project.ID}, LabelIDs: []primitive.ObjectID{defect.ID, feature.ID}}
+	page, err := m.Tasks.List(ctx, filters, primitive.NilObjectID, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 3 || page[0].ID != tasks[0].ID || page[2].ID != tasks[2].ID {
+		return fmt.Errorf("filtering by label: got %d tasks; want the first three", len(page))
+	}
+
+	if err := m.Labels.CountTasks(ctx, list); err != nil {
+		return err
+	}
+	if list[0].TaskCount != 1 || list[1].TaskCount != 1 || list[2].TaskCount != 2 {
+		return fmt.Errorf("got task counts %d, %d and %d; want 1, 1 and 2", list[0].TaskCount, list[1].TaskCount, list[2].TaskCount)
+	}
+
+	var merged []*data.Task
+	err = m.WithTransaction(ctx, func(ctx context.Context, tx data.Models) error {
+		var err error
+		merged, err = tx.Tasks.ReplaceLabel(ctx, defect.ID, bug.ID)
+		if err != nil {
+			return err
+		}
+		return tx.Labels.Delete(ctx, defect.ID)
+	})
+	if err != nil {
+		return err
+	}
+	if len(merged) != 2 {
+		return fmt.Errorf("merging: got %d tasks changed; want 2", len(merged))
+	}
+
+	for i, want := range [][]primitive.ObjectID{{bug.ID}, {bug.ID}} {
+		got, err := m.Tasks.Get(ctx, tasks[i].ID)
+		if err != nil {
+			return err
+		}
+		if len(got.LabelIDs) != len(want) || got.LabelIDs[0] != want[0] || got.Version != 2 {
+			return fmt.Errorf("after merging: got labels %v at version %d; want %v at version 2", got.LabelIDs, got.Version, want)
+		}
+		rev, err := m.Tasks.GetRevision(ctx, tasks[i].ID, 2)
+		if err != nil {
+			return err
+		}
+		if len(rev.LabelIDs) != 1 || rev.LabelIDs[0] != bug.ID {
+			return fmt.Errorf("after merging: got revision labels %v; want %v", rev.LabelIDs, want)
+		}
+	}
+	if _, err := m.Labels.Get(ctx, defect.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v fetching a merged label; want ErrRecordNotFound", err)
+	}
+
+	removed, err := m.Tasks.ReplaceLabel(ctx, bug.ID, primitive.NilObjectID)
+	if err != nil {
+		return err
+	}
+	if len(removed) != 2 || removed[0].LabelIDs != nil || removed[1].LabelIDs != nil {
+		return fmt.Errorf("removing: got %d tasks changed; want 2 left without labels", len(removed))
+	}
+
+	if err := m.Labels.CountTasks(ctx, []*data.Label{bug, feature}); err != nil {
+		return err
+	}
+	if bug.TaskCount != 0 || feature.TaskCount != 1 {
+		return fmt.Errorf("got task counts %d and %d after removing; want 0 and 1", bug.TaskCount, feature.TaskCount)
+	}
+	return nil
+}
+
+func boardsLifecycle(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "bartholomew@example.com")
+	if err != nil {
+		return err
+	}
+
+	workspace := &data.Workspace{Name: "Planning", OwnerID: user.ID}
+	if err := m.Workspaces.Insert(ctx, workspace); err != nil {
+		return err
+	}
+	project := &data.Project{WorkspaceID: workspace.ID, OwnerID: user.ID, Name: "Planning"}
+	if err := m.Projects.Insert(ctx, project); err != nil {
+		return err
+	}
+
+	board := &data.Board{ProjectID: project.ID, Name: "Sprint", Columns: data.DefaultBoardColumns()}
+	if err := m.Boards.Insert(ctx, board); err != nil {
+		return err
+	}
+	got, err := m.Boards.Get(ctx, board.ID)
+	if err != nil {
+		return err
+	}
+	if got.Name != "Sprint" || len(got.Columns) != 3 || got.Columns[0] != board.Columns[0] || got.Columns[2].Status != data.TaskStatusDone {
+		return fmt.Errorf("got board %q with columns %v; want Sprint with %v", got.Name, got.Columns, board.Columns)
+	}
+
+	stale := *board
+	board.Name = "Next sprint"
+	board.Columns = board.Columns[:2]
+	board.Columns[1].WIPLimit = 2
+	if err := m.Boards.Update(ctx, board); err != nil {
+		return err
+	}
+	if err := m.Boards.Update(ctx, &stale); !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("got %v updating a stale board; want ErrEditConflict", err)
+	}
+
+	other := &data.Board{ProjectID: project.ID, Name: "Triage", Columns: data.DefaultBoardColumns()[:1]}
+	if err := m.Boards.Insert(ctx, other); err != nil {
+		return err
+	}
+	boards, err := m.Boards.ForProject(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	if len(boards) != 2 || boards[0].ID != board.ID || boards[1].ID != other.ID {
+		return fmt.Errorf("got %d boards; want Next sprint and Triage in order", len(boards))
+	}
+	if boards[0].Name != "Next sprint" || len(boards[0].Columns) != 2 || boards[0].Columns[1].WIPLimit != 2 || boards[0].Version != 2 {
+		return fmt.Errorf("got board %q with columns %v at version %d; want the update saved", boards[0].Name, boards[0].Columns, boards[0].Version)
+	}
+
+	var tasks []*data.Task
+	for _, title := range []string{"First", "Second", "Third"} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: user.ID, Title: title, Status: data.TaskStatusTodo}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+	if tasks[0].Rank == "" || tasks[0].Rank >= tasks[1].Rank || tasks[1].Rank >= tasks[2].Rank {
+		return fmt.Errorf("got ranks %q, %q and %q for new tasks; want them ascending", tasks[0].Rank, tasks[1].Rank, tasks[2].Rank)
+	}
+
+	// Moving the third task between the others rewrites its status and rank
+	// and nothing else.
+	moved := tasks[2]
+	moved.Status = data.TaskStatusInProgress
+	moved.Rank = data.RankBetween(tasks[0].Rank, tasks[1].Rank)
+	moved.Title = "Not saved"
+	if err := m.Tasks.Move(ctx, moved); err != nil {
+		return err
+	}
+	saved, err := m.Tasks.Get(ctx, moved.ID)
+	if err != nil {
+		return err
+	}
+	if saved.Rank != moved.Rank || saved.Status != data.TaskStatusInProgress || saved.Title != "Third" || saved.Version != 2 {
+		return fmt.Errorf("after moving: got %q with rank %q in %s at version %d", saved.Title, saved.Rank, saved.Status, saved.Version)
+	}
+	if saved.Rank <= tasks[0].Rank || saved.Rank >= tasks[1].Rank {
+		return fmt.Errorf("got rank %q; want it between %q and %q", saved.Rank, tasks[0].Rank, tasks[1].Rank)
+	}
+	rev, err := m.Tasks.GetRevision(ctx, moved.ID, 2)
+	if err != nil {
+		return err
+	}
+	if rev.Rank != moved.Rank {
+		return fmt.Errorf("got revision rank %q; want %q", rev.Rank, moved.Rank)
+	}
+	if err := m.Tasks.Move(ctx, &data.Task{ID: moved.ID, Version: 1}); !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("got %v moving a stale task; want ErrEditConflict", err)
+	}
+
+	last := &data.Task{ProjectID: project.ID, CreatedBy: user.ID, Title: "Fourth", Status: data.TaskStatusTodo}
+	if err := m.Tasks.Insert(ctx, last); err != nil {
+		return err
+	}
+	if last.Rank <= tasks[1].Rank {
+		return fmt.Errorf("got rank %q for a new task; want it after %q", last.Rank, tasks[1].Rank)
+	}
+
+	if err := m.Boards.Delete(ctx, board.ID); err != nil {
+		return err
+	}
+	if _, err := m.Boards.Get(ctx, board.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v fetching a deleted board; want ErrRecordNotFound", err)
+	}
+	if err := m.Boards.Delete(ctx, board.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v deleting a deleted board; want ErrRecordNotFound", err)
+	}
+	return nil
+}
+
+func contextCanceled(ctx context.Context, m data.Models) error {
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := m.Users.GetByEmail(ctx, "alice@example.com")
+	if !errors.Is(err, data.ErrCanceled) {
+		return fmt.Errorf("got error %v; want %v", err, data.ErrCanceled)
+	}
+	return nil
+}
+
+func insertProject(ctx context.Context, m data.Models, email string) (*data.Project, error) {
+	user, err := insertUser(ctx, m, email)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Conformance"}
+	return project, m.Projects.Insert(ctx, project)
+}
+
+func workspacesMembership(ctx context.Context, m data.Models) error {
+	owner, err := insertUser(ctx, m, "gwen@example.com")
+	if err != nil {
+		return err
+	}
+	member, err := insertUser(ctx, m, "gus@example.com")
+	if err != nil {
+		return err
+	}
+
+	workspace := &data.Workspace{Name: "Team", OwnerID: owner.ID}
+	if err := m.Workspaces.Insert(ctx, workspace); err != nil {
+		return err
+	}
+	if !workspace.HasMember(owner.ID) {
+		return fmt.Errorf("owner is not a member of %+v", workspace)
+	}
+
+	if err := m.Workspaces.AddMember(ctx, workspace, member.ID); err != nil {
+		return err
+	}
+	if workspace.Version != 2 || !workspace.HasMember(member.ID) {
+		return fmt.Errorf("got %+v after adding a member", workspace)
+	}
+
+	ok, err := m.Workspaces.IsMember(ctx, workspace.ID, member.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("added member is not a member")
+	}
+
+	workspaces, err := m.Workspaces.ForUser(ctx, member.ID)
+	if err != nil {
+		return err
+	}
+	if len(workspaces) != 2 || !workspaces[0].Personal || workspaces[1].ID != workspace.ID {
+		return fmt.Errorf("got workspaces %+v; want personal and team", workspaces)
+	}
+
+	ids, err := m.Workspaces.IDsForUser(ctx, member.ID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 2 || ids[0] != member.ID || ids[1] != workspace.ID {
+		return fmt.Errorf("got workspace ids %v", ids)
+	}
+
+	stale := *workspace
+	stale.Version = 1
+	err = m.Workspaces.RemoveMember(ctx, &stale, member.ID)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale remove: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	if err := m.Workspaces.RemoveMember(ctx, workspace, member.ID); err != nil {
+		return err
+	}
+	ok, err = m.Workspaces.IsMember(ctx, workspace.ID, member.ID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("removed member is still a member")
+	}
+	return nil
+}
+
+func projectsLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "heidi@example.com")
+	if err != nil {
+		return err
+	}
+	if project.ID.IsZero() || project.Version != 1 || project.Seq == 0 {
+		return fmt.Errorf("got %+v after insert", project)
+	}
+
+	err = m.Projects.Insert(ctx, &data.Project{ID: project.ID, WorkspaceID: project.WorkspaceID, OwnerID: project.OwnerID, Name: "Again"})
+	if !errors.Is(err, data.ErrDuplicateID) {
+		return fmt.Errorf("duplicate insert: got error %v; want %v", err, data.ErrDuplicateID)
+	}
+
+	stale := *project
+	project.Name = "Renamed"
+	seq := project.Seq
+	if err := m.Projects.Update(ctx, project); err != nil {
+		return err
+	}
+	if project.Version != 2 || project.Seq <= seq {
+		return fmt.Errorf("got version %d and seq %d after update", project.Version, project.Seq)
+	}
+
+	err = m.Projects.Update(ctx, &stale)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	if err := m.Projects.Delete(ctx, project); err != nil {
+		return err
+	}
+	_, err = m.Projects.Get(ctx, project.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("get after delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	changes, err := m.Projects.ChangesSince(ctx, []primitive.ObjectID{project.WorkspaceID}, 0, 10)
+	if err != nil {
+		return err
+	}
+	if len(changes) != 1 || !changes[0].Deleted || changes[0].Seq != project.Seq {
+		return fmt.Errorf("got changes %+v; want a single tombstone", changes)
+	}
+	return nil
+}
+
+func tasksLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "ivan@example.com")
+	if err != nil {
+		return err
+	}
+
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := &data.Task{
+		ProjectID: project.ID,
+		CreatedBy: project.OwnerID,
+		Title:     "Conformance",
+		Status:    data.TaskStatusTodo,
+		Priority:  data.TaskPriorityHigh,
+		DueAt:     &due,
+	}
+	if err := m.Tasks.Insert(ctx, task); err != nil {
+		return err
+	}
+
+	got, err := m.Tasks.Get(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if got.Title != task.Title || got.Priority != task.Priority || got.DueAt == nil || !got.DueAt.Equal(due) {
+		return fmt.Errorf("got %+v; want %+v", got, task)
+	}
+
+	got.Status = data.TaskStatusDone
+	got.DueAt = nil
+	if err := m.Tasks.Update(ctx, got); err != nil {
+		return err
+	}
+	err = m.Tasks.Update(ctx, task)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	got, err = m.Tasks.Get(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != data.TaskStatusDone || got.DueAt != nil || got.Version != 2 {
+		return fmt.Errorf("got %+v after update", got)
+	}
+
+	if err := m.Tasks.Delete(ctx, got); err != nil {
+		return err
+	}
+	_, err = m.Tasks.Get(ctx, task.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("get after delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func tasksChangesSince(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "judy@example.com")
+	if err != nil {
+		return err
+	}
+	since := project.Seq
+
+	var tasks []*data.Task
+	for i := 0; i < 3; i++ {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Task", Status: data.TaskStatusTodo}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	page, err := m.Tasks.ChangesSince(ctx, []primitive.ObjectID{project.ID}, since, 2)
+	if err != nil {
+		return err
+	}
+	if len(page) != 2 || page[0].ID != tasks[0].ID || page[1].ID != tasks[1].ID {
+		return fmt.Errorf("got first page %+v", page)
+	}
+
+	if err := m.Tasks.DeleteAllForProject(ctx, project.ID); err != nil {
+		return err
+	}
+
+	page, err = m.Tasks.ChangesSince(ctx, []primitive.ObjectID{project.ID}, tasks[2].Seq, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 3 {
+		return fmt.Errorf("got %d changes after deleting all; want 3", len(page))
+	}
+	for i, task := range page {
+		if !task.Deleted || (i > 0 && task.Seq <= page[i-1].Seq) {
+			return fmt.Errorf("got %+v; want tombstones in sequence order", page)
+		}
+	}
+	return nil
+}
+
+// changesHorizon checks that once every write is done the horizon has
+// caught up with the latest change, claims and all.
+func changesHorizon(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "kevin@example.com")
+	if err != nil {
+		return err
+	}
+
+	task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Task", Status: data.TaskStatusTodo}
+	if err := m.Tasks.Insert(ctx, task); err != nil {
+		return err
+	}
+	if err := m.Tasks.DeleteAllForProject(ctx, project.ID); err != nil {
+		return err
+	}
+
+	page, err := m.Tasks.ChangesSince(ctx, []primitive.ObjectID{project.ID}, task.Seq, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 {
+		return fmt.Errorf("got %d changes after deleting all; want 1", len(page))
+	}
+
+	horizon, err := m.Changes.Horizon(ctx)
+	if err != nil {
+		return err
+	}
+	if horizon != page[0].Seq {
+		return fmt.Errorf("got horizon %d; want %d", horizon, page[0].Seq)
+	}
+	return nil
+}
+
+func tasksList(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "nina@example.com")
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+	var tasks []*data.Task
+	for i, status := range []string{data.TaskStatusTodo, data.TaskStatusDone, data.TaskStatusTodo, data.TaskStatusTodo} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Task", Status: status}
+		if i == 2 {
+			task.DueAt = &due
+		}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := m.Tasks.Delete(ctx, tasks[3]); err != nil {
+		return err
+	}
+
+	filters := data.Filters{ProjectIDs: []primitive.ObjectID{project.ID}, Statuses: []string{data.TaskStatusTodo}}
+
+	page, err := m.Tasks.List(ctx, filters, primitive.NilObjectID, 1)
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 || page[0].ID != tasks[0].ID {
+		return fmt.Errorf("got first page %+v", page)
+	}
+
+	page, err = m.Tasks.List(ctx, filters, page[0].ID, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 || page[0].ID != tasks[2].ID {
+		return fmt.Errorf("got second page %+v; want only the live todo task", page)
+	}
+
+	after := due.Add(-time.Minute)
+	filters = data.Filters{ProjectIDs: []primitive.ObjectID{project.ID}, DueAfter: &after}
+	page, err = m.Tasks.List(ctx, filters, primitive.NilObjectID, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 || page[0].ID != tasks[2].ID {
+		return fmt.Errorf("got %+v due after %v", page, after)
+	}
+
+	page, err = m.Tasks.List(ctx, data.Filters{}, primitive.NilObjectID, 10)
+	if err != nil {
+		return err
+	}
+	if len(page) != 0 {
+		return fmt.Errorf("got %d tasks without any projects; want 0", len(page))
+	}
+	return nil
+}
+
+func tasksListByDue(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "yara@example.com")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var tasks []*data.Task
+	for _, days := range []int{3, 1, 0, 2} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Task", Status: data.TaskStatusTodo}
+		if days > 0 {
+			due := now.AddDate(0, 0, days)
+			task.DueAt = &due
+		}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	filters := data.Filters{ProjectIDs: []primitive.ObjectID{project.ID}}
+	page, err := m.Tasks.ListByDue(ctx, filters, 2)
+	if err != nil {
+		return err
+	}
+	if len(page) != 2 || page[0].ID != tasks[1].ID || page[1].ID != tasks[3].ID {
+		return fmt.Errorf("got %+v; want the two tasks due soonest, soonest first", page)
+	}
+	return nil
+}
+
+func insertWebhook(ctx context.Context, m data.Models, email string) (*data.Webhook, error) {
+	user, err := insertUser(ctx, m, email)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := &data.Workspace{Name: "Hooks", OwnerID: user.ID}
+	if err := m.Workspaces.Insert(ctx, workspace); err != nil {
+		return nil, err
+	}
+
+	webhook := &data.Webhook{
+		WorkspaceID: workspace.ID,
+		CreatedBy:   user.ID,
+		URL:         "https://example.com/hook",
+		Events:      []string{"task.*"},
+		Secret:      "0123456789abcdef",
+	}
+	return webhook, m.Webhooks.Insert(ctx, webhook)
+}
+
+func webhooksLifecycle(ctx context.Context, m data.Models) error {
+	webhook, err := insertWebhook(ctx, m, "kate@example.com")
+	if err != nil {
+		return err
+	}
+
+	wanted, err := m.Webhooks.ForEvent(ctx, webhook.WorkspaceID, "task.created")
+	if err != nil {
+		return err
+	}
+	if len(wanted) != 1 || wanted[0].ID != webhook.ID || wanted[0].Secret != webhook.Secret {
+		return fmt.Errorf("got %+v for task.created; want the webhook", wanted)
+	}
+	wanted, err = m.Webhooks.ForEvent(ctx, webhook.WorkspaceID, "project.created")
+	if err != nil {
+		return err
+	}
+	if len(wanted) != 0 {
+		return fmt.Errorf("got %+v for project.created; want none", wanted)
+	}
+
+	for i := 1; i <= 3; i++ {
+		disabled, err := m.Webhooks.RecordResult(ctx, webhook.ID, false, 3)
+		if err != nil {
+			return err
+		}
+		if disabled != (i == 3) {
+			return fmt.Errorf("failure %d: got disabled %v", i, disabled)
+		}
+	}
+
+	got, err := m.Webhooks.Get(ctx, webhook.ID)
+	if err != nil {
+		return err
+	}
+	if got.Active || got.FailureCount != 3 || got.DisabledAt == nil || got.Version != 2 {
+		return fmt.Errorf("got %+v after repeated failures", got)
+	}
+
+	got.Active = true
+	if err := m.Webhooks.Update(ctx, got); err != nil {
+		return err
+	}
+	err = m.Webhooks.Update(ctx, webhook)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	got, err = m.Webhooks.Get(ctx, webhook.ID)
+	if err != nil {
+		return err
+	}
+	if !got.Active || got.FailureCount != 0 || got.DisabledAt != nil {
+		return fmt.Errorf("got %+v after re-activating", got)
+	}
+
+	if err := m.Webhooks.Delete(ctx, webhook.ID); err != nil {
+		return err
+	}
+	err = m.Webhooks.Delete(ctx, webhook.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("second delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func webhooksDeliveries(ctx context.Context, m data.Models) error {
+	webhook, err := insertWebhook(ctx, m, "liam@example.com")
+	if err != nil {
+		return err
+	}
+
+	delivery := &data.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   7,
+		EventType: "task.created",
+		Payload:   []byte(`{"id":7}`),
+	}
+	if err := m.Deliveries.Insert(ctx, delivery); err != nil {
+		return err
+	}
+
+	due, err := m.Deliveries.Due(ctx, time.Now().Add(time.Second), 10)
+	if err != nil {
+		return err
+	}
+	if len(due) != 1 || due[0].ID != delivery.ID || string(due[0].Payload) != `{"id":7}` {
+		return fmt.Errorf("got due %+v; want the new delivery", due)
+	}
+
+	claimed := *due[0]
+	later := time.Now().Add(time.Minute)
+	claimed.NextAttemptAt = &later
+	if err := m.Deliveries.Update(ctx, &claimed); err != nil {
+		return err
+	}
+	err = m.Deliveries.Update(ctx, due[0])
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("second claim: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	claimed.Status = data.WebhookDeliverySucceeded
+	claimed.NextAttemptAt = nil
+	claimed.Attempts = append(claimed.Attempts, data.WebhookAttempt{At: time.Now().UTC(), StatusCode: 204})
+	if err := m.Deliveries.Update(ctx, &claimed); err != nil {
+		return err
+	}
+
+	deliveries, err := m.Deliveries.ForWebhook(ctx, webhook.ID, 10)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != data.WebhookDeliverySucceeded || len(deliveries[0].Attempts) != 1 || deliveries[0].Attempts[0].StatusCode != 204 {
+		return fmt.Errorf("got deliveries %+v after success", deliveries)
+	}
+
+	due, err = m.Deliveries.Due(ctx, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		return err
+	}
+	if len(due) != 0 {
+		return fmt.Errorf("got due %+v after success; want none", due)
+	}
+	return nil
+}
+
+func inboundHooksLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "mona@example.com")
+	if err != nil {
+		return err
+	}
+
+	hook := &data.InboundHook{
+		ProjectID: project.ID,
+		CreatedBy: project.OwnerID,
+		Name:      "Alerts",
+		Template:  data.TaskTemplate{Title: "{{alert.name}}", IdempotencyKey: "{{alert.id}}"},
+	}
+	if err := m.InboundHooks.Insert(ctx, hook); err != nil {
+		return err
+	}
+	if len(hook.PlainToken) != 32 {
+		return fmt.Errorf("got token %q; want 32 characters", hook.PlainToken)
+	}
+
+	got, err := m.InboundHooks.GetForToken(ctx, hook.PlainToken)
+	if err != nil {
+		return err
+	}
+	if got.ID != hook.ID || got.Template != hook.Template || got.PlainToken != "" {
+		return fmt.Errorf("got %+v for token; want %+v", got, hook)
+	}
+
+	oldToken := hook.PlainToken
+	if err := got.SetToken(); err != nil {
+		return err
+	}
+	if err := m.InboundHooks.Update(ctx, got); err != nil {
+		return err
+	}
+	err = m.InboundHooks.Update(ctx, hook)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+	_, err = m.InboundHooks.GetForToken(ctx, oldToken)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("rotated token: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	taskID := primitive.NewObjectID()
+	if err := m.InboundHooks.ClaimKey(ctx, hook.ID, "alert-1", taskID); err != nil {
+		return err
+	}
+	err = m.InboundHooks.ClaimKey(ctx, hook.ID, "alert-1", primitive.NewObjectID())
+	if !errors.Is(err, data.ErrDuplicateKey) {
+		return fmt.Errorf("second claim: got error %v; want %v", err, data.ErrDuplicateKey)
+	}
+	claimedBy, err := m.InboundHooks.TaskForKey(ctx, hook.ID, "alert-1")
+	if err != nil {
+		return err
+	}
+	if claimedBy != taskID {
+		return fmt.Errorf("got task %s for key; want %s", claimedBy.Hex(), taskID.Hex())
+	}
+
+	// Releasing a key only lets go of it for the task it was claimed for.
+	if err := m.InboundHooks.ReleaseKey(ctx, hook.ID, "alert-1", primitive.NewObjectID()); err != nil {
+		return err
+	}
+	if _, err := m.InboundHooks.TaskForKey(ctx, hook.ID, "alert-1"); err != nil {
+		return fmt.Errorf("after releasing for another task: %w", err)
+	}
+	if err := m.InboundHooks.ReleaseKey(ctx, hook.ID, "alert-1", taskID); err != nil {
+		return err
+	}
+	_, err = m.InboundHooks.TaskForKey(ctx, hook.ID, "alert-1")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("key after release: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	if err := m.InboundHooks.ClaimKey(ctx, hook.ID, "alert-1", taskID); err != nil {
+		return fmt.Errorf("claim after release: %w", err)
+	}
+
+	hooks, err := m.InboundHooks.ForProject(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	if len(hooks) != 1 || hooks[0].ID != hook.ID {
+		return fmt.Errorf("got %+v for project; want the hook", hooks)
+	}
+
+	if err := m.InboundHooks.Delete(ctx, hook.ID); err != nil {
+		return err
+	}
+	_, err = m.InboundHooks.TaskForKey(ctx, hook.ID, "alert-1")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("key after delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func idempotencyClaim(ctx context.Context, m data.Models) error {
+	record := &data.IdempotencyRecord{Scope: "user:conformance", Key: "retry-1", Fingerprint: []byte{1, 2, 3}}
+	if err := m.Idempotency.Claim(ctx, record); err != nil {
+		return err
+	}
+
+	second := &data.IdempotencyRecord{Scope: record.Scope, Key: record.Key, Fingerprint: []byte{4}}
+	err := m.Idempotency.Claim(ctx, second)
+	if !errors.Is(err, data.ErrDuplicateKey) {
+		return fmt.Errorf("second claim: got error %v; want %v", err, data.ErrDuplicateKey)
+	}
+
+	got, err := m.Idempotency.Get(ctx, record.Scope, record.Key)
+	if err != nil {
+		return err
+	}
+	if got.Completed() || string(got.Fingerprint) != string(record.Fingerprint) {
+		return fmt.Errorf("got %+v while in progress", got)
+	}
+
+	record.Status = 201
+	record.Header = map[string][]string{"Content-Type": {"application/json"}}
+	record.Body = []byte(`{"ok":true}`)
+	if err := m.Idempotency.Complete(ctx, record); err != nil {
+		return err
+	}
+
+	got, err = m.Idempotency.Get(ctx, record.Scope, record.Key)
+	if err != nil {
+		return err
+	}
+	if got.Status != 201 || string(got.Body) != `{"ok":true}` || got.Header["Content-Type"][0] != "application/json" {
+		return fmt.Errorf("got %+v after completing", got)
+	}
+
+	_, err = m.Idempotency.Get(ctx, "user:other", record.Key)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("other scope: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	// A completed record is kept for replays rather than released.
+	if err := m.Idempotency.Release(ctx, record); err != nil {
+		return err
+	}
+	err = m.Idempotency.Claim(ctx, second)
+	if !errors.Is(err, data.ErrDuplicateKey) {
+		return fmt.Errorf("claim after completing: got error %v; want %v", err, data.ErrDuplicateKey)
+	}
+
+	// Once a claim has passed to another request, such as a retry after it
+	// expired, its old holder can neither complete nor release it.
+	stale := &data.IdempotencyRecord{Scope: record.Scope, Key: "retry-2", Fingerprint: []byte{5}}
+	if err := m.Idempotency.Claim(ctx, stale); err != nil {
+		return err
+	}
+	if err := m.Idempotency.Release(ctx, stale); err != nil {
+		return err
+	}
+	retry := &data.IdempotencyRecord{Scope: stale.Scope, Key: stale.Key, Fingerprint: stale.Fingerprint}
+	if err := m.Idempotency.Claim(ctx, retry); err != nil {
+		return err
+	}
+
+	stale.Status = 201
+	err = m.Idempotency.Complete(ctx, stale)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale complete: got error %v; want %v", err, data.ErrEditConflict)
+	}
+	if err := m.Idempotency.Release(ctx, stale); err != nil {
+		return err
+	}
+
+	got, err = m.Idempotency.Get(ctx, retry.Scope, retry.Key)
+	if err != nil {
+		return err
+	}
+	if got.Completed() || got.ClaimID != retry.ClaimID {
+		return fmt.Errorf("got %+v after a stale complete and release; want the retry's claim", got)
+	}
+
+	if err := m.Idempotency.Release(ctx, retry); err != nil {
+		return err
+	}
+	return m.Idempotency.Claim(ctx, retry)
+}
+
+func importJobsLifecycle(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "omar@example.com")
+	if err != nil {
+		return err
+	}
+
+	job := &data.ImportJob{UserID: user.ID, Total: 250}
+	if err := m.Imports.Insert(ctx, job); err != nil {
+		return err
+	}
+	if job.Status != data.ImportJobPending || job.Version != 1 {
+		return fmt.Errorf("got %+v after insert", job)
+	}
+
+	stale := *job
+	job.Status = data.ImportJobRunning
+	job.Processed = 100
+	if err := m.Imports.Update(ctx, job); err != nil {
+		return err
+	}
+
+	stale.Status = data.ImportJobFailed
+	err = m.Imports.Update(ctx, &stale)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	got, err := m.Imports.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.UserID != user.ID || got.Status != data.ImportJobRunning || got.Processed != 100 || got.Total != 250 || got.Version != 2 {
+		return fmt.Errorf("got %+v after update", got)
+	}
+
+	_, err = m.Imports.Get(ctx, primitive.NewObjectID())
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("missing job: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func calendarObjectsLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "pia@example.com")
+	if err != nil {
+		return err
+	}
+
+	object := &data.CalendarObject{ProjectID: project.ID, Name: "a1b2.ics", TaskID: primitive.NewObjectID(), UID: "a1b2@example.com"}
+	if err := m.Calendars.Insert(ctx, object); err != nil {
+		return err
+	}
+
+	duplicate := &data.CalendarObject{ProjectID: project.ID, Name: object.Name, TaskID: primitive.NewObjectID(), UID: "other"}
+	err = m.Calendars.Insert(ctx, duplicate)
+	if !errors.Is(err, data.ErrDuplicateName) {
+		return fmt.Errorf("duplicate name: got error %v; want %v", err, data.ErrDuplicateName)
+	}
+
+	got, err := m.Calendars.GetByName(ctx, project.ID, object.Name)
+	if err != nil {
+		return err
+	}
+	if got.TaskID != object.TaskID || got.UID != object.UID || got.ProjectID != project.ID {
+		return fmt.Errorf("got %+v; want %+v", got, object)
+	}
+
+	objects, err := m.Calendars.ForProject(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	if len(objects) != 1 || objects[0].Name != object.Name {
+		return fmt.Errorf("got %d objects for project; want 1", len(objects))
+	}
+
+	if err := m.Calendars.Delete(ctx, project.ID, object.Name); err != nil {
+		return err
+	}
+	_, err = m.Calendars.GetByName(ctx, project.ID, object.Name)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("after delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return m.Calendars.Insert(ctx, duplicate)
+}
+
+func searchRanking(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "quinn@example.com")
+	if err != nil {
+		return err
+	}
+
+	var tasks []*data.Task
+	for _, t := range []struct{ title, description, status string }{
+		{"Renew passport", "Book an appointment at the office", data.TaskStatusTodo},
+		{"Pack bags", "Passport, tickets and chargers", data.TaskStatusTodo},
+		{"Renew passport photos", "", data.TaskStatusDone},
+		{"Water plants", "", data.TaskStatusTodo},
+	} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: t.title, Description: t.description, Status: t.status}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := m.Tasks.Delete(ctx, tasks[3]); err != nil {
+		return err
+	}
+
+	search := func(q string) ([]*data.SearchResult, error) {
+		v := validator.New()
+		query := data.ParseSearchQuery(v, q)
+		if !v.Valid() {
+			return nil, fmt.Errorf("query %q: %v", q, v.Errors)
+		}
+		return m.Search.Search(ctx, query, []primitive.ObjectID{project.ID}, 10)
+	}
+
+	results, err := search("passport")
+	if err != nil {
+		return err
+	}
+	if len(results) != 3 || results[2].Task == nil || results[2].Task.ID != tasks[1].ID {
+		return fmt.Errorf("passport: got %d results; want 3 with the description match last", len(results))
+	}
+
+	results, err = search(`"renew passport" status:open`)
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || results[0].Task == nil || results[0].Task.ID != tasks[0].ID {
+		return fmt.Errorf("open phrase: got %d results; want only the open task", len(results))
+	}
+
+	results, err = search("confor* type:project")
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || results[0].Project == nil || results[0].Project.ID != project.ID {
+		return fmt.Errorf("project prefix: got %d results; want the project", len(results))
+	}
+
+	results, err = search("water")
+	if err != nil {
+		return err
+	}
+	if len(results) != 0 {
+		return fmt.Errorf("deleted task: got %d results; want none", len(results))
+	}
+	return nil
+}
+
+func tasksQuery(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "rosa@example.com")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	soon, later := now.Add(48*time.Hour), now.Add(30*24*time.Hour)
+	var tasks []*data.Task
+	for _, t := range []struct {
+		status   string
+		priority int32
+		due      *time.Time
+	}{
+		{data.TaskStatusTodo, data.TaskPriorityHigh, &soon},
+		{data.TaskStatusDone, data.TaskPriorityHigh, &soon},
+		{data.TaskStatusInProgress, data.TaskPriorityLow, &later},
+		{data.TaskStatusTodo, data.TaskPriorityNone, nil},
+	} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Task", Status: t.status, Priority: t.priority, DueAt: t.due}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	other := primitive.NewObjectID()
+	tasks[2].AssigneeIDs = []primitive.ObjectID{other, project.OwnerID}
+	if err := m.Tasks.Update(ctx, tasks[2]); err != nil {
+		return err
+	}
+	tasks[3].AssigneeIDs = []primitive.ObjectID{other}
+	if err := m.Tasks.Update(ctx, tasks[3]); err != nil {
+		return err
+	}
+
+	got, err := m.Tasks.Get(ctx, tasks[2].ID)
+	if err != nil {
+		return err
+	}
+	if len(got.AssigneeIDs) != 2 || got.AssigneeIDs[0] != other || got.AssigneeIDs[1] != project.OwnerID {
+		return fmt.Errorf("got assignees %v; want %v", got.AssigneeIDs, tasks[2].AssigneeIDs)
+	}
+
+	for query, want := range map[string][]*data.Task{
+		"due:<7d -status:done":     {tasks[0]},
+		"status:open -priority:0":  {tasks[0], tasks[2]},
+		"due:none":                 {tasks[3]},
+		"due:any priority:low,3":   {tasks[0], tasks[1], tasks[2]},
+		"due:>7d":                  {tasks[2]},
+		"-status:todo,in_progress": {tasks[1]},
+		"assignee:me":              {tasks[2]},
+		"assignee:" + other.Hex():  {tasks[2], tasks[3]},
+	} {
+		filters, err := data.ParseTaskQuery(query, project.OwnerID, now)
+		if err != nil {
+			return fmt.Errorf("query %q: %w", query, err)
+		}
+		filters.ProjectIDs = []primitive.ObjectID{project.ID}
+
+		got, err := m.Tasks.List(ctx, filters, primitive.NilObjectID, 10)
+		if err != nil {
+			return err
+		}
+		if len(got) != len(want) {
+			return fmt.Errorf("query %q: got %d tasks; want %d", query, len(got), len(want))
+		}
+		for i := range got {
+			if got[i].ID != want[i].ID {
+				return fmt.Errorf("query %q: got task %s at %d; want %s", query, got[i].ID.Hex(), i, want[i].ID.Hex())
+			}
+		}
+	}
+	return nil
+}
+
+func savedViewsLifecycle(ctx context.Context, m data.Models) error {
+	owner, err := insertUser(ctx, m, "sam@example.com")
+	if err != nil {
+		return err
+	}
+	workspace := &data.Workspace{Name: "Views", OwnerID: owner.ID}
+	if err := m.Workspaces.Insert(ctx, workspace); err != nil {
+		return err
+	}
+
+	personal := &data.SavedView{OwnerID: owner.ID, Name: "Mine", Query: "status:open", Sort: "-priority"}
+	if err := m.Views.Insert(ctx, personal); err != nil {
+		return err
+	}
+	shared := &data.SavedView{OwnerID: owner.ID, WorkspaceID: &workspace.ID, Name: "Team", Query: "due:<7d", GroupBy: "status"}
+	if err := m.Views.Insert(ctx, shared); err != nil {
+		return err
+	}
+
+	got, err := m.Views.Get(ctx, shared.ID)
+	if err != nil {
+		return err
+	}
+	if got.WorkspaceID == nil || *got.WorkspaceID != workspace.ID || got.Query != shared.Query || got.GroupBy != "status" {
+		return fmt.Errorf("got %+v; want %+v", got, shared)
+	}
+
+	views, err := m.Views.ForUser(ctx, owner.ID, nil)
+	if err != nil {
+		return err
+	}
+	if len(views) != 1 || views[0].ID != personal.ID || views[0].WorkspaceID != nil {
+		return fmt.Errorf("got %d views without workspaces; want only the personal one", len(views))
+	}
+	views, err = m.Views.ForUser(ctx, primitive.NewObjectID(), []primitive.ObjectID{workspace.ID})
+	if err != nil {
+		return err
+	}
+	if len(views) != 1 || views[0].ID != shared.ID {
+		return fmt.Errorf("got %d views for a workspace member; want only the shared one", len(views))
+	}
+
+	personal.Query = "-status:done"
+	if err := m.Views.Update(ctx, personal); err != nil {
+		return err
+	}
+	stale := *personal
+	stale.Version = 1
+	err = m.Views.Update(ctx, &stale)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("stale update: got error %v; want %v", err, data.ErrEditConflict)
+	}
+
+	if err := m.Views.Delete(ctx, personal.ID); err != nil {
+		return err
+	}
+	_, err = m.Views.Get(ctx, personal.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("after delete: got error %v; want %v", err, data.ErrRecordNotFound)
+	}
+	return nil
+}
+
+func watchersLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "tess@example.com")
+	if err != nil {
+		return err
+	}
+
+	task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Watched"}
+	if err := m.Tasks.Insert(ctx, task); err != nil {
+		return err
+	}
+
+	user, err := insertUser(ctx, m, "ursula@example.com")
+	if err != nil {
+		return err
+	}
+	other := user.ID
+
+	for _, id := range []primitive.ObjectID{project.OwnerID, other, project.OwnerID} {
+		if err := m.Watchers.Add(ctx, task.ID, id); err != nil {
+			return err
+		}
+	}
+
+	ids, err := m.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 2 || ids[0] != project.OwnerID || ids[1] != other {
+		return fmt.Errorf("got watchers %v; want the owner then the other user once each", ids)
+	}
+
+	if err := m.Watchers.Remove(ctx, task.ID, project.OwnerID); err != nil {
+		return err
+	}
+	ids, err = m.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 || ids[0] != other {
+		return fmt.Errorf("got watchers %v after removing the owner; want only %s", ids, other.Hex())
+	}
+
+	// Leaving a workspace stops the user watching the tasks in its
+	// projects and no others.
+	elsewhere, err := insertProject(ctx, m, "yvonne@example.com")
+	if err != nil {
+		return err
+	}
+	kept := &data.Task{ProjectID: elsewhere.ID, CreatedBy: elsewhere.OwnerID, Title: "Still watched"}
+	if err := m.Tasks.Insert(ctx, kept); err != nil {
+		return err
+	}
+	if err := m.Watchers.Add(ctx, kept.ID, other); err != nil {
+		return err
+	}
+	if err := m.Watchers.RemoveForProjects(ctx, other, []primitive.ObjectID{project.ID}); err != nil {
+		return err
+	}
+	ids, err = m.Watchers.ForTask(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 0 {
+		return fmt.Errorf("got watchers %v after removing the project's; want none", ids)
+	}
+	ids, err = m.Watchers.ForTask(ctx, kept.ID)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 || ids[0] != other {
+		return fmt.Errorf("got watchers %v of another project's task; want only %s", ids, other.Hex())
+	}
+
+	ids, err = m.Watchers.ForTask(ctx, primitive.NewObjectID())
+	if err != nil {
+		return err
+	}
+	if len(ids) != 0 {
+		return fmt.Errorf("got %d watchers of an unknown task; want 0", len(ids))
+	}
+	return nil
+}
+
+func commentsLifecycle(ctx context.Context, m data.Models) error {
+	project, err := insertProject(ctx, m, "vera@example.com")
+	if err != nil {
+		return err
+	}
+
+	var tasks []*data.Task
+	for _, title := range []string{"Discussed", "Also discussed"} {
+		task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: title}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	}
+
+	var comments []*data.Comment
+	for i, body := range []string{"First thoughts on the *zeppelin*", "Second", "Elsewhere"} {
+		comment := &data.Comment{
+			TaskID:     tasks[i/2].ID,
+			ProjectID:  project.ID,
+			AuthorID:   project.OwnerID,
+			Body:       body,
+			BodyHTML:   "<p>" + body + "</p>",
+			MentionIDs: []primitive.ObjectID{project.OwnerID},
+		}
+		if err := m.Comments.Insert(ctx, comment); err != nil {
+			return err
+		}
+		comments = append(comments, comment)
+	}
+
+	got, err := m.Comments.Get(ctx, comments[0].ID)
+	if err != nil {
+		return err
+	}
+	if got.Body != comments[0].Body || got.Version != 1 || len(got.MentionIDs) != 1 || got.MentionIDs[0] != project.OwnerID {
+		return fmt.Errorf("got %+v; want %+v", got, comments[0])
+	}
+
+	list, err := m.Comments.ForTask(ctx, tasks[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 2 || list[0].ID != comments[0].ID || list[1].ID != comments[1].ID {
+		return fmt.Errorf("got %d comments on the first task; want 2 oldest first", len(list))
+	}
+
+	stale := *comments[0]
+	comments[0].Body, comments[0].MentionIDs = "Second thoughts", nil
+	if err := m.Comments.Update(ctx, comments[0]); err != nil {
+		return err
+	}
+	if comments[0].Version != 2 {
+		return fmt.Errorf("got version %d after an update; want 2", comments[0].Version)
+	}
+	if err := m.Comments.Update(ctx, &stale); !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("got %v updating a stale comment; want ErrEditConflict", err)
+	}
+
+	revs, err := m.Comments.Revisions(ctx, comments[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(revs) != 2 || revs[0].Body != "First thoughts on the *zeppelin*" || revs[1].Body != "Second thoughts" {
+		return fmt.Errorf("got %d revisions; want the original body then the edit", len(revs))
+	}
+
+	v := validator.New()
+	query := data.ParseSearchQuery(v, "elsewhere")
+	results, err := m.Search.Search(ctx, query, []primitive.ObjectID{project.ID}, 10)
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || results[0].Comment == nil || results[0].Comment.ID != comments[2].ID {
+		return fmt.Errorf("search: got %d results; want the comment", len(results))
+	}
+
+	if err := m.Comments.Delete(ctx, comments[1].ID); err != nil {
+		return err
+	}
+	if _, err := m.Comments.Get(ctx, comments[1].ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v getting a deleted comment; want ErrRecordNotFound", err)
+	}
+
+	if err := m.Tasks.Delete(ctx, tasks[0]); err != nil {
+		return err
+	}
+	if _, err := m.Comments.Get(ctx, comments[0].ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v getting a comment on a deleted task; want ErrRecordNotFound", err)
+	}
+	revs, err = m.Comments.Revisions(ctx, comments[0].ID)
+	if err != nil {
+		return err
+	}
+	if len(revs) != 0 {
+		return fmt.Errorf("got %d revisions of a comment on a deleted task; want 0", len(revs))
+	}
+
+	if err := m.Tasks.DeleteAllForProject(ctx, project.ID); err != nil {
+		return err
+	}
+	list, err = m.Comments.ForTask(ctx, tasks[1].ID)
+	if err != nil {
+		return err
+	}
+	if len(list) != 0 {
+		return fmt.Errorf("got %d comments after deleting the project's tasks; want 0", len(list))
+	}
+	return nil
+}
+
+// commentsTaskMoves checks that comments follow their task to another
+// project, for searching and for deleting the project they left.
+func commentsTaskMoves(ctx context.Context, m data.Models) error {
+	from, err := insertProject(ctx, m, "wendy@example.com")
+	if err != nil {
+		return err
+	}
+	to, err := insertProject(ctx, m, "walt@example.com")
+	if err != nil {
+		return err
+	}
+
+	var tasks []*data.Task
+	var comments []*data.Comment
+	for _, body := range []string{"Moving to the hangar", "Staying put"} {
+		task := &data.Task{ProjectID: from.ID, CreatedBy: from.OwnerID, Title: "Discussed"}
+		if err := m.Tasks.Insert(ctx, task); err != nil {
+			return err
+		}
+		comment := &data.Comment{TaskID: task.ID, ProjectID: from.ID, AuthorID: from.OwnerID, Body: body, BodyHTML: "<p>" + body + "</p>"}
+		if err := m.Comments.Insert(ctx, comment); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+		comments = append(comments, comment)
+	}
+
+	tasks[0].ProjectID = to.ID
+	if err := m.Tasks.Update(ctx, tasks[0]); err != nil {
+		return err
+	}
+
+	got, err := m.Comments.Get(ctx, comments[0].ID)
+	if err != nil {
+		return err
+	}
+	if got.ProjectID != to.ID {
+		return fmt.Errorf("got project %s for a moved task's comment; want %s", got.ProjectID.Hex(), to.ID.Hex())
+	}
+
+	v := validator.New()
+	query := data.ParseSearchQuery(v, "hangar")
+	for _, project := range []struct {
+		id   primitive.ObjectID
+		want int
+	}{{from.ID, 0}, {to.ID, 1}} {
+		results, err := m.Search.Search(ctx, query, []primitive.ObjectID{project.id}, 10)
+		if err != nil {
+			return err
+		}
+		if len(results) != project.want {
+			return fmt.Errorf("search in %s: got %d results; want %d", project.id.Hex(), len(results), project.want)
+		}
+	}
+
+	if err := m.Tasks.DeleteAllForProject(ctx, from.ID); err != nil {
+		return err
+	}
+	if _, err := m.Comments.Get(ctx, comments[0].ID); err != nil {
+		return fmt.Errorf("got %v getting a moved task's comment after deleting its old project; want it kept", err)
+	}
+	if _, err := m.Comments.Get(ctx, comments[1].ID); !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v getting a comment in the deleted project; want ErrRecordNotFound", err)
+	}
+	return nil
+}
+
+func notificationsInbox(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "wanda@example.com")
+	if err != nil {
+		return err
+	}
+	other, err := insertUser(ctx, m, "xavier@example.com")
+	if err != nil {
+		return err
+	}
+
+	var notifications []*data.Notification
+	for _, title := range []string{"First", "Second", "Third"} {
+		notification := &data.Notification{
+			UserID:    user.ID,
+			Type:      data.NotificationTaskUpdated,
+			ActorID:   other.ID,
+			TaskID:    primitive.NewObjectID(),
+			ProjectID: primitive.NewObjectID(),
+			TaskTitle: title,
+		}
+		if err := m.Notifications.Insert(ctx, notification); err != nil {
+			return err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	page, err := m.Notifications.ForUser(ctx, user.ID, primitive.NilObjectID, false, 2)
+	if err != nil {
+		return err
+	}
+	if len(page) != 2 || page[0].ID != notifications[2].ID || page[1].ID != notifications[1].ID {
+		return fmt.Errorf("got first page %v; want the newest two, newest first", page)
+	}
+	page, err = m.Notifications.ForUser(ctx, user.ID, page[1].ID, false, 2)
+	if err != nil {
+		return err
+	}
+	if len(page) != 1 || page[0].ID != notifications[0].ID {
+		return fmt.Errorf("got second page %v; want only the oldest", page)
+	}
+
+	n, err := m.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if n != 3 {
+		return fmt.Errorf("got %d unread; want 3", n)
+	}
+
+	read, err := m.Notifications.SetRead(ctx, user.ID, notifications[1].ID, true)
+	if err != nil {
+		return err
+	}
+	if read.ReadAt == nil || read.TaskTitle != "Second" {
+		return fmt.Errorf("got %+v after marking it read; want it read", read)
+	}
+	readAt := *read.ReadAt
+
+	again, err := m.Notifications.SetRead(ctx, user.ID, notifications[1].ID, true)
+	if err != nil {
+		return err
+	}
+	if again.ReadAt == nil || !again.ReadAt.Equal(readAt) {
+		return fmt.Errorf("got read at %v after marking it read again; want %v kept", again.ReadAt, readAt)
+	}
+
+	_, err = m.Notifications.SetRead(ctx, other.ID, notifications[0].ID, true)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return fmt.Errorf("got %v marking someone else's notification read; want ErrRecordNotFound", err)
+	}
+
+	unread, err := m.Notifications.ForUser(ctx, user.ID, primitive.NilObjectID, true, 10)
+	if err != nil {
+		return err
+	}
+	if len(unread) != 2 || unread[0].ID != notifications[2].ID || unread[1].ID != notifications[0].ID {
+		return fmt.Errorf("got unread %v; want the third and first", unread)
+	}
+
+	n, err = m.Notifications.MarkAllRead(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if n != 2 {
+		return fmt.Errorf("marked %d read; want 2", n)
+	}
+	n, err = m.Notifications.UnreadCount(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		return fmt.Errorf("got %d unread after marking all read; want 0", n)
+	}
+
+	unreadAgain, err := m.Notifications.SetRead(ctx, user.ID, notifications[2].ID, false)
+	if err != nil {
+		return err
+	}
+	if unreadAgain.ReadAt != nil {
+		return fmt.Errorf("got read at %v after marking it unread; want none", unreadAgain.ReadAt)
+	}
+
+	prefs, err := m.Preferences.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if !prefs.InApp(data.NotificationMentioned) || !prefs.Email(data.NotificationMentioned) {
+		return errors.New("preferences which were never set do not default to both")
+	}
+
+	prefs.Channels = map[string]string{data.NotificationTaskUpdated: data.ChannelInApp}
+	if err := m.Preferences.Put(ctx, prefs); err != nil {
+		return err
+	}
+	prefs.Channels = map[string]string{data.NotificationTaskUpdated: data.ChannelEmail, data.NotificationMentioned: data.ChannelNone}
+	if err := m.Preferences.Put(ctx, prefs); err != nil {
+		return err
+	}
+
+	got, err := m.Preferences.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if got.Channel(data.NotificationTaskUpdated) != data.ChannelEmail || got.Channel(data.NotificationMentioned) != data.ChannelNone ||
+		got.Channel(data.NotificationTaskAssigned) != data.ChannelBoth {
+		return fmt.Errorf("got channels %v; want the second set replacing the first", got.Channels)
+	}
+	return nil
+}
+
+func notificationsDigests(ctx context.Context, m data.Models) error {
+	user, err := insertUser(ctx, m, "yolanda@example.com")
+	if err != nil {
+		return err
+	}
+
+	prefs, err := m.Preferences.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if prefs.Digest || prefs.DigestHour != data.DefaultDigestHour || prefs.DigestFrequency != data.DigestDaily || prefs.DigestWeekday != data.DefaultDigestWeekday {
+		return fmt.Errorf("got %+v for preferences which were never set; want no daily digest at the default hour", prefs)
+	}
+
+	// 13:30 UTC is 09:30 in New York, so the next 08:00 there is tomorrow.
+	now := time.Date(2030, time.June, 3, 13, 30, 0, 0, time.UTC)
+	prefs.Digest = true
+	prefs.Timezone = "America/New_York"
+	prefs.ScheduleDigest(now)
+	want := time.Date(2030, time.June, 4, 12, 0, 0, 0, time.UTC)
+	if prefs.NextDigestAt == nil || !prefs.NextDigestAt.Equal(want) {
+		return fmt.Errorf("got next digest at %v; want %v", prefs.NextDigestAt, want)
+	}
+	if err := m.Preferences.Put(ctx, prefs); err != nil {
+		return err
+	}
+
+	due, err := m.Preferences.DueDigests(ctx, now, 10)
+	if err != nil {
+		return err
+	}
+	for _, p := range due {
+		if p.UserID == user.ID {
+			return errors.New("digest is due before its time")
+		}
+	}
+
+	later := want.Add(time.Minute)
+	due, err = m.Preferences.DueDigests(ctx, later, 10)
+	if err != nil {
+		return err
+	}
+	var claimed *data.NotificationPreferences
+	for _, p := range due {
+		if p.UserID == user.ID {
+			claimed = p
+		}
+	}
+	if claimed == nil || claimed.Timezone != "America/New_York" {
+		return fmt.Errorf("got due digests %v; want the user's", due)
+	}
+
+	claimed.ScheduleDigest(later)
+	if err := m.Preferences.ClaimDigest(ctx, claimed, later); err != nil {
+		return err
+	}
+	err = m.Preferences.ClaimDigest(ctx, claimed, later)
+	if !errors.Is(err, data.ErrEditConflict) {
+		return fmt.Errorf("got %v claiming a digest twice; want ErrEditConflict", err)
+	}
+
+	got, err := m.Preferences.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	next := want.AddDate(0, 0, 1)
+	if got.NextDigestAt == nil || !got.NextDigestAt.Equal(next) || got.LastDigestAt == nil || !got.LastDigestAt.Equal(later) {
+		return fmt.Errorf("got next %v and last %v after claiming; want %v and %v", got.NextDigestAt, got.LastDigestAt, next, later)
+	}
+
+	// 4 June 2030 is a Tuesday, so a weekly digest on Fridays comes three
+	// days after the daily one would have.
+	got.DigestFrequency = data.DigestWeekly
+	got.DigestWeekday = "friday"
+	got.ScheduleDigest(later)
+	weekly := want.AddDate(0, 0, 3)
+	if got.NextDigestAt == nil || !got.NextDigestAt.Equal(weekly) {
+		return fmt.Errorf("got next weekly digest at %v; want %v", got.NextDigestAt, weekly)
+	}
+	if err := m.Preferences.Put(ctx, got); err != nil {
+		return err
+	}
+	got, err = m.Preferences.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if got.DigestFrequency != data.DigestWeekly || got.DigestWeekday != "friday" || !got.NextDigestAt.Equal(weekly) {
+		return fmt.Errorf("got %+v after saving a weekly digest", got)
+	}
+
+	// A weekly digest on the day it goes out waits a week for the next.
+	got.ScheduleDigest(weekly)
+	if !got.NextDigestAt.Equal(weekly.AddDate(0, 0, 7)) {
+		return fmt.Errorf("got next weekly digest at %v; want a week after %v", got.NextDigestAt, weekly)
+	}
+
+	got.Digest = false
+	got.ScheduleDigest(later)
+	if err := m.Preferences.Put(ctx, got); err != nil {
+		return err
+	}
+	due, err = m.Preferences.DueDigests(ctx, next.AddDate(1, 0, 0), 10)
+	if err != nil {
+		return err
+	}
+	for _, p := range due {
+		if p.UserID == user.ID {
+			return errors.New("digest is still due after being turned off")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Change is a single project or task write picked up by a ChangeFeed.
+// Exactly one of Project and Task is set.
+type Change struct {
+	Seq     int64
+	Project *Project
+	Task    *Task
+}
+
+// ChangeFeed delivers the project and task writes made through any API
+// instance sharing the database, so that each instance can pass them on to
+// its own event subscribers. Run blocks, calling fn for each change, until
+// ctx is canceled or the feed fails. A change may be delivered more than
+// once.
+type ChangeFeed interface {
+	Run(ctx context.Context, fn func(Change)) error
+}
+
+const (
+	feedBatchSize = 500
+	// How long a poller keeps looking for a skipped change sequence before
+	// assuming its write was rolled back or has since been superseded.
+	feedGapTimeout = 10 * time.Second
+	// Least time between two saves of a change stream resume token.
+	feedSaveInterval = time.Second
+)
+
+// changePoller implements a ChangeFeed by repeatedly querying for writes
+// with a change sequence above the highest seen so far. Sequences are
+// allocated before the write commits, so a slow writer can commit below the
+// highest sequence already seen. Skipped sequences are therefore looked up
+// again on every poll until they turn up or feedGapTimeout passes. A
+// record written several times between two polls is only delivered in its
+// latest state.
+type changePoller struct {
+	interval  time.Duration
+	latest    func(ctx context.Context) (int64, error)
+	fetch     func(ctx context.Context, since int64, limit int) ([]Change, error)
+	fetchSeqs func(ctx context.Context, seqs []int64) ([]Change, error)
+}
+
+func (p changePoller) run(ctx context.Context, fn func(Change)) error {
+	high, err := p.latest(ctx)
+	if err != nil {
+		return err
+	}
+	missing := make(map[int64]time.Time)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+
+		if len(missing) > 0 {
+			seqs := make([]int64, 0, len(missing))
+			for seq, seen := range missing {
+				if now.Sub(seen) >= feedGapTimeout {
+					delete(missing, seq)
+					continue
+				}
+				if len(seqs) < feedBatchSize {
+					seqs = append(seqs, seq)
+				}
+			}
+
+			if len(seqs) > 0 {
+				changes, err := p.fetchSeqs(ctx, seqs)
+				if err != nil {
+					return err
+				}
+				for _, c := range changes {
+					delete(missing, c.Seq)
+					fn(c)
+				}
+			}
+		}
+
+		changes, err := p.fetch(ctx, high, feedBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			for seq := high + 1; seq < c.Seq; seq++ {
+				missing[seq] = now
+			}
+			high = c.Seq
+			fn(c)
+		}
+
+		// Keep going straight away while there is a backlog.
+		if len(changes) == feedBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// mergeChanges combines projects and tasks, each sorted by seq and fetched
+// with the given limit, into a single list sorted by seq. If either list
+// was cut short by the limit, changes beyond its last seq are dropped, as
+// the other list may be missing changes below them.
+func mergeChanges(projects []*Project, tasks []*Task, limit int) []Change {
+	changes := make([]Change, 0, len(projects)+len(tasks))
+	for _, p := range projects {
+		changes = append(changes, Change{Seq: p.Seq, Project: p})
+	}
+	for _, t := range tasks {
+		changes = append(changes, Change{Seq: t.Seq, Task: t})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Seq < changes[j].Seq
+	})
+
+	cutoff := int64(-1)
+	if limit > 0 && len(projects) == limit {
+		cutoff = projects[len(projects)-1].Seq
+	}
+	if limit > 0 && len(tasks) == limit {
+		if seq := tasks[len(tasks)-1].Seq; cutoff < 0 || seq < cutoff {
+			cutoff = seq
+		}
+	}
+	if cutoff >= 0 {
+		n := sort.Search(len(changes), func(i int) bool {
+			return changes[i].Seq > cutoff
+		})
+		changes = changes[:n]
+	}
+	return changes
+}
+
+// MongoChangeFeed tails a change stream on the projects and tasks
+// collections, saving its resume token under Name so that a restarted
+// instance picks up where it left off. Standalone servers don't support
+// change streams, so on those it polls every PollInterval instead.
+type MongoChangeFeed struct {
+	DB           *mongo.Database
+	Name         string
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+type feedPosition struct {
+	Name        string    `bson:"_id"`
+	ResumeToken bson.Raw  `bson:"resume_token"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+}
+
+// Server error codes meaning a saved resume token can no longer be used.
+var mongoResumeErrorCodes = []int{
+	260, // InvalidResumeToken
+	280, // ChangeStreamFatalError
+	286, // ChangeStreamHistoryLost
+}
+
+func (f MongoChangeFeed) Run(ctx context.Context, fn func(Change)) error {
+	checkCtx, cancel := withTimeout(ctx, f.Timeout)
+	supported, err := replicaSetOrRouter(checkCtx, f.DB)
+	cancel()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if !supported {
+		return f.poller().run(ctx, fn)
+	}
+	return f.watch(ctx, fn)
+}
+
+func (f MongoChangeFeed) watch(ctx context.Context, fn func(Change)) error {
+	positions := f.DB.Collection("feed_positions")
+
+	var position feedPosition
+	err := positions.FindOne(ctx, bson.M{"_id": f.Name}).Decode(&position)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return queryError(ctx, err)
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.coll":       bson.M{"$in": []string{"projects", "tasks"}},
+		"operationType": bson.M{"$in": []string{"insert", "update", "replace"}},
+	}}}}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if position.ResumeToken != nil {
+		opts.SetStartAfter(position.ResumeToken)
+	}
+
+	stream, err := f.DB.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return f.watchError(ctx, err)
+	}
+	defer stream.Close(context.Background())
+
+	// The token is saved at most every feedSaveInterval, so a restarted
+	// instance may see the last few changes again.
+	var saved time.Time
+	save := func() error {
+		saveCtx, cancel := withTimeout(ctx, f.Timeout)
+		defer cancel()
+
+		position := feedPosition{Name: f.Name, ResumeToken: stream.ResumeToken(), UpdatedAt: time.Now().UTC()}
+		_, err := positions.ReplaceOne(saveCtx, bson.M{"_id": f.Name}, position, options.Replace().SetUpsert(true))
+		saved = time.Now()
+		return err
+	}
+
+	for stream.Next(ctx) {
+		var event struct {
+			NS struct {
+				Coll string `bson:"coll"`
+			} `bson:"ns"`
+			FullDocument bson.Raw `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+
+		// The document may have been removed before it could be looked up.
+		if event.FullDocument != nil {
+			var change Change
+			switch event.NS.Coll {
+			case "projects":
+				change.Project = &Project{}
+				err = bson.Unmarshal(event.FullDocument, change.Project)
+				change.Seq = change.Project.Seq
+			default:
+				change.Task = &Task{}
+				err = bson.Unmarshal(event.FullDocument, change.Task)
+				change.Seq = change.Task.Seq
+			}
+			if err != nil {
+				return err
+			}
+			fn(change)
+		}
+
+		if time.Since(saved) >= feedSaveInterval {
+			if err := save(); err != nil {
+				return queryError(ctx, err)
+			}
+		}
+	}
+	return f.watchError(ctx, stream.Err())
+}
+
+// watchError forgets the saved resume token if err shows it has aged out of
+// the oplog, so that the next attempt starts again from now. The changes in
+// between are lost to this instance.
+func (f MongoChangeFeed) watchError(ctx context.Context, err error) error {
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		for _, code := range mongoResumeErrorCodes {
+			if !serverErr.HasErrorCode(code) {
+				continue
+			}
+
+			_, delErr := f.DB.Collection("feed_positions").DeleteOne(ctx, bson.M{"_id": f.Name})
+			if delErr != nil {
+				return queryError(ctx, delErr)
+			}
+			break
+		}
+	}
+	return queryError(ctx, err)
+}
+
+func (f MongoChangeFeed) poller() changePoller {
+	projects := f.DB.Collection("projects")
+	tasks := f.DB.Collection("tasks")
+
+	find := func(ctx context.Context, filter bson.M, limit int) ([]Change, error) {
+		ctx, cancel := withTimeout(ctx, f.Timeout)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+		if limit > 0 {
+			opts.SetLimit(int64(limit))
+		}
+
+		cursor, err := projects.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		changedProjects := []*Project{}
+		if err := cursor.All(ctx, &changedProjects); err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		cursor, err = tasks.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		changedTasks := []*Task{}
+		if err := cursor.All(ctx, &changedTasks); err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		return mergeChanges(changedProjects, changedTasks, limit), nil
+	}
+
+	return changePoller{
+		interval: f.PollInterval,
+		latest: func(ctx context.Context) (int64, error) {
+			ctx, cancel := withTimeout(ctx, f.Timeout)
+			defer cancel()
+
+			var counter struct {
+				Value int64 `bson:"value"`
+			}
+			err := f.DB.Collection("counters").FindOne(ctx, bson.M{"_id": changeSeqName}).Decode(&counter)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return 0, queryError(ctx, err)
+			}
+			return counter.Value, nil
+		},
+		fetch: func(ctx context.Context, since int64, limit int) ([]Change, error) {
+			return find(ctx, bson.M{"seq": bson.M{"$gt": since}}, limit)
+		},
+		fetchSeqs: func(ctx context.Context, seqs []int64) ([]Change, error) {
+			return find(ctx, bson.M{"seq": bson.M{"$in": seqs}}, 0)
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SQLChangeFeed polls the projects and tasks tables every PollInterval.
+type SQLChangeFeed struct {
+	DB           *sql.DB
+	Dialect      Dialect
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+func (f SQLChangeFeed) Run(ctx context.Context, fn func(Change)) error {
+	p := changePoller{
+		interval: f.PollInterval,
+		latest:   f.latest,
+		fetch: func(ctx context.Context, since int64, limit int) ([]Change, error) {
+			return f.find(ctx, "seq > ?", []interface{}{since}, limit)
+		},
+		fetchSeqs: func(ctx context.Context, seqs []int64) ([]Change, error) {
+			args := make([]interface{}, len(seqs))
+			for i, seq := range seqs {
+				args[i] = seq
+			}
+			return f.find(ctx, "seq IN ("+placeholders(len(seqs))+")", args, 0)
+		},
+	}
+	return p.run(ctx, fn)
+}
+
+func (f SQLChangeFeed) latest(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT value
+		FROM counters
+		WHERE name = ?`
+
+	var value int64
+	err := f.DB.QueryRowContext(ctx, f.Dialect.rebind(query), changeSeqName).Scan(&value)
+	return value, queryError(ctx, err)
+}
+
+func (f SQLChangeFeed) find(ctx context.Context, where string, args []interface{}, limit int) ([]Change, error) {
+	ctx, cancel := withTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	suffix := ` ORDER BY seq`
+	if limit > 0 {
+		suffix += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	projects := []*Project{}
+	err := f.query(ctx, `SELECT `+projectColumns+` FROM projects WHERE `+where+suffix, args, func(row rowScanner) error {
+		project, err := scanProject(row)
+		if err == nil {
+			projects = append(projects, project)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	tasks := []*Task{}
+	err = f.query(ctx, `SELECT `+taskColumns+` FROM tasks WHERE `+where+suffix, args, func(row rowScanner) error {
+		task, err := scanTask(row)
+		if err == nil {
+			tasks = append(tasks, task)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return mergeChanges(projects, tasks, limit), nil
+}
+
+func (f SQLChangeFeed) query(ctx context.Context, query string, args []interface{}, scan func(rowScanner) error) error {
+	rows, err := f.DB.QueryContext(ctx, f.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
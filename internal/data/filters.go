@@ -0,0 +1,146 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filters narrows down a listing of tasks. ProjectIDs scopes the listing to
+// the projects the caller can see and matches nothing when empty; every
+// other field matches anything when left empty. AssigneeIDs matches tasks
+// assigned to any of the users, and LabelIDs tasks carrying any of the
+// labels. HasDue, when set, matches tasks with or without a due date.
+type Filters struct {
+	ProjectIDs        []primitive.ObjectID
+	Statuses          []string
+	Priorities        []int32
+	ExcludeStatuses   []string
+	ExcludePriorities []int32
+	AssigneeIDs       []primitive.ObjectID
+	LabelIDs          []primitive.ObjectID
+	DueAfter          *time.Time
+	DueBefore         *time.Time
+	HasDue            *bool
+}
+
+func (f Filters) mongoFilter() bson.M {
+	filter := bson.M{
+		"project_id": bson.M{"$in": f.ProjectIDs},
+		"deleted":    false,
+	}
+	status := bson.M{}
+	if len(f.Statuses) > 0 {
+		status["$in"] = f.Statuses
+	}
+	if len(f.ExcludeStatuses) > 0 {
+		status["$nin"] = f.ExcludeStatuses
+	}
+	if len(status) > 0 {
+		filter["status"] = status
+	}
+
+	priority := bson.M{}
+	if len(f.Priorities) > 0 {
+		priority["$in"] = f.Priorities
+	}
+	if len(f.ExcludePriorities) > 0 {
+		priority["$nin"] = f.ExcludePriorities
+	}
+	if len(priority) > 0 {
+		filter["priority"] = priority
+	}
+
+	if len(f.AssigneeIDs) > 0 {
+		filter["assignee_ids"] = bson.M{"$in": f.AssigneeIDs}
+	}
+	if len(f.LabelIDs) > 0 {
+		filter["label_ids"] = bson.M{"$in": f.LabelIDs}
+	}
+
+	due := bson.M{}
+	if f.HasDue != nil && *f.HasDue {
+		due["$ne"] = nil
+	}
+	if f.HasDue != nil && !*f.HasDue {
+		due["$eq"] = nil
+	}
+	if f.DueAfter != nil {
+		due["$gte"] = f.DueAfter.UTC()
+	}
+	if f.DueBefore != nil {
+		due["$lt"] = f.DueBefore.UTC()
+	}
+	if len(due) > 0 {
+		filter["due_at"] = due
+	}
+	return filter
+}
+
+// sqlWhere returns the conditions for the filters on the tasks table, to be
+// joined with AND, along with their arguments.
+func (f Filters) sqlWhere() (string, []interface{}) {
+	where := `project_id IN (` + placeholders(len(f.ProjectIDs)) + `) AND deleted = FALSE`
+	args := hexIDs(f.ProjectIDs)
+
+	if len(f.Statuses) > 0 {
+		where += ` AND status IN (` + placeholders(len(f.Statuses)) + `)`
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(f.Priorities) > 0 {
+		where += ` AND priority IN (` + placeholders(len(f.Priorities)) + `)`
+		for _, priority := range f.Priorities {
+			args = append(args, priority)
+		}
+	}
+	if len(f.ExcludeStatuses) > 0 {
+		where += ` AND status NOT IN (` + placeholders(len(f.ExcludeStatuses)) + `)`
+		for _, status := range f.ExcludeStatuses {
+			args = append(args, status)
+		}
+	}
+	if len(f.ExcludePriorities) > 0 {
+		where += ` AND priority NOT IN (` + placeholders(len(f.ExcludePriorities)) + `)`
+		for _, priority := range f.ExcludePriorities {
+			args = append(args, priority)
+		}
+	}
+	if len(f.AssigneeIDs) > 0 {
+		// assignee_ids holds a JSON array of hex ids, which can't be
+		// mistaken for one another, so matching the quoted id is enough.
+		conditions := make([]string, len(f.AssigneeIDs))
+		for i, id := range f.AssigneeIDs {
+			conditions[i] = `assignee_ids LIKE ?`
+			args = append(args, `%"`+id.Hex()+`"%`)
+		}
+		where += ` AND (` + strings.Join(conditions, ` OR `) + `)`
+	}
+	if len(f.LabelIDs) > 0 {
+		// As with assignee_ids above.
+		conditions := make([]string, len(f.LabelIDs))
+		for i, id := range f.LabelIDs {
+			conditions[i] = `label_ids LIKE ?`
+			args = append(args, `%"`+id.Hex()+`"%`)
+		}
+		where += ` AND (` + strings.Join(conditions, ` OR `) + `)`
+	}
+	if f.HasDue != nil && *f.HasDue {
+		where += ` AND due_at IS NOT NULL`
+	}
+	if f.HasDue != nil && !*f.HasDue {
+		where += ` AND due_at IS NULL`
+	}
+	if f.DueAfter != nil {
+		where += ` AND due_at >= ?`
+		args = append(args, f.DueAfter.UTC())
+	}
+	if f.DueBefore != nil {
+		where += ` AND due_at < ?`
+		args = append(args, f.DueBefore.UTC())
+	}
+	return where, args
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IdempotencyKeyTTL is how long the response to a request sent with an
+// Idempotency-Key header is kept for replaying to retries.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// IdempotencyLockTTL bounds how long a key stays claimed by a request which
+// is still running. It only matters if the server dies part way through;
+// otherwise the key is completed or released when the request finishes. It
+// is kept well above the time the slowest handler, an attachment upload, may
+// take, and should a handler outlast it anyway, ClaimID stops it from
+// settling a claim which has since passed to a retry.
+const IdempotencyLockTTL = 10 * time.Minute
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key header. Scope keeps the keys of different clients apart,
+// and Fingerprint is a hash of the request, so that reusing a key for a
+// different request can be refused. Status is zero while the first request
+// with the key is still running. ClaimID is set afresh by every claim, so
+// that only the request holding the claim can complete or release it.
+type IdempotencyRecord struct {
+	Scope       string              `bson:"scope"`
+	Key         string              `bson:"key"`
+	ClaimID     string              `bson:"claim_id"`
+	Fingerprint []byte              `bson:"fingerprint"`
+	Status      int                 `bson:"status"`
+	Header      map[string][]string `bson:"header,omitempty"`
+	Body        []byte              `bson:"body,omitempty"`
+	Expiry      time.Time           `bson:"expiry"`
+}
+
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
+
+type IdempotencyModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+// Claim stores a new in-progress record for the key, returning
+// ErrDuplicateKey if an unexpired record already holds it.
+func (m IdempotencyModel) Claim(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	record.ClaimID = primitive.NewObjectID().Hex()
+	record.Status = 0
+	record.Expiry = now.Add(IdempotencyLockTTL)
+
+	// Expired records are only removed from time to time by the TTL index.
+	_, err := m.DB.DeleteOne(ctx, bson.M{"scope": record.Scope, "key": record.Key, "expiry": bson.M{"$lte": now}})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	_, err = m.DB.InsertOne(ctx, record)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateKey
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m IdempotencyModel) Get(ctx context.Context, scope, key string) (*IdempotencyRecord, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"scope": scope, "key": key, "expiry": bson.M{"$gt": time.Now().UTC()}}
+
+	var record IdempotencyRecord
+	err := m.DB.FindOne(ctx, filter).Decode(&record)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &record, nil
+}
+
+// Complete saves the response to a claimed record and keeps it for
+// IdempotencyKeyTTL. It returns ErrEditConflict if the record's claim has
+// expired and the key has been claimed again since.
+func (m IdempotencyModel) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	record.Expiry = time.Now().UTC().Add(IdempotencyKeyTTL)
+
+	update := bson.M{
+		"$set": bson.M{
+			"status": record.Status,
+			"header": record.Header,
+			"body":   record.Body,
+			"expiry": record.Expiry,
+		},
+	}
+
+	filter := bson.M{"scope": record.Scope, "key": record.Key, "claim_id": record.ClaimID, "status": 0}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// Release gives up a claimed key so that the request can be retried. It
+// does nothing if the record's claim has been completed, or has expired and
+// passed to another request.
+func (m IdempotencyModel) Release(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.DeleteOne(ctx, bson.M{"scope": record.Scope, "key": record.Key, "claim_id": record.ClaimID, "status": 0})
+	return queryError(ctx, err)
+}
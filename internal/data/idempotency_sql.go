@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLIdempotencyModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLIdempotencyModel) Claim(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	record.ClaimID = primitive.NewObjectID().Hex()
+	record.Status = 0
+	record.Expiry = now.Add(IdempotencyLockTTL)
+
+	// There is no TTL index to clear out expired records, so every claim
+	// sweeps them up.
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expiry <= ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), now)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	query = `
+		INSERT INTO idempotency_keys (scope, key, claim_id, fingerprint, status, header, body, expiry)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{record.Scope, record.Key, record.ClaimID, record.Fingerprint, record.Status, "{}", []byte{}, record.Expiry}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateKey
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLIdempotencyModel) Get(ctx context.Context, scope, key string) (*IdempotencyRecord, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT scope, key, claim_id, fingerprint, status, header, body, expiry
+		FROM idempotency_keys
+		WHERE scope = ? AND key = ? AND expiry > ?`
+
+	var record IdempotencyRecord
+	var header string
+
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), scope, key, time.Now().UTC()).Scan(
+		&record.Scope,
+		&record.Key,
+		&record.ClaimID,
+		&record.Fingerprint,
+		&record.Status,
+		&header,
+		&record.Body,
+		&record.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(header), &record.Header); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (m SQLIdempotencyModel) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	record.Expiry = time.Now().UTC().Add(IdempotencyKeyTTL)
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = ?, header = ?, body = ?, expiry = ?
+		WHERE scope = ? AND key = ? AND claim_id = ? AND status = 0`
+
+	// An empty response has a nil body, which would be stored as NULL.
+	body := record.Body
+	if body == nil {
+		body = []byte{}
+	}
+
+	args := []interface{}{record.Status, string(header), body, record.Expiry, record.Scope, record.Key, record.ClaimID}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func (m SQLIdempotencyModel) Release(ctx context.Context, record *IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE scope = ? AND key = ? AND claim_id = ? AND status = 0`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), record.Scope, record.Key, record.ClaimID)
+	return queryError(ctx, err)
+}
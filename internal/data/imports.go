@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobSucceeded = "succeeded"
+	ImportJobFailed    = "failed"
+)
+
+// ImportJobTTL is how long a finished or abandoned import job is kept for
+// its status to be looked up.
+const ImportJobTTL = 7 * 24 * time.Hour
+
+// ImportJobStaleAfter is how long a job can go without saving its progress
+// before it is taken to have been abandoned, by a restart say. Jobs save
+// their progress far more often than this.
+const ImportJobStaleAfter = 10 * time.Minute
+
+// ImportJob tracks a task import running in the background. Total is the
+// number of tasks to create and Processed how many have been so far. Error
+// explains why a failed job stopped; the tasks it created before then are
+// kept.
+type ImportJob struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Status    string             `json:"status" bson:"status"`
+	Total     int                `json:"total" bson:"total"`
+	Processed int                `json:"processed" bson:"processed"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	Expiry    time.Time          `json:"-" bson:"expiry"`
+	Version   int32              `json:"version" bson:"version"`
+}
+
+// Stale reports whether the job is unfinished but hasn't saved its progress
+// for ImportJobStaleAfter.
+func (j *ImportJob) Stale(now time.Time) bool {
+	unfinished := j.Status == ImportJobPending || j.Status == ImportJobRunning
+	return unfinished && now.Sub(j.UpdatedAt) > ImportJobStaleAfter
+}
+
+type ImportJobModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+// Insert stores a new pending job, which expires after ImportJobTTL.
+func (m ImportJobModel) Insert(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	job.ID = primitive.NewObjectID()
+	job.Status = ImportJobPending
+	job.CreatedAt = time.Now().UTC()
+	job.UpdatedAt = job.CreatedAt
+	job.Expiry = job.CreatedAt.Add(ImportJobTTL)
+	job.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, job)
+	return queryError(ctx, err)
+}
+
+func (m ImportJobModel) Get(ctx context.Context, id primitive.ObjectID) (*ImportJob, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "expiry": bson.M{"$gt": time.Now().UTC()}}
+
+	var job ImportJob
+	err := m.DB.FindOne(ctx, filter).Decode(&job)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &job, nil
+}
+
+// Update saves the job's progress if it is still at job.Version, returning
+// ErrEditConflict otherwise.
+func (m ImportJobModel) Update(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	updatedAt := time.Now().UTC()
+
+	filter := bson.M{"_id": job.ID, "version": job.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     job.Status,
+			"processed":  job.Processed,
+			"error":      job.Error,
+			"updated_at": updatedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	job.UpdatedAt = updatedAt
+	job.Version++
+	return nil
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLImportJobModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const importJobColumns = `id, user_id, status, total, processed, error, created_at, updated_at, expiry, version`
+
+func (m SQLImportJobModel) Insert(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	job.ID = primitive.NewObjectID()
+	job.Status = ImportJobPending
+	job.CreatedAt = time.Now().UTC()
+	job.UpdatedAt = job.CreatedAt
+	job.Expiry = job.CreatedAt.Add(ImportJobTTL)
+	job.Version = 1
+
+	// There is no TTL index to clear out expired jobs, so every new job
+	// sweeps them up.
+	query := `
+		DELETE FROM import_jobs
+		WHERE expiry <= ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), job.CreatedAt)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	query = `
+		INSERT INTO import_jobs (` + importJobColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		job.ID.Hex(), job.UserID.Hex(), job.Status, job.Total, job.Processed, job.Error,
+		job.CreatedAt, job.UpdatedAt, job.Expiry, job.Version,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLImportJobModel) Get(ctx context.Context, id primitive.ObjectID) (*ImportJob, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + importJobColumns + `
+		FROM import_jobs
+		WHERE id = ? AND expiry > ?`
+
+	var job ImportJob
+	var jobID, userID string
+
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex(), time.Now().UTC()).Scan(
+		&jobID,
+		&userID,
+		&job.Status,
+		&job.Total,
+		&job.Processed,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Expiry,
+		&job.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	job.ID, err = primitive.ObjectIDFromHex(jobID)
+	if err != nil {
+		return nil, err
+	}
+	job.UserID, err = primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (m SQLImportJobModel) Update(ctx context.Context, job *ImportJob) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	updatedAt := time.Now().UTC()
+
+	query := `
+		UPDATE import_jobs
+		SET status = ?, processed = ?, error = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{job.Status, job.Processed, job.Error, updatedAt, job.ID.Hex(), job.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	job.UpdatedAt = updatedAt
+	job.Version++
+	return nil
+}
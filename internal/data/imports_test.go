@@ -0,0 +1,31 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"tasksync/internal/data"
+)
+
+func TestImportJobStale(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		status  string
+		updated time.Duration
+		want    bool
+	}{
+		{data.ImportJobPending, time.Minute, false},
+		{data.ImportJobPending, time.Hour, true},
+		{data.ImportJobRunning, time.Minute, false},
+		{data.ImportJobRunning, data.ImportJobStaleAfter + time.Second, true},
+		{data.ImportJobSucceeded, time.Hour, false},
+		{data.ImportJobFailed, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		job := &data.ImportJob{Status: tt.status, UpdatedAt: now.Add(-tt.updated)}
+		if got := job.Stale(now); got != tt.want {
+			t.Errorf("%s job updated %v ago: got stale %t; want %t", tt.status, tt.updated, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+var ErrDuplicateKey = errors.New("duplicate idempotency key")
+
+// InboundKeyTTL is how long an idempotency key sent to an inbound hook is
+// remembered. A payload repeated within that time returns the task created
+// the first time instead of opening another.
+const InboundKeyTTL = 7 * 24 * time.Hour
+
+// InboundHook lets an external system without a user session create tasks
+// in a project by POSTing JSON to a secret URL. Only a hash of the token in
+// that URL is stored; PlainToken is set when the hook is created or its
+// token is rotated, and is the only time the client sees it.
+type InboundHook struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	ProjectID   primitive.ObjectID `json:"project_id" bson:"project_id"`
+	CreatedBy   primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	Name        string             `json:"name" bson:"name"`
+	Template    TaskTemplate       `json:"template" bson:"template"`
+	PlainToken  string             `json:"token,omitempty" bson:"-"`
+	HashedToken []byte             `json:"-" bson:"hashed_token"`
+	Version     int32              `json:"version" bson:"version"`
+}
+
+// SetToken gives the hook a new random token, replacing any it had.
+func (h *InboundHook) SetToken() error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	h.PlainToken = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(h.PlainToken))
+	h.HashedToken = hash[:]
+	return nil
+}
+
+func ValidateInboundHook(v *validator.Validator, hook *InboundHook) {
+	v.Check(!hook.ProjectID.IsZero(), "project_id", "must be provided")
+	v.Check(hook.Name != "", "name", "must be provided")
+	v.Check(len(hook.Name) <= 200, "name", "must not be more than 200 bytes long")
+	ValidateTaskTemplate(v, hook.Template)
+}
+
+func ValidateInboundToken(v *validator.Validator, token string) {
+	v.Check(token != "", "token", "must be provided")
+	v.Check(len(token) == 32, "token", "must be 32 bytes long")
+}
+
+// TaskTemplate maps an inbound payload to the fields of a new task. Each
+// field is text in which {{path}} placeholders are replaced with values
+// from the payload, a path being object keys and array indexes separated by
+// dots, such as {{alert.labels.severity}} or {{items.0.name}}. Missing
+// values become empty text. Empty fields leave the task's defaults alone.
+//
+// Priority must render to 0-3 or one of none, low, medium and high, and
+// DueAt to an RFC 3339 time. IdempotencyKey, when it renders to anything,
+// deduplicates payloads for InboundKeyTTL.
+type TaskTemplate struct {
+	Title          string `json:"title" bson:"title"`
+	Description    string `json:"description,omitempty" bson:"description,omitempty"`
+	Status         string `json:"status,omitempty" bson:"status,omitempty"`
+	Priority       string `json:"priority,omitempty" bson:"priority,omitempty"`
+	DueAt          string `json:"due_at,omitempty" bson:"due_at,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"`
+}
+
+func ValidateTaskTemplate(v *validator.Validator, t TaskTemplate) {
+	v.Check(t.Title != "", "template.title", "must be provided")
+
+	for key, field := range t.fields() {
+		v.Check(len(field) <= 2000, "template."+key, "must not be more than 2000 bytes long")
+		v.Check(validPlaceholders(field), "template."+key, "must only contain complete {{path}} placeholders")
+	}
+}
+
+func (t TaskTemplate) fields() map[string]string {
+	return map[string]string{
+		"title":           t.Title,
+		"description":     t.Description,
+		"status":          t.Status,
+		"priority":        t.Priority,
+		"due_at":          t.DueAt,
+		"idempotency_key": t.IdempotencyKey,
+	}
+}
+
+// Apply renders the template against payload onto task, recording any
+// field which doesn't render to a valid value in v, and returns the
+// rendered idempotency key. Rendered text is trimmed of surrounding space.
+func (t TaskTemplate) Apply(v *validator.Validator, task *Task, payload interface{}) string {
+	if s := renderTemplate(t.Title, payload); s != "" {
+		task.Title = s
+	}
+	if s := renderTemplate(t.Description, payload); s != "" {
+		task.Description = s
+	}
+	if s := renderTemplate(t.Status, payload); s != "" {
+		task.Status = s
+	}
+
+	if s := renderTemplate(t.Priority, payload); s != "" {
+		priority, ok := ParseTaskPriority(s)
+		v.Check(ok, "priority", "must be 0-3 or one of none, low, medium or high")
+		task.Priority = priority
+	}
+
+	if s := renderTemplate(t.DueAt, payload); s != "" {
+		dueAt, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			v.AddError("due_at", "must be an RFC 3339 time")
+		} else {
+			dueAt = dueAt.UTC()
+			task.DueAt = &dueAt
+		}
+	}
+
+	key := renderTemplate(t.IdempotencyKey, payload)
+	v.Check(len(key) <= 200, "idempotency_key", "must not be more than 200 bytes long")
+	return key
+}
+
+func validPlaceholders(text string) bool {
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			return !strings.Contains(text, "}}")
+		}
+		if strings.Contains(text[:start], "}}") {
+			return false
+		}
+
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			return false
+		}
+		if strings.TrimSpace(text[start+2:start+end]) == "" {
+			return false
+		}
+		text = text[start+end+2:]
+	}
+}
+
+func renderTemplate(text string, payload interface{}) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			b.WriteString(text)
+			break
+		}
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			b.WriteString(text)
+			break
+		}
+
+		b.WriteString(text[:start])
+		b.WriteString(formatPayloadValue(lookupPayload(payload, text[start+2:start+end])))
+		text = text[start+end+2:]
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func lookupPayload(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(strings.TrimSpace(path), ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			value = node[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func formatPayloadValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		js, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		return string(js)
+	}
+}
+
+type inboundKey struct {
+	HookID primitive.ObjectID `bson:"hook_id"`
+	Key    string             `bson:"key"`
+	TaskID primitive.ObjectID `bson:"task_id"`
+	Expiry time.Time          `bson:"expiry"`
+}
+
+type InboundHookModel struct {
+	DB      *mongo.Collection
+	Keys    *mongo.Collection
+	Timeout time.Duration
+}
+
+// Insert stores a new hook with a fresh token.
+func (m InboundHookModel) Insert(ctx context.Context, hook *InboundHook) error {
+	if err := hook.SetToken(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hook.ID = primitive.NewObjectID()
+	hook.CreatedAt = time.Now().UTC()
+	hook.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, hook)
+	return queryError(ctx, err)
+}
+
+func (m InboundHookModel) Get(ctx context.Context, id primitive.ObjectID) (*InboundHook, error) {
+	return m.findOne(ctx, bson.M{"_id": id})
+}
+
+func (m InboundHookModel) GetForToken(ctx context.Context, tokenPlaintext string) (*InboundHook, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	return m.findOne(ctx, bson.M{"hashed_token": tokenHash[:]})
+}
+
+func (m InboundHookModel) findOne(ctx context.Context, filter bson.M) (*InboundHook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var hook InboundHook
+	err := m.DB.FindOne(ctx, filter).Decode(&hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &hook, nil
+}
+
+func (m InboundHookModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*InboundHook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := m.DB.Find(ctx, bson.M{"project_id": projectID}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	hooks := []*InboundHook{}
+	err = cursor.All(ctx, &hooks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return hooks, nil
+}
+
+// Update saves the hook's name, template and token hash if it is still at
+// hook.Version, returning ErrEditConflict otherwise.
+func (m InboundHookModel) Update(ctx context.Context, hook *InboundHook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": hook.ID, "version": hook.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"name":         hook.Name,
+			"template":     hook.Template,
+			"hashed_token": hook.HashedToken,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	hook.Version++
+	return nil
+}
+
+// Delete removes the hook along with the idempotency keys it has seen.
+func (m InboundHookModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = m.Keys.DeleteMany(ctx, bson.M{"hook_id": id})
+	return queryError(ctx, err)
+}
+
+// ClaimKey records that the idempotency key sent to the hook created the
+// task, returning ErrDuplicateKey if the key was already claimed within
+// InboundKeyTTL.
+func (m InboundHookModel) ClaimKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	// Expired keys are only removed from time to time by the TTL index.
+	_, err := m.Keys.DeleteOne(ctx, bson.M{"hook_id": hookID, "key": key, "expiry": bson.M{"$lte": now}})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	_, err = m.Keys.InsertOne(ctx, inboundKey{
+		HookID: hookID,
+		Key:    key,
+		TaskID: taskID,
+		Expiry: now.Add(InboundKeyTTL),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateKey
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+// ReleaseKey undoes ClaimKey for a task which couldn't be created after all,
+// so that the payload can be sent again. Without a transaction to roll the
+// claim back, it would otherwise point at a task which doesn't exist. A key
+// claimed for another task is left alone.
+func (m InboundHookModel) ReleaseKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.Keys.DeleteOne(ctx, bson.M{"hook_id": hookID, "key": key, "task_id": taskID})
+	return queryError(ctx, err)
+}
+
+// TaskForKey returns the ID of the task created for an idempotency key.
+func (m InboundHookModel) TaskForKey(ctx context.Context, hookID primitive.ObjectID, key string) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"hook_id": hookID, "key": key, "expiry": bson.M{"$gt": time.Now().UTC()}}
+
+	var record inboundKey
+	err := m.Keys.FindOne(ctx, filter).Decode(&record)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return primitive.NilObjectID, ErrRecordNotFound
+		default:
+			return primitive.NilObjectID, queryError(ctx, err)
+		}
+	}
+	return record.TaskID, nil
+}
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLInboundHookModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const inboundHookColumns = `id, project_id, created_by, created_at, name, template, hashed_token, version`
+
+func (m SQLInboundHookModel) Insert(ctx context.Context, hook *InboundHook) error {
+	if err := hook.SetToken(); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hook.ID = primitive.NewObjectID()
+	hook.CreatedAt = time.Now().UTC()
+	hook.Version = 1
+
+	template, err := json.Marshal(hook.Template)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO inbound_hooks (` + inboundHookColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		hook.ID.Hex(), hook.ProjectID.Hex(), hook.CreatedBy.Hex(), hook.CreatedAt,
+		hook.Name, string(template), hook.HashedToken, hook.Version,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLInboundHookModel) Get(ctx context.Context, id primitive.ObjectID) (*InboundHook, error) {
+	return m.findOne(ctx, `id = ?`, id.Hex())
+}
+
+func (m SQLInboundHookModel) GetForToken(ctx context.Context, tokenPlaintext string) (*InboundHook, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	return m.findOne(ctx, `hashed_token = ?`, tokenHash[:])
+}
+
+func (m SQLInboundHookModel) findOne(ctx context.Context, where string, args ...interface{}) (*InboundHook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + inboundHookColumns + `
+		FROM inbound_hooks
+		WHERE ` + where
+
+	hook, err := scanInboundHook(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), args...))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return hook, nil
+}
+
+func (m SQLInboundHookModel) ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*InboundHook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + inboundHookColumns + `
+		FROM inbound_hooks
+		WHERE project_id = ?
+		ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), projectID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	hooks := []*InboundHook{}
+	for rows.Next() {
+		hook, err := scanInboundHook(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, queryError(ctx, rows.Err())
+}
+
+func (m SQLInboundHookModel) Update(ctx context.Context, hook *InboundHook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	template, err := json.Marshal(hook.Template)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE inbound_hooks
+		SET name = ?, template = ?, hashed_token = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{hook.Name, string(template), hook.HashedToken, hook.ID.Hex(), hook.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	hook.Version++
+	return nil
+}
+
+// Delete removes the hook. Its idempotency keys go with it through the
+// foreign key.
+func (m SQLInboundHookModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM inbound_hooks
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m SQLInboundHookModel) ClaimKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	query := `
+		DELETE FROM inbound_hook_keys
+		WHERE hook_id = ? AND expiry <= ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), hookID.Hex(), now)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	query = `
+		INSERT INTO inbound_hook_keys (hook_id, key, task_id, expiry)
+		VALUES (?, ?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), hookID.Hex(), key, taskID.Hex(), now.Add(InboundKeyTTL))
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateKey
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLInboundHookModel) ReleaseKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM inbound_hook_keys
+		WHERE hook_id = ? AND key = ? AND task_id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), hookID.Hex(), key, taskID.Hex())
+	return queryError(ctx, err)
+}
+
+func (m SQLInboundHookModel) TaskForKey(ctx context.Context, hookID primitive.ObjectID, key string) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT task_id
+		FROM inbound_hook_keys
+		WHERE hook_id = ? AND key = ? AND expiry > ?`
+
+	var taskID string
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), hookID.Hex(), key, time.Now().UTC()).Scan(&taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return primitive.NilObjectID, ErrRecordNotFound
+		default:
+			return primitive.NilObjectID, queryError(ctx, err)
+		}
+	}
+	return primitive.ObjectIDFromHex(taskID)
+}
+
+func scanInboundHook(row rowScanner) (*InboundHook, error) {
+	var hook InboundHook
+	var id, projectID, createdBy, template string
+
+	err := row.Scan(
+		&id,
+		&projectID,
+		&createdBy,
+		&hook.CreatedAt,
+		&hook.Name,
+		&template,
+		&hook.HashedToken,
+		&hook.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(template), &hook.Template); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		src string
+		dst *primitive.ObjectID
+	}{{id, &hook.ID}, {projectID, &hook.ProjectID}, {createdBy, &hook.CreatedBy}} {
+		*f.dst, err = primitive.ObjectIDFromHex(f.src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &hook, nil
+}
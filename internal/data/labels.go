@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// DefaultLabelColor is the colour of a label created without one.
+const DefaultLabelColor = "#6b7280"
+
+var LabelColorRX = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// MaxTaskLabels caps the number of labels a task can carry.
+const MaxTaskLabels = 20
+
+// Label is a tag which the members of a workspace can put on its tasks.
+// Names are unique within the workspace regardless of case, which NameKey,
+// kept up to date by the store, lets the database enforce. Tasks refer to
+// their labels by id, so renaming a label never touches them. TaskCount is
+// the number of live tasks carrying the label, filled in by CountTasks.
+type Label struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	Name        string             `json:"name" bson:"name"`
+	NameKey     string             `json:"-" bson:"name_key"`
+	Color       string             `json:"color" bson:"color"`
+	TaskCount   int64              `json:"task_count" bson:"-"`
+	Version     int32              `json:"version" bson:"version"`
+}
+
+// labelKey is the form of a label's name which must be unique within its
+// workspace.
+func labelKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// ValidateLabel checks the label, including that its name is not already
+// taken by one of the workspace's other labels. others may include the
+// label itself, which is skipped.
+func ValidateLabel(v *validator.Validator, label *Label, others []*Label) {
+	v.Check(label.Name != "", "name", "must be provided")
+	v.Check(len(label.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(strings.TrimSpace(label.Name) == label.Name, "name", "must not start or end with spaces")
+	v.Check(validator.Matches(label.Color, LabelColorRX), "color", "must be a hex colour such as #ff8800")
+
+	keys := []string{labelKey(label.Name)}
+	for _, other := range others {
+		if other.ID != label.ID {
+			keys = append(keys, labelKey(other.Name))
+		}
+	}
+	v.Check(validator.Unique(keys), "name", "is already used by another label in this workspace")
+}
+
+// replaceLabelID returns ids with from swapped for to, or left out when to
+// is the zero id or is already among them.
+func replaceLabelID(ids []primitive.ObjectID, from, to primitive.ObjectID) []primitive.ObjectID {
+	var replaced []primitive.ObjectID
+	for _, id := range ids {
+		if id != from {
+			replaced = append(replaced, id)
+		}
+	}
+	for _, id := range replaced {
+		if id == to {
+			return replaced
+		}
+	}
+	if !to.IsZero() {
+		replaced = append(replaced, to)
+	}
+	return replaced
+}
+
+type LabelModel struct {
+	DB      *mongo.Collection
+	Tasks   *mongo.Collection
+	Timeout time.Duration
+}
+
+// Insert stores a new label, returning ErrDuplicateName if the workspace
+// already has a label by that name.
+func (m LabelModel) Insert(ctx context.Context, label *Label) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	label.ID = primitive.NewObjectID()
+	label.CreatedAt = time.Now().UTC()
+	label.UpdatedAt = label.CreatedAt
+	label.NameKey = labelKey(label.Name)
+	label.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, label)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m LabelModel) Get(ctx context.Context, id primitive.ObjectID) (*Label, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var label Label
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&label)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &label, nil
+}
+
+// ForWorkspace returns the workspace's labels in order of name.
+func (m LabelModel) ForWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]*Label, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name_key", Value: 1}})
+	cursor, err := m.DB.Find(ctx, bson.M{"workspace_id": workspaceID}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	labels := []*Label{}
+	err = cursor.All(ctx, &labels)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return labels, nil
+}
+
+// Update saves the label's name and colour if it is still at
+// label.Version, returning ErrEditConflict otherwise, or ErrDuplicateName if
+// another of the workspace's labels already has the new name.
+func (m LabelModel) Update(ctx context.Context, label *Label) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	label.UpdatedAt = time.Now().UTC()
+	label.NameKey = labelKey(label.Name)
+
+	filter := bson.M{"_id": label.ID, "version": label.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"updated_at": label.UpdatedAt,
+			"name":       label.Name,
+			"name_key":   label.NameKey,
+			"color":      label.Color,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	label.Version++
+	return nil
+}
+
+// Delete removes the label itself. Callers take it off its tasks first with
+// TaskStore.ReplaceLabel.
+func (m LabelModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountTasks sets the TaskCount of each of the labels.
+func (m LabelModel) CountTasks(ctx context.Context, labels []*Label) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	ids := make([]primitive.ObjectID, len(labels))
+	for i, label := range labels {
+		ids[i] = label.ID
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"label_ids": bson.M{"$in": ids}, "deleted": false}}},
+		{{Key: "$unwind", Value: "$label_ids"}},
+		{{Key: "$match", Value: bson.M{"label_ids": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$label_ids", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := m.Tasks.Aggregate(ctx, pipeline)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	var counts []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Count int64              `bson:"count"`
+	}
+	err = cursor.All(ctx, &counts)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	byID := make(map[primitive.ObjectID]int64, len(counts))
+	for _, c := range counts {
+		byID[c.ID] = c.Count
+	}
+	for _, label := range labels {
+		label.TaskCount = byID[label.ID]
+	}
+	return nil
+}
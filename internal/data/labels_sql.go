@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLLabelModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const labelColumns = `id, workspace_id, created_at, updated_at, name, name_key, color, version`
+
+func (m SQLLabelModel) Insert(ctx context.Context, label *Label) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	label.ID = primitive.NewObjectID()
+	label.CreatedAt = time.Now().UTC()
+	label.UpdatedAt = label.CreatedAt
+	label.NameKey = labelKey(label.Name)
+	label.Version = 1
+
+	query := `
+		INSERT INTO labels (` + labelColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		label.ID.Hex(), label.WorkspaceID.Hex(), label.CreatedAt, label.UpdatedAt,
+		label.Name, label.NameKey, label.Color, label.Version,
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLLabelModel) Get(ctx context.Context, id primitive.ObjectID) (*Label, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + labelColumns + `
+		FROM labels
+		WHERE id = ?`
+
+	label, err := scanLabel(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return label, nil
+}
+
+func (m SQLLabelModel) ForWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]*Label, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + labelColumns + `
+		FROM labels
+		WHERE workspace_id = ?
+		ORDER BY name_key`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), workspaceID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	labels := []*Label{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, queryError(ctx, rows.Err())
+}
+
+func (m SQLLabelModel) Update(ctx context.Context, label *Label) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	label.UpdatedAt = time.Now().UTC()
+	label.NameKey = labelKey(label.Name)
+
+	query := `
+		UPDATE labels
+		SET updated_at = ?, name = ?, name_key = ?, color = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{label.UpdatedAt, label.Name, label.NameKey, label.Color, label.ID.Hex(), label.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	label.Version++
+	return nil
+}
+
+func (m SQLLabelModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM labels
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountTasks matches each label's quoted id against label_ids, as the task
+// filters do.
+func (m SQLLabelModel) CountTasks(ctx context.Context, labels []*Label) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM tasks
+		WHERE label_ids LIKE ? AND deleted = FALSE`
+
+	for _, label := range labels {
+		err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), `%"`+label.ID.Hex()+`"%`).Scan(&label.TaskCount)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+	}
+	return nil
+}
+
+func scanLabel(row rowScanner) (*Label, error) {
+	var label Label
+	var id, workspaceID string
+
+	err := row.Scan(
+		&id,
+		&workspaceID,
+		&label.CreatedAt,
+		&label.UpdatedAt,
+		&label.Name,
+		&label.NameKey,
+		&label.Color,
+		&label.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	label.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	label.WorkspaceID, err = primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return &label, nil
+}
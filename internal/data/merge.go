@@ -0,0 +1,135 @@
+package data
+
+import (
+	"time"
+
+	"tasksync/internal/validator"
+)
+
+// Policies for settling a field which was changed both on the server and
+// by an offline client since the client's base version.
+const (
+	MergePolicyLastWriterWins = "last_writer_wins"
+	MergePolicyServerWins     = "server_wins"
+	MergePolicyManual         = "manual"
+)
+
+var MergePolicies = []string{MergePolicyLastWriterWins, MergePolicyServerWins, MergePolicyManual}
+
+func ValidateMergePolicy(v *validator.Validator, policy string) {
+	v.Check(validator.In(policy, MergePolicies...), "policy", "must be one of last_writer_wins, server_wins or manual")
+}
+
+// FieldConflict describes a field changed on both sides. Winner is "server"
+// or "client" once the conflict has been settled by a policy, and empty if
+// it is left for the client to resolve.
+type FieldConflict struct {
+	Field  string      `json:"field"`
+	Base   interface{} `json:"base"`
+	Server interface{} `json:"server"`
+	Client interface{} `json:"client"`
+	Winner string      `json:"winner,omitempty"`
+}
+
+type taskField struct {
+	name string
+	get  func(t *Task) interface{}
+	set  func(dst, src *Task)
+}
+
+// taskMergeFields lists the user editable task fields which take part in a
+// merge. Everything else is owned by the server.
+var taskMergeFields = []taskField{
+	{"project_id", func(t *Task) interface{} { return t.ProjectID }, func(dst, src *Task) { dst.ProjectID = src.ProjectID }},
+	{"title", func(t *Task) interface{} { return t.Title }, func(dst, src *Task) { dst.Title = src.Title }},
+	{"description", func(t *Task) interface{} { return t.Description }, func(dst, src *Task) { dst.Description = src.Description }},
+	{"status", func(t *Task) interface{} { return t.Status }, func(dst, src *Task) { dst.Status = src.Status }},
+	{"priority", func(t *Task) interface{} { return t.Priority }, func(dst, src *Task) { dst.Priority = src.Priority }},
+	{"due_at", func(t *Task) interface{} { return mergeTime(t.DueAt) }, func(dst, src *Task) { dst.DueAt = src.DueAt }},
+	{"recurrence", func(t *Task) interface{} { return t.Recurrence }, func(dst, src *Task) { dst.Recurrence = src.Recurrence }},
+}
+
+// mergeTime normalises a timestamp to the millisecond precision every
+// backend can store, so a round trip through the database doesn't look
+// like an edit.
+func mergeTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// TaskFieldsEqual reports whether two copies of a task agree on every user
+// editable field.
+func TaskFieldsEqual(a, b *Task) bool {
+	for _, f := range taskMergeFields {
+		if f.get(a) != f.get(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// TaskFieldTimes works out when each field of the task was last changed,
+// given the revisions after a base revision in version order.
+func TaskFieldTimes(base *Task, revisions []*Task) map[string]time.Time {
+	times := make(map[string]time.Time)
+
+	prev := base
+	for _, rev := range revisions {
+		for _, f := range taskMergeFields {
+			if f.get(rev) != f.get(prev) {
+				times[f.name] = rev.UpdatedAt
+			}
+		}
+		prev = rev
+	}
+	return times
+}
+
+// MergeTask performs a field level three-way merge of an offline client's
+// copy of a task against the current server copy, using the revision both
+// started from as the base. Fields changed on only one side are taken from
+// that side. Fields changed on both sides to different values are settled by
+// the policy: server_wins keeps the server value, last_writer_wins compares
+// clientChangedAt with the time the server field last changed, and manual
+// leaves them unresolved.
+//
+// The merged task carries the server's version, ready to be saved with an
+// optimistic update. ok is false if any conflict was left unresolved.
+func MergeTask(base, server, client *Task, clientChangedAt time.Time, serverFieldTimes map[string]time.Time, policy string) (merged *Task, conflicts []FieldConflict, ok bool) {
+	m := *server
+	merged = &m
+	ok = true
+
+	for _, f := range taskMergeFields {
+		baseValue, serverValue, clientValue := f.get(base), f.get(server), f.get(client)
+
+		switch {
+		case clientValue == baseValue, clientValue == serverValue:
+			continue
+		case serverValue == baseValue:
+			f.set(merged, client)
+			continue
+		}
+
+		conflict := FieldConflict{Field: f.name, Base: baseValue, Server: serverValue, Client: clientValue}
+
+		switch policy {
+		case MergePolicyServerWins:
+			conflict.Winner = "server"
+		case MergePolicyLastWriterWins:
+			conflict.Winner = "server"
+			if clientChangedAt.After(serverFieldTimes[f.name]) {
+				conflict.Winner = "client"
+				f.set(merged, client)
+			}
+		default:
+			ok = false
+		}
+
+		conflicts = append(conflicts, conflict)
+	}
+
+	return merged, conflicts, ok
+}
@@ -0,0 +1,197 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+)
+
+var mergeBaseTime = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+func mergeBase() *data.Task {
+	due := mergeBaseTime.Add(24 * time.Hour)
+	return &data.Task{
+		ID:        primitive.NewObjectID(),
+		Title:     "Base",
+		Status:    data.TaskStatusTodo,
+		Priority:  data.TaskPriorityLow,
+		DueAt:     &due,
+		UpdatedAt: mergeBaseTime,
+		Version:   1,
+	}
+}
+
+// edit returns a copy of the task with the change applied.
+func edit(task *data.Task, change func(t *data.Task)) *data.Task {
+	t := *task
+	if change != nil {
+		change(&t)
+	}
+	return &t
+}
+
+func TestMergeTask(t *testing.T) {
+	retitle := func(title string) func(t *data.Task) {
+		return func(t *data.Task) { t.Title = title }
+	}
+
+	tests := []struct {
+		name   string
+		server func(t *data.Task)
+		client func(t *data.Task)
+		// clientLater is whether the client's change came after the
+		// server's, for last_writer_wins.
+		clientLater bool
+		// want is the merged title for each policy, and winner the winner
+		// of the title conflict, if any, with "" meaning unresolved.
+		want     map[string]string
+		conflict bool
+		winner   map[string]string
+	}{
+		{
+			name: "neither changed",
+			want: map[string]string{data.MergePolicyServerWins: "Base", data.MergePolicyLastWriterWins: "Base", data.MergePolicyManual: "Base"},
+		},
+		{
+			name:   "client changed",
+			client: retitle("Client"),
+			want:   map[string]string{data.MergePolicyServerWins: "Client", data.MergePolicyLastWriterWins: "Client", data.MergePolicyManual: "Client"},
+		},
+		{
+			name:   "server changed",
+			server: retitle("Server"),
+			want:   map[string]string{data.MergePolicyServerWins: "Server", data.MergePolicyLastWriterWins: "Server", data.MergePolicyManual: "Server"},
+		},
+		{
+			name:   "both changed alike",
+			server: retitle("Same"),
+			client: retitle("Same"),
+			want:   map[string]string{data.MergePolicyServerWins: "Same", data.MergePolicyLastWriterWins: "Same", data.MergePolicyManual: "Same"},
+		},
+		{
+			name:        "both changed, client later",
+			server:      retitle("Server"),
+			client:      retitle("Client"),
+			clientLater: true,
+			want:        map[string]string{data.MergePolicyServerWins: "Server", data.MergePolicyLastWriterWins: "Client", data.MergePolicyManual: "Server"},
+			conflict:    true,
+			winner:      map[string]string{data.MergePolicyServerWins: "server", data.MergePolicyLastWriterWins: "client", data.MergePolicyManual: ""},
+		},
+		{
+			name:     "both changed, server later",
+			server:   retitle("Server"),
+			client:   retitle("Client"),
+			want:     map[string]string{data.MergePolicyServerWins: "Server", data.MergePolicyLastWriterWins: "Server", data.MergePolicyManual: "Server"},
+			conflict: true,
+			winner:   map[string]string{data.MergePolicyServerWins: "server", data.MergePolicyLastWriterWins: "server", data.MergePolicyManual: ""},
+		},
+		{
+			name:   "different fields changed",
+			server: func(t *data.Task) { t.Status = data.TaskStatusDone },
+			client: retitle("Client"),
+			want:   map[string]string{data.MergePolicyServerWins: "Client", data.MergePolicyLastWriterWins: "Client", data.MergePolicyManual: "Client"},
+		},
+		{
+			name: "due date changed below storage precision",
+			client: func(t *data.Task) {
+				due := t.DueAt.Add(time.Microsecond)
+				t.DueAt = &due
+			},
+			want: map[string]string{data.MergePolicyServerWins: "Base", data.MergePolicyLastWriterWins: "Base", data.MergePolicyManual: "Base"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, policy := range data.MergePolicies {
+			t.Run(tt.name+"/"+policy, func(t *testing.T) {
+				base := mergeBase()
+				server := edit(base, tt.server)
+				server.Version = 3
+				client := edit(base, tt.client)
+
+				serverChangedAt := mergeBaseTime.Add(time.Hour)
+				clientChangedAt := mergeBaseTime.Add(30 * time.Minute)
+				if tt.clientLater {
+					clientChangedAt = mergeBaseTime.Add(2 * time.Hour)
+				}
+				fieldTimes := map[string]time.Time{"title": serverChangedAt, "status": serverChangedAt}
+
+				merged, conflicts, ok := data.MergeTask(base, server, client, clientChangedAt, fieldTimes, policy)
+
+				if merged.Title != tt.want[policy] {
+					t.Errorf("got title %q; want %q", merged.Title, tt.want[policy])
+				}
+				if merged.Status != server.Status {
+					t.Errorf("got status %q; want the server's %q", merged.Status, server.Status)
+				}
+				if merged.DueAt != server.DueAt {
+					t.Errorf("got due at %v; want the server's %v", merged.DueAt, server.DueAt)
+				}
+				if merged.Version != server.Version {
+					t.Errorf("got version %d; want the server's %d", merged.Version, server.Version)
+				}
+
+				wantOK := !tt.conflict || policy != data.MergePolicyManual
+				if ok != wantOK {
+					t.Errorf("got ok %t; want %t", ok, wantOK)
+				}
+
+				if !tt.conflict {
+					if len(conflicts) != 0 {
+						t.Errorf("got conflicts %+v; want none", conflicts)
+					}
+					return
+				}
+				if len(conflicts) != 1 {
+					t.Fatalf("got conflicts %+v; want one on the title", conflicts)
+				}
+				want := data.FieldConflict{Field: "title", Base: "Base", Server: "Server", Client: "Client", Winner: tt.winner[policy]}
+				if conflicts[0] != want {
+					t.Errorf("got conflict %+v; want %+v", conflicts[0], want)
+				}
+			})
+		}
+	}
+}
+
+func TestTaskFieldTimes(t *testing.T) {
+	base := mergeBase()
+	at := func(minutes int) time.Time { return mergeBaseTime.Add(time.Duration(minutes) * time.Minute) }
+
+	first := edit(base, func(t *data.Task) { t.Title = "First" })
+	first.UpdatedAt = at(10)
+	second := edit(first, func(t *data.Task) { t.Status = data.TaskStatusInProgress })
+	second.UpdatedAt = at(20)
+	third := edit(second, func(t *data.Task) { t.Title = "Third"; t.DueAt = nil })
+	third.UpdatedAt = at(30)
+	// A revision which changes nothing a merge looks at.
+	fourth := edit(third, func(t *data.Task) { t.Rank = "i00001" })
+	fourth.UpdatedAt = at(40)
+
+	tests := []struct {
+		name      string
+		revisions []*data.Task
+		want      map[string]time.Time
+	}{
+		{"no revisions", nil, map[string]time.Time{}},
+		{"one revision", []*data.Task{first}, map[string]time.Time{"title": at(10)}},
+		{"later changes win", []*data.Task{first, second, third}, map[string]time.Time{"title": at(30), "status": at(20), "due_at": at(30)}},
+		{"other fields ignored", []*data.Task{first, second, third, fourth}, map[string]time.Time{"title": at(30), "status": at(20), "due_at": at(30)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := data.TaskFieldTimes(base, tt.revisions)
+			if len(got) != len(tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+			for field, want := range tt.want {
+				if !got[field].Equal(want) {
+					t.Errorf("got %s changed at %v; want %v", field, got[field], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed "migrations"
+var migrationsFS embed.FS
+
+var ErrNoMigrations = errors.New("no migrations have been applied")
+
+type MigrationStatus struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator applies and reverts the schema changes for a storage backend.
+// Up applies every pending migration, Down reverts the most recently
+// applied one and Status reports every known migration in version order.
+type Migrator interface {
+	Up(ctx context.Context) error
+	Down(ctx context.Context) error
+	Status(ctx context.Context) ([]MigrationStatus, error)
+}
+
+type sqlMigration struct {
+	version  int64
+	name     string
+	up       string
+	down     string
+	backfill sqlBackfill
+}
+
+// sqlBackfill fills in data which a migration's SQL can't compute. It runs
+// after the up script, in the same transaction.
+type sqlBackfill func(ctx context.Context, tx *sql.Tx, dialect Dialect) error
+
+// sqlBackfills are the backfills for each migration which needs one, by
+// version.
+var sqlBackfills = map[int64]sqlBackfill{
+	25: backfillSearchWords,
+}
+
+type SQLMigrator struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+func (m SQLMigrator) Up(ctx context.Context) error {
+	migrations, applied, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mg := range migrations {
+		if _, ok := applied[mg.version]; ok {
+			continue
+		}
+
+		err := m.run(ctx, mg.up, func(tx *sql.Tx) error {
+			if mg.backfill != nil {
+				if err := mg.backfill(ctx, tx, m.Dialect); err != nil {
+					return err
+				}
+			}
+
+			query := `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`
+			_, err := tx.ExecContext(ctx, m.Dialect.rebind(query), mg.version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", mg.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m SQLMigrator) Down(ctx context.Context) error {
+	migrations, applied, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mg := migrations[i]
+		if _, ok := applied[mg.version]; !ok {
+			continue
+		}
+
+		err := m.run(ctx, mg.down, func(tx *sql.Tx) error {
+			query := `DELETE FROM schema_migrations WHERE version = ?`
+			_, err := tx.ExecContext(ctx, m.Dialect.rebind(query), mg.version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", mg.name, err)
+		}
+		return nil
+	}
+
+	return ErrNoMigrations
+}
+
+func (m SQLMigrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, applied, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, mg := range migrations {
+		appliedAt, ok := applied[mg.version]
+		status[i] = MigrationStatus{
+			Version:   mg.version,
+			Name:      mg.name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		}
+	}
+	return status, nil
+}
+
+func (m SQLMigrator) run(ctx context.Context, script string, record func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(script) != "" {
+		_, err = tx.ExecContext(ctx, script)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// load reads the embedded migrations for the dialect, creating the
+// schema_migrations bookkeeping table if needed, and returns them alongside
+// the versions which have already been applied.
+func (m SQLMigrator) load(ctx context.Context) ([]sqlMigration, map[int64]time.Time, error) {
+	migrations, err := loadSQLMigrations(m.Dialect)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return migrations, applied, nil
+}
+
+func loadSQLMigrations(dialect Dialect) ([]sqlMigration, error) {
+	dir := "migrations/" + dialect.String()
+
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []sqlMigration
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".up.sql")
+		if !ok {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		up, err := fs.ReadFile(migrationsFS, dir+"/"+name+".up.sql")
+		if err != nil {
+			return nil, err
+		}
+		down, err := fs.ReadFile(migrationsFS, dir+"/"+name+".down.sql")
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, sqlMigration{
+			version:  version,
+			name:     name,
+			up:       string(up),
+			down:     string(down),
+			backfill: sqlBackfills[version],
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    email text NOT NULL UNIQUE,
+    password bytea NOT NULL,
+    activated bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1
+);
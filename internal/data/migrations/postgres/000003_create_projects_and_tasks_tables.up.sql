@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS counters (
+    name text PRIMARY KEY,
+    value bigint NOT NULL
+);
+
+INSERT INTO counters (name, value) VALUES ('changes', 0);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id text PRIMARY KEY,
+    owner_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    seq bigint NOT NULL,
+    deleted bool NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS projects_owner_id_seq_idx ON projects (owner_id, seq);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id text PRIMARY KEY,
+    project_id text NOT NULL REFERENCES projects ON DELETE CASCADE,
+    created_by text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    status text NOT NULL,
+    priority integer NOT NULL DEFAULT 0,
+    due_at timestamp with time zone,
+    version integer NOT NULL DEFAULT 1,
+    seq bigint NOT NULL,
+    deleted bool NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS tasks_project_id_seq_idx ON tasks (project_id, seq);
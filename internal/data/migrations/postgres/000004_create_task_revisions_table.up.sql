@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS task_revisions (
+    task_id text NOT NULL REFERENCES tasks ON DELETE CASCADE,
+    version integer NOT NULL,
+    snapshot jsonb NOT NULL,
+    PRIMARY KEY (task_id, version)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS projects_workspace_id_seq_idx;
+ALTER TABLE projects DROP COLUMN IF EXISTS workspace_id;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
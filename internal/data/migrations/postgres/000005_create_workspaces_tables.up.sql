@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+    id text PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    owner_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    personal bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id text NOT NULL REFERENCES workspaces ON DELETE CASCADE,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_id_idx ON workspace_members (user_id);
+
+INSERT INTO workspaces (id, created_at, name, owner_id, personal, version)
+SELECT id, created_at, 'Personal', id, true, 1 FROM users;
+
+INSERT INTO workspace_members (workspace_id, user_id)
+SELECT id, id FROM users;
+
+ALTER TABLE projects ADD COLUMN workspace_id text NOT NULL DEFAULT '';
+
+UPDATE projects SET workspace_id = owner_id;
+
+CREATE INDEX IF NOT EXISTS projects_workspace_id_seq_idx ON projects (workspace_id, seq);
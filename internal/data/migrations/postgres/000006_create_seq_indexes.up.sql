@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS projects_seq_idx ON projects (seq);
+CREATE INDEX IF NOT EXISTS tasks_seq_idx ON tasks (seq);
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id text PRIMARY KEY,
+    workspace_id text NOT NULL REFERENCES workspaces ON DELETE CASCADE,
+    created_by text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    url text NOT NULL,
+    events jsonb NOT NULL,
+    secret text NOT NULL,
+    active bool NOT NULL DEFAULT true,
+    failure_count integer NOT NULL DEFAULT 0,
+    disabled_at timestamp with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_workspace_id_idx ON webhooks (workspace_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id text PRIMARY KEY,
+    webhook_id text NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id bigint NOT NULL,
+    event_type text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts jsonb NOT NULL,
+    next_attempt_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_idx ON webhook_deliveries (webhook_id, created_at);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_at_idx ON webhook_deliveries (status, next_attempt_at);
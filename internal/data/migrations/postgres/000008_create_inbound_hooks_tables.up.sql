@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS inbound_hooks (
+    id text PRIMARY KEY,
+    project_id text NOT NULL REFERENCES projects ON DELETE CASCADE,
+    created_by text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    template jsonb NOT NULL,
+    hashed_token bytea NOT NULL UNIQUE,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS inbound_hooks_project_id_idx ON inbound_hooks (project_id);
+
+CREATE TABLE IF NOT EXISTS inbound_hook_keys (
+    hook_id text NOT NULL REFERENCES inbound_hooks ON DELETE CASCADE,
+    key text NOT NULL,
+    task_id text NOT NULL,
+    expiry timestamp with time zone NOT NULL,
+    PRIMARY KEY (hook_id, key)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    status text NOT NULL,
+    total integer NOT NULL,
+    processed integer NOT NULL,
+    error text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    expiry timestamp with time zone NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS import_jobs_expiry_idx ON import_jobs (expiry);
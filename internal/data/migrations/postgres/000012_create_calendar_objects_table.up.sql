@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS calendar_objects (
+    project_id text NOT NULL REFERENCES projects ON DELETE CASCADE,
+    name text NOT NULL,
+    task_id text NOT NULL,
+    uid text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (project_id, name)
+);
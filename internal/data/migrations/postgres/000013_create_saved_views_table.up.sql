@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS saved_views (
+    id text PRIMARY KEY,
+    owner_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    workspace_id text REFERENCES workspaces ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    query text NOT NULL,
+    sort text NOT NULL,
+    group_by text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS saved_views_owner_id_idx ON saved_views (owner_id);
+CREATE INDEX IF NOT EXISTS saved_views_workspace_id_idx ON saved_views (workspace_id);
@@ -0,0 +1,8 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS assignee_ids text NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS task_watchers (
+    task_id text NOT NULL REFERENCES tasks ON DELETE CASCADE,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (task_id, user_id)
+);
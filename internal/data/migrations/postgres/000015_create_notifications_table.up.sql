@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    type text NOT NULL,
+    actor_id text NOT NULL,
+    task_id text NOT NULL,
+    project_id text NOT NULL,
+    task_title text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    read_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_id_idx ON notifications (user_id, id);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id text PRIMARY KEY,
+    task_id text NOT NULL REFERENCES tasks ON DELETE CASCADE,
+    project_id text NOT NULL,
+    author_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    body text NOT NULL,
+    body_html text NOT NULL,
+    mention_ids text NOT NULL DEFAULT '[]',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS comments_task_id_idx ON comments (task_id, id);
+CREATE INDEX IF NOT EXISTS comments_project_id_idx ON comments (project_id, created_at);
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id text NOT NULL REFERENCES comments ON DELETE CASCADE,
+    version integer NOT NULL,
+    body text NOT NULL,
+    edited_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (comment_id, version)
+);
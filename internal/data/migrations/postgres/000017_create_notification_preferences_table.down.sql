@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS notifications_user_id_read_at_idx;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id text PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    channels text NOT NULL,
+    updated_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_read_at_idx ON notifications (user_id, read_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS notification_preferences_next_digest_at_idx;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS last_digest_at;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS next_digest_at;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS digest_hour;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS timezone;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS digest;
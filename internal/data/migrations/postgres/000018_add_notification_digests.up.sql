@@ -0,0 +1,7 @@
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS digest boolean NOT NULL DEFAULT false;
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT '';
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS digest_hour integer NOT NULL DEFAULT 8;
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS next_digest_at timestamp with time zone;
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS last_digest_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS notification_preferences_next_digest_at_idx ON notification_preferences (next_digest_at);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id text PRIMARY KEY,
+    task_id text NOT NULL,
+    project_id text NOT NULL,
+    workspace_id text NOT NULL,
+    uploader_id text NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    filename text NOT NULL,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    checksum text NOT NULL,
+    storage_key text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_task_id_idx ON attachments (task_id, id);
+CREATE INDEX IF NOT EXISTS attachments_project_id_idx ON attachments (project_id);
+CREATE INDEX IF NOT EXISTS attachments_workspace_id_idx ON attachments (workspace_id);
@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP COLUMN IF EXISTS label_ids;
+DROP TABLE IF EXISTS labels;
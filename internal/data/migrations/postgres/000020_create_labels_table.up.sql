@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS labels (
+    id text PRIMARY KEY,
+    workspace_id text NOT NULL REFERENCES workspaces ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    name_key text NOT NULL,
+    color text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS labels_workspace_id_name_key_idx ON labels (workspace_id, name_key);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS label_ids text NOT NULL DEFAULT '[]';
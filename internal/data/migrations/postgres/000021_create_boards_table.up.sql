@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS boards (
+    id text PRIMARY KEY,
+    project_id text NOT NULL REFERENCES projects ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    columns text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS boards_project_id_idx ON boards (project_id, created_at);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS rank text COLLATE "C" NOT NULL DEFAULT '';
+
+UPDATE tasks SET rank = 'i' || id;
+
+CREATE INDEX IF NOT EXISTS tasks_project_id_rank_idx ON tasks (project_id, rank);
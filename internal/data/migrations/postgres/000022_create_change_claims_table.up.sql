@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS change_claims (
+    seq bigint PRIMARY KEY,
+    claimed_at timestamp with time zone NOT NULL
+);
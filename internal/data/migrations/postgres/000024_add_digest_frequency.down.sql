@@ -0,0 +1,2 @@
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS digest_weekday;
+ALTER TABLE notification_preferences DROP COLUMN IF EXISTS digest_frequency;
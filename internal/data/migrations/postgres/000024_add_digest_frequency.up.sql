@@ -0,0 +1,2 @@
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS digest_frequency text NOT NULL DEFAULT 'daily';
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS digest_weekday text NOT NULL DEFAULT 'monday';
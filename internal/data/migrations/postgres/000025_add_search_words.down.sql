@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS search_words;
+ALTER TABLE tasks DROP COLUMN IF EXISTS search_words;
@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS search_words text NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS search_words text NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+UPDATE comments
+SET project_id = (SELECT project_id FROM tasks WHERE tasks.id = comments.task_id)
+WHERE project_id <> (SELECT project_id FROM tasks WHERE tasks.id = comments.task_id);
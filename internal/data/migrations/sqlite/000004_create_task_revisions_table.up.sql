@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS task_revisions (
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    snapshot TEXT NOT NULL,
+    PRIMARY KEY (task_id, version)
+);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    owner_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    personal BOOLEAN NOT NULL DEFAULT FALSE,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id TEXT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS workspace_members_user_id_idx ON workspace_members (user_id);
+
+INSERT INTO workspaces (id, created_at, name, owner_id, personal, version)
+SELECT id, created_at, 'Personal', id, TRUE, 1 FROM users;
+
+INSERT INTO workspace_members (workspace_id, user_id)
+SELECT id, id FROM users;
+
+ALTER TABLE projects ADD COLUMN workspace_id TEXT NOT NULL DEFAULT '';
+
+UPDATE projects SET workspace_id = owner_id;
+
+CREATE INDEX IF NOT EXISTS projects_workspace_id_seq_idx ON projects (workspace_id, seq);
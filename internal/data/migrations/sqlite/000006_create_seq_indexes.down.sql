@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tasks_seq_idx;
+DROP INDEX IF EXISTS projects_seq_idx;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS inbound_hook_keys;
+DROP TABLE IF EXISTS inbound_hooks;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS inbound_hooks (
+    id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    template TEXT NOT NULL,
+    hashed_token BLOB NOT NULL UNIQUE,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS inbound_hooks_project_id_idx ON inbound_hooks (project_id);
+
+CREATE TABLE IF NOT EXISTS inbound_hook_keys (
+    hook_id TEXT NOT NULL REFERENCES inbound_hooks (id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    task_id TEXT NOT NULL,
+    expiry TIMESTAMP NOT NULL,
+    PRIMARY KEY (hook_id, key)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS calendar_objects (
+    project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    task_id TEXT NOT NULL,
+    uid TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (project_id, name)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_watchers;
+ALTER TABLE tasks DROP COLUMN assignee_ids;
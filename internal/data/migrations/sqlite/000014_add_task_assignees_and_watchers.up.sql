@@ -0,0 +1,8 @@
+ALTER TABLE tasks ADD COLUMN assignee_ids TEXT NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS task_watchers (
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (task_id, user_id)
+);
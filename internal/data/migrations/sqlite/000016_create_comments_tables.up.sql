@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id TEXT PRIMARY KEY,
+    task_id TEXT NOT NULL REFERENCES tasks (id) ON DELETE CASCADE,
+    project_id TEXT NOT NULL,
+    author_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    body TEXT NOT NULL,
+    body_html TEXT NOT NULL,
+    mention_ids TEXT NOT NULL DEFAULT '[]',
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS comments_task_id_idx ON comments (task_id, id);
+CREATE INDEX IF NOT EXISTS comments_project_id_idx ON comments (project_id, created_at);
+
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id TEXT NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    edited_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (comment_id, version)
+);
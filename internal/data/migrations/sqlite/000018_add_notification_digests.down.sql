@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS notification_preferences_next_digest_at_idx;
+ALTER TABLE notification_preferences DROP COLUMN last_digest_at;
+ALTER TABLE notification_preferences DROP COLUMN next_digest_at;
+ALTER TABLE notification_preferences DROP COLUMN digest_hour;
+ALTER TABLE notification_preferences DROP COLUMN timezone;
+ALTER TABLE notification_preferences DROP COLUMN digest;
@@ -0,0 +1,7 @@
+ALTER TABLE notification_preferences ADD COLUMN digest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE notification_preferences ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+ALTER TABLE notification_preferences ADD COLUMN digest_hour INTEGER NOT NULL DEFAULT 8;
+ALTER TABLE notification_preferences ADD COLUMN next_digest_at TIMESTAMP;
+ALTER TABLE notification_preferences ADD COLUMN last_digest_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS notification_preferences_next_digest_at_idx ON notification_preferences (next_digest_at);
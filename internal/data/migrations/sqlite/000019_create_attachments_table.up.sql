@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id TEXT PRIMARY KEY,
+    task_id TEXT NOT NULL,
+    project_id TEXT NOT NULL,
+    workspace_id TEXT NOT NULL,
+    uploader_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    checksum TEXT NOT NULL,
+    storage_key TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS attachments_task_id_idx ON attachments (task_id, id);
+CREATE INDEX IF NOT EXISTS attachments_project_id_idx ON attachments (project_id);
+CREATE INDEX IF NOT EXISTS attachments_workspace_id_idx ON attachments (workspace_id);
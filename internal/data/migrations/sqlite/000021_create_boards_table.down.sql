@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tasks_project_id_rank_idx;
+ALTER TABLE tasks DROP COLUMN rank;
+DROP TABLE IF EXISTS boards;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS boards (
+    id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    columns TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS boards_project_id_idx ON boards (project_id, created_at);
+
+ALTER TABLE tasks ADD COLUMN rank TEXT NOT NULL DEFAULT '';
+
+UPDATE tasks SET rank = 'i' || id;
+
+CREATE INDEX IF NOT EXISTS tasks_project_id_rank_idx ON tasks (project_id, rank);
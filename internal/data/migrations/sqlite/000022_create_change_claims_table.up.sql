@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS change_claims (
+    seq BIGINT PRIMARY KEY,
+    claimed_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE notification_preferences DROP COLUMN digest_weekday;
+ALTER TABLE notification_preferences DROP COLUMN digest_frequency;
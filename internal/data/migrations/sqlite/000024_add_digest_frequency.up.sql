@@ -0,0 +1,2 @@
+ALTER TABLE notification_preferences ADD COLUMN digest_frequency TEXT NOT NULL DEFAULT 'daily';
+ALTER TABLE notification_preferences ADD COLUMN digest_weekday TEXT NOT NULL DEFAULT 'monday';
@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN search_words;
+ALTER TABLE tasks DROP COLUMN search_words;
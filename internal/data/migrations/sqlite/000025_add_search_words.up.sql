@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN search_words TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN search_words TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+UPDATE attachments
+SET project_id = (SELECT project_id FROM tasks WHERE tasks.id = attachments.task_id),
+    workspace_id = (
+        SELECT projects.workspace_id
+        FROM tasks JOIN projects ON projects.id = tasks.project_id
+        WHERE tasks.id = attachments.task_id
+    )
+WHERE project_id <> (SELECT project_id FROM tasks WHERE tasks.id = attachments.task_id);
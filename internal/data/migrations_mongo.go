@@ -0,0 +1,860 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoMigration struct {
+	version int64
+	name    string
+	up      func(ctx context.Context, db *mongo.Database) error
+	down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// mongoMigrations is the ordered list of schema changes for the Mongo
+// backend. Append new entries with the next version number; never edit or
+// renumber one which has already shipped.
+var mongoMigrations = []mongoMigration{
+	{
+		version: 1,
+		name:    "create_users_email_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetName("email_unique").SetUnique(true),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("users"), "email_unique")
+		},
+	},
+	{
+		version: 2,
+		name:    "create_tokens_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "expiry", Value: 1}},
+					Options: options.Index().SetName("expiry_ttl").SetExpireAfterSeconds(0),
+				},
+				{
+					Keys:    bson.D{{Key: "hashedToken", Value: 1}, {Key: "scope", Value: 1}},
+					Options: options.Index().SetName("hashedToken_scope"),
+				},
+				{
+					Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "scope", Value: 1}},
+					Options: options.Index().SetName("userID_scope"),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"expiry_ttl", "hashedToken_scope", "userID_scope"} {
+				if err := dropIndex(ctx, db.Collection("tokens"), name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 3,
+		name:    "create_users_validator",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "users", bson.M{
+				"bsonType": "object",
+				"required": bson.A{"created_at", "name", "email", "password", "activated", "version"},
+				"properties": bson.M{
+					"created_at": bson.M{"bsonType": "date"},
+					"name":       bson.M{"bsonType": "string", "maxLength": 500},
+					"email":      bson.M{"bsonType": "string"},
+					"password":   bson.M{"bsonType": "binData"},
+					"activated":  bson.M{"bsonType": "bool"},
+					"version":    bson.M{"bsonType": "int", "minimum": 1},
+				},
+			})
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "users", nil)
+		},
+	},
+	{
+		version: 4,
+		name:    "create_tokens_validator",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", bson.M{
+				"bsonType": "object",
+				"required": bson.A{"hashedToken", "userID", "expiry", "scope"},
+				"properties": bson.M{
+					"hashedToken": bson.M{"bsonType": "binData"},
+					"userID":      bson.M{"bsonType": "objectId"},
+					"expiry":      bson.M{"bsonType": "date"},
+					"scope":       bson.M{"bsonType": "string", "enum": bson.A{ScopeActivation, ScopeAuthentication}},
+				},
+			})
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", nil)
+		},
+	},
+	{
+		version: 5,
+		name:    "create_projects_and_tasks_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("projects").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "owner_id", Value: 1}, {Key: "seq", Value: 1}},
+				Options: options.Index().SetName("owner_id_seq"),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "project_id", Value: 1}, {Key: "seq", Value: 1}},
+				Options: options.Index().SetName("project_id_seq"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndex(ctx, db.Collection("projects"), "owner_id_seq"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, db.Collection("tasks"), "project_id_seq")
+		},
+	},
+	{
+		version: 6,
+		name:    "create_task_revisions_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("task_revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "task_id", Value: 1}, {Key: "version", Value: 1}},
+				Options: options.Index().SetName("task_id_version").SetUnique(true),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("task_revisions"), "task_id_version")
+		},
+	},
+	{
+		version: 7,
+		name:    "create_workspaces",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("workspaces").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "member_ids", Value: 1}},
+				Options: options.Index().SetName("member_ids"),
+			})
+			if err != nil {
+				return err
+			}
+
+			// Existing projects move into their owner's personal workspace,
+			// which shares the owner's id.
+			_, err = db.Collection("projects").UpdateMany(ctx,
+				bson.M{"workspace_id": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"workspace_id": "$owner_id"}}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("projects").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "workspace_id", Value: 1}, {Key: "seq", Value: 1}},
+				Options: options.Index().SetName("workspace_id_seq"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndex(ctx, db.Collection("projects"), "workspace_id_seq"); err != nil {
+				return err
+			}
+
+			_, err := db.Collection("projects").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"workspace_id": ""}})
+			if err != nil {
+				return err
+			}
+			return db.Collection("workspaces").Drop(ctx)
+		},
+	},
+	{
+		version: 8,
+		name:    "create_seq_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"projects", "tasks"} {
+				_, err := db.Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "seq", Value: 1}},
+					Options: options.Index().SetName("seq"),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndex(ctx, db.Collection("tasks"), "seq"); err != nil {
+				return err
+			}
+			return dropIndex(ctx, db.Collection("projects"), "seq")
+		},
+	},
+	{
+		version: 9,
+		name:    "create_webhooks_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("webhooks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "workspace_id", Value: 1}},
+				Options: options.Index().SetName("workspace_id"),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("webhook_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+					Options: options.Index().SetName("webhook_id_created_at"),
+				},
+				{
+					Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+					Options: options.Index().SetName("status_next_attempt_at"),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection("webhook_deliveries").Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection("webhooks").Drop(ctx)
+		},
+	},
+	{
+		version: 10,
+		name:    "create_inbound_hooks_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("inbound_hooks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "project_id", Value: 1}},
+					Options: options.Index().SetName("project_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "hashed_token", Value: 1}},
+					Options: options.Index().SetName("hashed_token_unique").SetUnique(true),
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("inbound_hook_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "hook_id", Value: 1}, {Key: "key", Value: 1}},
+					Options: options.Index().SetName("hook_id_key_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "expiry", Value: 1}},
+					Options: options.Index().SetName("expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection("inbound_hook_keys").Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection("inbound_hooks").Drop(ctx)
+		},
+	},
+	{
+		version: 11,
+		name:    "create_idempotency_keys_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("idempotency_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "key", Value: 1}},
+					Options: options.Index().SetName("scope_key_unique").SetUnique(true),
+				},
+				{
+					Keys:    bson.D{{Key: "expiry", Value: 1}},
+					Options: options.Index().SetName("expiry_ttl").SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("idempotency_keys").Drop(ctx)
+		},
+	},
+	{
+		version: 12,
+		name:    "create_import_jobs_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("import_jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expiry", Value: 1}},
+				Options: options.Index().SetName("expiry_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("import_jobs").Drop(ctx)
+		},
+	},
+	{
+		version: 13,
+		name:    "create_calendar_objects_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("calendar_objects").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "project_id", Value: 1}, {Key: "name", Value: 1}},
+				Options: options.Index().SetName("project_id_name_unique").SetUnique(true),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("calendar_objects").Drop(ctx)
+		},
+	},
+	{
+		version: 14,
+		name:    "create_tasks_text_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			// Search ranks its candidates itself, so the index leaves words as
+			// they are rather than stemming them or dropping stop words.
+			_, err := db.Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+				Options: options.Index().
+					SetName("title_description_text").
+					SetWeights(bson.D{{Key: "title", Value: 3}, {Key: "description", Value: 1}}).
+					SetDefaultLanguage("none"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("tasks"), "title_description_text")
+		},
+	},
+	{
+		version: 15,
+		name:    "create_saved_views_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("saved_views").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "owner_id", Value: 1}},
+					Options: options.Index().SetName("owner_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "workspace_id", Value: 1}},
+					Options: options.Index().SetName("workspace_id").SetSparse(true),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("saved_views").Drop(ctx)
+		},
+	},
+	{
+		version: 16,
+		name:    "create_assignment_and_notification_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "assignee_ids", Value: 1}},
+				Options: options.Index().SetName("assignee_ids"),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("task_watchers").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "task_id", Value: 1}, {Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("task_id_user_id_unique").SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}},
+				Options: options.Index().SetName("user_id_id"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection("notifications").Drop(ctx); err != nil {
+				return err
+			}
+			if err := db.Collection("task_watchers").Drop(ctx); err != nil {
+				return err
+			}
+			return dropIndex(ctx, db.Collection("tasks"), "assignee_ids")
+		},
+	},
+	{
+		version: 17,
+		name:    "create_comments_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("comments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "task_id", Value: 1}, {Key: "_id", Value: 1}},
+					Options: options.Index().SetName("task_id_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "project_id", Value: 1}},
+					Options: options.Index().SetName("project_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "body", Value: "text"}},
+					Options: options.Index().SetName("body_text").SetDefaultLanguage("none"),
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("comment_revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "comment_id", Value: 1}, {Key: "version", Value: 1}},
+				Options: options.Index().SetName("comment_id_version").SetUnique(true),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection("comment_revisions").Drop(ctx); err != nil {
+				return err
+			}
+			return db.Collection("comments").Drop(ctx)
+		},
+	},
+	{
+		version: 18,
+		name:    "create_notifications_unread_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "read_at", Value: 1}},
+				Options: options.Index().SetName("user_id_read_at"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("notifications"), "user_id_read_at")
+		},
+	},
+	{
+		version: 19,
+		name:    "create_notification_preferences_digest_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("notification_preferences").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "digest", Value: 1}, {Key: "next_digest_at", Value: 1}},
+				Options: options.Index().SetName("digest_next_digest_at"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("notification_preferences"), "digest_next_digest_at")
+		},
+	},
+	{
+		version: 20,
+		name:    "create_attachments_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("attachments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "task_id", Value: 1}, {Key: "_id", Value: 1}},
+					Options: options.Index().SetName("task_id_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "project_id", Value: 1}},
+					Options: options.Index().SetName("project_id"),
+				},
+				{
+					Keys:    bson.D{{Key: "workspace_id", Value: 1}},
+					Options: options.Index().SetName("workspace_id"),
+				},
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("attachments").Drop(ctx)
+		},
+	},
+	{
+		version: 21,
+		name:    "create_labels_indexes",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("labels").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "workspace_id", Value: 1}, {Key: "name_key", Value: 1}},
+				Options: options.Index().SetName("workspace_id_name_key_unique").SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "label_ids", Value: 1}},
+				Options: options.Index().SetName("label_ids"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := db.Collection("labels").Drop(ctx); err != nil {
+				return err
+			}
+			return dropIndex(ctx, db.Collection("tasks"), "label_ids")
+		},
+	},
+	{
+		version: 22,
+		name:    "create_boards_and_task_ranks",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("boards").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "project_id", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index().SetName("project_id_created_at"),
+			})
+			if err != nil {
+				return err
+			}
+
+			// Existing tasks are ranked in the order they were created in.
+			_, err = db.Collection("tasks").UpdateMany(ctx,
+				bson.M{"rank": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"rank": bson.M{"$concat": bson.A{"i", bson.M{"$toString": "$_id"}}}}}}},
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "project_id", Value: 1}, {Key: "rank", Value: 1}},
+				Options: options.Index().SetName("project_id_rank"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndex(ctx, db.Collection("tasks"), "project_id_rank"); err != nil {
+				return err
+			}
+
+			_, err := db.Collection("tasks").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"rank": ""}})
+			if err != nil {
+				return err
+			}
+			return db.Collection("boards").Drop(ctx)
+		},
+	},
+	{
+		version: 23,
+		name:    "allow_calendar_tokens",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication, ScopeCalendar))
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication))
+		},
+	},
+	{
+		version: 24,
+		name:    "allow_api_key_tokens",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication, ScopeCalendar, ScopeAPIKey))
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication, ScopeCalendar))
+		},
+	},
+	{
+		version: 25,
+		name:    "allow_unsubscribe_tokens",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication, ScopeCalendar, ScopeAPIKey, ScopeUnsubscribe))
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return setValidator(ctx, db, "tokens", tokensSchema(ScopeActivation, ScopeAuthentication, ScopeCalendar, ScopeAPIKey))
+		},
+	},
+	{
+		version: 26,
+		name:    "set_digest_frequency",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("notification_preferences").UpdateMany(ctx,
+				bson.M{"digest_frequency": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"digest_frequency": DigestDaily, "digest_weekday": DefaultDigestWeekday}},
+			)
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("notification_preferences").UpdateMany(ctx, bson.M{},
+				bson.M{"$unset": bson.M{"digest_frequency": "", "digest_weekday": ""}},
+			)
+			return err
+		},
+	},
+	{
+		version: 27,
+		name:    "repair_comment_projects",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			// Comments on tasks moved before comments followed their
+			// tasks still name the old project.
+			pipeline := mongo.Pipeline{
+				{{Key: "$group", Value: bson.M{"_id": "$task_id"}}},
+				{{Key: "$lookup", Value: bson.M{"from": "tasks", "localField": "_id", "foreignField": "_id", "as": "task"}}},
+				{{Key: "$unwind", Value: "$task"}},
+				{{Key: "$project", Value: bson.M{"project_id": "$task.project_id"}}},
+			}
+			cursor, err := db.Collection("comments").Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var task struct {
+					ID        primitive.ObjectID `bson:"_id"`
+					ProjectID primitive.ObjectID `bson:"project_id"`
+				}
+				if err := cursor.Decode(&task); err != nil {
+					return err
+				}
+				_, err := db.Collection("comments").UpdateMany(ctx,
+					bson.M{"task_id": task.ID, "project_id": bson.M{"$ne": task.ProjectID}},
+					bson.M{"$set": bson.M{"project_id": task.ProjectID}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	},
+	{
+		version: 28,
+		name:    "repair_attachment_projects",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			// As for comments above, with the workspace too.
+			pipeline := mongo.Pipeline{
+				{{Key: "$group", Value: bson.M{"_id": "$task_id"}}},
+				{{Key: "$lookup", Value: bson.M{"from": "tasks", "localField": "_id", "foreignField": "_id", "as": "task"}}},
+				{{Key: "$unwind", Value: "$task"}},
+				{{Key: "$lookup", Value: bson.M{"from": "projects", "localField": "task.project_id", "foreignField": "_id", "as": "project"}}},
+				{{Key: "$unwind", Value: "$project"}},
+				{{Key: "$project", Value: bson.M{"project_id": "$project._id", "workspace_id": "$project.workspace_id"}}},
+			}
+			cursor, err := db.Collection("attachments").Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var task struct {
+					ID          primitive.ObjectID `bson:"_id"`
+					ProjectID   primitive.ObjectID `bson:"project_id"`
+					WorkspaceID primitive.ObjectID `bson:"workspace_id"`
+				}
+				if err := cursor.Decode(&task); err != nil {
+					return err
+				}
+				_, err := db.Collection("attachments").UpdateMany(ctx,
+					bson.M{"task_id": task.ID, "project_id": bson.M{"$ne": task.ProjectID}},
+					bson.M{"$set": bson.M{"project_id": task.ProjectID, "workspace_id": task.WorkspaceID}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	},
+	{
+		version: 29,
+		name:    "create_task_watchers_user_index",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("task_watchers").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("user_id"),
+			})
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndex(ctx, db.Collection("task_watchers"), "user_id")
+		},
+	},
+}
+
+type mongoMigrationRecord struct {
+	Version   int64     `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+type MongoMigrator struct {
+	DB *mongo.Database
+}
+
+func (m MongoMigrator) Up(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mg := range sortedMongoMigrations() {
+		if _, ok := applied[mg.version]; ok {
+			continue
+		}
+
+		if err := mg.up(ctx, m.DB); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mg.version, mg.name, err)
+		}
+
+		_, err := m.DB.Collection("migrations").InsertOne(ctx, mongoMigrationRecord{
+			Version:   mg.version,
+			Name:      mg.name,
+			AppliedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MongoMigrator) Down(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrations := sortedMongoMigrations()
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mg := migrations[i]
+		if _, ok := applied[mg.version]; !ok {
+			continue
+		}
+
+		if err := mg.down(ctx, m.DB); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mg.version, mg.name, err)
+		}
+
+		_, err := m.DB.Collection("migrations").DeleteOne(ctx, bson.M{"_id": mg.version})
+		return err
+	}
+
+	return ErrNoMigrations
+}
+
+func (m MongoMigrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, mg := range sortedMongoMigrations() {
+		appliedAt, ok := applied[mg.version]
+		status = append(status, MigrationStatus{
+			Version:   mg.version,
+			Name:      fmt.Sprintf("%06d_%s", mg.version, mg.name),
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return status, nil
+}
+
+func (m MongoMigrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	cursor, err := m.DB.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mongoMigrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+func sortedMongoMigrations() []mongoMigration {
+	migrations := make([]mongoMigration, len(mongoMigrations))
+	copy(migrations, mongoMigrations)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations
+}
+
+// setValidator installs a $jsonSchema validator on the collection, creating
+// the collection first if it does not yet exist. A nil schema removes any
+// existing validator.
+func setValidator(ctx context.Context, db *mongo.Database, collection string, schema bson.M) error {
+	validator := bson.M{}
+	if schema != nil {
+		validator = bson.M{"$jsonSchema": schema}
+	}
+
+	err := db.CreateCollection(ctx, collection, options.CreateCollection().SetValidator(validator))
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != 48 {
+		return err
+	}
+
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+	}).Err()
+}
+
+// tokensSchema returns the validator schema for the tokens collection,
+// allowing only the given scopes. Migrations which add a scope pass the
+// whole list, as it stood once they were applied.
+func tokensSchema(scopes ...string) bson.M {
+	enum := bson.A{}
+	for _, scope := range scopes {
+		enum = append(enum, scope)
+	}
+
+	return bson.M{
+		"bsonType": "object",
+		"required": bson.A{"hashedToken", "userID", "expiry", "scope"},
+		"properties": bson.M{
+			"hashedToken": bson.M{"bsonType": "binData"},
+			"userID":      bson.M{"bsonType": "objectId"},
+			"expiry":      bson.M{"bsonType": "date"},
+			"scope":       bson.M{"bsonType": "string", "enum": enum},
+		},
+	}
+}
+
+func dropIndex(ctx context.Context, coll *mongo.Collection, name string) error {
+	_, err := coll.Indexes().DropOne(ctx, name)
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27) {
+		return nil
+	}
+	return err
+}
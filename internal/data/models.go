@@ -1,23 +1,298 @@
 package data
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// DefaultQueryTimeout bounds a single query when no timeout is configured.
+const DefaultQueryTimeout = 5 * time.Second
+
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrCanceled       = errors.New("query canceled")
 )
 
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	Update(ctx context.Context, user *User) error
+}
+
+type TokenStore interface {
+	New(ctx context.Context, userID primitive.ObjectID, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	GetUserIDForToken(ctx context.Context, tokenScope, tokenPlaintext string) (primitive.ObjectID, error)
+	DeleteAllForUser(ctx context.Context, scope string, userID primitive.ObjectID) error
+}
+
+type WorkspaceStore interface {
+	Insert(ctx context.Context, workspace *Workspace) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Workspace, error)
+	ForUser(ctx context.Context, userID primitive.ObjectID) ([]*Workspace, error)
+	IDsForUser(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error)
+	IsMember(ctx context.Context, workspaceID, userID primitive.ObjectID) (bool, error)
+	AddMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error
+	RemoveMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error
+}
+
+type WebhookStore interface {
+	Insert(ctx context.Context, webhook *Webhook) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Webhook, error)
+	ForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]*Webhook, error)
+	ForEvent(ctx context.Context, workspaceID primitive.ObjectID, eventType string) ([]*Webhook, error)
+	Update(ctx context.Context, webhook *Webhook) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	RecordResult(ctx context.Context, id primitive.ObjectID, success bool, disableAfter int) (bool, error)
+}
+
+type WebhookDeliveryStore interface {
+	Insert(ctx context.Context, delivery *WebhookDelivery) error
+	Get(ctx context.Context, id primitive.ObjectID) (*WebhookDelivery, error)
+	ForWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]*WebhookDelivery, error)
+	Due(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error)
+	Update(ctx context.Context, delivery *WebhookDelivery) error
+}
+
+type InboundHookStore interface {
+	Insert(ctx context.Context, hook *InboundHook) error
+	Get(ctx context.Context, id primitive.ObjectID) (*InboundHook, error)
+	GetForToken(ctx context.Context, tokenPlaintext string) (*InboundHook, error)
+	ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*InboundHook, error)
+	Update(ctx context.Context, hook *InboundHook) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	ClaimKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error
+	ReleaseKey(ctx context.Context, hookID primitive.ObjectID, key string, taskID primitive.ObjectID) error
+	TaskForKey(ctx context.Context, hookID primitive.ObjectID, key string) (primitive.ObjectID, error)
+}
+
+type IdempotencyStore interface {
+	Claim(ctx context.Context, record *IdempotencyRecord) error
+	Get(ctx context.Context, scope, key string) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	Release(ctx context.Context, record *IdempotencyRecord) error
+}
+
+type ImportJobStore interface {
+	Insert(ctx context.Context, job *ImportJob) error
+	Get(ctx context.Context, id primitive.ObjectID) (*ImportJob, error)
+	Update(ctx context.Context, job *ImportJob) error
+}
+
+type CalendarObjectStore interface {
+	Insert(ctx context.Context, object *CalendarObject) error
+	GetByName(ctx context.Context, projectID primitive.ObjectID, name string) (*CalendarObject, error)
+	ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*CalendarObject, error)
+	Delete(ctx context.Context, projectID primitive.ObjectID, name string) error
+}
+
+type WatcherStore interface {
+	Add(ctx context.Context, taskID, userID primitive.ObjectID) error
+	Remove(ctx context.Context, taskID, userID primitive.ObjectID) error
+	RemoveForProjects(ctx context.Context, userID primitive.ObjectID, projectIDs []primitive.ObjectID) error
+	ForTask(ctx context.Context, taskID primitive.ObjectID) ([]primitive.ObjectID, error)
+}
+
+type CommentStore interface {
+	Insert(ctx context.Context, comment *Comment) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Comment, error)
+	ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Comment, error)
+	Update(ctx context.Context, comment *Comment) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Revisions(ctx context.Context, id primitive.ObjectID) ([]*CommentRevision, error)
+}
+
+type AttachmentStore interface {
+	Insert(ctx context.Context, attachment *Attachment) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Attachment, error)
+	ForTask(ctx context.Context, taskID primitive.ObjectID) ([]*Attachment, error)
+	ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Attachment, error)
+	Usage(ctx context.Context, workspaceID primitive.ObjectID) (int64, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type LabelStore interface {
+	Insert(ctx context.Context, label *Label) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Label, error)
+	ForWorkspace(ctx context.Context, workspaceID primitive.ObjectID) ([]*Label, error)
+	Update(ctx context.Context, label *Label) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	CountTasks(ctx context.Context, labels []*Label) error
+}
+
+type BoardStore interface {
+	Insert(ctx context.Context, board *Board) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Board, error)
+	ForProject(ctx context.Context, projectID primitive.ObjectID) ([]*Board, error)
+	Update(ctx context.Context, board *Board) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type NotificationStore interface {
+	Insert(ctx context.Context, notification *Notification) error
+	ForUser(ctx context.Context, userID, before primitive.ObjectID, unreadOnly bool, limit int) ([]*Notification, error)
+	UnreadCount(ctx context.Context, userID primitive.ObjectID) (int, error)
+	SetRead(ctx context.Context, userID, id primitive.ObjectID, read bool) (*Notification, error)
+	MarkAllRead(ctx context.Context, userID primitive.ObjectID) (int, error)
+}
+
+type NotificationPreferenceStore interface {
+	Get(ctx context.Context, userID primitive.ObjectID) (*NotificationPreferences, error)
+	Put(ctx context.Context, prefs *NotificationPreferences) error
+	DueDigests(ctx context.Context, now time.Time, limit int) ([]*NotificationPreferences, error)
+	ClaimDigest(ctx context.Context, prefs *NotificationPreferences, now time.Time) error
+}
+
+type SavedViewStore interface {
+	Insert(ctx context.Context, view *SavedView) error
+	Get(ctx context.Context, id primitive.ObjectID) (*SavedView, error)
+	ForUser(ctx context.Context, userID primitive.ObjectID, workspaceIDs []primitive.ObjectID) ([]*SavedView, error)
+	Update(ctx context.Context, view *SavedView) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type SearchStore interface {
+	Search(ctx context.Context, query SearchQuery, projectIDs []primitive.ObjectID, limit int) ([]*SearchResult, error)
+}
+
+type ChangeStore interface {
+	Horizon(ctx context.Context) (int64, error)
+}
+
+type ProjectStore interface {
+	Insert(ctx context.Context, project *Project) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Project, error)
+	Update(ctx context.Context, project *Project) error
+	Delete(ctx context.Context, project *Project) error
+	IDsForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]primitive.ObjectID, error)
+	ChangesSince(ctx context.Context, workspaceIDs []primitive.ObjectID, since int64, limit int) ([]*Project, error)
+}
+
+type TaskStore interface {
+	Insert(ctx context.Context, task *Task) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Task, error)
+	Update(ctx context.Context, task *Task) error
+	Move(ctx context.Context, task *Task) error
+	Delete(ctx context.Context, task *Task) error
+	DeleteAllForProject(ctx context.Context, projectID primitive.ObjectID) error
+	ChangesSince(ctx context.Context, projectIDs []primitive.ObjectID, since int64, limit int) ([]*Task, error)
+	List(ctx context.Context, filters Filters, after primitive.ObjectID, limit int) ([]*Task, error)
+	ListByDue(ctx context.Context, filters Filters, limit int) ([]*Task, error)
+	GetRevision(ctx context.Context, id primitive.ObjectID, version int32) (*Task, error)
+	RevisionsSince(ctx context.Context, id primitive.ObjectID, version int32) ([]*Task, error)
+	ReplaceLabel(ctx context.Context, from, to primitive.ObjectID) ([]*Task, error)
+}
+
 type Models struct {
-	Users  UserModel
-	Tokens TokenModel
+	Users         UserStore
+	Tokens        TokenStore
+	Workspaces    WorkspaceStore
+	Projects      ProjectStore
+	Tasks         TaskStore
+	Webhooks      WebhookStore
+	Deliveries    WebhookDeliveryStore
+	InboundHooks  InboundHookStore
+	Idempotency   IdempotencyStore
+	Imports       ImportJobStore
+	Calendars     CalendarObjectStore
+	Search        SearchStore
+	Views         SavedViewStore
+	Watchers      WatcherStore
+	Comments      CommentStore
+	Notifications NotificationStore
+	Preferences   NotificationPreferenceStore
+	Attachments   AttachmentStore
+	Labels        LabelStore
+	Boards        BoardStore
+	Changes       ChangeStore
+	tx            transactor
+}
+
+func NewModels(db *mongo.Database, timeout time.Duration) Models {
+	counters := db.Collection("counters")
+	comments := CommentModel{DB: db.Collection("comments"), History: db.Collection("comment_revisions"), Timeout: timeout}
+	attachments := AttachmentModel{DB: db.Collection("attachments"), Projects: db.Collection("projects"), Timeout: timeout}
+
+	return Models{
+		Users:         UserModel{DB: db.Collection("users"), Timeout: timeout},
+		Tokens:        TokenModel{DB: db.Collection("tokens"), Timeout: timeout},
+		Workspaces:    WorkspaceModel{DB: db.Collection("workspaces"), Timeout: timeout},
+		Projects:      ProjectModel{DB: db.Collection("projects"), Counters: counters, Timeout: timeout},
+		Tasks:         TaskModel{DB: db.Collection("tasks"), Counters: counters, History: db.Collection("task_revisions"), Comments: comments, Attachments: attachments, Timeout: timeout},
+		Webhooks:      WebhookModel{DB: db.Collection("webhooks"), Deliveries: db.Collection("webhook_deliveries"), Timeout: timeout},
+		Deliveries:    WebhookDeliveryModel{DB: db.Collection("webhook_deliveries"), Timeout: timeout},
+		InboundHooks:  InboundHookModel{DB: db.Collection("inbound_hooks"), Keys: db.Collection("inbound_hook_keys"), Timeout: timeout},
+		Idempotency:   IdempotencyModel{DB: db.Collection("idempotency_keys"), Timeout: timeout},
+		Imports:       ImportJobModel{DB: db.Collection("import_jobs"), Timeout: timeout},
+		Calendars:     CalendarObjectModel{DB: db.Collection("calendar_objects"), Timeout: timeout},
+		Search:        SearchModel{Tasks: db.Collection("tasks"), Projects: db.Collection("projects"), Comments: db.Collection("comments"), Timeout: timeout},
+		Views:         SavedViewModel{DB: db.Collection("saved_views"), Timeout: timeout},
+		Watchers:      WatcherModel{DB: db.Collection("task_watchers"), Tasks: db.Collection("tasks"), Timeout: timeout},
+		Comments:      comments,
+		Notifications: NotificationModel{DB: db.Collection("notifications"), Timeout: timeout},
+		Preferences:   NotificationPreferenceModel{DB: db.Collection("notification_preferences"), Timeout: timeout},
+		Attachments:   attachments,
+		Labels:        LabelModel{DB: db.Collection("labels"), Tasks: db.Collection("tasks"), Timeout: timeout},
+		Boards:        BoardModel{DB: db.Collection("boards"), Timeout: timeout},
+		Changes:       ChangeModel{Counters: counters, Timeout: timeout},
+		tx:            &mongoTransactor{db: db},
+	}
+}
+
+func NewSQLModels(db *sql.DB, dialect Dialect, timeout time.Duration) Models {
+	models := newSQLModels(db, dialect, timeout)
+	models.tx = sqlTransactor{db: db, dialect: dialect, timeout: timeout}
+	return models
 }
 
-func NewModels(db *mongo.Database) Models {
+func newSQLModels(db SQLQuerier, dialect Dialect, timeout time.Duration) Models {
 	return Models{
-		Users:  UserModel{DB: db.Collection("users")},
-		Tokens: TokenModel{DB: db.Collection("tokens")},
+		Users:         SQLUserModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Tokens:        SQLTokenModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Workspaces:    SQLWorkspaceModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Projects:      SQLProjectModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Tasks:         SQLTaskModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Webhooks:      SQLWebhookModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Deliveries:    SQLWebhookDeliveryModel{DB: db, Dialect: dialect, Timeout: timeout},
+		InboundHooks:  SQLInboundHookModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Idempotency:   SQLIdempotencyModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Imports:       SQLImportJobModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Calendars:     SQLCalendarObjectModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Search:        SQLSearchModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Views:         SQLSavedViewModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Watchers:      SQLWatcherModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Comments:      SQLCommentModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Notifications: SQLNotificationModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Preferences:   SQLNotificationPreferenceModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Attachments:   SQLAttachmentModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Labels:        SQLLabelModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Boards:        SQLBoardModel{DB: db, Dialect: dialect, Timeout: timeout},
+		Changes:       SQLChangeModel{DB: db, Dialect: dialect, Timeout: timeout},
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError wraps err with ErrCanceled when the query failed because the
+// caller's context was canceled, typically by the client disconnecting or
+// the server shutting down, so handlers can tell it apart from a genuine
+// database failure. Queries which run past their own timeout are left as is.
+func queryError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
 	}
+	return err
 }
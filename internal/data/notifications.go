@@ -0,0 +1,359 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// Notification types, named for what happened to the task.
+const (
+	NotificationTaskAssigned  = "task.assigned"
+	NotificationTaskUpdated   = "task.updated"
+	NotificationTaskDeleted   = "task.deleted"
+	NotificationTaskCommented = "task.commented"
+	NotificationMentioned     = "comment.mentioned"
+)
+
+var NotificationTypes = []string{
+	NotificationTaskAssigned,
+	NotificationTaskUpdated,
+	NotificationTaskDeleted,
+	NotificationTaskCommented,
+	NotificationMentioned,
+}
+
+// Notification channels say where a type of notification goes: to the
+// in-app notification center, by email, to both or nowhere.
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+	ChannelBoth  = "both"
+	ChannelNone  = "none"
+)
+
+var NotificationChannels = []string{ChannelInApp, ChannelEmail, ChannelBoth, ChannelNone}
+
+// Notification tells a user that someone else did something to a task they
+// are involved in. The task's title is kept as it was at the time, so the
+// notification still reads sensibly once the task changes or is deleted.
+// ActorID is zero for changes nobody in particular made.
+type Notification struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Type      string             `json:"type" bson:"type"`
+	ActorID   primitive.ObjectID `json:"actor_id" bson:"actor_id"`
+	TaskID    primitive.ObjectID `json:"task_id" bson:"task_id"`
+	ProjectID primitive.ObjectID `json:"project_id" bson:"project_id"`
+	TaskTitle string             `json:"task_title" bson:"task_title"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ReadAt    *time.Time         `json:"read_at,omitempty" bson:"read_at,omitempty"`
+}
+
+// DefaultDigestHour is the local hour digests are sent at unless a user
+// picks another.
+const DefaultDigestHour = 8
+
+// Digests go out every day, or once a week on the user's DigestWeekday.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+var DigestFrequencies = []string{DigestDaily, DigestWeekly}
+
+// DigestWeekdays are the days a weekly digest can go out on, indexed by
+// time.Weekday.
+var DigestWeekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+const DefaultDigestWeekday = "monday"
+
+// NotificationPreferences are the channels a user has chosen for each type
+// of notification. Types they haven't chosen a channel for go to both.
+//
+// Users may also ask for a digest email, sent daily or weekly as
+// DigestFrequency says at DigestHour in their Timezone, an IANA name with ""
+// meaning UTC. Weekly digests go out on DigestWeekday. NextDigestAt is when
+// the next one is due, and is nil while the digest is off.
+type NotificationPreferences struct {
+	UserID          primitive.ObjectID `json:"-" bson:"_id"`
+	Channels        map[string]string  `json:"channels" bson:"channels"`
+	Digest          bool               `json:"digest" bson:"digest"`
+	DigestFrequency string             `json:"digest_frequency" bson:"digest_frequency"`
+	DigestWeekday   string             `json:"digest_weekday" bson:"digest_weekday"`
+	Timezone        string             `json:"timezone" bson:"timezone"`
+	DigestHour      int                `json:"digest_hour" bson:"digest_hour"`
+	NextDigestAt    *time.Time         `json:"next_digest_at,omitempty" bson:"next_digest_at,omitempty"`
+	LastDigestAt    *time.Time         `json:"last_digest_at,omitempty" bson:"last_digest_at,omitempty"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// NewNotificationPreferences returns the preferences of a user who has
+// never set any.
+func NewNotificationPreferences(userID primitive.ObjectID) *NotificationPreferences {
+	return &NotificationPreferences{
+		UserID:          userID,
+		Channels:        map[string]string{},
+		DigestFrequency: DigestDaily,
+		DigestWeekday:   DefaultDigestWeekday,
+		DigestHour:      DefaultDigestHour,
+	}
+}
+
+// Channel returns the channel notifications of the given type go to.
+func (p *NotificationPreferences) Channel(kind string) string {
+	if channel, ok := p.Channels[kind]; ok {
+		return channel
+	}
+	return ChannelBoth
+}
+
+// InApp and Email report whether notifications of the given type go to
+// the notification center and by email.
+func (p *NotificationPreferences) InApp(kind string) bool {
+	channel := p.Channel(kind)
+	return channel == ChannelInApp || channel == ChannelBoth
+}
+
+func (p *NotificationPreferences) Email(kind string) bool {
+	channel := p.Channel(kind)
+	return channel == ChannelEmail || channel == ChannelBoth
+}
+
+// Location returns the user's time zone, falling back to UTC if it can no
+// longer be loaded.
+func (p *NotificationPreferences) Location() *time.Location {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ScheduleDigest sets NextDigestAt to the first DigestHour in the user's
+// time zone after now, on DigestWeekday for a weekly digest, or clears it if
+// the digest is off. A digest which was missed, say while the server was
+// down, is not made up.
+func (p *NotificationPreferences) ScheduleDigest(now time.Time) {
+	if !p.Digest {
+		p.NextDigestAt = nil
+		return
+	}
+
+	loc := p.Location()
+	local := now.In(loc)
+	for day := 0; ; day++ {
+		next := time.Date(local.Year(), local.Month(), local.Day()+day, p.DigestHour, 0, 0, 0, loc)
+		if !next.After(now) {
+			continue
+		}
+		if p.DigestFrequency == DigestWeekly && DigestWeekdays[next.Weekday()] != p.DigestWeekday {
+			continue
+		}
+		next = next.UTC()
+		p.NextDigestAt = &next
+		return
+	}
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, prefs *NotificationPreferences) {
+	for kind, channel := range prefs.Channels {
+		v.Check(validator.In(kind, NotificationTypes...), "channels", "must only contain known notification types")
+		v.Check(validator.In(channel, NotificationChannels...), "channels", "must only use in_app, email, both or none")
+	}
+
+	_, err := time.LoadLocation(prefs.Timezone)
+	v.Check(err == nil, "timezone", "must be a known time zone")
+	v.Check(prefs.DigestHour >= 0 && prefs.DigestHour <= 23, "digest_hour", "must be between 0 and 23")
+	v.Check(validator.In(prefs.DigestFrequency, DigestFrequencies...), "digest_frequency", "must be daily or weekly")
+	v.Check(validator.In(prefs.DigestWeekday, DigestWeekdays...), "digest_weekday", "must be a day of the week, such as monday")
+}
+
+type NotificationModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+func (m NotificationModel) Insert(ctx context.Context, notification *Notification) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	notification.ID = primitive.NewObjectID()
+	notification.CreatedAt = time.Now().UTC()
+
+	_, err := m.DB.InsertOne(ctx, notification)
+	return queryError(ctx, err)
+}
+
+// ForUser returns up to limit of the user's notifications older than
+// before, newest first, or only the unread ones. A zero before starts from
+// the newest.
+func (m NotificationModel) ForUser(ctx context.Context, userID, before primitive.ObjectID, unreadOnly bool, limit int) ([]*Notification, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if !before.IsZero() {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+	if unreadOnly {
+		filter["read_at"] = nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	notifications := []*Notification{}
+	err = cursor.All(ctx, &notifications)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return notifications, nil
+}
+
+func (m NotificationModel) UnreadCount(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	n, err := m.DB.CountDocuments(ctx, bson.M{"user_id": userID, "read_at": nil})
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return int(n), nil
+}
+
+// SetRead marks one of the user's notifications as read, keeping the time
+// it was first read, or as unread again. It returns ErrRecordNotFound if
+// the user has no such notification.
+func (m NotificationModel) SetRead(ctx context.Context, userID, id primitive.ObjectID, read bool) (*Notification, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var update interface{} = bson.M{"$unset": bson.M{"read_at": ""}}
+	if read {
+		update = mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"read_at": bson.M{"$ifNull": bson.A{"$read_at", time.Now().UTC()}},
+		}}}}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var notification Notification
+	err := m.DB.FindOneAndUpdate(ctx, bson.M{"_id": id, "user_id": userID}, update, opts).Decode(&notification)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &notification, nil
+}
+
+// MarkAllRead marks all of the user's unread notifications as read,
+// returning how many there were.
+func (m NotificationModel) MarkAllRead(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID, "read_at": nil}
+	result, err := m.DB.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": time.Now().UTC()}})
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+type NotificationPreferenceModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+// Get returns the user's preferences, which are empty if they have never
+// set any.
+func (m NotificationPreferenceModel) Get(ctx context.Context, userID primitive.ObjectID) (*NotificationPreferences, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// Fields missing from documents saved before they existed keep their
+	// defaults.
+	prefs := NewNotificationPreferences(userID)
+	err := m.DB.FindOne(ctx, bson.M{"_id": userID}).Decode(prefs)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return NewNotificationPreferences(userID), nil
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	if prefs.Channels == nil {
+		prefs.Channels = map[string]string{}
+	}
+	return prefs, nil
+}
+
+// Put replaces the user's preferences.
+func (m NotificationPreferenceModel) Put(ctx context.Context, prefs *NotificationPreferences) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	prefs.UpdatedAt = time.Now().UTC()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := m.DB.ReplaceOne(ctx, bson.M{"_id": prefs.UserID}, prefs, opts)
+	return queryError(ctx, err)
+}
+
+// DueDigests returns up to limit preferences of users whose digest is due
+// at now.
+func (m NotificationPreferenceModel) DueDigests(ctx context.Context, now time.Time, limit int) ([]*NotificationPreferences, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"digest": true, "next_digest_at": bson.M{"$lte": now.UTC()}}
+	opts := options.Find().SetSort(bson.D{{Key: "next_digest_at", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	prefs := []*NotificationPreferences{}
+	err = cursor.All(ctx, &prefs)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return prefs, nil
+}
+
+// ClaimDigest records that the user's digest due at now is being sent and
+// schedules the next one for prefs.NextDigestAt. It returns ErrEditConflict
+// if the digest is no longer due, because another instance claimed it first
+// or the user turned it off.
+func (m NotificationPreferenceModel) ClaimDigest(ctx context.Context, prefs *NotificationPreferences, now time.Time) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": prefs.UserID, "digest": true, "next_digest_at": bson.M{"$lte": now.UTC()}}
+	update := bson.M{"$set": bson.M{"next_digest_at": prefs.NextDigestAt, "last_digest_at": now.UTC()}}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLNotificationModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const notificationColumns = `id, user_id, type, actor_id, task_id, project_id, task_title, created_at, read_at`
+
+func (m SQLNotificationModel) Insert(ctx context.Context, notification *Notification) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	notification.ID = primitive.NewObjectID()
+	notification.CreatedAt = time.Now().UTC()
+
+	query := `
+		INSERT INTO notifications (` + notificationColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		notification.ID.Hex(), notification.UserID.Hex(), notification.Type, notification.ActorID.Hex(),
+		notification.TaskID.Hex(), notification.ProjectID.Hex(), notification.TaskTitle,
+		notification.CreatedAt, nullTime(notification.ReadAt),
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLNotificationModel) ForUser(ctx context.Context, userID, before primitive.ObjectID, unreadOnly bool, limit int) ([]*Notification, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notifications
+		WHERE user_id = ?`
+	args := []interface{}{userID.Hex()}
+
+	if !before.IsZero() {
+		query += ` AND id < ?`
+		args = append(args, before.Hex())
+	}
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	query += `
+		ORDER BY id DESC
+		LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, queryError(ctx, rows.Err())
+}
+
+func (m SQLNotificationModel) UnreadCount(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM notifications
+		WHERE user_id = ? AND read_at IS NULL`
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), userID.Hex()).Scan(&n)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return n, nil
+}
+
+func (m SQLNotificationModel) SetRead(ctx context.Context, userID, id primitive.ObjectID, read bool) (*Notification, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE notifications
+		SET read_at = NULL
+		WHERE id = ? AND user_id = ?`
+	args := []interface{}{id.Hex(), userID.Hex()}
+
+	if read {
+		query = `
+			UPDATE notifications
+			SET read_at = COALESCE(read_at, ?)
+			WHERE id = ? AND user_id = ?`
+		args = append([]interface{}{time.Now().UTC()}, args...)
+	}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query = `
+		SELECT ` + notificationColumns + `
+		FROM notifications
+		WHERE id = ?`
+
+	notification, err := scanNotification(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return notification, nil
+}
+
+func (m SQLNotificationModel) MarkAllRead(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE notifications
+		SET read_at = ?
+		WHERE user_id = ? AND read_at IS NULL`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), time.Now().UTC(), userID.Hex())
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), nil
+}
+
+func scanNotification(row rowScanner) (*Notification, error) {
+	var notification Notification
+	var id, userID, actorID, taskID, projectID string
+	var readAt sql.NullTime
+
+	err := row.Scan(
+		&id,
+		&userID,
+		&notification.Type,
+		&actorID,
+		&taskID,
+		&projectID,
+		&notification.TaskTitle,
+		&notification.CreatedAt,
+		&readAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if readAt.Valid {
+		notification.ReadAt = &readAt.Time
+	}
+	for _, f := range []struct {
+		src string
+		dst *primitive.ObjectID
+	}{{id, &notification.ID}, {userID, &notification.UserID}, {actorID, &notification.ActorID}, {taskID, &notification.TaskID}, {projectID, &notification.ProjectID}} {
+		*f.dst, err = primitive.ObjectIDFromHex(f.src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &notification, nil
+}
+
+type SQLNotificationPreferenceModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const notificationPreferenceColumns = `user_id, channels, digest, digest_frequency, digest_weekday, timezone, digest_hour, next_digest_at, last_digest_at, updated_at`
+
+func (m SQLNotificationPreferenceModel) Get(ctx context.Context, userID primitive.ObjectID) (*NotificationPreferences, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + notificationPreferenceColumns + `
+		FROM notification_preferences
+		WHERE user_id = ?`
+
+	prefs, err := scanNotificationPreferences(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), userID.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return NewNotificationPreferences(userID), nil
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return prefs, nil
+}
+
+func (m SQLNotificationPreferenceModel) Put(ctx context.Context, prefs *NotificationPreferences) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	channels, err := json.Marshal(prefs.Channels)
+	if err != nil {
+		return err
+	}
+	if prefs.Channels == nil {
+		channels = []byte("{}")
+	}
+
+	prefs.UpdatedAt = time.Now().UTC()
+
+	query := `
+		INSERT INTO notification_preferences (` + notificationPreferenceColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			channels = excluded.channels, digest = excluded.digest,
+			digest_frequency = excluded.digest_frequency, digest_weekday = excluded.digest_weekday, timezone = excluded.timezone,
+			digest_hour = excluded.digest_hour, next_digest_at = excluded.next_digest_at,
+			last_digest_at = excluded.last_digest_at, updated_at = excluded.updated_at`
+
+	args := []interface{}{
+		prefs.UserID.Hex(), string(channels), prefs.Digest, prefs.DigestFrequency, prefs.DigestWeekday, prefs.Timezone, prefs.DigestHour,
+		nullTime(prefs.NextDigestAt), nullTime(prefs.LastDigestAt), prefs.UpdatedAt,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLNotificationPreferenceModel) DueDigests(ctx context.Context, now time.Time, limit int) ([]*NotificationPreferences, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + notificationPreferenceColumns + `
+		FROM notification_preferences
+		WHERE digest = TRUE AND next_digest_at <= ?
+		ORDER BY next_digest_at
+		LIMIT ?`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), now.UTC(), limit)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	all := []*NotificationPreferences{}
+	for rows.Next() {
+		prefs, err := scanNotificationPreferences(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		all = append(all, prefs)
+	}
+	return all, queryError(ctx, rows.Err())
+}
+
+func (m SQLNotificationPreferenceModel) ClaimDigest(ctx context.Context, prefs *NotificationPreferences, now time.Time) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE notification_preferences
+		SET next_digest_at = ?, last_digest_at = ?
+		WHERE user_id = ? AND digest = TRUE AND next_digest_at <= ?`
+
+	args := []interface{}{nullTime(prefs.NextDigestAt), now.UTC(), prefs.UserID.Hex(), now.UTC()}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+func scanNotificationPreferences(row rowScanner) (*NotificationPreferences, error) {
+	var prefs NotificationPreferences
+	var userID, channels string
+	var nextDigestAt, lastDigestAt sql.NullTime
+
+	err := row.Scan(
+		&userID,
+		&channels,
+		&prefs.Digest,
+		&prefs.DigestFrequency,
+		&prefs.DigestWeekday,
+		&prefs.Timezone,
+		&prefs.DigestHour,
+		&nextDigestAt,
+		&lastDigestAt,
+		&prefs.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(channels), &prefs.Channels); err != nil {
+		return nil, err
+	}
+	if nextDigestAt.Valid {
+		prefs.NextDigestAt = &nextDigestAt.Time
+	}
+	if lastDigestAt.Valid {
+		prefs.LastDigestAt = &lastDigestAt.Time
+	}
+	prefs.UserID, err = primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+var (
+	ErrDuplicateID = errors.New("duplicate id")
+)
+
+type Project struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	OwnerID     primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Version     int32              `json:"version" bson:"version"`
+	Seq         int64              `json:"seq" bson:"seq"`
+	Deleted     bool               `json:"deleted,omitempty" bson:"deleted"`
+}
+
+func ValidateProject(v *validator.Validator, project *Project) {
+	v.Check(project.Name != "", "name", "must be provided")
+	v.Check(len(project.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(project.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+}
+
+type ProjectModel struct {
+	DB       *mongo.Collection
+	Counters *mongo.Collection
+	Timeout  time.Duration
+}
+
+func (m ProjectModel) Insert(ctx context.Context, project *Project) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextMongoSeq(ctx, m.Counters)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseMongoSeq(ctx, m.Counters, seq)
+
+	if project.ID.IsZero() {
+		project.ID = primitive.NewObjectID()
+	}
+	project.CreatedAt = time.Now().UTC()
+	project.UpdatedAt = project.CreatedAt
+	project.Version = 1
+	project.Seq = seq
+	project.Deleted = false
+
+	_, err = m.DB.InsertOne(ctx, project)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateID
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m ProjectModel) Get(ctx context.Context, id primitive.ObjectID) (*Project, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var project Project
+	err := m.DB.FindOne(ctx, bson.M{"_id": id, "deleted": false}).Decode(&project)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &project, nil
+}
+
+// Update saves the project if it is still at project.Version, returning
+// ErrEditConflict otherwise, and advances the version and change sequence.
+func (m ProjectModel) Update(ctx context.Context, project *Project) error {
+	return m.write(ctx, project, bson.M{
+		"name":        project.Name,
+		"description": project.Description,
+	})
+}
+
+// Delete replaces the project with a tombstone, so the deletion is still
+// visible to clients syncing from an older cursor.
+func (m ProjectModel) Delete(ctx context.Context, project *Project) error {
+	err := m.write(ctx, project, bson.M{"deleted": true})
+	if err != nil {
+		return err
+	}
+	project.Deleted = true
+	return nil
+}
+
+func (m ProjectModel) write(ctx context.Context, project *Project, fields bson.M) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextMongoSeq(ctx, m.Counters)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseMongoSeq(ctx, m.Counters, seq)
+
+	fields["updated_at"] = time.Now().UTC()
+	fields["version"] = project.Version + 1
+	fields["seq"] = seq
+
+	filter := bson.M{
+		"_id":     project.ID,
+		"version": project.Version,
+		"deleted": false,
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated Project
+	err = m.DB.FindOneAndUpdate(ctx, filter, bson.M{"$set": fields}, opts).Decode(&updated)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	project.UpdatedAt = updated.UpdatedAt
+	project.Version = updated.Version
+	project.Seq = updated.Seq
+	return nil
+}
+
+// IDsForWorkspaces returns the ids of every project in the workspaces,
+// including deleted ones, so that the tombstones of their tasks can still be
+// synced.
+func (m ProjectModel) IDsForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	ids, err := m.DB.Distinct(ctx, "_id", bson.M{"workspace_id": bson.M{"$in": workspaceIDs}})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	projectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			projectIDs = append(projectIDs, oid)
+		}
+	}
+	return projectIDs, nil
+}
+
+// ChangesSince returns up to limit of the workspaces' projects, including
+// tombstones, which changed after the given sequence, oldest change first.
+func (m ProjectModel) ChangesSince(ctx context.Context, workspaceIDs []primitive.ObjectID, since int64, limit int) ([]*Project, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{
+		"workspace_id": bson.M{"$in": workspaceIDs},
+		"seq":          bson.M{"$gt": since},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	projects := []*Project{}
+	err = cursor.All(ctx, &projects)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return projects, nil
+}
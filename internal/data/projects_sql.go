@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLProjectModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const projectColumns = `id, workspace_id, owner_id, created_at, updated_at, name, description, version, seq, deleted`
+
+func (m SQLProjectModel) Insert(ctx context.Context, project *Project) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextSQLSeq(ctx, m.DB, m.Dialect)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseSQLSeq(ctx, m.DB, m.Dialect, seq)
+
+	if project.ID.IsZero() {
+		project.ID = primitive.NewObjectID()
+	}
+	project.CreatedAt = time.Now().UTC()
+	project.UpdatedAt = project.CreatedAt
+	project.Version = 1
+	project.Seq = seq
+	project.Deleted = false
+
+	query := `
+		INSERT INTO projects (` + projectColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		project.ID.Hex(), project.WorkspaceID.Hex(), project.OwnerID.Hex(), project.CreatedAt, project.UpdatedAt,
+		project.Name, project.Description, project.Version, project.Seq, project.Deleted,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateID
+		}
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLProjectModel) Get(ctx context.Context, id primitive.ObjectID) (*Project, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + projectColumns + `
+		FROM projects
+		WHERE id = ? AND deleted = FALSE`
+
+	project, err := scanProject(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return project, nil
+}
+
+func (m SQLProjectModel) Update(ctx context.Context, project *Project) error {
+	return m.write(ctx, project, "name = ?, description = ?", project.Name, project.Description)
+}
+
+func (m SQLProjectModel) Delete(ctx context.Context, project *Project) error {
+	err := m.write(ctx, project, "deleted = ?", true)
+	if err != nil {
+		return err
+	}
+	project.Deleted = true
+	return nil
+}
+
+func (m SQLProjectModel) write(ctx context.Context, project *Project, set string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextSQLSeq(ctx, m.DB, m.Dialect)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseSQLSeq(ctx, m.DB, m.Dialect, seq)
+
+	updatedAt := time.Now().UTC()
+
+	query := `
+		UPDATE projects
+		SET ` + set + `, updated_at = ?, version = version + 1, seq = ?
+		WHERE id = ? AND version = ? AND deleted = FALSE`
+
+	args = append(args, updatedAt, seq, project.ID.Hex(), project.Version)
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	project.UpdatedAt = updatedAt
+	project.Version++
+	project.Seq = seq
+	return nil
+}
+
+func (m SQLProjectModel) IDsForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if len(workspaceIDs) == 0 {
+		return []primitive.ObjectID{}, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT id
+		FROM projects
+		WHERE workspace_id IN (` + placeholders(len(workspaceIDs)) + `)`
+
+	ids, err := queryIDs(ctx, m.DB, m.Dialect.rebind(query), hexIDs(workspaceIDs)...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return ids, nil
+}
+
+func (m SQLProjectModel) ChangesSince(ctx context.Context, workspaceIDs []primitive.ObjectID, since int64, limit int) ([]*Project, error) {
+	if len(workspaceIDs) == 0 {
+		return []*Project{}, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + projectColumns + `
+		FROM projects
+		WHERE workspace_id IN (` + placeholders(len(workspaceIDs)) + `) AND seq > ?
+		ORDER BY seq
+		LIMIT ?`
+
+	args := append(hexIDs(workspaceIDs), since, limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		projects = append(projects, project)
+	}
+	return projects, queryError(ctx, rows.Err())
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProject(row rowScanner) (*Project, error) {
+	var project Project
+	var id, workspaceID, ownerID string
+
+	err := row.Scan(
+		&id,
+		&workspaceID,
+		&ownerID,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+		&project.Name,
+		&project.Description,
+		&project.Version,
+		&project.Seq,
+		&project.Deleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	project.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	project.WorkspaceID, err = primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	project.OwnerID, err = primitive.ObjectIDFromHex(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// placeholders returns n comma separated ? placeholders for an IN clause.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func hexIDs(ids []primitive.ObjectID) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id.Hex()
+	}
+	return args
+}
@@ -0,0 +1,111 @@
+package data
+
+import "strings"
+
+// Tasks are ordered within a board column by rank: a string of base 36
+// digits read as a fraction, so that there is always room for another rank
+// between any two and moving a task only ever rewrites that task. Ranks
+// compare as plain byte strings, which is how every backend sorts them.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// rankWidth is the number of leading digits which RankBetween steps by one
+// when placing a task at either end of a column, so that ranks handed out
+// one after another stay short.
+const rankWidth = 6
+
+// firstRank is the rank of the first task in a project, halfway through the
+// range to leave room on either side.
+const firstRank = "i00000"
+
+// RankBetween returns a rank which sorts after before and ahead of after.
+// Either may be empty to stand for the start or end of the column. There is
+// no such rank if before doesn't sort ahead of after, which happens when
+// tasks end up sharing a rank, or if after is the lowest rank there is; the
+// result then sorts after both, and the column needs fresh ranks from
+// SpreadRanks before a task can be placed there.
+func RankBetween(before, after string) string {
+	switch {
+	case before == "" && after == "":
+		return firstRank
+	case after == "":
+		if rank, ok := stepRank(before, 1); ok {
+			return rank
+		}
+	case before == "":
+		if rank, ok := stepRank(after, -1); ok {
+			return rank
+		}
+	}
+	return midRank(before, after)
+}
+
+// SpreadRanks returns n ranks in order, one step apart from firstRank on,
+// for re-ranking a whole column.
+func SpreadRanks(n int) []string {
+	ranks := make([]string, 0, n)
+	rank := firstRank
+	for i := 0; i < n; i++ {
+		ranks = append(ranks, rank)
+		rank, _ = stepRank(rank, 1)
+	}
+	return ranks
+}
+
+// stepRank adds delta, which is 1 or -1, to the first rankWidth digits of
+// rank. It reports false if they would overflow or underflow.
+func stepRank(rank string, delta int) (string, bool) {
+	digits := make([]int, rankWidth)
+	for i := range digits {
+		digits[i] = rankDigit(rank, i)
+	}
+
+	for i := rankWidth - 1; i >= 0; i-- {
+		digits[i] += delta
+		if digits[i] >= 0 && digits[i] < len(rankDigits) {
+			var b strings.Builder
+			for _, d := range digits {
+				b.WriteByte(rankDigits[d])
+			}
+			return b.String(), true
+		}
+		digits[i] = (digits[i] + len(rankDigits)) % len(rankDigits)
+	}
+	return "", false
+}
+
+// midRank returns a rank roughly halfway between before and after, with an
+// empty after standing for the end of the range. The result never ends in
+// a zero digit, which would make it equal to a shorter rank.
+func midRank(before, after string) string {
+	var rank []byte
+	bounded := after != ""
+
+	for i := 0; ; i++ {
+		lo := rankDigit(before, i)
+		hi := len(rankDigits)
+		if bounded && (i < len(before) || i < len(after)) {
+			hi = rankDigit(after, i)
+		}
+
+		if hi-lo > 1 {
+			return string(append(rank, rankDigits[(lo+hi)/2]))
+		}
+		rank = append(rank, rankDigits[lo])
+		// Once the rank is below after at this digit, anything may follow.
+		if hi > lo {
+			bounded = false
+		}
+	}
+}
+
+// rankDigit is the value of the ith digit of rank, which is zero past its
+// end.
+func rankDigit(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	if d := strings.IndexByte(rankDigits, rank[i]); d >= 0 {
+		return d
+	}
+	return 0
+}
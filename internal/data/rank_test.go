@@ -0,0 +1,111 @@
+package data_test
+
+import (
+	"testing"
+
+	"tasksync/internal/data"
+)
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{"empty column", "", "", "i00000"},
+		{"end of column", "i00000", "", "i00001"},
+		{"start of column", "", "i00000", "hzzzzz"},
+		{"carry at the end", "i0000z", "", "i00010"},
+		{"between", "i00000", "i00002", "i00001"},
+		{"between adjacent", "i00000", "i00001", "i00000i"},
+		{"between, shorter after", "i00000i", "i00001", "i00000r"},
+		{"between, longer after", "i00000", "i000001", "i000000i"},
+		{"end of range", "zzzzzz", "", "zzzzzzi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := data.RankBetween(tt.before, tt.after)
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+			if got <= tt.before || (tt.after != "" && got >= tt.after) {
+				t.Errorf("got %q, which isn't between %q and %q", got, tt.before, tt.after)
+			}
+		})
+	}
+}
+
+// TestRankBetweenNoRoom checks that RankBetween's result is recognisably out
+// of place when there's no rank between its arguments.
+func TestRankBetweenNoRoom(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+	}{
+		{"equal", "i00000", "i00000"},
+		{"out of order", "i00001", "i00000"},
+		{"below the lowest rank", "", "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := data.RankBetween(tt.before, tt.after)
+			if got > tt.before && got < tt.after {
+				t.Errorf("got %q between %q and %q", got, tt.before, tt.after)
+			}
+		})
+	}
+}
+
+// TestRankBetweenRepeated checks that ranks stay in order however many tasks
+// are placed at the same spot.
+func TestRankBetweenRepeated(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		place func(before, after string) (string, string)
+	}{
+		{"appended", func(before, after string) (string, string) { return after, "" }},
+		{"prepended", func(before, after string) (string, string) { return "", before }},
+		{"after the first", func(before, after string) (string, string) { return before, after }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := "i00000", "i00001"
+			for i := 0; i < 200; i++ {
+				lo, hi := tt.place(before, after)
+				rank := data.RankBetween(lo, hi)
+				if rank <= lo || (hi != "" && rank >= hi) {
+					t.Fatalf("placing %d: got %q, which isn't between %q and %q", i, rank, lo, hi)
+				}
+				switch {
+				case hi == "":
+					after = rank
+				case lo == "":
+					before = rank
+				default:
+					after = rank
+				}
+			}
+		})
+	}
+}
+
+func TestSpreadRanks(t *testing.T) {
+	ranks := data.SpreadRanks(40)
+	if len(ranks) != 40 {
+		t.Fatalf("got %d ranks; want 40", len(ranks))
+	}
+	if ranks[0] != "i00000" {
+		t.Errorf("got %q first; want %q", ranks[0], "i00000")
+	}
+	for i := 1; i < len(ranks); i++ {
+		if ranks[i] <= ranks[i-1] {
+			t.Errorf("got %q after %q", ranks[i], ranks[i-1])
+		}
+		if rank := data.RankBetween(ranks[i-1], ranks[i]); rank <= ranks[i-1] || rank >= ranks[i] {
+			t.Errorf("got no room between %q and %q", ranks[i-1], ranks[i])
+		}
+	}
+}
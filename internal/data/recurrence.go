@@ -0,0 +1,105 @@
+package data
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tasksync/internal/validator"
+)
+
+var (
+	recurrenceFrequencies = []string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}
+	recurrenceWeekdays    = []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
+	recurrenceByDayRX = regexp.MustCompile(`^([+-]?[1-9][0-9]?)?(MO|TU|WE|TH|FR|SA|SU)$`)
+)
+
+// ValidateRecurrence checks a task's recurrence, which is the value of an
+// iCalendar RRULE such as FREQ=WEEKLY;BYDAY=MO,WE. Tasks recur by whole
+// days at the finest, so only the DAILY, WEEKLY, MONTHLY and YEARLY
+// frequencies are accepted, along with the rule parts which make sense for
+// them.
+func ValidateRecurrence(v *validator.Validator, rule string) {
+	v.Check(len(rule) <= 500, "recurrence", "must not be more than 500 bytes long")
+	v.Check(validRecurrence(rule), "recurrence", "must be a valid RRULE, such as FREQ=WEEKLY;BYDAY=MO")
+}
+
+func validRecurrence(rule string) bool {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return false
+		}
+		if _, exists := parts[key]; exists {
+			return false
+		}
+		parts[key] = value
+	}
+
+	if !validator.In(parts["FREQ"], recurrenceFrequencies...) {
+		return false
+	}
+	if parts["COUNT"] != "" && parts["UNTIL"] != "" {
+		return false
+	}
+
+	for key, value := range parts {
+		var ok bool
+		switch key {
+		case "FREQ":
+			ok = true
+		case "INTERVAL", "COUNT":
+			ok = recurrenceInts(value, 1, 1000, false)
+		case "UNTIL":
+			_, err := parseRecurrenceUntil(value)
+			ok = err == nil
+		case "BYDAY":
+			ok = true
+			for _, day := range strings.Split(value, ",") {
+				ok = ok && recurrenceByDayRX.MatchString(day)
+			}
+		case "BYMONTHDAY":
+			ok = recurrenceInts(value, 1, 31, true)
+		case "BYMONTH":
+			ok = recurrenceInts(value, 1, 12, false)
+		case "BYSETPOS":
+			ok = recurrenceInts(value, 1, 366, true)
+		case "WKST":
+			ok = validator.In(value, recurrenceWeekdays...)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// recurrenceInts reports whether value is a comma-separated list of
+// integers between min and max, or their negatives too when signed.
+func recurrenceInts(value string, min, max int, signed bool) bool {
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return false
+		}
+		if signed && n < 0 {
+			n = -n
+		}
+		if n < min || n > max {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRecurrenceUntil reads the UNTIL part of a rule, which is either a
+// date or a UTC date and time in iCalendar's basic format.
+func parseRecurrenceUntil(value string) (time.Time, error) {
+	if len(value) == len("20060102") {
+		return time.Parse("20060102", value)
+	}
+	return time.Parse("20060102T150405Z", value)
+}
@@ -0,0 +1,330 @@
+package data
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/search"
+	"tasksync/internal/validator"
+)
+
+const (
+	SearchTypeTask    = "task"
+	SearchTypeProject = "project"
+	SearchTypeComment = "comment"
+)
+
+// searchCandidateLimit caps the tasks, and separately the comments, a
+// search ranks when a backend can't narrow them down to those having every
+// word of the query. Mongo's text search keeps the best candidates, and a
+// search without words, whose results all score the same, the most recent.
+const searchCandidateLimit = 10_000
+
+// searchWeights make a match in a title or name count for more than one in
+// a description or a comment.
+var searchWeights = map[string]float64{"title": 3, "name": 3, "description": 1, "body": 1}
+
+// SearchResult is one task, project or comment found by a search.
+type SearchResult struct {
+	Type    string   `json:"type"`
+	Score   float64  `json:"score"`
+	Task    *Task    `json:"task,omitempty"`
+	Project *Project `json:"project,omitempty"`
+	Comment *Comment `json:"comment,omitempty"`
+}
+
+// SearchQuery is a parsed search. Terms are the text to look for, each
+// either in any field or scoped to title, name, description or body, the
+// last being a comment's. The rest come from qualifiers: type:task,
+// type:project or type:comment, status:, priority:, and project:, which
+// matches project names as a phrase would.
+type SearchQuery struct {
+	Terms      []search.Term
+	Types      []string
+	Statuses   []string
+	Priorities []int32
+	Projects   []search.Term
+}
+
+// searchStatuses are the values status: accepts, with open and closed
+// standing for more than one status.
+var searchStatuses = map[string][]string{
+	"open":        {TaskStatusTodo, TaskStatusInProgress},
+	"closed":      {TaskStatusDone},
+	"todo":        {TaskStatusTodo},
+	"in_progress": {TaskStatusInProgress},
+	"in-progress": {TaskStatusInProgress},
+	"done":        {TaskStatusDone},
+}
+
+// ParseSearchQuery reads a search query, recording any problem with it in v
+// under the q key.
+func ParseSearchQuery(v *validator.Validator, q string) SearchQuery {
+	var query SearchQuery
+
+	v.Check(strings.TrimSpace(q) != "", "q", "must be provided")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+	if !v.Valid() {
+		return query
+	}
+
+	terms, err := search.Parse(q)
+	if err != nil {
+		v.AddError("q", err.Error())
+		return query
+	}
+
+	for _, term := range terms {
+		value := strings.ToLower(term.Raw)
+
+		switch term.Field {
+		case "", "title", "name", "description", "body":
+			query.Terms = append(query.Terms, term)
+		case "type":
+			v.Check(validator.In(value, SearchTypeTask, SearchTypeProject, SearchTypeComment), "q", "must only use type:task, type:project or type:comment")
+			query.Types = append(query.Types, value)
+		case "status":
+			statuses, ok := searchStatuses[value]
+			v.Check(ok, "q", "must only use status:open, status:closed or a task status")
+			query.Statuses = append(query.Statuses, statuses...)
+		case "priority":
+			priority, ok := ParseTaskPriority(value)
+			v.Check(ok && priority >= TaskPriorityNone && priority <= TaskPriorityHigh, "q", "must only use priority:none, low, medium or high")
+			query.Priorities = append(query.Priorities, priority)
+		case "project":
+			term.Field = "name"
+			query.Projects = append(query.Projects, term)
+		default:
+			v.AddError("q", "must not use the unknown qualifier "+term.Field+":")
+		}
+	}
+
+	if v.Valid() && len(query.Terms) == 0 && len(query.Types)+len(query.Statuses)+len(query.Priorities)+len(query.Projects) == 0 {
+		v.AddError("q", "must contain something to search for")
+	}
+	return query
+}
+
+// wants reports whether the query can return results of the given type.
+// Qualifiers which only apply to tasks rule out projects and comments.
+func (q SearchQuery) wants(resultType string) bool {
+	if len(q.Types) > 0 && !validator.In(resultType, q.Types...) {
+		return false
+	}
+	if resultType != SearchTypeTask {
+		return len(q.Statuses) == 0 && len(q.Priorities) == 0
+	}
+	return true
+}
+
+// idsOf returns the ids of the projects.
+func idsOf(projects []*Project) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(projects))
+	for i, project := range projects {
+		ids[i] = project.ID
+	}
+	return ids
+}
+
+// taskFilters returns the filters for the tasks a query could match among
+// the given projects.
+func (q SearchQuery) taskFilters(projectIDs []primitive.ObjectID) Filters {
+	return Filters{ProjectIDs: projectIDs, Statuses: q.Statuses, Priorities: q.Priorities}
+}
+
+// narrowProjects keeps the projects which project: qualifiers allow, if
+// there are any; a project has only to match one of them.
+func (q SearchQuery) narrowProjects(projects []*Project) []*Project {
+	if len(q.Projects) == 0 {
+		return projects
+	}
+
+	ix := search.NewIndex(nil)
+	byID := make(map[string]*Project, len(projects))
+	for _, project := range projects {
+		ix.Add(project.ID.Hex(), map[string]string{"name": project.Name})
+		byID[project.ID.Hex()] = project
+	}
+
+	seen := make(map[string]bool)
+	var narrowed []*Project
+	for _, term := range q.Projects {
+		for _, hit := range ix.Search([]search.Term{term}) {
+			if !seen[hit.ID] {
+				seen[hit.ID] = true
+				narrowed = append(narrowed, byID[hit.ID])
+			}
+		}
+	}
+	return narrowed
+}
+
+// rankSearch ranks the candidate tasks, projects and comments against the
+// query, returning the best limit of them. Every backend ranks the same way,
+// so that they agree on results however they find the candidates.
+func rankSearch(q SearchQuery, tasks []*Task, projects []*Project, comments []*Comment, limit int) []*SearchResult {
+	ix := search.NewIndex(searchWeights)
+	results := make(map[string]*SearchResult, len(tasks)+len(projects)+len(comments))
+
+	for _, task := range tasks {
+		id := "t" + task.ID.Hex()
+		ix.Add(id, map[string]string{"title": task.Title, "description": task.Description})
+		results[id] = &SearchResult{Type: SearchTypeTask, Task: task}
+	}
+	if q.wants(SearchTypeProject) {
+		for _, project := range projects {
+			id := "p" + project.ID.Hex()
+			ix.Add(id, map[string]string{"name": project.Name, "description": project.Description})
+			results[id] = &SearchResult{Type: SearchTypeProject, Project: project}
+		}
+	}
+	for _, comment := range comments {
+		id := "c" + comment.ID.Hex()
+		ix.Add(id, map[string]string{"body": comment.Body})
+		results[id] = &SearchResult{Type: SearchTypeComment, Comment: comment}
+	}
+
+	hits := ix.Search(q.Terms)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	ranked := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		result := results[hit.ID]
+		result.Score = hit.Score
+		ranked = append(ranked, result)
+	}
+	return ranked
+}
+
+// textSearch returns the words and phrases of the query in the form MongoDB
+// text search takes, leaving out prefixes, which it can't match. It is
+// empty if the query has nothing it can use.
+func (q SearchQuery) textSearch() string {
+	var parts []string
+	for _, term := range q.Terms {
+		switch {
+		case term.Prefix && len(term.Words) == 1:
+			continue
+		case term.Prefix:
+			parts = append(parts, `"`+strings.Join(term.Words[:len(term.Words)-1], " ")+`"`)
+		case len(term.Words) == 1:
+			parts = append(parts, term.Words[0])
+		case len(term.Words) > 1:
+			parts = append(parts, `"`+strings.Join(term.Words, " ")+`"`)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchesNothing reports whether the query has a term without any words,
+// such as title:"!", which nothing can match.
+func (q SearchQuery) matchesNothing() bool {
+	for _, term := range q.Terms {
+		if len(term.Words) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchModel finds candidate tasks and comments with the text indexes on
+// their collections when the query has words it can use, and ranks them
+// with the rest.
+type SearchModel struct {
+	Tasks    *mongo.Collection
+	Projects *mongo.Collection
+	Comments *mongo.Collection
+	Timeout  time.Duration
+}
+
+// Search finds the tasks, projects and comments among the given projects
+// which match the query, best first.
+func (m SearchModel) Search(ctx context.Context, q SearchQuery, projectIDs []primitive.ObjectID, limit int) ([]*SearchResult, error) {
+	if len(projectIDs) == 0 || q.matchesNothing() {
+		return []*SearchResult{}, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	cursor, err := m.Projects.Find(ctx, bson.M{"_id": bson.M{"$in": projectIDs}, "deleted": false})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	projects := []*Project{}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	projects = q.narrowProjects(projects)
+
+	tasks := []*Task{}
+	if q.wants(SearchTypeTask) && len(projects) > 0 {
+		filter := q.taskFilters(idsOf(projects)).mongoFilter()
+		opts := q.textSearchOptions(filter, "updated_at", "title", "description")
+
+		cursor, err := m.Tasks.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		if err := cursor.All(ctx, &tasks); err != nil {
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	comments := []*Comment{}
+	if q.wants(SearchTypeComment) && len(projects) > 0 {
+		filter := bson.M{"project_id": bson.M{"$in": idsOf(projects)}}
+		opts := q.textSearchOptions(filter, "created_at", "body")
+
+		cursor, err := m.Comments.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		if err := cursor.All(ctx, &comments); err != nil {
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return rankSearch(q, tasks, projects, comments, limit), nil
+}
+
+// textSearchOptions adds the query's text search to filter, if it has one,
+// and returns options keeping the best searchCandidateLimit matches. Text
+// search can't match prefixes, so a query made only of them instead needs
+// each one in one of the fields, case aside, which takes in every match.
+// Without any words the documents latest by the recent field are kept.
+func (q SearchQuery) textSearchOptions(filter bson.M, recent string, fields ...string) *options.FindOptions {
+	opts := options.Find()
+	if text := q.textSearch(); text != "" {
+		filter["$text"] = bson.M{"$search": text}
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}})
+		opts.SetSort(bson.M{"score": bson.M{"$meta": "textScore"}})
+		return opts.SetLimit(searchCandidateLimit)
+	}
+
+	opts.SetSort(bson.D{{Key: recent, Value: -1}})
+	if len(q.Terms) == 0 {
+		return opts.SetLimit(searchCandidateLimit)
+	}
+
+	var prefixes bson.A
+	for _, term := range q.Terms {
+		for _, word := range term.Words {
+			var in bson.A
+			for _, field := range fields {
+				in = append(in, bson.M{field: bson.M{"$regex": regexp.QuoteMeta(word), "$options": "i"}})
+			}
+			prefixes = append(prefixes, bson.M{"$or": in})
+		}
+	}
+	filter["$and"] = prefixes
+	return opts
+}
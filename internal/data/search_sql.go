@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/search"
+)
+
+// SQLSearchModel has no text index to lean on. Instead tasks and comments
+// keep their words, as the ranking splits them, in a search_words column,
+// which narrows the candidates down to those having every word of the
+// query before they are ranked.
+type SQLSearchModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+// Search finds the tasks, projects and comments among the given projects
+// which match the query, best first.
+func (m SQLSearchModel) Search(ctx context.Context, q SearchQuery, projectIDs []primitive.ObjectID, limit int) ([]*SearchResult, error) {
+	if len(projectIDs) == 0 || q.matchesNothing() {
+		return []*SearchResult{}, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + projectColumns + `
+		FROM projects
+		WHERE id IN (` + placeholders(len(projectIDs)) + `) AND deleted = FALSE`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), hexIDs(projectIDs)...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		projects = append(projects, project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	projects = q.narrowProjects(projects)
+
+	words, wordArgs := q.sqlWordsWhere()
+
+	tasks := []*Task{}
+	if q.wants(SearchTypeTask) && len(projects) > 0 {
+		where, args := q.taskFilters(idsOf(projects)).sqlWhere()
+
+		query := `
+			SELECT ` + taskColumns + `
+			FROM tasks
+			WHERE ` + where + words + `
+			ORDER BY updated_at DESC`
+
+		args = append(args, wordArgs...)
+		if len(q.Terms) == 0 {
+			query += ` LIMIT ?`
+			args = append(args, searchCandidateLimit)
+		}
+
+		rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				return nil, queryError(ctx, err)
+			}
+			tasks = append(tasks, task)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	comments := []*Comment{}
+	if q.wants(SearchTypeComment) && len(projects) > 0 {
+		ids := idsOf(projects)
+
+		query := `
+			SELECT ` + commentColumns + `
+			FROM comments
+			WHERE project_id IN (` + placeholders(len(ids)) + `)` + words + `
+			ORDER BY created_at DESC`
+
+		args := append(hexIDs(ids), wordArgs...)
+		if len(q.Terms) == 0 {
+			query += ` LIMIT ?`
+			args = append(args, searchCandidateLimit)
+		}
+
+		rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			comment, err := scanComment(rows)
+			if err != nil {
+				return nil, queryError(ctx, err)
+			}
+			comments = append(comments, comment)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return rankSearch(q, tasks, projects, comments, limit), nil
+}
+
+// searchWords returns the words of the texts for the search_words column:
+// each distinct word once, with a space on either side, so that a LIKE
+// pattern can match a whole word or the start of one. Words are only ever
+// letters and digits, so they never contain LIKE's wildcards.
+func searchWords(texts ...string) string {
+	var b strings.Builder
+	b.WriteByte(' ')
+
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, word := range search.Tokenize(text) {
+			if !seen[word] {
+				seen[word] = true
+				b.WriteString(word)
+				b.WriteByte(' ')
+			}
+		}
+	}
+	return b.String()
+}
+
+// sqlWordsWhere returns the conditions, to be added to a WHERE clause, that
+// search_words has every word of the query. Fields are left to the ranking,
+// as are the order of a phrase's words.
+func (q SearchQuery) sqlWordsWhere() (string, []interface{}) {
+	var where string
+	var args []interface{}
+	for _, term := range q.Terms {
+		for i, word := range term.Words {
+			where += ` AND search_words LIKE ?`
+			if term.Prefix && i == len(term.Words)-1 {
+				args = append(args, "% "+word+"%")
+			} else {
+				args = append(args, "% "+word+" %")
+			}
+		}
+	}
+	return where, args
+}
+
+// backfillSearchWords fills in search_words for the tasks and comments
+// written before the column was added.
+func backfillSearchWords(ctx context.Context, tx *sql.Tx, dialect Dialect) error {
+	for _, table := range []struct{ name, text string }{
+		{"tasks", "title, description"},
+		{"comments", "body, ''"},
+	} {
+		// Rows are read in batches by id, since they can't be updated
+		// while still being read.
+		after := ""
+		for {
+			query := `
+				SELECT id, ` + table.text + `
+				FROM ` + table.name + `
+				WHERE id > ?
+				ORDER BY id
+				LIMIT 1000`
+
+			rows, err := tx.QueryContext(ctx, dialect.rebind(query), after)
+			if err != nil {
+				return err
+			}
+
+			words := make(map[string]string)
+			for rows.Next() {
+				var id, first, second string
+				if err := rows.Scan(&id, &first, &second); err != nil {
+					rows.Close()
+					return err
+				}
+				words[id] = searchWords(first, second)
+				after = id
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if len(words) == 0 {
+				break
+			}
+
+			for id, w := range words {
+				query := `UPDATE ` + table.name + ` SET search_words = ? WHERE id = ?`
+				if _, err := tx.ExecContext(ctx, dialect.rebind(query), w, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+	"tasksync/internal/validator"
+)
+
+func newSearchTestModels(t *testing.T) (*sql.DB, data.Models) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	if err := (data.SQLMigrator{DB: db, Dialect: data.DialectSQLite}).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db, data.NewSQLModels(db, data.DialectSQLite, 0)
+}
+
+func insertSearchTestProject(t *testing.T, m data.Models) *data.Project {
+	t.Helper()
+	ctx := context.Background()
+
+	user := &data.User{Name: "Search", Email: "search@example.com"}
+	if err := user.SetPassword("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	project := &data.Project{WorkspaceID: user.ID, OwnerID: user.ID, Name: "Search"}
+	if err := m.Projects.Insert(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	return project
+}
+
+func searchTest(t *testing.T, m data.Models, project *data.Project, q string) []*data.SearchResult {
+	t.Helper()
+
+	v := validator.New()
+	query := data.ParseSearchQuery(v, q)
+	if !v.Valid() {
+		t.Fatalf("query %q: %v", q, v.Errors)
+	}
+	results, err := m.Search.Search(context.Background(), query, []primitive.ObjectID{project.ID}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// TestSQLSearchOldMatches checks that the SQL backends rank every task
+// matching a query, however many more recent ones match too.
+func TestSQLSearchOldMatches(t *testing.T) {
+	_, m := newSearchTestModels(t)
+	project := insertSearchTestProject(t, m)
+	ctx := context.Background()
+
+	// The oldest task is the best match, its title being the shortest.
+	old := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Needle", Status: data.TaskStatusDone}
+	if err := m.Tasks.Insert(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.WithTransaction(ctx, func(ctx context.Context, tx data.Models) error {
+		for i := 0; i < 10_000; i++ {
+			task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Needle in the hay", Status: data.TaskStatusTodo}
+			if err := tx.Tasks.Insert(ctx, task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"needle", "need*", "title:needle", "needle status:closed"} {
+		results := searchTest(t, m, project, q)
+		if len(results) == 0 || results[0].Task == nil || results[0].Task.ID != old.ID {
+			t.Errorf("%s: got %d results without the oldest task first", q, len(results))
+		}
+	}
+
+	if results := searchTest(t, m, project, "needle haystack"); len(results) != 0 {
+		t.Errorf("got %d results for a word no task has; want none", len(results))
+	}
+}
+
+// TestSQLSearchBackfill checks that migrating fills in the words of tasks
+// and comments written before search_words was added.
+func TestSQLSearchBackfill(t *testing.T) {
+	db, m := newSearchTestModels(t)
+	project := insertSearchTestProject(t, m)
+	ctx := context.Background()
+
+	task := &data.Task{ProjectID: project.ID, CreatedBy: project.OwnerID, Title: "Quarterly report", Description: "Draft the summary", Status: data.TaskStatusTodo}
+	if err := m.Tasks.Insert(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	comment := &data.Comment{TaskID: task.ID, ProjectID: project.ID, AuthorID: project.OwnerID, Body: "Figures attached"}
+	if err := m.Comments.Insert(ctx, comment); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := data.SQLMigrator{DB: db, Dialect: data.DialectSQLite}
+	if err := migrator.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"summary", "quarter*", "figures"} {
+		if results := searchTest(t, m, project, q); len(results) != 1 {
+			t.Errorf("%s: got %d results after migrating; want 1", q, len(results))
+		}
+	}
+}
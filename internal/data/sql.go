@@ -0,0 +1,55 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Dialect int
+
+const (
+	DialectSQLite Dialect = iota
+	DialectPostgres
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case DialectSQLite:
+		return "sqlite"
+	case DialectPostgres:
+		return "postgres"
+	default:
+		return ""
+	}
+}
+
+// rebind rewrites the ? placeholders used by the SQL models into the
+// positional $N form expected by Postgres.
+func (d Dialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (d Dialect) isUniqueViolation(err error) bool {
+	switch d {
+	case DialectSQLite:
+		return strings.Contains(err.Error(), "UNIQUE constraint failed")
+	case DialectPostgres:
+		return strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+	default:
+		return false
+	}
+}
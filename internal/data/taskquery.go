@@ -0,0 +1,217 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ParseTaskQuery compiles a task query into the filters for listing tasks,
+// leaving ProjectIDs for the caller to fill in. A query is a list of
+// conditions separated by spaces, all of which a task must meet:
+//
+//	status:todo,in_progress  status:open  status:closed
+//	priority:high,medium     priority:3
+//	assignee:me              assignee:<user id>
+//	label:<label id>
+//	due:<7d  due:>=2024-06-01  due:2024-06-01  due:3d
+//	due:today  due:overdue  due:none  due:any
+//
+// A status or priority condition starting with - excludes the tasks it
+// matches instead. Due dates are either calendar dates, taken as whole days
+// in now's time zone, which should be the user's, or hours (h), days (d) or
+// weeks (w) from now; a bare relative date such as due:3d means due between
+// now and then. Overdue tasks are those due before now which aren't done. Assignee conditions match tasks
+// assigned to any of the users they name, with me standing for userID, and
+// label conditions tasks carrying any of the labels they name.
+func ParseTaskQuery(query string, userID primitive.ObjectID, now time.Time) (Filters, error) {
+	var filters Filters
+
+	for _, condition := range strings.Fields(query) {
+		field, value, ok := strings.Cut(condition, ":")
+		if !ok || value == "" {
+			return filters, fmt.Errorf("has %q, which isn't a field:value condition", condition)
+		}
+		field, exclude := strings.CutPrefix(strings.ToLower(field), "-")
+
+		switch field {
+		case "status":
+			var statuses []string
+			for _, s := range strings.Split(strings.ToLower(value), ",") {
+				matched, ok := searchStatuses[s]
+				if !ok {
+					return filters, fmt.Errorf("has an unknown status %q", s)
+				}
+				statuses = append(statuses, matched...)
+			}
+			if exclude {
+				filters.ExcludeStatuses = append(filters.ExcludeStatuses, statuses...)
+				continue
+			}
+			if filters.Statuses != nil {
+				return filters, errors.New("has more than one status condition")
+			}
+			filters.Statuses = statuses
+
+		case "priority":
+			var priorities []int32
+			for _, s := range strings.Split(value, ",") {
+				priority, ok := ParseTaskPriority(s)
+				if !ok || priority < TaskPriorityNone || priority > TaskPriorityHigh {
+					return filters, fmt.Errorf("has an unknown priority %q", s)
+				}
+				priorities = append(priorities, priority)
+			}
+			if exclude {
+				filters.ExcludePriorities = append(filters.ExcludePriorities, priorities...)
+				continue
+			}
+			if filters.Priorities != nil {
+				return filters, errors.New("has more than one priority condition")
+			}
+			filters.Priorities = priorities
+
+		case "assignee":
+			if exclude {
+				return filters, errors.New("can't exclude assignees with -assignee")
+			}
+			if filters.AssigneeIDs != nil {
+				return filters, errors.New("has more than one assignee condition")
+			}
+			for _, s := range strings.Split(value, ",") {
+				id := userID
+				if !strings.EqualFold(s, "me") {
+					var err error
+					id, err = primitive.ObjectIDFromHex(s)
+					if err != nil {
+						return filters, fmt.Errorf("has an unknown assignee %q", s)
+					}
+				}
+				filters.AssigneeIDs = append(filters.AssigneeIDs, id)
+			}
+
+		case "label":
+			if exclude {
+				return filters, errors.New("can't exclude labels with -label")
+			}
+			if filters.LabelIDs != nil {
+				return filters, errors.New("has more than one label condition")
+			}
+			for _, s := range strings.Split(value, ",") {
+				id, err := primitive.ObjectIDFromHex(s)
+				if err != nil {
+					return filters, fmt.Errorf("has an unknown label %q", s)
+				}
+				filters.LabelIDs = append(filters.LabelIDs, id)
+			}
+
+		case "due":
+			if exclude {
+				return filters, errors.New("can't exclude due dates with -due")
+			}
+			if err := filters.parseDue(strings.ToLower(value), now); err != nil {
+				return filters, err
+			}
+
+		default:
+			return filters, fmt.Errorf("has an unknown field %q", field)
+		}
+	}
+	return filters, nil
+}
+
+// parseDue narrows the filters to the due dates of one due: condition.
+func (f *Filters) parseDue(value string, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch value {
+	case "today":
+		f.dueBetween(&today, addDays(today, 1))
+		return nil
+	case "overdue":
+		f.dueBetween(nil, &now)
+		f.ExcludeStatuses = append(f.ExcludeStatuses, TaskStatusDone)
+		return nil
+	case "none", "any":
+		hasDue := value == "any"
+		f.HasDue = &hasDue
+		return nil
+	}
+
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "<", ">"} {
+		if rest, ok := strings.CutPrefix(value, prefix); ok {
+			op, value = prefix, rest
+			break
+		}
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		switch op {
+		case "<":
+			f.dueBetween(nil, &t)
+		case "<=":
+			f.dueBetween(nil, addDays(t, 1))
+		case ">":
+			f.dueBetween(addDays(t, 1), nil)
+		case ">=":
+			f.dueBetween(&t, nil)
+		default:
+			f.dueBetween(&t, addDays(t, 1))
+		}
+		return nil
+	}
+
+	d, ok := parseRelativeDuration(value)
+	if !ok {
+		return fmt.Errorf("has an invalid due date %q", value)
+	}
+	t := now.Add(d)
+	switch op {
+	case "<", "<=":
+		f.dueBetween(nil, &t)
+	case ">", ">=":
+		f.dueBetween(&t, nil)
+	default:
+		f.dueBetween(&now, &t)
+	}
+	return nil
+}
+
+// dueBetween narrows the due dates the filters allow to those from after
+// until before, either of which may be nil to leave that end open.
+func (f *Filters) dueBetween(after, before *time.Time) {
+	if after != nil && (f.DueAfter == nil || after.After(*f.DueAfter)) {
+		f.DueAfter = after
+	}
+	if before != nil && (f.DueBefore == nil || before.Before(*f.DueBefore)) {
+		f.DueBefore = before
+	}
+}
+
+func addDays(t time.Time, days int) *time.Time {
+	t = t.AddDate(0, 0, days)
+	return &t
+}
+
+// parseRelativeDuration reads a count of hours, days or weeks such as 36h,
+// 7d or 2w.
+func parseRelativeDuration(s string) (time.Duration, bool) {
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) < 2 {
+		return 0, false
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 || n > 10_000 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
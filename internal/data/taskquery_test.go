@@ -0,0 +1,45 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tasksync/internal/data"
+)
+
+// TestParseTaskQueryTimeZone checks that calendar dates are whole days in
+// the time zone of now, not in UTC.
+func TestParseTaskQueryTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Early on 2 June in Auckland, when it is still 1 June in UTC.
+	now := time.Date(2024, time.June, 2, 7, 0, 0, 0, loc)
+
+	tests := []struct {
+		query  string
+		after  time.Time
+		before time.Time
+	}{
+		{"due:today", time.Date(2024, time.June, 2, 0, 0, 0, 0, loc), time.Date(2024, time.June, 3, 0, 0, 0, 0, loc)},
+		{"due:2024-06-10", time.Date(2024, time.June, 10, 0, 0, 0, 0, loc), time.Date(2024, time.June, 11, 0, 0, 0, 0, loc)},
+		{"due:>=2024-06-01 due:<=2024-06-01", time.Date(2024, time.June, 1, 0, 0, 0, 0, loc), time.Date(2024, time.June, 2, 0, 0, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			filters, err := data.ParseTaskQuery(tt.query, primitive.NewObjectID(), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filters.DueAfter == nil || !filters.DueAfter.Equal(tt.after) {
+				t.Errorf("got due after %v; want %v", filters.DueAfter, tt.after)
+			}
+			if filters.DueBefore == nil || !filters.DueBefore.Equal(tt.before) {
+				t.Errorf("got due before %v; want %v", filters.DueBefore, tt.before)
+			}
+		})
+	}
+}
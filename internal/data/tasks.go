@@ -0,0 +1,527 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+const (
+	TaskStatusTodo       = "todo"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusDone       = "done"
+)
+
+var TaskStatuses = []string{TaskStatusTodo, TaskStatusInProgress, TaskStatusDone}
+
+// Task priorities run from 0 (none) to 3 (high).
+const (
+	TaskPriorityNone int32 = iota
+	TaskPriorityLow
+	TaskPriorityMedium
+	TaskPriorityHigh
+)
+
+var taskPriorityNames = map[string]int32{
+	"none":   TaskPriorityNone,
+	"low":    TaskPriorityLow,
+	"medium": TaskPriorityMedium,
+	"high":   TaskPriorityHigh,
+}
+
+// ParseTaskPriority reads a priority given either as a number or by name,
+// such as high. It reports false if s is neither.
+func ParseTaskPriority(s string) (int32, bool) {
+	if priority, ok := taskPriorityNames[strings.ToLower(s)]; ok {
+		return priority, true
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(n), true
+}
+
+// MaxTaskAssignees caps the number of users a task can be assigned to.
+const MaxTaskAssignees = 20
+
+type Task struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id"`
+	ProjectID   primitive.ObjectID   `json:"project_id" bson:"project_id"`
+	CreatedBy   primitive.ObjectID   `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at" bson:"updated_at"`
+	Title       string               `json:"title" bson:"title"`
+	Description string               `json:"description" bson:"description"`
+	Status      string               `json:"status" bson:"status"`
+	Priority    int32                `json:"priority" bson:"priority"`
+	DueAt       *time.Time           `json:"due_at,omitempty" bson:"due_at,omitempty"`
+	Recurrence  string               `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	AssigneeIDs []primitive.ObjectID `json:"assignee_ids,omitempty" bson:"assignee_ids,omitempty"`
+	LabelIDs    []primitive.ObjectID `json:"label_ids,omitempty" bson:"label_ids,omitempty"`
+	Rank        string               `json:"rank" bson:"rank"`
+	Version     int32                `json:"version" bson:"version"`
+	Seq         int64                `json:"seq" bson:"seq"`
+	Deleted     bool                 `json:"deleted,omitempty" bson:"deleted"`
+}
+
+// HasLabel reports whether the task carries the label.
+func (t *Task) HasLabel(labelID primitive.ObjectID) bool {
+	for _, id := range t.LabelIDs {
+		if id == labelID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAssignee reports whether the task is assigned to the user.
+func (t *Task) IsAssignee(userID primitive.ObjectID) bool {
+	for _, id := range t.AssigneeIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateTask(v *validator.Validator, task *Task) {
+	v.Check(!task.ProjectID.IsZero(), "project_id", "must be provided")
+	v.Check(task.Title != "", "title", "must be provided")
+	v.Check(len(task.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(task.Description) <= 100_000, "description", "must not be more than 100000 bytes long")
+	v.Check(validator.In(task.Status, TaskStatuses...), "status", "must be one of todo, in_progress or done")
+	v.Check(task.Priority >= TaskPriorityNone && task.Priority <= TaskPriorityHigh, "priority", "must be between 0 and 3")
+
+	v.Check(len(task.AssigneeIDs) <= MaxTaskAssignees, "assignee_ids", "must not contain more than 20 users")
+	v.Check(uniqueIDs(task.AssigneeIDs), "assignee_ids", "must not contain duplicate users")
+
+	v.Check(len(task.LabelIDs) <= MaxTaskLabels, "label_ids", "must not contain more than 20 labels")
+	v.Check(uniqueIDs(task.LabelIDs), "label_ids", "must not contain duplicate labels")
+
+	if task.Recurrence != "" {
+		v.Check(task.DueAt != nil, "recurrence", "must be empty for a task without a due date")
+		ValidateRecurrence(v, task.Recurrence)
+	}
+}
+
+func uniqueIDs(ids []primitive.ObjectID) bool {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// TaskHistoryLimit is the number of past revisions kept for each task. A
+// client whose base version has dropped out of the history can no longer be
+// merged automatically.
+const TaskHistoryLimit = 50
+
+type taskRevision struct {
+	TaskID  primitive.ObjectID `bson:"task_id"`
+	Version int32              `bson:"version"`
+	Task    Task               `bson:"task"`
+}
+
+type TaskModel struct {
+	DB          *mongo.Collection
+	Counters    *mongo.Collection
+	History     *mongo.Collection
+	Comments    CommentModel
+	Attachments AttachmentModel
+	Timeout     time.Duration
+}
+
+func (m TaskModel) Insert(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextMongoSeq(ctx, m.Counters)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseMongoSeq(ctx, m.Counters, seq)
+
+	if task.ID.IsZero() {
+		task.ID = primitive.NewObjectID()
+	}
+	task.CreatedAt = time.Now().UTC()
+	task.UpdatedAt = task.CreatedAt
+	task.Version = 1
+	task.Seq = seq
+	task.Deleted = false
+
+	task.Rank, err = m.nextRank(ctx, task.ProjectID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	_, err = m.DB.InsertOne(ctx, task)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateID
+		}
+		return queryError(ctx, err)
+	}
+	return m.saveRevision(ctx, task)
+}
+
+func (m TaskModel) Get(ctx context.Context, id primitive.ObjectID) (*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var task Task
+	err := m.DB.FindOne(ctx, bson.M{"_id": id, "deleted": false}).Decode(&task)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &task, nil
+}
+
+// Update saves the task if it is still at task.Version, returning
+// ErrEditConflict otherwise, and advances the version and change sequence.
+// The task's comments and attachments follow it to its project.
+func (m TaskModel) Update(ctx context.Context, task *Task) error {
+	err := m.write(ctx, task, bson.M{
+		"project_id":   task.ProjectID,
+		"title":        task.Title,
+		"description":  task.Description,
+		"status":       task.Status,
+		"priority":     task.Priority,
+		"due_at":       task.DueAt,
+		"recurrence":   task.Recurrence,
+		"assignee_ids": task.AssigneeIDs,
+		"label_ids":    task.LabelIDs,
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.Comments.moveTask(ctx, task.ID, task.ProjectID); err != nil {
+		return err
+	}
+	if err := m.Attachments.moveTask(ctx, task.ID, task.ProjectID); err != nil {
+		return err
+	}
+	return m.saveRevision(ctx, task)
+}
+
+// Move saves the task's status and rank and nothing else, so that moving it
+// on a board rewrites no other task.
+func (m TaskModel) Move(ctx context.Context, task *Task) error {
+	err := m.write(ctx, task, bson.M{
+		"status": task.Status,
+		"rank":   task.Rank,
+	})
+	if err != nil {
+		return err
+	}
+	return m.saveRevision(ctx, task)
+}
+
+// Delete replaces the task with a tombstone, so the deletion is still
+// visible to clients syncing from an older cursor, and deletes its
+// comments.
+func (m TaskModel) Delete(ctx context.Context, task *Task) error {
+	err := m.write(ctx, task, bson.M{"deleted": true})
+	if err != nil {
+		return err
+	}
+	task.Deleted = true
+	return m.Comments.deleteWhere(ctx, bson.M{"task_id": task.ID})
+}
+
+// DeleteAllForProject tombstones every live task in the project and deletes
+// their comments, which are found by task so that those of tasks moved
+// elsewhere are left alone. Each task gets its own change sequence so sync
+// pages never split a shared one.
+func (m TaskModel) DeleteAllForProject(ctx context.Context, projectID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	ids, err := m.DB.Distinct(ctx, "_id", bson.M{"project_id": projectID, "deleted": false})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	for _, id := range ids {
+		seq, err := nextMongoSeq(ctx, m.Counters)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		update := bson.M{
+			"$set": bson.M{"deleted": true, "seq": seq, "updated_at": time.Now().UTC()},
+			"$inc": bson.M{"version": 1},
+		}
+		_, err = m.DB.UpdateOne(ctx, bson.M{"_id": id, "deleted": false}, update)
+		releaseMongoSeq(ctx, m.Counters, seq)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return m.Comments.deleteWhere(ctx, bson.M{"task_id": bson.M{"$in": ids}})
+}
+
+// ReplaceLabel swaps one label for another on every live task carrying it,
+// or only takes it off them when to is the zero id, and returns the tasks it
+// changed. Each of them gets a new version and change sequence as for any
+// other edit, so the change reaches syncing clients. Run it in a
+// transaction to change every task or none; otherwise a failure part-way
+// returns the tasks changed so far along with the error.
+func (m TaskModel) ReplaceLabel(ctx context.Context, from, to primitive.ObjectID) ([]*Task, error) {
+	tasks, err := m.withLabel(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each write has a timeout of its own, rather than sharing one between
+	// however many tasks carry the label.
+	for i, task := range tasks {
+		task.LabelIDs = replaceLabelID(task.LabelIDs, from, to)
+		err := m.write(ctx, task, bson.M{"label_ids": task.LabelIDs})
+		if err != nil {
+			return tasks[:i], err
+		}
+		err = m.saveRevision(ctx, task)
+		if err != nil {
+			return tasks[:i+1], err
+		}
+	}
+	return tasks, nil
+}
+
+// withLabel returns the live tasks carrying the label.
+func (m TaskModel) withLabel(ctx context.Context, labelID primitive.ObjectID) ([]*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	cursor, err := m.DB.Find(ctx, bson.M{"label_ids": labelID, "deleted": false})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	tasks := []*Task{}
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return tasks, nil
+}
+
+func (m TaskModel) write(ctx context.Context, task *Task, fields bson.M) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextMongoSeq(ctx, m.Counters)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseMongoSeq(ctx, m.Counters, seq)
+
+	fields["updated_at"] = time.Now().UTC()
+	fields["version"] = task.Version + 1
+	fields["seq"] = seq
+
+	filter := bson.M{
+		"_id":     task.ID,
+		"version": task.Version,
+		"deleted": false,
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated Task
+	err = m.DB.FindOneAndUpdate(ctx, filter, bson.M{"$set": fields}, opts).Decode(&updated)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	task.UpdatedAt = updated.UpdatedAt
+	task.Version = updated.Version
+	task.Seq = updated.Seq
+	return nil
+}
+
+// nextRank returns a rank after that of every task in the project, so new
+// tasks join the end of their board column.
+func (m TaskModel) nextRank(ctx context.Context, projectID primitive.ObjectID) (string, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "rank", Value: -1}}).SetProjection(bson.M{"rank": 1})
+
+	var last Task
+	err := m.DB.FindOne(ctx, bson.M{"project_id": projectID}, opts).Decode(&last)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	return RankBetween(last.Rank, ""), nil
+}
+
+// saveRevision records a snapshot of the task in its history and drops any
+// revisions which have fallen outside TaskHistoryLimit.
+func (m TaskModel) saveRevision(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.History.InsertOne(ctx, taskRevision{TaskID: task.ID, Version: task.Version, Task: *task})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	_, err = m.History.DeleteMany(ctx, bson.M{
+		"task_id": task.ID,
+		"version": bson.M{"$lte": task.Version - TaskHistoryLimit},
+	})
+	return queryError(ctx, err)
+}
+
+// GetRevision returns the task as it was at the given version.
+func (m TaskModel) GetRevision(ctx context.Context, id primitive.ObjectID, version int32) (*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rev taskRevision
+	err := m.History.FindOne(ctx, bson.M{"task_id": id, "version": version}).Decode(&rev)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &rev.Task, nil
+}
+
+// RevisionsSince returns every stored revision of the task after the given
+// version, oldest first.
+func (m TaskModel) RevisionsSince(ctx context.Context, id primitive.ObjectID, version int32) ([]*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"task_id": id, "version": bson.M{"$gt": version}}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+
+	cursor, err := m.History.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	var revs []taskRevision
+	err = cursor.All(ctx, &revs)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	tasks := make([]*Task, len(revs))
+	for i := range revs {
+		tasks[i] = &revs[i].Task
+	}
+	return tasks, nil
+}
+
+// ChangesSince returns up to limit tasks in the given projects, including
+// tombstones, which changed after the given sequence, oldest change first.
+func (m TaskModel) ChangesSince(ctx context.Context, projectIDs []primitive.ObjectID, since int64, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(projectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{
+		"project_id": bson.M{"$in": projectIDs},
+		"seq":        bson.M{"$gt": since},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return tasks, nil
+}
+
+// List returns up to limit live tasks matching the filters whose ids come
+// after the given one, in id order, so that callers can page through every
+// match by passing the last id they saw.
+func (m TaskModel) List(ctx context.Context, filters Filters, after primitive.ObjectID, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(filters.ProjectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := filters.mongoFilter()
+	filter["_id"] = bson.M{"$gt": after}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return tasks, nil
+}
+
+// ListByDue returns up to limit live tasks matching the filters which have a
+// due date, soonest due first.
+func (m TaskModel) ListByDue(ctx context.Context, filters Filters, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(filters.ProjectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hasDue := true
+	filters.HasDue = &hasDue
+	opts := options.Find().SetSort(bson.D{{Key: "due_at", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := m.DB.Find(ctx, filters.mongoFilter(), opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,613 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLTaskModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const taskColumns = `id, project_id, created_by, created_at, updated_at, title, description, status, priority, due_at, recurrence, assignee_ids, label_ids, rank, version, seq, deleted`
+
+func (m SQLTaskModel) Insert(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextSQLSeq(ctx, m.DB, m.Dialect)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseSQLSeq(ctx, m.DB, m.Dialect, seq)
+
+	if task.ID.IsZero() {
+		task.ID = primitive.NewObjectID()
+	}
+	task.CreatedAt = time.Now().UTC()
+	task.UpdatedAt = task.CreatedAt
+	task.Version = 1
+	task.Seq = seq
+	task.Deleted = false
+
+	task.Rank, err = m.nextRank(ctx, task.ProjectID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	assignees, err := marshalIDs(task.AssigneeIDs)
+	if err != nil {
+		return err
+	}
+	labels, err := marshalIDs(task.LabelIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO tasks (` + taskColumns + `, search_words)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		task.ID.Hex(), task.ProjectID.Hex(), task.CreatedBy.Hex(), task.CreatedAt, task.UpdatedAt,
+		task.Title, task.Description, task.Status, task.Priority, nullTime(task.DueAt),
+		task.Recurrence, assignees, labels, task.Rank, task.Version, task.Seq, task.Deleted,
+		searchWords(task.Title, task.Description),
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateID
+		}
+		return queryError(ctx, err)
+	}
+	return m.saveRevision(ctx, task)
+}
+
+func (m SQLTaskModel) Get(ctx context.Context, id primitive.ObjectID) (*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = ? AND deleted = FALSE`
+
+	task, err := scanTask(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return task, nil
+}
+
+func (m SQLTaskModel) Update(ctx context.Context, task *Task) error {
+	assignees, err := marshalIDs(task.AssigneeIDs)
+	if err != nil {
+		return err
+	}
+	labels, err := marshalIDs(task.LabelIDs)
+	if err != nil {
+		return err
+	}
+
+	set := "project_id = ?, title = ?, description = ?, status = ?, priority = ?, due_at = ?, recurrence = ?, assignee_ids = ?, label_ids = ?, search_words = ?"
+	err = m.write(ctx, task, set,
+		task.ProjectID.Hex(), task.Title, task.Description, task.Status, task.Priority, nullTime(task.DueAt), task.Recurrence, assignees, labels,
+		searchWords(task.Title, task.Description))
+	if err != nil {
+		return err
+	}
+	if err := m.moveComments(ctx, task); err != nil {
+		return err
+	}
+	if err := m.moveAttachments(ctx, task); err != nil {
+		return err
+	}
+	return m.saveRevision(ctx, task)
+}
+
+func (m SQLTaskModel) Move(ctx context.Context, task *Task) error {
+	err := m.write(ctx, task, "status = ?, rank = ?", task.Status, task.Rank)
+	if err != nil {
+		return err
+	}
+	return m.saveRevision(ctx, task)
+}
+
+// Delete tombstones the task and deletes its comments.
+func (m SQLTaskModel) Delete(ctx context.Context, task *Task) error {
+	err := m.write(ctx, task, "deleted = ?", true)
+	if err != nil {
+		return err
+	}
+	task.Deleted = true
+	return m.deleteComments(ctx, task.ID)
+}
+
+func (m SQLTaskModel) DeleteAllForProject(ctx context.Context, projectID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT id
+		FROM tasks
+		WHERE project_id = ? AND deleted = FALSE`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), projectID.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return queryError(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	for _, id := range ids {
+		seq, err := nextSQLSeq(ctx, m.DB, m.Dialect)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		query := `
+			UPDATE tasks
+			SET deleted = TRUE, seq = ?, updated_at = ?, version = version + 1
+			WHERE id = ? AND deleted = FALSE`
+
+		_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), seq, time.Now().UTC(), id)
+		releaseSQLSeq(ctx, m.DB, m.Dialect, seq)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+	}
+
+	// Comments are found by task, as for the Mongo model.
+	query = `
+		DELETE FROM comments
+		WHERE task_id IN (SELECT id FROM tasks WHERE project_id = ?)`
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), projectID.Hex())
+	return queryError(ctx, err)
+}
+
+func (m SQLTaskModel) ReplaceLabel(ctx context.Context, from, to primitive.ObjectID) ([]*Task, error) {
+	tasks, err := m.withLabel(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, task := range tasks {
+		task.LabelIDs = replaceLabelID(task.LabelIDs, from, to)
+		labels, err := marshalIDs(task.LabelIDs)
+		if err != nil {
+			return tasks[:i], err
+		}
+		err = m.write(ctx, task, "label_ids = ?", labels)
+		if err != nil {
+			return tasks[:i], err
+		}
+		err = m.saveRevision(ctx, task)
+		if err != nil {
+			return tasks[:i+1], err
+		}
+	}
+	return tasks, nil
+}
+
+func (m SQLTaskModel) withLabel(ctx context.Context, labelID primitive.ObjectID) ([]*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE label_ids LIKE ? AND deleted = FALSE`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), `%"`+labelID.Hex()+`"%`)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	tasks := []*Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return tasks, nil
+}
+
+// deleteComments deletes the task's comments. Their history goes with them.
+func (m SQLTaskModel) deleteComments(ctx context.Context, taskID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM comments
+		WHERE task_id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), taskID.Hex())
+	return queryError(ctx, err)
+}
+
+// moveComments points the task's comments at the project it is now in.
+func (m SQLTaskModel) moveComments(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE comments
+		SET project_id = ?
+		WHERE task_id = ? AND project_id <> ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), task.ProjectID.Hex(), task.ID.Hex(), task.ProjectID.Hex())
+	return queryError(ctx, err)
+}
+
+// moveAttachments points the task's attachments at the project it is now in
+// and that project's workspace.
+func (m SQLTaskModel) moveAttachments(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE attachments
+		SET project_id = ?, workspace_id = (SELECT workspace_id FROM projects WHERE id = ?)
+		WHERE task_id = ? AND project_id <> ?`
+
+	projectID := task.ProjectID.Hex()
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), projectID, projectID, task.ID.Hex(), projectID)
+	return queryError(ctx, err)
+}
+
+func (m SQLTaskModel) write(ctx context.Context, task *Task, set string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	seq, err := nextSQLSeq(ctx, m.DB, m.Dialect)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer releaseSQLSeq(ctx, m.DB, m.Dialect, seq)
+
+	updatedAt := time.Now().UTC()
+
+	query := `
+		UPDATE tasks
+		SET ` + set + `, updated_at = ?, version = version + 1, seq = ?
+		WHERE id = ? AND version = ? AND deleted = FALSE`
+
+	args = append(args, updatedAt, seq, task.ID.Hex(), task.Version)
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	task.UpdatedAt = updatedAt
+	task.Version++
+	task.Seq = seq
+	return nil
+}
+
+func (m SQLTaskModel) nextRank(ctx context.Context, projectID primitive.ObjectID) (string, error) {
+	query := `
+		SELECT COALESCE(MAX(rank), '')
+		FROM tasks
+		WHERE project_id = ?`
+
+	var last string
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), projectID.Hex()).Scan(&last)
+	if err != nil {
+		return "", err
+	}
+	return RankBetween(last, ""), nil
+}
+
+func (m SQLTaskModel) saveRevision(ctx context.Context, task *Task) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	snapshot, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO task_revisions (task_id, version, snapshot)
+		VALUES (?, ?, ?)`
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), task.ID.Hex(), task.Version, string(snapshot))
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	query = `
+		DELETE FROM task_revisions
+		WHERE task_id = ? AND version <= ?`
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), task.ID.Hex(), task.Version-TaskHistoryLimit)
+	return queryError(ctx, err)
+}
+
+func (m SQLTaskModel) GetRevision(ctx context.Context, id primitive.ObjectID, version int32) (*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT snapshot
+		FROM task_revisions
+		WHERE task_id = ? AND version = ?`
+
+	var snapshot string
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex(), version).Scan(&snapshot)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	var task Task
+	err = json.Unmarshal([]byte(snapshot), &task)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (m SQLTaskModel) RevisionsSince(ctx context.Context, id primitive.ObjectID, version int32) ([]*Task, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT snapshot
+		FROM task_revisions
+		WHERE task_id = ? AND version > ?
+		ORDER BY version`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), id.Hex(), version)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	tasks := []*Task{}
+	for rows.Next() {
+		var snapshot string
+		if err := rows.Scan(&snapshot); err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		var task Task
+		if err := json.Unmarshal([]byte(snapshot), &task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &task)
+	}
+	return tasks, queryError(ctx, rows.Err())
+}
+
+func (m SQLTaskModel) ChangesSince(ctx context.Context, projectIDs []primitive.ObjectID, since int64, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(projectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE project_id IN (` + placeholders(len(projectIDs)) + `) AND seq > ?
+		ORDER BY seq
+		LIMIT ?`
+
+	args := append(hexIDs(projectIDs), since, limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, queryError(ctx, rows.Err())
+}
+
+func (m SQLTaskModel) List(ctx context.Context, filters Filters, after primitive.ObjectID, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(filters.ProjectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	where, args := filters.sqlWhere()
+
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE ` + where + ` AND id > ?
+		ORDER BY id
+		LIMIT ?`
+
+	args = append(args, after.Hex(), limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, queryError(ctx, rows.Err())
+}
+
+func (m SQLTaskModel) ListByDue(ctx context.Context, filters Filters, limit int) ([]*Task, error) {
+	tasks := []*Task{}
+	if len(filters.ProjectIDs) == 0 {
+		return tasks, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	hasDue := true
+	filters.HasDue = &hasDue
+	where, args := filters.sqlWhere()
+
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE ` + where + `
+		ORDER BY due_at, id
+		LIMIT ?`
+
+	args = append(args, limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, queryError(ctx, rows.Err())
+}
+
+func scanTask(row rowScanner) (*Task, error) {
+	var task Task
+	var id, projectID, createdBy string
+	var dueAt sql.NullTime
+	var assignees, labels string
+
+	err := row.Scan(
+		&id,
+		&projectID,
+		&createdBy,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+		&task.Title,
+		&task.Description,
+		&task.Status,
+		&task.Priority,
+		&dueAt,
+		&task.Recurrence,
+		&assignees,
+		&labels,
+		&task.Rank,
+		&task.Version,
+		&task.Seq,
+		&task.Deleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if dueAt.Valid {
+		task.DueAt = &dueAt.Time
+	}
+	if assignees != "[]" {
+		if err := json.Unmarshal([]byte(assignees), &task.AssigneeIDs); err != nil {
+			return nil, err
+		}
+	}
+	if labels != "[]" {
+		if err := json.Unmarshal([]byte(labels), &task.LabelIDs); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range []struct {
+		src string
+		dst *primitive.ObjectID
+	}{{id, &task.ID}, {projectID, &task.ProjectID}, {createdBy, &task.CreatedBy}} {
+		*f.dst, err = primitive.ObjectIDFromHex(f.src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &task, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func nullID(id *primitive.ObjectID) sql.NullString {
+	if id == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: id.Hex(), Valid: true}
+}
+
+// marshalIDs encodes ids as the JSON array of hex strings which the SQL
+// models store lists of ids as.
+func marshalIDs(ids []primitive.ObjectID) (string, error) {
+	if ids == nil {
+		ids = []primitive.ObjectID{}
+	}
+	b, err := json.Marshal(ids)
+	return string(b), err
+}
@@ -16,6 +16,9 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeCalendar       = "calendar"
+	ScopeAPIKey         = "api_key"
+	ScopeUnsubscribe    = "unsubscribe"
 )
 
 type Token struct {
@@ -52,31 +55,32 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *mongo.Collection
+	DB      *mongo.Collection
+	Timeout time.Duration
 }
 
-func (m TokenModel) New(userID primitive.ObjectID, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID primitive.ObjectID, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
-	return token, nil
+	err = m.Insert(ctx, token)
+	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.InsertOne(ctx, token)
-	return err
+	return queryError(ctx, err)
 }
 
-func (m TokenModel) GetUserIDForToken(tokenScope, tokenPlaintext string) (primitive.ObjectID, error) {
+func (m TokenModel) GetUserIDForToken(ctx context.Context, tokenScope, tokenPlaintext string) (primitive.ObjectID, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	filter := bson.M{
@@ -92,19 +96,19 @@ func (m TokenModel) GetUserIDForToken(tokenScope, tokenPlaintext string) (primit
 		case err == mongo.ErrNoDocuments:
 			return primitive.NilObjectID, ErrRecordNotFound
 		default:
-			return primitive.NilObjectID, err
+			return primitive.NilObjectID, queryError(ctx, err)
 		}
 	}
 	return token.UserID, nil
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.DeleteMany(ctx, bson.M{
 		"scope":  scope,
 		"userID": userID,
 	})
-	return err
+	return queryError(ctx, err)
 }
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLTokenModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLTokenModel) New(ctx context.Context, userID primitive.ObjectID, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m SQLTokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES (?, ?, ?, ?)`
+
+	args := []interface{}{token.HashedToken, token.UserID.Hex(), token.Expiry.UTC(), token.Scope}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLTokenModel) GetUserIDForToken(ctx context.Context, tokenScope, tokenPlaintext string) (primitive.ObjectID, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT user_id
+		FROM tokens
+		WHERE hash = ? AND scope = ? AND expiry > ?`
+
+	var userID string
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), tokenHash[:], tokenScope, time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return primitive.NilObjectID, ErrRecordNotFound
+		default:
+			return primitive.NilObjectID, queryError(ctx, err)
+		}
+	}
+	return primitive.ObjectIDFromHex(userID)
+}
+
+func (m SQLTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM tokens
+		WHERE scope = ? AND user_id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), scope, userID.Hex())
+	return queryError(ctx, err)
+}
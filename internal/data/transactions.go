@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SQLQuerier is the subset of *sql.DB and *sql.Tx used by the SQL models,
+// which lets the same model run either directly against the pool or inside
+// a transaction.
+type SQLQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type transactor interface {
+	withTransaction(ctx context.Context, m Models, fn func(ctx context.Context, tx Models) error) error
+	transactionsSupported(ctx context.Context) bool
+}
+
+// WithTransaction runs fn with a context and a copy of the models bound to a
+// single transaction, committing if fn returns nil and rolling back
+// otherwise. Every write which must succeed or fail together should go
+// through tx using the ctx passed to fn rather than the receiver.
+//
+// On the Mongo backend fn may be retried on transient transaction errors, so
+// it should not have side effects outside the database. Standalone Mongo
+// servers do not support transactions; there fn simply runs against the
+// ordinary models.
+func (m Models) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx Models) error) error {
+	if m.tx == nil {
+		return fn(ctx, m)
+	}
+	return m.tx.withTransaction(ctx, m, fn)
+}
+
+// SupportsTransactions reports whether WithTransaction really runs fn in a
+// transaction, which callers promising all-or-nothing writes need to know.
+func (m Models) SupportsTransactions(ctx context.Context) bool {
+	return m.tx != nil && m.tx.transactionsSupported(ctx)
+}
+
+type mongoTransactor struct {
+	db *mongo.Database
+
+	mu        sync.Mutex
+	checked   bool
+	supported bool
+}
+
+func (t *mongoTransactor) withTransaction(ctx context.Context, m Models, fn func(ctx context.Context, tx Models) error) error {
+	if !t.transactionsSupported(ctx) {
+		return fn(ctx, m)
+	}
+
+	session, err := t.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// The Mongo models join the session through the context, so fn gets
+	// the same models back.
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc, m)
+	})
+	return queryError(ctx, err)
+}
+
+// transactionsSupported reports whether the server is a replica set member
+// or a mongos router. The answer is cached after the first successful check.
+func (t *mongoTransactor) transactionsSupported(ctx context.Context) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.checked {
+		return t.supported
+	}
+
+	supported, err := replicaSetOrRouter(ctx, t.db)
+	if err != nil {
+		return false
+	}
+
+	t.checked = true
+	t.supported = supported
+	return t.supported
+}
+
+// replicaSetOrRouter reports whether the server is a replica set member or a
+// mongos router, which is what both transactions and change streams need.
+func replicaSetOrRouter(ctx context.Context, db *mongo.Database) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+type sqlTransactor struct {
+	db      *sql.DB
+	dialect Dialect
+	timeout time.Duration
+}
+
+func (t sqlTransactor) transactionsSupported(ctx context.Context) bool {
+	return true
+}
+
+func (t sqlTransactor) withTransaction(ctx context.Context, m Models, fn func(ctx context.Context, tx Models) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, newSQLModels(tx, t.dialect, t.timeout)); err != nil {
+		return err
+	}
+
+	return queryError(ctx, tx.Commit())
+}
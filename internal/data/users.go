@@ -77,11 +77,12 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *mongo.Collection
+	DB      *mongo.Collection
+	Timeout time.Duration
 }
 
-func (m UserModel) Insert(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	user.CreatedAt = time.Now()
@@ -96,7 +97,7 @@ func (m UserModel) Insert(user *User) error {
 				}
 			}
 		}
-		return err
+		return queryError(ctx, err)
 	}
 
 	oid, ok := result.InsertedID.(primitive.ObjectID)
@@ -107,10 +108,10 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	filter := bson.M{"email": email}
@@ -120,16 +121,16 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, mongo.ErrNoDocuments):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 	return &user, nil
 }
 
-func (m UserModel) GetByID(id primitive.ObjectID) (*User, error) {
+func (m UserModel) GetByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	filter := bson.M{"_id": id}
@@ -139,14 +140,14 @@ func (m UserModel) GetByID(id primitive.ObjectID) (*User, error) {
 		case errors.Is(err, mongo.ErrNoDocuments):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	filter := bson.M{
@@ -166,9 +167,9 @@ func (m UserModel) Update(user *User) error {
 	result := m.DB.FindOneAndUpdate(ctx, filter, update, opts)
 	if result.Err() != nil {
 		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return fmt.Errorf("edit conflict")
+			return ErrEditConflict
 		}
-		return result.Err()
+		return queryError(ctx, result.Err())
 	}
 
 	var updatedUser User
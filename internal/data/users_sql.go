@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLUserModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLUserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	id := primitive.NewObjectID()
+	createdAt := time.Now().UTC()
+
+	query := `
+		INSERT INTO users (id, created_at, name, email, password, activated, version)
+		VALUES (?, ?, ?, ?, ?, ?, 1)`
+
+	args := []interface{}{id.Hex(), createdAt, user.Name, user.Email, user.Password, user.Activated}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		if m.Dialect.isUniqueViolation(err) {
+			return ErrDuplicateEmail
+		}
+		return queryError(ctx, err)
+	}
+
+	user.ID = id
+	user.CreatedAt = createdAt
+	user.Version = 1
+	return nil
+}
+
+func (m SQLUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password, activated, version
+		FROM users
+		WHERE email = ?`
+
+	return m.get(ctx, query, email)
+}
+
+func (m SQLUserModel) GetByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password, activated, version
+		FROM users
+		WHERE id = ?`
+
+	return m.get(ctx, query, id.Hex())
+}
+
+func (m SQLUserModel) get(ctx context.Context, query string, args ...interface{}) (*User, error) {
+	var user User
+	var id string
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), args...).Scan(
+		&id,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	user.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m SQLUserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET name = ?, password = ?, version = ?, activated = ?
+		WHERE id = ?`
+
+	args := []interface{}{user.Name, user.Password, user.Version + 1, user.Activated, user.ID.Hex()}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
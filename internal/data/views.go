@@ -0,0 +1,258 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// ViewSorts are the orders a saved view can list its tasks in, each
+// descending when prefixed with -. Without one, tasks are listed in the
+// order they were created.
+var ViewSorts = []string{"title", "status", "priority", "due_at", "created_at", "updated_at"}
+
+// ViewGroupings are the fields a saved view can group its tasks by.
+var ViewGroupings = []string{"status", "priority", "project"}
+
+// SavedView is a named task query, along with how to sort and group the
+// tasks it finds. A view without a workspace belongs to its owner alone;
+// otherwise it is shared with the members of the workspace and lists only
+// that workspace's tasks.
+type SavedView struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id"`
+	OwnerID     primitive.ObjectID  `json:"owner_id" bson:"owner_id"`
+	WorkspaceID *primitive.ObjectID `json:"workspace_id,omitempty" bson:"workspace_id,omitempty"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at" bson:"updated_at"`
+	Name        string              `json:"name" bson:"name"`
+	Query       string              `json:"query" bson:"query"`
+	Sort        string              `json:"sort,omitempty" bson:"sort"`
+	GroupBy     string              `json:"group_by,omitempty" bson:"group_by"`
+	Version     int32               `json:"version" bson:"version"`
+}
+
+func ValidateSavedView(v *validator.Validator, view *SavedView) {
+	v.Check(view.Name != "", "name", "must be provided")
+	v.Check(len(view.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(view.Query) <= 1000, "query", "must not be more than 1000 bytes long")
+	if _, err := ParseTaskQuery(view.Query, view.OwnerID, time.Now()); err != nil {
+		v.AddError("query", err.Error())
+	}
+
+	if view.Sort != "" {
+		v.Check(validator.In(strings.TrimPrefix(view.Sort, "-"), ViewSorts...), "sort", "must be one of title, status, priority, due_at, created_at or updated_at, optionally prefixed with -")
+	}
+	if view.GroupBy != "" {
+		v.Check(validator.In(view.GroupBy, ViewGroupings...), "group_by", "must be one of status, priority or project")
+	}
+}
+
+// SortTasks puts tasks in the order of a saved view's sort. Ties, and tasks
+// without a due date when sorting by one, keep their order at the end.
+func SortTasks(tasks []*Task, by string) {
+	field, desc := strings.CutPrefix(by, "-")
+
+	var less func(a, b *Task) int
+	switch field {
+	case "title":
+		less = func(a, b *Task) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) }
+	case "status":
+		less = func(a, b *Task) int { return statusRank(a.Status) - statusRank(b.Status) }
+	case "priority":
+		less = func(a, b *Task) int { return int(a.Priority - b.Priority) }
+	case "due_at":
+		less = func(a, b *Task) int { return a.DueAt.Compare(*b.DueAt) }
+	case "created_at":
+		less = func(a, b *Task) int { return a.CreatedAt.Compare(b.CreatedAt) }
+	case "updated_at":
+		less = func(a, b *Task) int { return a.UpdatedAt.Compare(b.UpdatedAt) }
+	default:
+		return
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if field == "due_at" && (a.DueAt == nil || b.DueAt == nil) {
+			return a.DueAt != nil && b.DueAt == nil
+		}
+		if desc {
+			return less(a, b) > 0
+		}
+		return less(a, b) < 0
+	})
+}
+
+func statusRank(status string) int {
+	for i, s := range TaskStatuses {
+		if s == status {
+			return i
+		}
+	}
+	return len(TaskStatuses)
+}
+
+// TaskGroup is the tasks of a saved view sharing a value of the field it
+// groups by, as a string: a status, a priority or a project id.
+type TaskGroup struct {
+	Key   string  `json:"key"`
+	Tasks []*Task `json:"tasks"`
+}
+
+// GroupTasks splits sorted tasks into groups, keeping their order within
+// each. Statuses come in workflow order and priorities highest first;
+// projects come in the order of their first task.
+func GroupTasks(tasks []*Task, by string) []*TaskGroup {
+	var groups []*TaskGroup
+	index := make(map[string]*TaskGroup)
+
+	for _, task := range tasks {
+		var key string
+		switch by {
+		case "status":
+			key = task.Status
+		case "priority":
+			key = strconv.Itoa(int(task.Priority))
+		case "project":
+			key = task.ProjectID.Hex()
+		}
+
+		group, ok := index[key]
+		if !ok {
+			group = &TaskGroup{Key: key, Tasks: []*Task{}}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.Tasks = append(group.Tasks, task)
+	}
+
+	switch by {
+	case "status":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return statusRank(groups[i].Key) < statusRank(groups[j].Key)
+		})
+	case "priority":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return groups[i].Tasks[0].Priority > groups[j].Tasks[0].Priority
+		})
+	}
+	return groups
+}
+
+type SavedViewModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+func (m SavedViewModel) Insert(ctx context.Context, view *SavedView) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	view.ID = primitive.NewObjectID()
+	view.CreatedAt = time.Now().UTC()
+	view.UpdatedAt = view.CreatedAt
+	view.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, view)
+	return queryError(ctx, err)
+}
+
+func (m SavedViewModel) Get(ctx context.Context, id primitive.ObjectID) (*SavedView, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var view SavedView
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&view)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &view, nil
+}
+
+// ForUser returns the user's own views along with those shared with the
+// given workspaces, oldest first.
+func (m SavedViewModel) ForUser(ctx context.Context, userID primitive.ObjectID, workspaceIDs []primitive.ObjectID) ([]*SavedView, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"owner_id": userID, "workspace_id": bson.M{"$exists": false}},
+		bson.M{"workspace_id": bson.M{"$in": workspaceIDs}},
+	}}
+	if len(workspaceIDs) == 0 {
+		filter = bson.M{"owner_id": userID, "workspace_id": bson.M{"$exists": false}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	views := []*SavedView{}
+	err = cursor.All(ctx, &views)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return views, nil
+}
+
+// Update saves the view if it is still at view.Version, returning
+// ErrEditConflict otherwise.
+func (m SavedViewModel) Update(ctx context.Context, view *SavedView) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	view.UpdatedAt = time.Now().UTC()
+
+	filter := bson.M{"_id": view.ID, "version": view.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"updated_at": view.UpdatedAt,
+			"name":       view.Name,
+			"query":      view.Query,
+			"sort":       view.Sort,
+			"group_by":   view.GroupBy,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	view.Version++
+	return nil
+}
+
+func (m SavedViewModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLSavedViewModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const savedViewColumns = `id, owner_id, workspace_id, created_at, updated_at, name, query, sort, group_by, version`
+
+func (m SQLSavedViewModel) Insert(ctx context.Context, view *SavedView) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	view.ID = primitive.NewObjectID()
+	view.CreatedAt = time.Now().UTC()
+	view.UpdatedAt = view.CreatedAt
+	view.Version = 1
+
+	query := `
+		INSERT INTO saved_views (` + savedViewColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		view.ID.Hex(), view.OwnerID.Hex(), nullID(view.WorkspaceID), view.CreatedAt, view.UpdatedAt,
+		view.Name, view.Query, view.Sort, view.GroupBy, view.Version,
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLSavedViewModel) Get(ctx context.Context, id primitive.ObjectID) (*SavedView, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + savedViewColumns + `
+		FROM saved_views
+		WHERE id = ?`
+
+	view, err := scanSavedView(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return view, nil
+}
+
+func (m SQLSavedViewModel) ForUser(ctx context.Context, userID primitive.ObjectID, workspaceIDs []primitive.ObjectID) ([]*SavedView, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	where := `owner_id = ? AND workspace_id IS NULL`
+	args := []interface{}{userID.Hex()}
+	if len(workspaceIDs) > 0 {
+		where = `(` + where + `) OR workspace_id IN (` + placeholders(len(workspaceIDs)) + `)`
+		args = append(args, hexIDs(workspaceIDs)...)
+	}
+
+	query := `
+		SELECT ` + savedViewColumns + `
+		FROM saved_views
+		WHERE ` + where + `
+		ORDER BY created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	views := []*SavedView{}
+	for rows.Next() {
+		view, err := scanSavedView(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		views = append(views, view)
+	}
+	return views, queryError(ctx, rows.Err())
+}
+
+func (m SQLSavedViewModel) Update(ctx context.Context, view *SavedView) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	view.UpdatedAt = time.Now().UTC()
+
+	query := `
+		UPDATE saved_views
+		SET updated_at = ?, name = ?, query = ?, sort = ?, group_by = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{view.UpdatedAt, view.Name, view.Query, view.Sort, view.GroupBy, view.ID.Hex(), view.Version}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	view.Version++
+	return nil
+}
+
+func (m SQLSavedViewModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM saved_views
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func scanSavedView(row rowScanner) (*SavedView, error) {
+	var view SavedView
+	var id, ownerID string
+	var workspaceID sql.NullString
+
+	err := row.Scan(
+		&id,
+		&ownerID,
+		&workspaceID,
+		&view.CreatedAt,
+		&view.UpdatedAt,
+		&view.Name,
+		&view.Query,
+		&view.Sort,
+		&view.GroupBy,
+		&view.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	view.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	view.OwnerID, err = primitive.ObjectIDFromHex(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceID.Valid {
+		wid, err := primitive.ObjectIDFromHex(workspaceID.String)
+		if err != nil {
+			return nil, err
+		}
+		view.WorkspaceID = &wid
+	}
+	return &view, nil
+}
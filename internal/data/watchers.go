@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TaskWatcher records that a user wants to hear about changes to a task.
+type TaskWatcher struct {
+	TaskID    primitive.ObjectID `bson:"task_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type WatcherModel struct {
+	DB      *mongo.Collection
+	Tasks   *mongo.Collection
+	Timeout time.Duration
+}
+
+// Add makes the user a watcher of the task. Watching a task twice is the
+// same as watching it once.
+func (m WatcherModel) Add(ctx context.Context, taskID, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	watcher := TaskWatcher{TaskID: taskID, UserID: userID, CreatedAt: time.Now().UTC()}
+
+	_, err := m.DB.InsertOne(ctx, watcher)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+// Remove stops the user watching the task, if they were.
+func (m WatcherModel) Remove(ctx context.Context, taskID, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.DeleteOne(ctx, bson.M{"task_id": taskID, "user_id": userID})
+	return queryError(ctx, err)
+}
+
+// RemoveForProjects stops the user watching any of the projects' tasks, for
+// when they leave the workspace the projects belong to.
+func (m WatcherModel) RemoveForProjects(ctx context.Context, userID primitive.ObjectID, projectIDs []primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	// The tasks the user watches are far fewer than those in the projects,
+	// so start from those.
+	watched, err := m.DB.Distinct(ctx, "task_id", bson.M{"user_id": userID})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if len(watched) == 0 {
+		return nil
+	}
+
+	taskIDs, err := m.Tasks.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": watched}, "project_id": bson.M{"$in": projectIDs}})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if len(taskIDs) == 0 {
+		return nil
+	}
+
+	_, err = m.DB.DeleteMany(ctx, bson.M{"user_id": userID, "task_id": bson.M{"$in": taskIDs}})
+	return queryError(ctx, err)
+}
+
+// ForTask returns the ids of the task's watchers, longest watching first.
+func (m WatcherModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "user_id", Value: 1}})
+	cursor, err := m.DB.Find(ctx, bson.M{"task_id": taskID}, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	var watchers []TaskWatcher
+	if err := cursor.All(ctx, &watchers); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	ids := make([]primitive.ObjectID, len(watchers))
+	for i, watcher := range watchers {
+		ids[i] = watcher.UserID
+	}
+	return ids, nil
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLWatcherModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLWatcherModel) Add(ctx context.Context, taskID, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO task_watchers (task_id, user_id, created_at)
+		VALUES (?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), taskID.Hex(), userID.Hex(), time.Now().UTC())
+	if err != nil && !m.Dialect.isUniqueViolation(err) {
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+func (m SQLWatcherModel) Remove(ctx context.Context, taskID, userID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM task_watchers
+		WHERE task_id = ? AND user_id = ?`
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), taskID.Hex(), userID.Hex())
+	return queryError(ctx, err)
+}
+
+func (m SQLWatcherModel) RemoveForProjects(ctx context.Context, userID primitive.ObjectID, projectIDs []primitive.ObjectID) error {
+	if len(projectIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM task_watchers
+		WHERE user_id = ? AND task_id IN (
+			SELECT id FROM tasks WHERE project_id IN (` + placeholders(len(projectIDs)) + `)
+		)`
+
+	args := append([]interface{}{userID.Hex()}, hexIDs(projectIDs)...)
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLWatcherModel) ForTask(ctx context.Context, taskID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT user_id
+		FROM task_watchers
+		WHERE task_id = ?
+		ORDER BY created_at, user_id`
+
+	ids, err := queryIDs(ctx, m.DB, m.Dialect.rebind(query), taskID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return ids, nil
+}
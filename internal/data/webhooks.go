@@ -0,0 +1,386 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/events"
+	"tasksync/internal/validator"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook posts the events of a workspace to an external URL. Events holds
+// the event types it wants, each either an exact type such as task.created,
+// a prefix such as task.* or * for everything.
+type Webhook struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	WorkspaceID  primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	CreatedBy    primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	URL          string             `json:"url" bson:"url"`
+	Events       []string           `json:"events" bson:"events"`
+	Secret       string             `json:"-" bson:"secret"`
+	Active       bool               `json:"active" bson:"active"`
+	FailureCount int                `json:"failure_count" bson:"failure_count"`
+	DisabledAt   *time.Time         `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+	Version      int32              `json:"version" bson:"version"`
+}
+
+func (w *Webhook) Wants(eventType string) bool {
+	for _, pattern := range w.Events {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	if u, err := url.Parse(webhook.URL); webhook.URL != "" {
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	}
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least one event type")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, pattern := range webhook.Events {
+		v.Check(validEventPattern(pattern), "events", "must only contain event types, type prefixes such as task.* or *")
+	}
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+}
+
+func validEventPattern(pattern string) bool {
+	if pattern == "*" {
+		return true
+	}
+	for _, t := range events.Types {
+		if pattern == t {
+			return true
+		}
+		if prefix, _, _ := strings.Cut(t, "."); pattern == prefix+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateWebhookSecret returns a random secret for signing payloads when
+// the client doesn't choose one.
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WebhookDelivery is a single event queued for a webhook, along with every
+// attempt made to deliver it. NextAttemptAt is nil once it has succeeded or
+// run out of attempts.
+type WebhookDelivery struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	WebhookID     primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	EventID       int64              `json:"event_id" bson:"event_id"`
+	EventType     string             `json:"event_type" bson:"event_type"`
+	Payload       json.RawMessage    `json:"payload" bson:"payload"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      []WebhookAttempt   `json:"attempts" bson:"attempts"`
+	NextAttemptAt *time.Time         `json:"next_attempt_at,omitempty" bson:"next_attempt_at"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
+	Version       int32              `json:"version" bson:"version"`
+}
+
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+type WebhookModel struct {
+	DB         *mongo.Collection
+	Deliveries *mongo.Collection
+	Timeout    time.Duration
+}
+
+func (m WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	webhook.ID = primitive.NewObjectID()
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.Active = true
+	webhook.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, webhook)
+	return queryError(ctx, err)
+}
+
+func (m WebhookModel) Get(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var webhook Webhook
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &webhook, nil
+}
+
+func (m WebhookModel) ForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]*Webhook, error) {
+	return m.find(ctx, bson.M{"workspace_id": bson.M{"$in": workspaceIDs}})
+}
+
+// ForEvent returns the active webhooks of the workspace which want the
+// event type.
+func (m WebhookModel) ForEvent(ctx context.Context, workspaceID primitive.ObjectID, eventType string) ([]*Webhook, error) {
+	webhooks, err := m.find(ctx, bson.M{"workspace_id": workspaceID, "active": true})
+	if err != nil {
+		return nil, err
+	}
+	return filterWebhooks(webhooks, eventType), nil
+}
+
+func (m WebhookModel) find(ctx context.Context, filter bson.M) ([]*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	webhooks := []*Webhook{}
+	err = cursor.All(ctx, &webhooks)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return webhooks, nil
+}
+
+// Update saves the webhook if it is still at webhook.Version, returning
+// ErrEditConflict otherwise. Re-activating a webhook clears its failures.
+func (m WebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if webhook.Active {
+		webhook.FailureCount = 0
+		webhook.DisabledAt = nil
+	}
+
+	filter := bson.M{"_id": webhook.ID, "version": webhook.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"url":           webhook.URL,
+			"events":        webhook.Events,
+			"secret":        webhook.Secret,
+			"active":        webhook.Active,
+			"failure_count": webhook.FailureCount,
+			"disabled_at":   webhook.DisabledAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	webhook.Version++
+	return nil
+}
+
+// Delete removes the webhook along with its deliveries.
+func (m WebhookModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = m.Deliveries.DeleteMany(ctx, bson.M{"webhook_id": id})
+	return queryError(ctx, err)
+}
+
+// RecordResult tracks consecutive failed attempts for the webhook, clearing
+// them on success, and disables it once disableAfter failures have piled
+// up. It reports whether this call disabled the webhook.
+func (m WebhookModel) RecordResult(ctx context.Context, id primitive.ObjectID, success bool, disableAfter int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if success {
+		_, err := m.DB.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"failure_count": 0}})
+		return false, queryError(ctx, err)
+	}
+
+	_, err := m.DB.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"failure_count": 1}})
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+
+	filter := bson.M{"_id": id, "active": true, "failure_count": bson.M{"$gte": disableAfter}}
+	update := bson.M{
+		"$set": bson.M{"active": false, "disabled_at": time.Now().UTC()},
+		"$inc": bson.M{"version": 1},
+	}
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func filterWebhooks(webhooks []*Webhook, eventType string) []*Webhook {
+	wanted := []*Webhook{}
+	for _, webhook := range webhooks {
+		if webhook.Wants(eventType) {
+			wanted = append(wanted, webhook)
+		}
+	}
+	return wanted
+}
+
+type WebhookDeliveryModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+// Insert queues the delivery for an immediate first attempt.
+func (m WebhookDeliveryModel) Insert(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	delivery.ID = primitive.NewObjectID()
+	delivery.Status = WebhookDeliveryPending
+	delivery.Attempts = []WebhookAttempt{}
+	delivery.NextAttemptAt = &now
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	delivery.Version = 1
+
+	_, err := m.DB.InsertOne(ctx, delivery)
+	return queryError(ctx, err)
+}
+
+func (m WebhookDeliveryModel) Get(ctx context.Context, id primitive.ObjectID) (*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var delivery WebhookDelivery
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &delivery, nil
+}
+
+// ForWebhook returns the webhook's most recent deliveries, newest first.
+func (m WebhookDeliveryModel) ForWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]*WebhookDelivery, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	return m.find(ctx, bson.M{"webhook_id": webhookID}, opts)
+}
+
+// Due returns up to limit pending deliveries whose next attempt is due.
+func (m WebhookDeliveryModel) Due(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	filter := bson.M{
+		"status":          WebhookDeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(int64(limit))
+	return m.find(ctx, filter, opts)
+}
+
+func (m WebhookDeliveryModel) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	cursor, err := m.DB.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	deliveries := []*WebhookDelivery{}
+	err = cursor.All(ctx, &deliveries)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return deliveries, nil
+}
+
+// Update saves the delivery if it is still at delivery.Version, returning
+// ErrEditConflict otherwise. Workers claim a due delivery by pushing its
+// next attempt back with Update, so only one of them makes the attempt.
+func (m WebhookDeliveryModel) Update(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	updatedAt := time.Now().UTC()
+
+	filter := bson.M{"_id": delivery.ID, "version": delivery.Version}
+	update := bson.M{
+		"$set": bson.M{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"updated_at":      updatedAt,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := m.DB.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	delivery.UpdatedAt = updatedAt
+	delivery.Version++
+	return nil
+}
@@ -0,0 +1,426 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLWebhookModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const webhookColumns = `id, workspace_id, created_by, created_at, url, events, secret, active, failure_count, disabled_at, version`
+
+func (m SQLWebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	webhook.ID = primitive.NewObjectID()
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.Active = true
+	webhook.Version = 1
+
+	eventTypes, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webhooks (` + webhookColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		webhook.ID.Hex(), webhook.WorkspaceID.Hex(), webhook.CreatedBy.Hex(), webhook.CreatedAt,
+		webhook.URL, string(eventTypes), webhook.Secret, webhook.Active, webhook.FailureCount,
+		nullTime(webhook.DisabledAt), webhook.Version,
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLWebhookModel) Get(ctx context.Context, id primitive.ObjectID) (*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE id = ?`
+
+	webhook, err := scanWebhook(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return webhook, nil
+}
+
+func (m SQLWebhookModel) ForWorkspaces(ctx context.Context, workspaceIDs []primitive.ObjectID) ([]*Webhook, error) {
+	if len(workspaceIDs) == 0 {
+		return []*Webhook{}, nil
+	}
+	return m.find(ctx, `workspace_id IN (`+placeholders(len(workspaceIDs))+`)`, hexIDs(workspaceIDs)...)
+}
+
+func (m SQLWebhookModel) ForEvent(ctx context.Context, workspaceID primitive.ObjectID, eventType string) ([]*Webhook, error) {
+	webhooks, err := m.find(ctx, `workspace_id = ? AND active = TRUE`, workspaceID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return filterWebhooks(webhooks, eventType), nil
+}
+
+func (m SQLWebhookModel) find(ctx context.Context, where string, args ...interface{}) ([]*Webhook, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE ` + where + `
+		ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, queryError(ctx, rows.Err())
+}
+
+func (m SQLWebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	if webhook.Active {
+		webhook.FailureCount = 0
+		webhook.DisabledAt = nil
+	}
+
+	eventTypes, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE webhooks
+		SET url = ?, events = ?, secret = ?, active = ?, failure_count = ?, disabled_at = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{
+		webhook.URL, string(eventTypes), webhook.Secret, webhook.Active, webhook.FailureCount,
+		nullTime(webhook.DisabledAt), webhook.ID.Hex(), webhook.Version,
+	}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	webhook.Version++
+	return nil
+}
+
+// Delete removes the webhook. Its deliveries go with it through the foreign
+// key.
+func (m SQLWebhookModel) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m SQLWebhookModel) RecordResult(ctx context.Context, id primitive.ObjectID, success bool, disableAfter int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE webhooks
+		SET failure_count = failure_count + 1
+		WHERE id = ?`
+	if success {
+		query = `
+			UPDATE webhooks
+			SET failure_count = 0
+			WHERE id = ?`
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), id.Hex())
+	if err != nil || success {
+		return false, queryError(ctx, err)
+	}
+
+	query = `
+		UPDATE webhooks
+		SET active = FALSE, disabled_at = ?, version = version + 1
+		WHERE id = ? AND active = TRUE AND failure_count >= ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), time.Now().UTC(), id.Hex(), disableAfter)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var webhook Webhook
+	var id, workspaceID, createdBy, eventTypes string
+	var disabledAt sql.NullTime
+
+	err := row.Scan(
+		&id,
+		&workspaceID,
+		&createdBy,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		&eventTypes,
+		&webhook.Secret,
+		&webhook.Active,
+		&webhook.FailureCount,
+		&disabledAt,
+		&webhook.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabledAt.Valid {
+		webhook.DisabledAt = &disabledAt.Time
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &webhook.Events); err != nil {
+		return nil, err
+	}
+
+	webhook.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.WorkspaceID, err = primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	webhook.CreatedBy, err = primitive.ObjectIDFromHex(createdBy)
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+type SQLWebhookDeliveryModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at, updated_at, version`
+
+func (m SQLWebhookDeliveryModel) Insert(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	delivery.ID = primitive.NewObjectID()
+	delivery.Status = WebhookDeliveryPending
+	delivery.Attempts = []WebhookAttempt{}
+	delivery.NextAttemptAt = &now
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	delivery.Version = 1
+
+	query := `
+		INSERT INTO webhook_deliveries (` + webhookDeliveryColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	args := []interface{}{
+		delivery.ID.Hex(), delivery.WebhookID.Hex(), delivery.EventID, delivery.EventType,
+		string(delivery.Payload), delivery.Status, "[]", now, now, now, delivery.Version,
+	}
+
+	_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	return queryError(ctx, err)
+}
+
+func (m SQLWebhookDeliveryModel) Get(ctx context.Context, id primitive.ObjectID) (*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE id = ?`
+
+	delivery, err := scanWebhookDelivery(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return delivery, nil
+}
+
+func (m SQLWebhookDeliveryModel) ForWebhook(ctx context.Context, webhookID primitive.ObjectID, limit int) ([]*WebhookDelivery, error) {
+	return m.find(ctx, `webhook_id = ? ORDER BY created_at DESC LIMIT ?`, webhookID.Hex(), limit)
+}
+
+func (m SQLWebhookDeliveryModel) Due(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	return m.find(ctx, `status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?`, WebhookDeliveryPending, now.UTC(), limit)
+}
+
+func (m SQLWebhookDeliveryModel) find(ctx context.Context, where string, args ...interface{}) ([]*WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE ` + where
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, queryError(ctx, rows.Err())
+}
+
+func (m SQLWebhookDeliveryModel) Update(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	attempts, err := json.Marshal(delivery.Attempts)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	args := []interface{}{
+		delivery.Status, string(attempts), nullTime(delivery.NextAttemptAt), updatedAt,
+		delivery.ID.Hex(), delivery.Version,
+	}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	delivery.UpdatedAt = updatedAt
+	delivery.Version++
+	return nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var id, webhookID, payload, attempts string
+	var nextAttemptAt sql.NullTime
+
+	err := row.Scan(
+		&id,
+		&webhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&attempts,
+		&nextAttemptAt,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+		&delivery.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = json.RawMessage(payload)
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if err := json.Unmarshal([]byte(attempts), &delivery.Attempts); err != nil {
+		return nil, err
+	}
+
+	delivery.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	delivery.WebhookID, err = primitive.ObjectIDFromHex(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"tasksync/internal/validator"
+)
+
+// Every user has a personal workspace which shares the user's id, so it
+// never needs to be looked up before use. Other workspaces are shared by
+// their members.
+type Workspace struct {
+	ID        primitive.ObjectID   `json:"id" bson:"_id"`
+	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
+	Name      string               `json:"name" bson:"name"`
+	OwnerID   primitive.ObjectID   `json:"owner_id" bson:"owner_id"`
+	Personal  bool                 `json:"personal" bson:"personal"`
+	MemberIDs []primitive.ObjectID `json:"member_ids" bson:"member_ids"`
+	Version   int32                `json:"version" bson:"version"`
+}
+
+func (w *Workspace) HasMember(userID primitive.ObjectID) bool {
+	for _, id := range w.MemberIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateWorkspace(v *validator.Validator, workspace *Workspace) {
+	v.Check(workspace.Name != "", "name", "must be provided")
+	v.Check(len(workspace.Name) <= 200, "name", "must not be more than 200 bytes long")
+}
+
+func personalWorkspace(userID primitive.ObjectID) *Workspace {
+	return &Workspace{
+		ID:        userID,
+		CreatedAt: time.Now().UTC(),
+		Name:      "Personal",
+		OwnerID:   userID,
+		Personal:  true,
+		MemberIDs: []primitive.ObjectID{userID},
+		Version:   1,
+	}
+}
+
+type WorkspaceModel struct {
+	DB      *mongo.Collection
+	Timeout time.Duration
+}
+
+func (m WorkspaceModel) Insert(ctx context.Context, workspace *Workspace) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	workspace.ID = primitive.NewObjectID()
+	workspace.CreatedAt = time.Now().UTC()
+	workspace.Version = 1
+	if !workspace.HasMember(workspace.OwnerID) {
+		workspace.MemberIDs = append(workspace.MemberIDs, workspace.OwnerID)
+	}
+
+	_, err := m.DB.InsertOne(ctx, workspace)
+	return queryError(ctx, err)
+}
+
+func (m WorkspaceModel) Get(ctx context.Context, id primitive.ObjectID) (*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var workspace Workspace
+	err := m.DB.FindOne(ctx, bson.M{"_id": id}).Decode(&workspace)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	return &workspace, nil
+}
+
+// ForUser returns every workspace the user belongs to, personal workspace
+// first, creating the personal workspace if it has never been stored.
+func (m WorkspaceModel) ForUser(ctx context.Context, userID primitive.ObjectID) ([]*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	personal := personalWorkspace(userID)
+	opts := options.Update().SetUpsert(true)
+	_, err := m.DB.UpdateOne(ctx, bson.M{"_id": personal.ID}, bson.M{"$setOnInsert": personal}, opts)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, queryError(ctx, err)
+	}
+
+	cursor, err := m.DB.Find(ctx, bson.M{"member_ids": userID}, options.Find().SetSort(bson.D{{Key: "personal", Value: -1}, {Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	workspaces := []*Workspace{}
+	err = cursor.All(ctx, &workspaces)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return workspaces, nil
+}
+
+// IDsForUser returns the ids of every workspace the user belongs to,
+// always including their personal workspace.
+func (m WorkspaceModel) IDsForUser(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	ids, err := m.DB.Distinct(ctx, "_id", bson.M{"member_ids": userID, "personal": false})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	workspaceIDs := []primitive.ObjectID{userID}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			workspaceIDs = append(workspaceIDs, oid)
+		}
+	}
+	return workspaceIDs, nil
+}
+
+func (m WorkspaceModel) IsMember(ctx context.Context, workspaceID, userID primitive.ObjectID) (bool, error) {
+	if workspaceID == userID {
+		return true, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	n, err := m.DB.CountDocuments(ctx, bson.M{"_id": workspaceID, "member_ids": userID})
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+	return n > 0, nil
+}
+
+func (m WorkspaceModel) AddMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error {
+	return m.updateMembers(ctx, workspace, bson.M{"$addToSet": bson.M{"member_ids": userID}})
+}
+
+func (m WorkspaceModel) RemoveMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error {
+	return m.updateMembers(ctx, workspace, bson.M{"$pull": bson.M{"member_ids": userID}})
+}
+
+func (m WorkspaceModel) updateMembers(ctx context.Context, workspace *Workspace, update bson.M) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	update["$inc"] = bson.M{"version": 1}
+	filter := bson.M{"_id": workspace.ID, "version": workspace.Version}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := m.DB.FindOneAndUpdate(ctx, filter, update, opts).Decode(workspace)
+	if err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SQLWorkspaceModel struct {
+	DB      SQLQuerier
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func (m SQLWorkspaceModel) Insert(ctx context.Context, workspace *Workspace) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	workspace.ID = primitive.NewObjectID()
+	workspace.CreatedAt = time.Now().UTC()
+	workspace.Version = 1
+	if !workspace.HasMember(workspace.OwnerID) {
+		workspace.MemberIDs = append(workspace.MemberIDs, workspace.OwnerID)
+	}
+
+	return m.insert(ctx, workspace, false)
+}
+
+// insert stores the workspace and its members. With ignoreExisting set an
+// already stored workspace is left untouched rather than reported.
+func (m SQLWorkspaceModel) insert(ctx context.Context, workspace *Workspace, ignoreExisting bool) error {
+	query := `
+		INSERT INTO workspaces (id, created_at, name, owner_id, personal, version)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	if ignoreExisting {
+		query += ` ON CONFLICT DO NOTHING`
+	}
+
+	args := []interface{}{
+		workspace.ID.Hex(), workspace.CreatedAt, workspace.Name,
+		workspace.OwnerID.Hex(), workspace.Personal, workspace.Version,
+	}
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return nil
+	}
+
+	for _, memberID := range workspace.MemberIDs {
+		query := `
+			INSERT INTO workspace_members (workspace_id, user_id)
+			VALUES (?, ?)`
+
+		_, err := m.DB.ExecContext(ctx, m.Dialect.rebind(query), workspace.ID.Hex(), memberID.Hex())
+		if err != nil {
+			return queryError(ctx, err)
+		}
+	}
+	return nil
+}
+
+func (m SQLWorkspaceModel) Get(ctx context.Context, id primitive.ObjectID) (*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, created_at, name, owner_id, personal, version
+		FROM workspaces
+		WHERE id = ?`
+
+	workspace, err := scanWorkspace(m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), id.Hex()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	err = m.loadMembers(ctx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+func (m SQLWorkspaceModel) ForUser(ctx context.Context, userID primitive.ObjectID) ([]*Workspace, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.insert(ctx, personalWorkspace(userID), true)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT w.id, w.created_at, w.name, w.owner_id, w.personal, w.version
+		FROM workspaces w
+		INNER JOIN workspace_members wm ON wm.workspace_id = w.id
+		WHERE wm.user_id = ?
+		ORDER BY w.personal DESC, w.created_at`
+
+	rows, err := m.DB.QueryContext(ctx, m.Dialect.rebind(query), userID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	workspaces := []*Workspace{}
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			rows.Close()
+			return nil, queryError(ctx, err)
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	for _, workspace := range workspaces {
+		if err := m.loadMembers(ctx, workspace); err != nil {
+			return nil, err
+		}
+	}
+	return workspaces, nil
+}
+
+func (m SQLWorkspaceModel) IDsForUser(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT workspace_id
+		FROM workspace_members
+		WHERE user_id = ? AND workspace_id <> ?`
+
+	ids, err := queryIDs(ctx, m.DB, m.Dialect.rebind(query), userID.Hex(), userID.Hex())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return append([]primitive.ObjectID{userID}, ids...), nil
+}
+
+func (m SQLWorkspaceModel) IsMember(ctx context.Context, workspaceID, userID primitive.ObjectID) (bool, error) {
+	if workspaceID == userID {
+		return true, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM workspace_members
+		WHERE workspace_id = ? AND user_id = ?`
+
+	var n int
+	err := m.DB.QueryRowContext(ctx, m.Dialect.rebind(query), workspaceID.Hex(), userID.Hex()).Scan(&n)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+	return n > 0, nil
+}
+
+func (m SQLWorkspaceModel) AddMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error {
+	query := `
+		INSERT INTO workspace_members (workspace_id, user_id)
+		VALUES (?, ?)
+		ON CONFLICT DO NOTHING`
+
+	return m.updateMembers(ctx, workspace, query, workspace.ID.Hex(), userID.Hex())
+}
+
+func (m SQLWorkspaceModel) RemoveMember(ctx context.Context, workspace *Workspace, userID primitive.ObjectID) error {
+	query := `
+		DELETE FROM workspace_members
+		WHERE workspace_id = ? AND user_id = ?`
+
+	return m.updateMembers(ctx, workspace, query, workspace.ID.Hex(), userID.Hex())
+}
+
+// updateMembers bumps the workspace version, failing with ErrEditConflict
+// if it has moved on, and then applies the membership change.
+func (m SQLWorkspaceModel) updateMembers(ctx context.Context, workspace *Workspace, query string, args ...interface{}) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	versionQuery := `
+		UPDATE workspaces
+		SET version = version + 1
+		WHERE id = ? AND version = ?`
+
+	result, err := m.DB.ExecContext(ctx, m.Dialect.rebind(versionQuery), workspace.ID.Hex(), workspace.Version)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrEditConflict
+	}
+
+	_, err = m.DB.ExecContext(ctx, m.Dialect.rebind(query), args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	workspace.Version++
+	return m.loadMembers(ctx, workspace)
+}
+
+func (m SQLWorkspaceModel) loadMembers(ctx context.Context, workspace *Workspace) error {
+	query := `
+		SELECT user_id
+		FROM workspace_members
+		WHERE workspace_id = ?
+		ORDER BY user_id`
+
+	ids, err := queryIDs(ctx, m.DB, m.Dialect.rebind(query), workspace.ID.Hex())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	workspace.MemberIDs = ids
+	return nil
+}
+
+func scanWorkspace(row rowScanner) (*Workspace, error) {
+	var workspace Workspace
+	var id, ownerID string
+
+	err := row.Scan(&id, &workspace.CreatedAt, &workspace.Name, &ownerID, &workspace.Personal, &workspace.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace.ID, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	workspace.OwnerID, err = primitive.ObjectIDFromHex(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// queryIDs runs a query selecting a single id column and parses the ids.
+func queryIDs(ctx context.Context, db SQLQuerier, query string, args ...interface{}) ([]primitive.ObjectID, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []primitive.ObjectID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		oid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, oid)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,214 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ProjectCreated = "project.created"
+	ProjectUpdated = "project.updated"
+	ProjectDeleted = "project.deleted"
+	TaskCreated    = "task.created"
+	TaskUpdated    = "task.updated"
+	TaskDeleted    = "task.deleted"
+)
+
+var Types = []string{
+	ProjectCreated, ProjectUpdated, ProjectDeleted,
+	TaskCreated, TaskUpdated, TaskDeleted,
+}
+
+var (
+	ErrHubClosed    = errors.New("hub closed")
+	ErrSlowConsumer = errors.New("subscriber fell behind")
+	ErrUnsubscribed = errors.New("unsubscribed")
+)
+
+// Event describes a single change to a project or task. Its ID is the
+// change sequence of the write, so clients can fall back to /v1/sync with
+// the last ID they saw if they miss any events.
+type Event struct {
+	ID          int64              `json:"id"`
+	Type        string             `json:"type"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id"`
+	Time        time.Time          `json:"time"`
+	Data        interface{}        `json:"data"`
+}
+
+// Hub fans events out to the subscribers of each workspace. Publishing never
+// blocks: a subscriber whose buffer is full is dropped with ErrSlowConsumer
+// rather than holding up everyone else. The most recent events are also kept
+// in a bounded log so that subscribers can resume after reconnecting.
+type Hub struct {
+	mu     sync.Mutex
+	topics map[primitive.ObjectID]map[*Subscription]struct{}
+	log    *eventLog
+	closed bool
+}
+
+// NewHub returns a hub which retains the last logSize events for resuming
+// subscribers.
+func NewHub(logSize int) *Hub {
+	return &Hub{
+		topics: make(map[primitive.ObjectID]map[*Subscription]struct{}),
+		log:    newEventLog(logSize),
+	}
+}
+
+type Subscription struct {
+	UserID     primitive.ObjectID
+	hub        *Hub
+	workspaces []primitive.ObjectID
+	events     chan Event
+	done       chan struct{}
+	err        error
+}
+
+// Subscribe registers a subscription for the user to the given workspaces
+// which buffers up to buffer undelivered events.
+func (h *Hub) Subscribe(userID primitive.ObjectID, workspaceIDs []primitive.ObjectID, buffer int) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.subscribe(userID, workspaceIDs, buffer)
+}
+
+// Resume subscribes like Subscribe and also returns the logged events for
+// the workspaces published after the one with ID lastID, which the caller
+// should deliver before anything from the subscription. It reports false if
+// the log no longer reaches back to lastID, in which case the caller has
+// missed events and must catch up some other way.
+func (h *Hub) Resume(userID primitive.ObjectID, workspaceIDs []primitive.ObjectID, buffer int, lastID int64) (*Subscription, []Event, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.subscribe(userID, workspaceIDs, buffer)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	backlog, ok := h.log.since(lastID, s.wants)
+	return s, backlog, ok, nil
+}
+
+// subscribe must be called with h.mu held.
+func (h *Hub) subscribe(userID primitive.ObjectID, workspaceIDs []primitive.ObjectID, buffer int) (*Subscription, error) {
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+
+	s := &Subscription{
+		UserID:     userID,
+		hub:        h,
+		workspaces: workspaceIDs,
+		events:     make(chan Event, buffer),
+		done:       make(chan struct{}),
+	}
+
+	for _, id := range workspaceIDs {
+		if h.topics[id] == nil {
+			h.topics[id] = make(map[*Subscription]struct{})
+		}
+		h.topics[id][s] = struct{}{}
+	}
+	return s, nil
+}
+
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// The same change can be published both by the instance which made it
+	// and by its change feed. Event IDs are unique, so repeats still in the
+	// log are dropped.
+	if h.log.contains(e.ID) {
+		return
+	}
+	h.log.append(e)
+
+	for s := range h.topics[e.WorkspaceID] {
+		select {
+		case s.events <- e:
+		default:
+			h.remove(s, ErrSlowConsumer)
+		}
+	}
+}
+
+// Unsubscribe drops every subscription the user holds to the workspace,
+// typically because they have just been removed from it.
+func (h *Hub) Unsubscribe(workspaceID, userID primitive.ObjectID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.topics[workspaceID] {
+		if s.UserID == userID {
+			h.remove(s, ErrUnsubscribed)
+		}
+	}
+}
+
+// Close ends every subscription with ErrHubClosed and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.topics {
+		for s := range subs {
+			h.remove(s, ErrHubClosed)
+		}
+	}
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(s *Subscription, err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+
+	for _, id := range s.workspaces {
+		delete(h.topics[id], s)
+		if len(h.topics[id]) == 0 {
+			delete(h.topics, id)
+		}
+	}
+}
+
+func (s *Subscription) wants(e Event) bool {
+	for _, id := range s.workspaces {
+		if id == e.WorkspaceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Events returns the channel on which events are delivered. It is never
+// closed; wait on Done as well to learn when the subscription has ended.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err reports why the subscription ended, or nil while it is active.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s, ErrUnsubscribed)
+}
@@ -0,0 +1,64 @@
+package events
+
+// eventLog is a ring buffer holding the most recently published events in
+// the order they were published.
+type eventLog struct {
+	events []Event
+	ids    map[int64]struct{}
+	start  int
+	n      int
+}
+
+func newEventLog(size int) *eventLog {
+	if size < 1 {
+		size = 1
+	}
+	return &eventLog{
+		events: make([]Event, size),
+		ids:    make(map[int64]struct{}, size),
+	}
+}
+
+func (l *eventLog) append(e Event) {
+	l.ids[e.ID] = struct{}{}
+
+	if l.n < len(l.events) {
+		l.events[(l.start+l.n)%len(l.events)] = e
+		l.n++
+		return
+	}
+	delete(l.ids, l.events[l.start].ID)
+	l.events[l.start] = e
+	l.start = (l.start + 1) % len(l.events)
+}
+
+func (l *eventLog) contains(id int64) bool {
+	_, ok := l.ids[id]
+	return ok
+}
+
+func (l *eventLog) at(i int) Event {
+	return l.events[(l.start+i)%len(l.events)]
+}
+
+// since returns the matching events with an ID above lastID. It reports
+// false unless the oldest retained event is at or before lastID, since
+// otherwise events after lastID may already have been discarded.
+//
+// Event IDs are change sequences, which concurrent writers can publish
+// slightly out of order, so this may repeat an event the caller has seen.
+// Applying an event twice is harmless as it carries the whole record.
+func (l *eventLog) since(lastID int64, match func(Event) bool) ([]Event, bool) {
+	if l.n == 0 || l.at(0).ID > lastID {
+		return nil, false
+	}
+
+	var events []Event
+	for i := 0; i < l.n; i++ {
+		e := l.at(i)
+		if e.ID > lastID && match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, true
+}
@@ -0,0 +1,392 @@
+// Package ical reads and writes the parts of iCalendar (RFC 5545) needed to
+// exchange tasks with calendar apps. A calendar is a tree of components,
+// each holding properties; property values are kept as their raw text, with
+// helpers for the TEXT, DATE and DATE-TIME value types.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxLineLength is the longest line, in bytes, written before folding.
+	maxLineLength = 75
+
+	// maxDepth limits how deeply components may nest when decoding.
+	maxDepth = 8
+
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+)
+
+// SyntaxError describes a calendar which couldn't be decoded. Line is the
+// line the problem was found on, counting from 1, or 0 for problems with
+// the calendar as a whole.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return "ical: " + e.Msg
+	}
+	return fmt.Sprintf("ical: line %d: %s", e.Line, e.Msg)
+}
+
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+type Component struct {
+	Name       string
+	Props      []*Property
+	Components []*Component
+}
+
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Add appends a property with a raw value, which must already be escaped
+// as its type requires.
+func (c *Component) Add(name, value string) *Property {
+	prop := &Property{Name: name, Value: value}
+	c.Props = append(c.Props, prop)
+	return prop
+}
+
+// AddText appends a property of type TEXT, escaping its value.
+func (c *Component) AddText(name, text string) *Property {
+	return c.Add(name, EscapeText(text))
+}
+
+// AddDateTime appends a DATE-TIME property, written in UTC.
+func (c *Component) AddDateTime(name string, t time.Time) *Property {
+	return c.Add(name, t.UTC().Format(dateTimeLayout)+"Z")
+}
+
+// AddDate appends a DATE property holding the day of t in UTC.
+func (c *Component) AddDate(name string, t time.Time) *Property {
+	prop := c.Add(name, t.UTC().Format(dateLayout))
+	prop.Params = map[string]string{"VALUE": "DATE"}
+	return prop
+}
+
+// Prop returns the first property with the given name, or nil if there
+// isn't one.
+func (c *Component) Prop(name string) *Property {
+	for _, prop := range c.Props {
+		if prop.Name == name {
+			return prop
+		}
+	}
+	return nil
+}
+
+// Text returns the unescaped value of the named TEXT property, or an empty
+// string if there isn't one.
+func (c *Component) Text(name string) string {
+	prop := c.Prop(name)
+	if prop == nil {
+		return ""
+	}
+	return UnescapeText(prop.Value)
+}
+
+// Time reads a DATE or DATE-TIME property. Dates are taken as midnight UTC
+// and reported as all-day. Local times are read in the zone named by the
+// TZID parameter, falling back to UTC when the zone is unknown, as it is
+// for the Windows zone names some apps write.
+func (p *Property) Time() (t time.Time, allDay bool, err error) {
+	value := p.Value
+	if p.Params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		t, err = time.Parse(dateLayout, value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse(dateTimeLayout, strings.TrimSuffix(value, "Z"))
+		return t, false, err
+	}
+
+	loc := time.UTC
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation(dateTimeLayout, value, loc)
+	return t.UTC(), false, err
+}
+
+var (
+	textEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+func EscapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func UnescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
+
+// Encode writes c and the components within it, folding long lines and
+// ending each with CRLF as the format requires.
+func Encode(w io.Writer, c *Component) error {
+	bw := bufio.NewWriter(w)
+	encodeComponent(bw, c)
+	return bw.Flush()
+}
+
+// Encoder writes a component a piece at a time, so that one with many
+// components within it needn't be held in memory: Begin writes its
+// properties, Encode each component within it in turn and End closes it.
+// Writes are buffered, and the first error writing is returned by Flush.
+type Encoder struct {
+	w *bufio.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Begin opens c and writes its properties, but not its components.
+func (e *Encoder) Begin(c *Component) {
+	writeLine(e.w, "BEGIN:"+c.Name)
+	for _, prop := range c.Props {
+		writeLine(e.w, encodeProperty(prop))
+	}
+}
+
+// Encode writes c and the components within it.
+func (e *Encoder) Encode(c *Component) {
+	encodeComponent(e.w, c)
+}
+
+// End closes c, which Begin opened.
+func (e *Encoder) End(c *Component) {
+	writeLine(e.w, "END:"+c.Name)
+}
+
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+func encodeComponent(w *bufio.Writer, c *Component) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, prop := range c.Props {
+		writeLine(w, encodeProperty(prop))
+	}
+	for _, child := range c.Components {
+		encodeComponent(w, child)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+func encodeProperty(prop *Property) string {
+	var b strings.Builder
+	b.WriteString(prop.Name)
+
+	names := make([]string, 0, len(prop.Params))
+	for name := range prop.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := prop.Params[name]
+		if strings.ContainsAny(value, ";:,") {
+			value = `"` + strings.ReplaceAll(value, `"`, "") + `"`
+		}
+		b.WriteString(";" + name + "=" + value)
+	}
+
+	b.WriteString(":" + prop.Value)
+	return b.String()
+}
+
+// writeLine folds line into pieces of at most maxLineLength bytes, never
+// splitting a UTF-8 sequence, continuing each with a leading space.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		w.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		// The leading space counts towards the length of the next line.
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+// Decode reads a single component, normally a VCALENDAR, along with
+// everything within it.
+func Decode(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		root  *Component
+		stack []*Component
+	)
+
+	for _, line := range lines {
+		if line.text == "" {
+			continue
+		}
+
+		prop, err := parseProperty(line.text)
+		if err != nil {
+			return nil, &SyntaxError{Line: line.number, Msg: err.Error()}
+		}
+
+		switch {
+		case prop.Name == "BEGIN":
+			if root != nil && len(stack) == 0 {
+				return nil, &SyntaxError{Line: line.number, Msg: "content after the end of the calendar"}
+			}
+			if len(stack) == maxDepth {
+				return nil, &SyntaxError{Line: line.number, Msg: "components nested too deeply"}
+			}
+
+			c := NewComponent(strings.ToUpper(prop.Value))
+			if len(stack) == 0 {
+				root = c
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			}
+			stack = append(stack, c)
+
+		case prop.Name == "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, &SyntaxError{Line: line.number, Msg: "unexpected END:" + prop.Value}
+			}
+			stack = stack[:len(stack)-1]
+
+		case len(stack) == 0:
+			return nil, &SyntaxError{Line: line.number, Msg: "property outside a component"}
+
+		default:
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, prop)
+		}
+	}
+
+	switch {
+	case root == nil:
+		return nil, &SyntaxError{Msg: "no components"}
+	case len(stack) > 0:
+		return nil, &SyntaxError{Msg: "missing END:" + stack[len(stack)-1].Name}
+	}
+	return root, nil
+}
+
+type contentLine struct {
+	number int
+	text   string
+}
+
+// unfold joins folded lines back together, numbering each by the line it
+// starts on. Bare LF line endings are accepted as well as CRLF.
+func unfold(r io.Reader) ([]contentLine, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+
+	var lines []contentLine
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if number == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+
+		if len(lines) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		lines = append(lines, contentLine{number: number, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &SyntaxError{Line: number + 1, Msg: err.Error()}
+	}
+	return lines, nil
+}
+
+// parseProperty reads a content line: a name, any parameters, each
+// separated by a semicolon, then a colon and the value. Parameter values
+// may be quoted, so that they can hold those separators.
+func parseProperty(line string) (*Property, error) {
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, errors.New("missing property name")
+	}
+
+	prop := &Property{Name: strings.ToUpper(line[:i])}
+	rest := line[i:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid parameter in %s", prop.Name)
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value strings.Builder
+		for {
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated quote in %s", prop.Name)
+				}
+				value.WriteString(rest[1 : end+1])
+				rest = rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ";:,")
+				if end < 0 {
+					return nil, fmt.Errorf("missing value for %s", prop.Name)
+				}
+				value.WriteString(rest[:end])
+				rest = rest[end:]
+			}
+
+			if !strings.HasPrefix(rest, ",") {
+				break
+			}
+			value.WriteByte(',')
+			rest = rest[1:]
+		}
+
+		if prop.Params == nil {
+			prop.Params = make(map[string]string)
+		}
+		prop.Params[name] = value.String()
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return nil, fmt.Errorf("missing value for %s", prop.Name)
+	}
+	prop.Value = rest[1:]
+	return prop, nil
+}
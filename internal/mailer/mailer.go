@@ -55,6 +55,17 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	message.SetHeader("To", recipient)
 	message.SetHeader("From", m.sender)
 	message.SetHeader("Subject", subject.String())
+	// Templates for mail which can be unsubscribed from define its link,
+	// for mail clients to offer one-click unsubscribe (RFC 8058).
+	if tmpl.Lookup("listUnsubscribe") != nil {
+		listUnsubscribe := new(bytes.Buffer)
+		err = tmpl.ExecuteTemplate(listUnsubscribe, "listUnsubscribe", data)
+		if err != nil {
+			return err
+		}
+		message.SetHeader("List-Unsubscribe", "<"+listUnsubscribe.String()+">")
+		message.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
 	message.SetBody("text/plain", plainBody.String())
 	message.AddAlternative("text/html", htmlBody.String())
 
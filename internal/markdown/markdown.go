@@ -0,0 +1,93 @@
+// Package markdown renders the Markdown users write, such as comments on
+// tasks, as HTML which is safe to show in a browser, and finds the users it
+// mentions.
+//
+// Markdown is CommonMark with the GitHub tables, strikethrough and task
+// lists. Raw HTML is never passed through, and the rendered HTML is
+// sanitised again on the way out in case a link or image slips past.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// Linkify is left out on purpose: it would turn the email addresses people
+// mention each other by into links, hiding them from Mentions.
+var md = goldmark.New(goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.TaskList))
+
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Task lists render as disabled checkboxes.
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// Render converts src to sanitised HTML.
+func Render(src string) (string, error) {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+	return policy.Sanitize(buf.String()), nil
+}
+
+// mentionRx matches @handle, where a handle is a run of letters, digits,
+// dots, dashes and underscores, optionally followed by @domain so that
+// people can be mentioned by email address. The @ mustn't follow a letter
+// or digit, which would make it part of an email address.
+var mentionRx = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.-]+(?:@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)+)?)`)
+
+// Mentions returns the handles mentioned in src, without their @, each once
+// in the order they first appear. Mentions in code and in links written as
+// <address> don't count.
+func Mentions(src string) []string {
+	source := []byte(src)
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	// Gather the text people can read, keeping blocks and lines apart so
+	// that a mention at the start of one isn't glued to the end of the last.
+	var b strings.Builder
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.CodeSpan, *ast.CodeBlock, *ast.FencedCodeBlock, *ast.HTMLBlock, *ast.RawHTML, *ast.AutoLink:
+			b.WriteByte(' ')
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		default:
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var handles []string
+	seen := make(map[string]bool)
+	for _, m := range mentionRx.FindAllStringSubmatch(b.String(), -1) {
+		handle := strings.TrimRight(m[1], ".-")
+		key := strings.ToLower(handle)
+		if handle != "" && !seen[key] {
+			seen[key] = true
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
@@ -0,0 +1,220 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// BM25 parameters, at their usual values.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Hit is a document matching a query, with its score. Higher scores are
+// better matches.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Index is an inverted index of documents made up of named text fields.
+// Matches in each field count for the field's weight, or 1 for fields
+// without one. An Index isn't safe for concurrent use while documents are
+// being added.
+type Index struct {
+	weights  map[string]float64
+	docs     []indexDoc
+	postings map[string][]posting
+	fieldLen map[string]int
+
+	// vocab is the sorted list of indexed words, for prefix matching. It is
+	// built on demand and cleared whenever a document is added.
+	vocab []string
+}
+
+type indexDoc struct {
+	id      string
+	lengths map[string]int
+}
+
+// posting records where a word appears in one field of a document.
+type posting struct {
+	doc       int
+	field     string
+	positions []int
+}
+
+func NewIndex(weights map[string]float64) *Index {
+	return &Index{
+		weights:  weights,
+		postings: make(map[string][]posting),
+		fieldLen: make(map[string]int),
+	}
+}
+
+// Add indexes a document. Documents are expected to have distinct IDs.
+func (ix *Index) Add(id string, fields map[string]string) {
+	doc := indexDoc{id: id, lengths: make(map[string]int, len(fields))}
+	n := len(ix.docs)
+
+	for field, text := range fields {
+		words := Tokenize(text)
+		doc.lengths[field] = len(words)
+		ix.fieldLen[field] += len(words)
+
+		positions := make(map[string][]int)
+		for i, word := range words {
+			positions[word] = append(positions[word], i)
+		}
+		for word, at := range positions {
+			ix.postings[word] = append(ix.postings[word], posting{doc: n, field: field, positions: at})
+		}
+	}
+
+	ix.docs = append(ix.docs, doc)
+	ix.vocab = nil
+}
+
+// Search returns the documents matching every term, best first, with ties
+// left in the order the documents were added. With no terms every document
+// matches, with a score of zero.
+func (ix *Index) Search(terms []Term) []Hit {
+	scores := make(map[int]float64, len(ix.docs))
+	for i := range ix.docs {
+		scores[i] = 0
+	}
+
+	for _, term := range terms {
+		matches := ix.match(term)
+
+		// BM25's inverse document frequency, in the form which stays
+		// positive however common the term.
+		n, df := float64(len(ix.docs)), float64(len(matches))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for doc := range scores {
+			fields, ok := matches[doc]
+			if !ok {
+				delete(scores, doc)
+				continue
+			}
+			for field, tf := range fields {
+				scores[doc] += idf * ix.weight(field) * ix.saturate(doc, field, float64(tf))
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	docs := make([]int, 0, len(scores))
+	for doc := range scores {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if scores[docs[i]] != scores[docs[j]] {
+			return scores[docs[i]] > scores[docs[j]]
+		}
+		return docs[i] < docs[j]
+	})
+	for _, doc := range docs {
+		hits = append(hits, Hit{ID: ix.docs[doc].id, Score: scores[doc]})
+	}
+	return hits
+}
+
+func (ix *Index) weight(field string) float64 {
+	if w, ok := ix.weights[field]; ok {
+		return w
+	}
+	return 1
+}
+
+// saturate is BM25's term frequency component, which grows ever more slowly
+// with repeated matches and is scaled down for longer than average fields.
+func (ix *Index) saturate(doc int, field string, tf float64) float64 {
+	avg := float64(ix.fieldLen[field]) / float64(len(ix.docs))
+	length := float64(ix.docs[doc].lengths[field])
+
+	norm := 1.0
+	if avg > 0 {
+		norm = 1 - bm25B + bm25B*length/avg
+	}
+	return tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+}
+
+// match finds where a term appears, as the number of times in each field
+// of each document which has it.
+func (ix *Index) match(term Term) map[int]map[string]int {
+	matches := make(map[int]map[string]int)
+	if len(term.Words) == 0 {
+		return matches
+	}
+
+	// The positions of each word of the term, by document and field, with
+	// the last word standing for every word it's a prefix of.
+	type location struct {
+		doc   int
+		field string
+	}
+	at := make([]map[location]map[int]bool, len(term.Words))
+	for i, word := range term.Words {
+		at[i] = make(map[location]map[int]bool)
+
+		words := []string{word}
+		if term.Prefix && i == len(term.Words)-1 {
+			words = ix.withPrefix(word)
+		}
+		for _, w := range words {
+			for _, p := range ix.postings[w] {
+				if term.Field != "" && p.field != term.Field {
+					continue
+				}
+				loc := location{p.doc, p.field}
+				if at[i][loc] == nil {
+					at[i][loc] = make(map[int]bool)
+				}
+				for _, pos := range p.positions {
+					at[i][loc][pos] = true
+				}
+			}
+		}
+	}
+
+	for loc, starts := range at[0] {
+		count := 0
+		for start := range starts {
+			phrase := true
+			for i := 1; i < len(term.Words) && phrase; i++ {
+				phrase = at[i][loc][start+i]
+			}
+			if phrase {
+				count++
+			}
+		}
+		if count > 0 {
+			if matches[loc.doc] == nil {
+				matches[loc.doc] = make(map[string]int)
+			}
+			matches[loc.doc][loc.field] += count
+		}
+	}
+	return matches
+}
+
+// withPrefix returns the indexed words starting with prefix.
+func (ix *Index) withPrefix(prefix string) []string {
+	if ix.vocab == nil {
+		ix.vocab = make([]string, 0, len(ix.postings))
+		for word := range ix.postings {
+			ix.vocab = append(ix.vocab, word)
+		}
+		sort.Strings(ix.vocab)
+	}
+
+	var words []string
+	for i := sort.SearchStrings(ix.vocab, prefix); i < len(ix.vocab) && strings.HasPrefix(ix.vocab[i], prefix); i++ {
+		words = append(words, ix.vocab[i])
+	}
+	return words
+}
@@ -0,0 +1,178 @@
+package search_test
+
+import (
+	"reflect"
+	"testing"
+
+	"tasksync/internal/search"
+)
+
+// newTestIndex returns an index of a few tasks, with titles counting for
+// three times as much as descriptions.
+func newTestIndex() *search.Index {
+	ix := search.NewIndex(map[string]float64{"title": 3, "description": 1})
+	for _, doc := range []struct{ id, title, description string }{
+		{"a", "Renew passport", "Book an appointment at the passport office"},
+		{"b", "Pack bags", "Passport, tickets and chargers"},
+		{"c", "Passport photos: renew", ""},
+		{"d", "Water plants", "Renewal of the plant food order"},
+	} {
+		ix.Add(doc.id, map[string]string{"title": doc.title, "description": doc.description})
+	}
+	return ix
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix := newTestIndex()
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"word", "passport", []string{"a", "c", "b"}},
+		{"any case", "PASSPORT", []string{"a", "c", "b"}},
+		{"no match", "visa", []string{}},
+		{"every word", "renew passport", []string{"a", "c"}},
+		{"every word, one missing", "renew tickets", []string{}},
+		{"phrase", `"renew passport"`, []string{"a"}},
+		{"phrase out of order", `"passport renew"`, []string{}},
+		{"phrase across punctuation", `"passport tickets"`, []string{"b"}},
+		{"whole words only", "renewal renew", []string{}},
+		{"not a prefix without a star", "ren", []string{}},
+		{"prefix", "renew*", []string{"a", "c", "d"}},
+		{"prefix of a phrase", `"renew pass"*`, []string{"a"}},
+		{"prefix and word", "pass* plants", []string{}},
+		{"field", "title:passport", []string{"a", "c"}},
+		{"other field", "description:passport", []string{"b", "a"}},
+		{"field phrase", `description:"plant food"`, []string{"d"}},
+		{"field prefix, ties in order added", "title:pa*", []string{"a", "b", "c"}},
+		{"field with no match", "title:tickets", []string{}},
+		{"unknown field", "status:passport", []string{}},
+		{"nothing", "", []string{"a", "b", "c", "d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms, err := search.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, hit := range ix.Search(terms) {
+				got = append(got, hit.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexRanking(t *testing.T) {
+	tests := []struct {
+		name  string
+		docs  []map[string]string
+		query string
+	}{
+		{
+			// A title match outweighs a description match.
+			"field weight",
+			[]map[string]string{
+				{"title": "Groceries", "description": "Milk"},
+				{"title": "Milk", "description": "Groceries"},
+			},
+			"milk",
+		},
+		{
+			"more matches",
+			[]map[string]string{
+				{"title": "Milk and bread"},
+				{"title": "Milk, milk and bread"},
+			},
+			"milk",
+		},
+		{
+			"shorter field",
+			[]map[string]string{
+				{"title": "Buy milk on the way home from work"},
+				{"title": "Buy milk"},
+			},
+			"milk",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ix := search.NewIndex(map[string]float64{"title": 3})
+			ids := []string{"first", "second"}
+			for i, doc := range tt.docs {
+				ix.Add(ids[i], doc)
+			}
+
+			terms, err := search.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hits := ix.Search(terms)
+			if len(hits) == 0 {
+				t.Fatal("got no hits")
+			}
+
+			// The best match is always the last document added, so that it
+			// can't come first by being the earliest of a tie.
+			want := ids[len(tt.docs)-1]
+			if hits[0].ID != want {
+				t.Errorf("got %s first; want %s", hits[0].ID, want)
+			}
+			for i := 1; i < len(hits); i++ {
+				if hits[i].Score >= hits[0].Score {
+					t.Errorf("got %s scoring %.3f, no less than the best %.3f", hits[i].ID, hits[i].Score, hits[0].Score)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexTies(t *testing.T) {
+	ix := search.NewIndex(nil)
+	for _, id := range []string{"x", "y", "z"} {
+		ix.Add(id, map[string]string{"title": "Same title"})
+	}
+
+	terms, err := search.Parse("same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, hit := range ix.Search(terms) {
+		if hit.Score <= 0 {
+			t.Errorf("got %s scoring %.3f; want more than nothing", hit.ID, hit.Score)
+		}
+		got = append(got, hit.ID)
+	}
+	if want := []string{"x", "y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v in the order added", got, want)
+	}
+}
+
+// TestIndexPrefixAfterAdd checks that words added after a prefix search are
+// found by the next one.
+func TestIndexPrefixAfterAdd(t *testing.T) {
+	ix := search.NewIndex(nil)
+	ix.Add("a", map[string]string{"title": "Planning"})
+
+	terms, err := search.Parse("plan*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits := ix.Search(terms); len(hits) != 1 {
+		t.Fatalf("got %d hits; want 1", len(hits))
+	}
+
+	ix.Add("b", map[string]string{"title": "Plant trees"})
+	if hits := ix.Search(terms); len(hits) != 2 {
+		t.Errorf("got %d hits after adding a match; want 2", len(hits))
+	}
+}
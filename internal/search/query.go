@@ -0,0 +1,96 @@
+// Package search provides keyword search over small sets of documents held
+// in memory: a query syntax, and an inverted index which ranks documents
+// against a query with BM25.
+//
+// A query is a list of terms separated by spaces, all of which a document
+// must match. A term is a word, a "quoted phrase", or either of those
+// ending in * to match words by prefix. Any term can be scoped to a field
+// as field:word or field:"a phrase". What the fields are, and whether some
+// of them are really qualifiers like status:open, is up to the caller.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxTerms caps the number of terms in a query.
+const MaxTerms = 20
+
+// Term is one term of a query. Words holds the term's words, normalised as
+// Tokenize does, with more than one for a phrase; the last of them is
+// matched as a prefix when Prefix is set. Raw is the term's value as
+// written, without quotes or a trailing *, for fields whose values aren't
+// text.
+type Term struct {
+	Field  string
+	Words  []string
+	Prefix bool
+	Raw    string
+}
+
+// Parse reads a query into its terms.
+func Parse(query string) ([]Term, error) {
+	var terms []Term
+
+	s := strings.TrimSpace(query)
+	for s != "" {
+		var term Term
+
+		// A field name runs up to a colon, but a quote or space coming
+		// first means there isn't one.
+		if i := strings.IndexByte(s, ':'); i > 0 && !strings.ContainsAny(s[:i], "\" \t") {
+			term.Field = strings.ToLower(s[:i])
+			s = s[i+1:]
+		}
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, errors.New("has a phrase without a closing quote")
+			}
+			value, s = s[1:end+1], s[end+2:]
+			if strings.HasPrefix(s, "*") {
+				term.Prefix = true
+				s = s[1:]
+			}
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+			if strings.HasSuffix(value, "*") {
+				term.Prefix = true
+				value = strings.TrimRight(value, "*")
+			}
+		}
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+
+		term.Raw = value
+		term.Words = Tokenize(value)
+		if len(term.Words) == 0 && term.Field == "" {
+			// Punctuation on its own matches nothing, so it's ignored.
+			continue
+		}
+		if term.Raw == "" {
+			return nil, fmt.Errorf("has no value for %s", term.Field)
+		}
+
+		terms = append(terms, term)
+		if len(terms) > MaxTerms {
+			return nil, fmt.Errorf("must not have more than %d terms", MaxTerms)
+		}
+	}
+	return terms, nil
+}
+
+// Tokenize splits text into lower case words, made of letters and digits.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}